@@ -0,0 +1,26 @@
+package ckb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBlake2b(t *testing.T) {
+	//the example of RFC 7693
+	if h := hex.EncodeToString(blake2b(64, nil, []byte("abc"))); h != "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923" {
+		t.Error("blake2b-512 of abc", h)
+	}
+	//the blank hash of ckb
+	if h := hex.EncodeToString(CKBHash()); h != "44f4c69744d5f8c55d642062949dcae49bc4e7ef43d388c5a12f42b5633d163e" {
+		t.Error("ckbhash of nothing", h)
+	}
+	//messages across several blocks, split anywhere
+	data := bytes.Repeat([]byte{0x5a}, 300)
+	if !bytes.Equal(CKBHash(data[:128], data[128:]), CKBHash(data[:7], data[7:])) {
+		t.Error("hash depends on the parts")
+	}
+	if bytes.Equal(CKBHash(data[:256]), CKBHash(data[:255])) {
+		t.Error("block boundary ignored")
+	}
+}
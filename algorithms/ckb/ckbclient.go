@@ -7,8 +7,12 @@ import (
 	"github.com/AGPFMiner/gominer/types"
 )
 
-// NewClient creates a new SiadClient given a '[stratum+tcp://]host:port' connectionstring
+// NewClient creates a new SiadClient given a '[stratum+tcp://]host:port' connectionstring,
+// or a NodeClient mining solo on the node at an 'http://host:port' RPC address
 func NewClient(pool *types.Pool) (sc clients.Client) {
-	sc = &StratumClient{Connectionstring: strings.TrimPrefix(pool.URL, "stratum+tcp://"), User: pool.User, Algo: pool.Algo}
+	if clients.IsNodeURL(pool.URL) {
+		return NewNodeClient(pool)
+	}
+	sc = &StratumClient{Connectionstring: strings.TrimPrefix(pool.URL, "stratum+tcp://"), User: pool.User, Algo: pool.Algo, Record: pool.Record}
 	return
 }
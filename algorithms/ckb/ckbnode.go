@@ -0,0 +1,366 @@
+package ckb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+const (
+	//NodePollInterval is how often the block template is fetched, ckb nodes have no longpoll
+	NodePollInterval = time.Second
+	//NodeRetryInterval is the wait after a failed call to the node
+	NodeRetryInterval = 10 * time.Second
+	//NodeRequestTimeout bounds every call to the node
+	NodeRequestTimeout = 30 * time.Second
+	//NodeExtraNonce1Size is the random part of the nonce in front of the extranonce, rigs mining on the
+	// same node get the same template and would repeat the work of each other without it
+	NodeExtraNonce1Size = 4
+)
+
+//ErrNoBlockTemplate is returned by GetHeaderForWork before the first template arrived
+var ErrNoBlockTemplate = errors.New("No block template received from the ckb node yet")
+
+//nodeWork is a decoded block template
+type nodeWork struct {
+	template BlockTemplate
+	header   RawHeader
+	powHash  []byte
+	target   []byte
+}
+
+//NodeJob is the work of a header handed out by the node client
+type NodeJob struct {
+	ID string
+	//Nonce is the part of the 16 byte nonce set by the client, the boards search the rest
+	Nonce []byte
+
+	work *nodeWork
+}
+
+//NodeClient mines solo on a ckb node through get_block_template and submit_block.
+// The block reward goes to the block assembler configured in the node.
+type NodeClient struct {
+	accept, reject, discard int32
+	lastAccept              int64
+
+	//URL is the 'http://host:port' address of the RPC server of the node
+	URL  string
+	User string
+	Algo string
+
+	rpc    *clients.RPCClient
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex       sync.Mutex // protects following
+	work        *nodeWork
+	jobID       string
+	templates   int
+	extraNonce1 []byte
+	extraNonce  jobs.ExtraNonce2
+	status      types.PoolConnectionStates
+	clients.BaseClient
+}
+
+//NewNodeClient creates a client for the ckb node at the RPC address pool.URL
+func NewNodeClient(pool *types.Pool) *NodeClient {
+	c := &NodeClient{URL: pool.URL, User: pool.User, Algo: pool.Algo, status: types.NotReady}
+	c.rpc = &clients.RPCClient{URL: pool.URL, User: pool.User, Password: pool.Pass, Version: "2.0"}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.extraNonce1 = make([]byte, NodeExtraNonce1Size)
+	rand.Read(c.extraNonce1)
+	return c
+}
+
+//AlgoName returns the algorithm of the node
+func (c *NodeClient) AlgoName() string {
+	return c.Algo
+}
+
+//Start polls the block templates of the node until Stop is called
+func (c *NodeClient) Start() {
+	for c.ctx.Err() == nil {
+		var t BlockTemplate
+		err := c.call("get_block_template", []interface{}{nil, nil, nil}, &t)
+		if err == nil {
+			err = c.setTemplate(t)
+		}
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			log.Println("ERROR get_block_template failed:", err)
+			c.setStatus(types.Sick)
+			c.wait(NodeRetryInterval)
+			continue
+		}
+		c.wait(NodePollInterval)
+	}
+}
+
+//Stop ends Start
+func (c *NodeClient) Stop() {
+	c.cancel()
+}
+
+func (c *NodeClient) wait(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *NodeClient) call(method string, params []interface{}, result interface{}) error {
+	ctx, cancel := context.WithTimeout(c.ctx, NodeRequestTimeout)
+	defer cancel()
+	return c.rpc.Call(ctx, method, params, result)
+}
+
+func (c *NodeClient) setStatus(status types.PoolConnectionStates) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.status = status
+}
+
+//PoolConnectionStates is alive while the node answers
+func (c *NodeClient) PoolConnectionStates() types.PoolConnectionStates {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.status
+}
+
+//GetPoolStats counts the blocks accepted and rejected by the node, the difficulty is the one of the network
+func (c *NodeClient) GetPoolStats() (info types.PoolStates) {
+	info.Status = c.PoolConnectionStates()
+	info.User = c.User
+	info.PoolAddr = c.URL
+	info.Algo = c.Algo
+	info.Accept = atomic.LoadInt32(&c.accept)
+	info.Reject = atomic.LoadInt32(&c.reject)
+	info.Discard = atomic.LoadInt32(&c.discard)
+	info.LastAccepted = atomic.LoadInt64(&c.lastAccept)
+	c.mutex.Lock()
+	if c.work != nil {
+		info.Diff = c.work.difficulty()
+	}
+	c.mutex.Unlock()
+	return
+}
+
+//setTemplate makes t the current job unless the node still hands out the same work.
+// A template on a new parent abandons the jobs of the previous one.
+func (c *NodeClient) setTemplate(t BlockTemplate) error {
+	c.mutex.Lock()
+	same := c.work != nil && c.work.template.WorkID == t.WorkID && t.WorkID != ""
+	c.mutex.Unlock()
+	if same {
+		return nil
+	}
+	w, err := newNodeWork(t)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.work == nil || !bytes.Equal(c.work.header.ParentHash, w.header.ParentHash) {
+		if c.work != nil {
+			log.Println("New block", w.header.Number-1, "on the ckb node, abandoning the jobs of the previous one")
+			atomic.AddInt32(&c.discard, 1)
+		}
+		c.DeprecateOutstandingJobs()
+	}
+	c.templates++
+	c.jobID = strconv.Itoa(c.templates)
+	c.work = w
+	c.extraNonce = jobs.ExtraNonce2{Size: 16 - NodeExtraNonce1Size - jobs.CKBBoardNonceSize}
+	c.status = types.Alive
+	c.AddJobToDeprecate(c.jobID)
+	return nil
+}
+
+//newNodeWork computes the roots and the pow hash of the header of a template
+func newNodeWork(t BlockTemplate) (*nodeWork, error) {
+	var p parser
+	h := RawHeader{
+		Version:       p.uint32("version", t.Version),
+		CompactTarget: p.uint32("compact_target", t.CompactTarget),
+		Timestamp:     p.uint64("current_time", t.CurrentTime),
+		Number:        p.uint64("number", t.Number),
+		Epoch:         p.uint64("epoch", t.Epoch),
+		ParentHash:    p.hash("parent_hash", t.ParentHash),
+		Dao:           p.hash("dao", t.Dao),
+	}
+	var uncles, proposals [][]byte
+	for _, uncle := range t.Uncles {
+		uncles = append(uncles, p.hash("uncles.hash", uncle.Hash))
+	}
+	for _, id := range t.Proposals {
+		b, err := parseBytes(id)
+		if err != nil || len(b) != 10 {
+			p.fail("proposals", id)
+		}
+		proposals = append(proposals, b)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	var txHashes, witnessHashes [][]byte
+	for _, tx := range append([]TransactionTemplate{t.Cellbase}, t.Transactions...) {
+		txHash, witnessHash, err := hashTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		txHashes = append(txHashes, txHash)
+		witnessHashes = append(witnessHashes, witnessHash)
+	}
+	h.TransactionsRoot = MerkleRoot([][]byte{MerkleRoot(txHashes), MerkleRoot(witnessHashes)})
+	h.ProposalsHash = hashOfAll(proposals)
+	h.ExtraHash = hashOfAll(uncles)
+	if t.Extension != nil {
+		extension, err := parseBytes(*t.Extension)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid extension %q in the block template", *t.Extension)
+		}
+		h.ExtraHash = CKBHash(h.ExtraHash, CKBHash(extension))
+	}
+
+	target, err := compactTarget(h.CompactTarget)
+	if err != nil {
+		return nil, err
+	}
+	return &nodeWork{template: t, header: h, powHash: h.PowHash(), target: target}, nil
+}
+
+//hashTransaction returns the transaction and witness hashes of tx. The transaction hash is checked
+// against the one of the node, a mismatch means the serialization is wrong and so would be the block.
+func hashTransaction(tx TransactionTemplate) (txHash, witnessHash []byte, err error) {
+	var decoded Transaction
+	if err = json.Unmarshal(tx.Data, &decoded); err != nil {
+		return nil, nil, fmt.Errorf("Invalid transaction %s in the block template: %v", tx.Hash, err)
+	}
+	raw, err := decoded.SerializeRaw()
+	if err != nil {
+		return
+	}
+	full, err := decoded.Serialize()
+	if err != nil {
+		return
+	}
+	txHash = CKBHash(raw)
+	if tx.Hash != "" && tx.Hash != hexBytes(txHash) {
+		return nil, nil, fmt.Errorf("Transaction %s of the block template hashes to %s", tx.Hash, hexBytes(txHash))
+	}
+	return txHash, CKBHash(full), nil
+}
+
+//compactTarget expands the compact target of a ckb header, which has no sign bit
+func compactTarget(compact uint32) ([]byte, error) {
+	exponent := uint(compact >> 24)
+	mantissa := big.NewInt(int64(compact & 0x00ffffff))
+	if exponent <= 3 {
+		mantissa.Rsh(mantissa, 8*(3-exponent))
+	} else {
+		mantissa.Lsh(mantissa, 8*(exponent-3))
+	}
+	if mantissa.Sign() == 0 {
+		return nil, fmt.Errorf("Compact target %08x is zero", compact)
+	}
+	return difficulty.TargetBytes(mantissa)
+}
+
+//GetHeaderForWork hands out the pow hash of the current template with the next nonce prefix,
+// in the 44 byte layout of the ckb boards
+func (c *NodeClient) GetHeaderForWork() (target []byte, difficulty float64, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.work == nil {
+		err = ErrNoBlockTemplate
+		return
+	}
+	en2, err := c.extraNonce.Take()
+	if err != nil {
+		return
+	}
+	w := c.work
+	j := NodeJob{ID: c.jobID, Nonce: append(append([]byte{}, c.extraNonce1...), en2.Bytes()...), work: w}
+	target = w.target
+	difficulty = w.difficulty()
+	header = append(append([]byte{}, w.powHash...), j.Nonce...)
+	deprecationChannel = c.GetDeprecationChannel(c.jobID)
+	job = j
+	return
+}
+
+//difficulty is the network difficulty of the template
+func (w *nodeWork) difficulty() float64 {
+	return difficulty.FromTarget(difficulty.MaxTarget, w.target)
+}
+
+//SubmitHeader submits the block of a solved header. The pow message is the pow hash and the little
+// endian nonce, the boards found its last 4 bytes.
+func (c *NodeClient) SubmitHeader(nonce []byte, job interface{}) (err error) {
+	j, ok := job.(NodeJob)
+	if !ok || j.work == nil || len(nonce) < 8 {
+		return errors.New("Invalid job submitted")
+	}
+	message := append(append([]byte{}, j.Nonce...), stratum.RevBytes(nonce[4:8])...)
+	block := j.work.block(stratum.RevBytes(message))
+
+	var hash string
+	if err = c.call("submit_block", []interface{}{j.work.template.WorkID, block}, &hash); err != nil {
+		atomic.AddInt32(&c.reject, 1)
+		log.Println("ERROR submit_block at height", j.work.header.Number, "failed:", err)
+		return
+	}
+	atomic.AddInt32(&c.accept, 1)
+	atomic.StoreInt64(&c.lastAccept, time.Now().Unix())
+	log.Println("Block", j.work.header.Number, hash, "accepted by the ckb node")
+	return
+}
+
+//block is the block of the template with the big endian nonce as submit_block takes it
+func (w *nodeWork) block(nonce []byte) map[string]interface{} {
+	uncles := make([]interface{}, 0, len(w.template.Uncles))
+	for _, u := range w.template.Uncles {
+		proposals := u.Proposals
+		if proposals == nil {
+			proposals = []string{}
+		}
+		uncles = append(uncles, map[string]interface{}{"header": u.Header, "proposals": proposals})
+	}
+	transactions := []json.RawMessage{w.template.Cellbase.Data}
+	for _, tx := range w.template.Transactions {
+		transactions = append(transactions, tx.Data)
+	}
+	proposals := w.template.Proposals
+	if proposals == nil {
+		proposals = []string{}
+	}
+	block := map[string]interface{}{
+		"header":       w.header.JSON(nonce),
+		"uncles":       uncles,
+		"transactions": transactions,
+		"proposals":    proposals,
+	}
+	if w.template.Extension != nil {
+		block["extension"] = *w.template.Extension
+	}
+	return block
+}
@@ -0,0 +1,310 @@
+package ckb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+const (
+	secp256k1CodeHash = "0x9bd7e06f3ecf4be0f2fcd2188b23f1b9fcc88e5d4b65a8637b17723bbda3cce8"
+	lockArgs          = "0xb2e61ff569acf041b3c2c17724e2379c581eeac3"
+)
+
+func TestMolecule(t *testing.T) {
+	var p parser
+	script := p.script(Script{CodeHash: secp256k1CodeHash, HashType: "type", Args: lockArgs})
+	//73 bytes: the header with three offsets, the code hash, the hash type and the args with their length
+	expected := "49000000" + "10000000" + "30000000" + "31000000" + secp256k1CodeHash[2:] + "01" + "14000000" + lockArgs[2:]
+	if hex.EncodeToString(script) != expected || p.err != nil {
+		t.Errorf("script %x %v", script, p.err)
+	}
+	p.script(Script{CodeHash: secp256k1CodeHash, HashType: "data3", Args: "0x"})
+	if p.err == nil {
+		t.Error("unknown hash type accepted")
+	}
+	if b, _ := bytesVec(nil); hex.EncodeToString(b) != "04000000" {
+		t.Errorf("empty bytes vector %x", b)
+	}
+	if b, _ := bytesVec([]string{"0x", "0x12"}); hex.EncodeToString(b) != "15000000"+"0c000000"+"10000000"+"00000000"+"0100000012" {
+		t.Errorf("bytes vector %x", b)
+	}
+	if _, err := bytesVec([]string{"12"}); err == nil {
+		t.Error("bytes without 0x accepted")
+	}
+}
+
+func TestCKBMerkleRoot(t *testing.T) {
+	leaves := [][]byte{CKBHash([]byte{0}), CKBHash([]byte{1}), CKBHash([]byte{2})}
+	if !bytes.Equal(MerkleRoot(nil), make([]byte, 32)) {
+		t.Error("root of no leaves")
+	}
+	if !bytes.Equal(MerkleRoot(leaves[:1]), leaves[0]) {
+		t.Error("root of one leaf")
+	}
+	//the complete binary tree puts the first leaf next to the root
+	if !bytes.Equal(MerkleRoot(leaves), CKBHash(CKBHash(leaves[1], leaves[2]), leaves[0])) {
+		t.Error("root of three leaves")
+	}
+}
+
+func TestCompactTarget(t *testing.T) {
+	target, err := compactTarget(0x1e083126)
+	if err != nil || hex.EncodeToString(target[:6]) != "000008312600" || new(big.Int).SetBytes(target[6:]).Sign() != 0 {
+		t.Errorf("target %x %v", target, err)
+	}
+	//the mantissa has no sign bit
+	target, _ = compactTarget(0x20ffffff)
+	if hex.EncodeToString(target[:4]) != "ffffff00" {
+		t.Errorf("target %x", target)
+	}
+	if _, err = compactTarget(0x21010000); err != difficulty.ErrTargetTooHigh {
+		t.Error("target above 256 bits", err)
+	}
+	if _, err = compactTarget(0x1e000000); err == nil {
+		t.Error("zero target accepted")
+	}
+}
+
+func cellbase(number string) Transaction {
+	return Transaction{
+		Version:     "0x0",
+		CellDeps:    []CellDep{},
+		HeaderDeps:  []string{},
+		Inputs:      []CellInput{{Since: number, PreviousOutput: OutPoint{TxHash: "0x" + strings.Repeat("00", 32), Index: "0xffffffff"}}},
+		Outputs:     []CellOutput{{Capacity: "0x18e64efc04", Lock: Script{CodeHash: secp256k1CodeHash, HashType: "type", Args: lockArgs}}},
+		OutputsData: []string{"0x"},
+		Witnesses:   []string{"0x1234"},
+	}
+}
+
+func transfer() Transaction {
+	typeScript := Script{CodeHash: "0x" + strings.Repeat("ab", 32), HashType: "data1", Args: "0x"}
+	return Transaction{
+		Version:     "0x0",
+		CellDeps:    []CellDep{{OutPoint: OutPoint{TxHash: "0x" + strings.Repeat("11", 32), Index: "0x0"}, DepType: "dep_group"}},
+		HeaderDeps:  []string{"0x" + strings.Repeat("22", 32)},
+		Inputs:      []CellInput{{Since: "0x0", PreviousOutput: OutPoint{TxHash: "0x" + strings.Repeat("33", 32), Index: "0x1"}}},
+		Outputs:     []CellOutput{{Capacity: "0x2540be400", Lock: Script{CodeHash: secp256k1CodeHash, HashType: "type", Args: lockArgs}, Type: &typeScript}},
+		OutputsData: []string{"0x0000000000000000"},
+		Witnesses:   []string{"0x55000000"},
+	}
+}
+
+//txTemplate encodes tx as a template transaction with the hash a node would compute
+func txTemplate(t *testing.T, tx Transaction) TransactionTemplate {
+	data, _ := json.Marshal(tx)
+	raw, err := tx.SerializeRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return TransactionTemplate{Hash: hexBytes(CKBHash(raw)), Data: data}
+}
+
+func blockTemplate(t *testing.T, workID, parentHash string) BlockTemplate {
+	return BlockTemplate{
+		Version:       "0x0",
+		CompactTarget: "0x20010000",
+		CurrentTime:   "0x174c45e17a3",
+		Number:        "0x401",
+		Epoch:         "0x7080019000001",
+		ParentHash:    parentHash,
+		Uncles:        []UncleTemplate{},
+		Transactions:  []TransactionTemplate{txTemplate(t, transfer())},
+		Proposals:     []string{"0xa0ef4eb5f4ceeb08a4c8"},
+		Cellbase:      txTemplate(t, cellbase("0x401")),
+		WorkID:        workID,
+		Dao:           "0x" + strings.Repeat("44", 32),
+	}
+}
+
+//stubNode answers get_block_template and submit_block like a ckb node
+type stubNode struct {
+	mutex     sync.Mutex
+	template  BlockTemplate
+	submitted []json.RawMessage
+	workIDs   []string
+}
+
+func (n *stubNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		JSONRPC string            `json:"jsonrpc"`
+		ID      int64             `json:"id"`
+		Method  string            `json:"method"`
+		Params  []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	reply := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	switch {
+	case req.JSONRPC != "2.0":
+		reply["error"] = map[string]interface{}{"code": -32600, "message": "Invalid request"}
+	case req.Method == "get_block_template":
+		reply["result"] = n.template
+	case req.Method == "submit_block" && len(req.Params) == 2:
+		var workID string
+		json.Unmarshal(req.Params[0], &workID)
+		n.workIDs = append(n.workIDs, workID)
+		n.submitted = append(n.submitted, req.Params[1])
+		reply["result"] = "0x" + strings.Repeat("ee", 32)
+	default:
+		reply["error"] = map[string]interface{}{"code": -32601, "message": "Method not found"}
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+//submittedBlock is the part of a submitted block the test checks
+type submittedBlock struct {
+	Header       map[string]string `json:"header"`
+	Transactions []Transaction     `json:"transactions"`
+	Proposals    []string          `json:"proposals"`
+	Uncles       []interface{}     `json:"uncles"`
+}
+
+func TestNodeClient(t *testing.T) {
+	node := &stubNode{template: blockTemplate(t, "0x1", "0x"+strings.Repeat("aa", 32))}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	c := NewClient(&types.Pool{URL: server.URL, User: "rpc", Algo: "ckb"}).(*NodeClient)
+	done := make(chan bool)
+	go func() {
+		c.Start()
+		close(done)
+	}()
+
+	var header []byte
+	var job interface{}
+	var deprecation chan bool
+	var err error
+	for i := 0; i < 200; i++ {
+		if _, _, header, deprecation, job, err = c.GetHeaderForWork(); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header) != 44 {
+		t.Fatalf("header of %d bytes, the boards take 44", len(header))
+	}
+	_, _, next, _, _, _ := c.GetHeaderForWork()
+	if !bytes.Equal(header[:32], next[:32]) || bytes.Equal(header[32:], next[32:]) {
+		t.Error("headers of the same template should differ in the nonce only")
+	}
+
+	boardNonce := []byte{0, 0, 0, 0, 0x12, 0x34, 0x56, 0x78}
+	if err = c.SubmitHeader(boardNonce, job); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.submitted) != 1 || node.workIDs[0] != "0x1" {
+		t.Fatal("submitted", len(node.submitted), "blocks for", node.workIDs)
+	}
+	var block submittedBlock
+	if err = json.Unmarshal(node.submitted[0], &block); err != nil {
+		t.Fatal(err)
+	}
+	checkSubmittedHeader(t, block.Header, header, boardNonce)
+	if len(block.Transactions) != 2 || block.Transactions[1].HeaderDeps[0] != transfer().HeaderDeps[0] || len(block.Proposals) != 1 || block.Uncles == nil {
+		t.Error("block body", block)
+	}
+	if stats := c.GetPoolStats(); stats.Accept != 1 || stats.Diff != 1<<8 {
+		t.Error("pool stats", stats)
+	}
+
+	//the template changes are fed directly, without the polls in between
+	c.Stop()
+	<-done
+	//the node keeps handing out the same work
+	if err = c.setTemplate(blockTemplate(t, "0x1", "0x"+strings.Repeat("bb", 32))); err != nil || c.jobID != "1" {
+		t.Error("same work id gave a new job", c.jobID, err)
+	}
+	if err = c.setTemplate(blockTemplate(t, "0x2", "0x"+strings.Repeat("aa", 32))); err != nil || c.jobID != "2" {
+		t.Error("new work on the same parent", c.jobID, err)
+	}
+	select {
+	case <-deprecation:
+		t.Fatal("job deprecated on the same parent")
+	default:
+	}
+	if err = c.setTemplate(blockTemplate(t, "0x3", "0x"+strings.Repeat("bb", 32))); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-deprecation:
+	default:
+		t.Error("job not deprecated by a new parent")
+	}
+
+	wrong := blockTemplate(t, "0x4", "0x"+strings.Repeat("cc", 32))
+	wrong.Transactions[0].Hash = "0x" + strings.Repeat("00", 32)
+	if err = c.setTemplate(wrong); err == nil {
+		t.Error("template with a transaction hash that does not match accepted")
+	}
+}
+
+//checkSubmittedHeader rebuilds the raw header from the submitted fields, it must hash to the pow hash
+// the boards mined on, and the nonce must be the one the boards hashed with it
+func checkSubmittedHeader(t *testing.T, fields map[string]string, header, boardNonce []byte) {
+	number := func(name string) uint64 {
+		n, err := parseNumber(fields[name], 64)
+		if err != nil {
+			t.Error(name, err)
+		}
+		return n
+	}
+	hash := func(name string) []byte {
+		b, err := parseBytes(fields[name])
+		if err != nil || len(b) != 32 {
+			t.Error(name, fields[name])
+		}
+		return b
+	}
+	raw := RawHeader{
+		Version:          uint32(number("version")),
+		CompactTarget:    uint32(number("compact_target")),
+		Timestamp:        number("timestamp"),
+		Number:           number("number"),
+		Epoch:            number("epoch"),
+		ParentHash:       hash("parent_hash"),
+		TransactionsRoot: hash("transactions_root"),
+		ProposalsHash:    hash("proposals_hash"),
+		ExtraHash:        hash("extra_hash"),
+		Dao:              hash("dao"),
+	}
+	if !bytes.Equal(raw.PowHash(), header[:32]) {
+		t.Error("submitted header does not hash to the pow hash")
+	}
+	if raw.Number != 0x401 || raw.Timestamp != 0x174c45e17a3 || !bytes.Equal(raw.ExtraHash, make([]byte, 32)) {
+		t.Error("header fields", fields)
+	}
+	cb, tx := cellbase("0x401"), transfer()
+	cbRaw, _ := cb.SerializeRaw()
+	cbFull, _ := cb.Serialize()
+	txRaw, _ := tx.SerializeRaw()
+	txFull, _ := tx.Serialize()
+	root := CKBHash(CKBHash(CKBHash(cbRaw), CKBHash(txRaw)), CKBHash(CKBHash(cbFull), CKBHash(txFull)))
+	if !bytes.Equal(raw.TransactionsRoot, root) {
+		t.Error("transactions root")
+	}
+
+	//the pow message after the pow hash is the nonce, little endian, as RegenHash rebuilds it
+	message := append(append([]byte{}, header[32:]...), stratum.RevBytes(boardNonce[4:])...)
+	nonce, ok := new(big.Int).SetString(strings.TrimPrefix(fields["nonce"], "0x"), 16)
+	if !ok || !bytes.Equal(stratum.RevBytes(message), append(make([]byte, 16-len(nonce.Bytes())), nonce.Bytes()...)) {
+		t.Errorf("nonce %s for the pow message %x", fields["nonce"], message)
+	}
+}
@@ -11,6 +11,8 @@ import (
 
 	"github.com/AGPFMiner/gominer/clients"
 	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
+	"github.com/AGPFMiner/gominer/difficulty"
 	"github.com/AGPFMiner/gominer/types"
 )
 
@@ -22,14 +24,7 @@ const (
 //Target declares what a solution should be smaller than to be accepted
 type Target [HashSize]byte
 
-type stratumJob struct {
-	JobID       string
-	headerHash  string
-	height      int
-	parentHash  string
-	ExtraNonce2 stratum.ExtraNonce2
-	CleanJobs   bool
-}
+type stratumJob = jobs.CKBJob
 
 //StratumClient is a ckb client using the stratum protocol
 type StratumClient struct {
@@ -38,6 +33,7 @@ type StratumClient struct {
 	Connectionstring        string
 	User, Password          string
 	Algo                    string
+	Record                  string     // file the stratum session is recorded to, empty for none
 	mutex                   sync.Mutex // protects following
 	stratumclient           *stratum.Client
 	target                  Target
@@ -111,18 +107,25 @@ func (sc *StratumClient) PoolConnectionStates() types.PoolConnectionStates {
 //startPoolConn connects to the stratumserver and processes the notifications
 func (sc *StratumClient) startPoolConn() {
 	sc.DeprecateOutstandingJobs()
+	sc.RecordStart()
 
-	sc.stratumclient = &stratum.Client{}
+	sc.stratumclient = &stratum.Client{Record: sc.Record}
 	//In case of an error, drop the current stratumclient and restart
 	sc.stratumclient.ErrorCallback = func(err error) {
 	}
+	sc.stratumclient.UnhandledNotification = func(method interface{}, params []interface{}) {
+		sc.RecordOddity("unexpected notification %v", method)
+	}
 
 	sc.subscribeToStratumDifficultyChanges()
 	sc.subscribeToStratumJobNotifications()
 
 	//Connect to the stratum server
 	log.Println("Connecting to", sc.Connectionstring)
-	sc.stratumclient.Dial(sc.Connectionstring)
+	if err := sc.stratumclient.Dial(sc.Connectionstring); err != nil {
+		return
+	}
+	sc.RecordConnected()
 
 	//Subscribe for mining
 	//Close the connection on an error will cause the client to generate an error, resulting in te errorhandler to be triggered
@@ -130,16 +133,38 @@ func (sc *StratumClient) startPoolConn() {
 	result, err := sc.stratumclient.Call("mining.subscribe", []interface{}{"AGPFminer", nil})
 	if err != nil {
 		log.Println("ERROR Error in response from stratum:", err)
+		sc.RecordOddity("mining.subscribe failed: %v", err)
+		sc.stratumclient.Close()
+		return
+	}
+	stratumRes, ok := result.([]interface{})
+	if !ok || len(stratumRes) < 3 {
+		log.Println("ERROR Invalid response from stratum:", result)
+		sc.RecordOddity("invalid mining.subscribe reply %v", result)
 		sc.stratumclient.Close()
 		return
 	}
-	stratumRes := result.([]interface{})
 	log.Println(stratumRes)
-	sc.nonce2Size = uint(stratumRes[2].(float64))
-	sc.nonce1 = stratumRes[1].(string)
+	nonce1, ok1 := stratumRes[1].(string)
+	nonce2Size, ok2 := stratumRes[2].(float64)
+	//the fpga returns 4 bytes of the extranonce2
+	if !ok1 || !ok2 || nonce2Size < jobs.CKBBoardNonceSize {
+		log.Println("ERROR Invalid extranonce from stratum:", stratumRes[1], stratumRes[2])
+		sc.RecordOddity("invalid extranonce1 %v or extranonce2_size %v, the miner needs at least 4 bytes", stratumRes[1], stratumRes[2])
+		sc.stratumclient.Close()
+		return
+	}
+	//Jobs may arrive before the reply is processed, they get the extranonce2 size here
+	sc.mutex.Lock()
+	sc.nonce2Size = uint(nonce2Size)
+	sc.nonce1 = nonce1
+	sc.currentJob.ExtraNonce2.Size = sc.nonce2Size - jobs.CKBBoardNonceSize
+	sc.mutex.Unlock()
+	sc.RecordSubscribe(nonce1, int(nonce2Size))
 
 	go func() {
 		result, err = sc.stratumclient.Call("mining.authorize", []string{sc.User, sc.Password})
+		sc.RecordAuthorize(result, err)
 		if err != nil {
 			log.Println("Unable to authorize:", err)
 			return
@@ -148,61 +173,48 @@ func (sc *StratumClient) startPoolConn() {
 	}()
 }
 
-// var diff1, _ = big.NewInt(0).SetString("0x00000000FFFF0000000000000000000000000000000000000000000000000000", 0)
-
 func (sc *StratumClient) subscribeToStratumDifficultyChanges() {
 	sc.stratumclient.SetNotificationHandler("mining.set_target", func(params []interface{}, result interface{}) {
+		if len(params) < 1 {
+			log.Print("missing target")
+			sc.RecordOddity("mining.set_target without a target")
+			return
+		}
 		targetStr, ok := params[0].(string)
 		if !ok {
 			log.Print("invalid target string")
+			sc.RecordOddity("invalid target %v", params[0])
 			return
 		}
 		target, err := hex.DecodeString(targetStr)
-		if err != nil {
+		if err != nil || len(target) != HashSize {
 			log.Print("unable to decode target")
+			sc.RecordOddity("invalid target %q, expected %d hex bytes", targetStr, HashSize)
 			return
 		}
 
 		log.Println("Stratum server changed target to", targetStr)
-		for i := 0; i < 32; i++ {
-			sc.target[i] = target[i]
-		}
-		// targetInt := big.NewInt(0).SetBytes(target).Uint64()
-		// sc.Difficulty = float64(diff1.Uint64()) / float64(targetInt)
+		sc.mutex.Lock()
+		copy(sc.target[:], target)
+		sc.Difficulty = difficulty.FromTarget(difficulty.MaxTarget, target)
+		sc.mutex.Unlock()
+		sc.RecordTarget(targetStr)
 	})
 }
 
 func (sc *StratumClient) subscribeToStratumJobNotifications() {
 	sc.stratumclient.SetNotificationHandler("mining.notify", func(params []interface{}, result interface{}) {
-		sj := stratumJob{}
-		if len(params) < 2 {
+		sc.RecordNotify()
+		if len(params) < jobs.CKBNotifyParams {
 			log.Print("invalid params")
+			sc.RecordOddity("mining.notify has %d parameters, expected %d", len(params), jobs.CKBNotifyParams)
 			return
 		}
-		jobID, ok := params[0].(string)
-		if !ok {
-			log.Print("invalid jobId")
-			return
-		}
-		sj.JobID = jobID
-
-		powHash, ok := params[1].(string)
-		_, err := hex.DecodeString(powHash)
-		if !ok || err != nil {
-			log.Print("invalid powHash")
-			return
-		}
-
-		cleanJob, ok := params[4].(bool)
-		if !ok {
-			log.Print("invalid cleanJob req")
+		sj, err := jobs.ParseCKBNotify(params)
+		if err != nil {
+			log.Print(err)
 			return
 		}
-
-		sj.headerHash = powHash
-		sj.CleanJobs = cleanJob
-		sj.ExtraNonce2.Size = sc.nonce2Size - 4 //fpga returns 4 bytes
-
 		sc.addNewStratumJob(sj)
 	})
 }
@@ -210,12 +222,16 @@ func (sc *StratumClient) subscribeToStratumJobNotifications() {
 func (sc *StratumClient) addNewStratumJob(sj stratumJob) {
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
+	if sc.nonce2Size >= jobs.CKBBoardNonceSize {
+		sj.ExtraNonce2.Size = sc.nonce2Size - jobs.CKBBoardNonceSize
+	}
 	sc.currentJob = sj
 	if sj.CleanJobs {
 		sc.discard++
 		sc.DeprecateOutstandingJobs()
 	}
 	sc.AddJobToDeprecate(sj.JobID)
+	sc.RecordJob()
 }
 
 //GetHeaderForWork fetches new work from the stratum pool
@@ -232,18 +248,16 @@ func (sc *StratumClient) GetHeaderForWork() (target []byte, difficulty float64,
 	deprecationChannel = sc.GetDeprecationChannel(sc.currentJob.JobID)
 
 	target = sc.target[:]
-	difficulty = -999
-	headerHashDecoded, _ := hex.DecodeString(sc.currentJob.headerHash)
+	difficulty = sc.Difficulty
+	en2, err := sc.currentJob.ExtraNonce2.Take()
+	if err != nil {
+		return
+	}
+	sj := sc.currentJob
+	sj.ExtraNonce2 = en2
+	job = sj
 	nonce1Decoded, _ := hex.DecodeString(sc.nonce1)
-	header = make([]byte, 0, 44)
-
-	header = append(header, headerHashDecoded...)
-	header = append(header, nonce1Decoded...)
-	header = append(header, sc.currentJob.ExtraNonce2.Bytes()...)
-	sc.currentJob.ExtraNonce2.Increment()
-
-	// header, _ = hex.DecodeString("d5a74fba920ad0d35ec5726f26327547cbc82180e356e5ccf6cf2e6bd75f8a6600c904bd0000000000000000")
-
+	header = sj.Header(nonce1Decoded)
 	return
 }
 
@@ -1,11 +1,13 @@
 package ckb
 
 import (
+	"encoding/hex"
 	"log"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/AGPFMiner/gominer/clients/pooltest"
 	"github.com/AGPFMiner/gominer/types"
 )
 
@@ -17,27 +19,62 @@ var ckbPool = &types.Pool{
 }
 
 func TestGetHeaderForWork(t *testing.T) {
+	mock := pooltest.NewMockPool("ckb")
+	if err := mock.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	c := NewClient(&types.Pool{URL: mock.URL(), User: "worker", Pass: "x", Algo: "ckb"})
+	go c.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		target, _, header, _, job, err := c.GetHeaderForWork()
+		if err != nil {
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		//the pow hash of the job, the extranonce1 of the subscription and the 8 bytes of the 12 byte
+		// extranonce2 the boards do not fill
+		expected := "6e2b1a5c3d4f5e6a7b8c9d0e1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c" + "e5f8c0a1"
+		if len(header) != 44 || hex.EncodeToString(header[:36]) != expected {
+			t.Errorf("header %02x", header)
+		}
+		if hex.EncodeToString(target) != "00000000ffff0000000000000000000000000000000000000000000000000000" {
+			t.Errorf("target %02x", target)
+		}
+		if sj, ok := job.(stratumJob); !ok || sj.JobID != "1" {
+			t.Error("job", job)
+		}
+		return
+	}
+}
+
+func TestLivePool(t *testing.T) {
+	if testing.Short() || !pooltest.Live() {
+		t.Skipf("set %s to test against %s", pooltest.LiveEnv, ckbPool.URL)
+	}
 	pool := ckbPool
 	c := &StratumClient{Connectionstring: strings.TrimPrefix(pool.URL, "stratum+tcp://"), User: pool.User, Password: pool.Pass}
-	c.SetDeprecatedJobCall(func(jobid string) {
+	c.SubscribeJobs(t, func(jobid string) {
 
-	})
+	}, nil)
 	go c.Start()
 
+	deadline := time.Now().Add(30 * time.Second)
 	for {
 		_, _, header, _, job, err := c.GetHeaderForWork()
 		if err != nil {
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(500 * time.Millisecond)
 			continue
 		}
 		log.Printf("header: %02x, err: %v, job: %v\n", header, err, job)
-
-		time.Sleep(500 * time.Millisecond)
+		return
 	}
 }
-
-func TestCKBStratum(t *testing.T) {
-	pool := ckbPool
-
-	sc := &StratumClient{Connectionstring: strings.TrimPrefix(pool.URL, "stratum+tcp://"), User: pool.User, Password: pool.Pass}
-	sc.Start()
-}
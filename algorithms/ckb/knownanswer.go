@@ -0,0 +1,22 @@
+package ckb
+
+import (
+	"encoding/hex"
+
+	"github.com/AGPFMiner/gominer/driver"
+)
+
+//KnownAnswer is the pow hash of TestEaglesongHash with an empty extranonce, the board
+// returns 0071E05D for it which gives the golden hash 000000C2F320C154...
+var KnownAnswer = driver.KnownAnswer{
+	Header: mustDecode("d5a74fba920ad0d35ec5726f26327547cbc82180e356e5ccf6cf2e6bd75f8a66" + "00c904bd" + "0000000000000000"),
+	Nonce:  [8]byte{0, 0, 0, 0, 0x5d, 0xe0, 0x71, 0x00},
+}
+
+func mustDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -4,10 +4,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"log"
-	"math/big"
 	"time"
 
 	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/difficulty"
 	"github.com/AGPFMiner/gominer/driver"
 
 	"github.com/AGPFMiner/gominer/mining"
@@ -43,11 +43,9 @@ func RegenHash(input []byte) (output []byte) {
 	return
 }
 
+//DiffChecker tells whether the big endian pow hash meets the target set by the pool
 func DiffChecker(hash []byte, work driver.MiningWork) bool {
-	targetInt := big.NewInt(0).SetBytes(work.Target)
-	hashInt := big.NewInt(0).SetBytes(hash)
-	log.Printf("target: %02x, hash: %02x\n", targetInt.Bytes(), hashInt.Bytes())
-	return hashInt.Cmp(targetInt) < 0
+	return difficulty.MeetsTarget(hash, work.Target)
 }
 
 //Halt stops all miners
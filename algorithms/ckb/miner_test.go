@@ -4,6 +4,8 @@ import (
 	"encoding/hex"
 	"log"
 	"testing"
+
+	"github.com/AGPFMiner/gominer/driver"
 )
 
 func TestRegenHash(t *testing.T) {
@@ -11,3 +13,19 @@ func TestRegenHash(t *testing.T) {
 	hash := RegenHash(header)
 	log.Printf("%02X\n", hash)
 }
+
+func TestDiffChecker(t *testing.T) {
+	//the golden hash of KnownAnswer
+	hash, _ := hex.DecodeString("000000c2f320c154" + "ffffffffffffffffffffffffffffffffffffffffffffffff")
+	target, _ := hex.DecodeString("0000002af31dc4611873bf3f70834acdae9f0f4f534f5d60585a5f1c1a3ced1b")
+	if DiffChecker(hash, driver.MiningWork{Target: target}) {
+		t.Error("hash accepted at difficulty 1e8")
+	}
+	target[2] = 0x01
+	if !DiffChecker(hash, driver.MiningWork{Target: target}) {
+		t.Error("hash rejected below its target")
+	}
+	if DiffChecker(hash, driver.MiningWork{}) {
+		t.Error("hash accepted without a target")
+	}
+}
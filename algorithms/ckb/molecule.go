@@ -0,0 +1,373 @@
+package ckb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+//The JSON types of the ckb RPC. Numbers are 0x prefixed hex strings, hashes and bytes 0x prefixed hex.
+
+//Script is a lock or type script
+type Script struct {
+	CodeHash string `json:"code_hash"`
+	HashType string `json:"hash_type"`
+	Args     string `json:"args"`
+}
+
+//OutPoint is the output of a transaction
+type OutPoint struct {
+	TxHash string `json:"tx_hash"`
+	Index  string `json:"index"`
+}
+
+//CellDep is a cell a transaction depends on
+type CellDep struct {
+	OutPoint OutPoint `json:"out_point"`
+	DepType  string   `json:"dep_type"`
+}
+
+//CellInput is an input of a transaction
+type CellInput struct {
+	Since          string   `json:"since"`
+	PreviousOutput OutPoint `json:"previous_output"`
+}
+
+//CellOutput is an output of a transaction
+type CellOutput struct {
+	Capacity string  `json:"capacity"`
+	Lock     Script  `json:"lock"`
+	Type     *Script `json:"type"`
+}
+
+//Transaction is a transaction as the RPC encodes it
+type Transaction struct {
+	Version     string       `json:"version"`
+	CellDeps    []CellDep    `json:"cell_deps"`
+	HeaderDeps  []string     `json:"header_deps"`
+	Inputs      []CellInput  `json:"inputs"`
+	Outputs     []CellOutput `json:"outputs"`
+	OutputsData []string     `json:"outputs_data"`
+	Witnesses   []string     `json:"witnesses"`
+}
+
+//TransactionTemplate is a transaction of a block template, Data is kept as sent for submit_block
+type TransactionTemplate struct {
+	Hash string          `json:"hash"`
+	Data json.RawMessage `json:"data"`
+}
+
+//UncleTemplate is an uncle of a block template
+type UncleTemplate struct {
+	Hash      string          `json:"hash"`
+	Proposals []string        `json:"proposals"`
+	Header    json.RawMessage `json:"header"`
+}
+
+//BlockTemplate is the reply of get_block_template, the fields the client uses
+type BlockTemplate struct {
+	Version       string                `json:"version"`
+	CompactTarget string                `json:"compact_target"`
+	CurrentTime   string                `json:"current_time"`
+	Number        string                `json:"number"`
+	Epoch         string                `json:"epoch"`
+	ParentHash    string                `json:"parent_hash"`
+	Uncles        []UncleTemplate       `json:"uncles"`
+	Transactions  []TransactionTemplate `json:"transactions"`
+	Proposals     []string              `json:"proposals"`
+	Cellbase      TransactionTemplate   `json:"cellbase"`
+	WorkID        string                `json:"work_id"`
+	Dao           string                `json:"dao"`
+	//Extension is only sent by nodes of the 2021 edition and later, it is committed in the extra hash
+	Extension *string `json:"extension,omitempty"`
+}
+
+//RawHeader is a header without its nonce, the pow hash is the hash of its molecule serialization
+type RawHeader struct {
+	Version          uint32
+	CompactTarget    uint32
+	Timestamp        uint64
+	Number           uint64
+	Epoch            uint64
+	ParentHash       []byte
+	TransactionsRoot []byte
+	ProposalsHash    []byte
+	ExtraHash        []byte
+	Dao              []byte
+}
+
+//Serialize is the molecule struct of the header, its fields in order with the numbers little endian
+func (h *RawHeader) Serialize() []byte {
+	b := make([]byte, 0, 192)
+	b = appendUint32(b, h.Version)
+	b = appendUint32(b, h.CompactTarget)
+	b = appendUint64(b, h.Timestamp)
+	b = appendUint64(b, h.Number)
+	b = appendUint64(b, h.Epoch)
+	for _, hash := range [][]byte{h.ParentHash, h.TransactionsRoot, h.ProposalsHash, h.ExtraHash, h.Dao} {
+		b = append(b, byte32(hash)...)
+	}
+	return b
+}
+
+//PowHash is the hash the boards mine on with the nonce appended
+func (h *RawHeader) PowHash() []byte {
+	return CKBHash(h.Serialize())
+}
+
+//JSON is the header with nonce as submit_block takes it
+func (h *RawHeader) JSON(nonce []byte) map[string]string {
+	return map[string]string{
+		"version":           hexNumber(uint64(h.Version)),
+		"compact_target":    hexNumber(uint64(h.CompactTarget)),
+		"timestamp":         hexNumber(h.Timestamp),
+		"number":            hexNumber(h.Number),
+		"epoch":             hexNumber(h.Epoch),
+		"parent_hash":       hexBytes(h.ParentHash),
+		"transactions_root": hexBytes(h.TransactionsRoot),
+		"proposals_hash":    hexBytes(h.ProposalsHash),
+		"extra_hash":        hexBytes(h.ExtraHash),
+		"dao":               hexBytes(h.Dao),
+		"nonce":             "0x" + new(big.Int).SetBytes(nonce).Text(16),
+	}
+}
+
+//MerkleRoot is the root of the complete binary merkle tree ckb builds over leaves,
+// the zero hash for no leaves
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return make([]byte, 32)
+	}
+	n := len(leaves)
+	nodes := make([][]byte, 2*n-1)
+	copy(nodes[n-1:], leaves)
+	for i := n - 2; i >= 0; i-- {
+		nodes[i] = CKBHash(nodes[2*i+1], nodes[2*i+2])
+	}
+	return nodes[0]
+}
+
+//hashOfAll is the hash of the concatenated items, the zero hash for none as ckb does for
+// the proposals and uncles hashes
+func hashOfAll(items [][]byte) []byte {
+	if len(items) == 0 {
+		return make([]byte, 32)
+	}
+	return CKBHash(items...)
+}
+
+//Serialize is the molecule table of the transaction, its hash is the witness hash
+func (tx *Transaction) Serialize() ([]byte, error) {
+	raw, err := tx.SerializeRaw()
+	if err != nil {
+		return nil, err
+	}
+	witnesses, err := bytesVec(tx.Witnesses)
+	if err != nil {
+		return nil, err
+	}
+	return table(raw, witnesses), nil
+}
+
+//SerializeRaw is the molecule table of the transaction without its witnesses, its hash is the transaction hash
+func (tx *Transaction) SerializeRaw() ([]byte, error) {
+	var p parser
+	version := appendUint32(nil, p.uint32("version", tx.Version))
+
+	var cellDeps []byte
+	for _, dep := range tx.CellDeps {
+		cellDeps = append(cellDeps, p.outPoint(dep.OutPoint)...)
+		cellDeps = append(cellDeps, p.depType(dep.DepType))
+	}
+	var headerDeps []byte
+	for _, hash := range tx.HeaderDeps {
+		headerDeps = append(headerDeps, p.hash("header_deps", hash)...)
+	}
+	var inputs []byte
+	for _, input := range tx.Inputs {
+		inputs = appendUint64(inputs, p.uint64("since", input.Since))
+		inputs = append(inputs, p.outPoint(input.PreviousOutput)...)
+	}
+	var outputs [][]byte
+	for _, output := range tx.Outputs {
+		capacity := appendUint64(nil, p.uint64("capacity", output.Capacity))
+		var typeScript []byte
+		if output.Type != nil {
+			typeScript = p.script(*output.Type)
+		}
+		outputs = append(outputs, table(capacity, p.script(output.Lock), typeScript))
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	outputsData, err := bytesVec(tx.OutputsData)
+	if err != nil {
+		return nil, err
+	}
+	return table(
+		version,
+		fixVec(len(tx.CellDeps), cellDeps),
+		fixVec(len(tx.HeaderDeps), headerDeps),
+		fixVec(len(tx.Inputs), inputs),
+		table(outputs...),
+		outputsData,
+	), nil
+}
+
+//parser decodes the fields of a transaction, keeping the first error
+type parser struct {
+	err error
+}
+
+func (p *parser) fail(name, value string) {
+	if p.err == nil {
+		p.err = fmt.Errorf("Invalid %s %q in the block template", name, value)
+	}
+}
+
+func (p *parser) uint64(name, s string) uint64 {
+	n, err := parseNumber(s, 64)
+	if err != nil {
+		p.fail(name, s)
+	}
+	return n
+}
+
+func (p *parser) uint32(name, s string) uint32 {
+	n, err := parseNumber(s, 32)
+	if err != nil {
+		p.fail(name, s)
+	}
+	return uint32(n)
+}
+
+func (p *parser) hash(name, s string) []byte {
+	b, err := parseBytes(s)
+	if err != nil || len(b) != 32 {
+		p.fail(name, s)
+		return make([]byte, 32)
+	}
+	return b
+}
+
+func (p *parser) outPoint(o OutPoint) []byte {
+	return appendUint32(p.hash("tx_hash", o.TxHash), p.uint32("index", o.Index))
+}
+
+func (p *parser) depType(s string) byte {
+	switch s {
+	case "code":
+		return 0
+	case "dep_group":
+		return 1
+	}
+	p.fail("dep_type", s)
+	return 0
+}
+
+//script serializes the Script table, hash types are the ones of the 2023 edition
+func (p *parser) script(s Script) []byte {
+	var hashType byte
+	switch s.HashType {
+	case "data":
+		hashType = 0
+	case "type":
+		hashType = 1
+	case "data1":
+		hashType = 2
+	case "data2":
+		hashType = 4
+	default:
+		p.fail("hash_type", s.HashType)
+	}
+	args, err := parseBytes(s.Args)
+	if err != nil {
+		p.fail("args", s.Args)
+	}
+	return table(p.hash("code_hash", s.CodeHash), []byte{hashType}, fixVec(len(args), args))
+}
+
+//table serializes a molecule table, or a vector of items of variable size: the total size and the
+// offset of every field in front of the fields
+func table(fields ...[]byte) []byte {
+	headerSize := 4 * (1 + len(fields))
+	size := headerSize
+	for _, f := range fields {
+		size += len(f)
+	}
+	b := appendUint32(make([]byte, 0, size), uint32(size))
+	offset := headerSize
+	for _, f := range fields {
+		b = appendUint32(b, uint32(offset))
+		offset += len(f)
+	}
+	for _, f := range fields {
+		b = append(b, f...)
+	}
+	return b
+}
+
+//fixVec serializes a molecule vector of n items of a fixed size
+func fixVec(n int, items []byte) []byte {
+	return append(appendUint32(nil, uint32(n)), items...)
+}
+
+//bytesVec serializes hex strings as a vector of Bytes
+func bytesVec(items []string) ([]byte, error) {
+	var fields [][]byte
+	for _, item := range items {
+		b, err := parseBytes(item)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bytes %q in the block template", item)
+		}
+		fields = append(fields, fixVec(len(b), b))
+	}
+	return table(fields...), nil
+}
+
+func appendUint32(b []byte, n uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], n)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, n uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], n)
+	return append(b, buf[:]...)
+}
+
+//byte32 pads a hash to 32 bytes, a missing one is zero
+func byte32(hash []byte) []byte {
+	b := make([]byte, 32)
+	copy(b, hash)
+	return b
+}
+
+//parseNumber decodes a 0x prefixed hex number of the RPC
+func parseNumber(s string, bitSize int) (uint64, error) {
+	if !strings.HasPrefix(s, "0x") {
+		return 0, fmt.Errorf("Number %q without 0x", s)
+	}
+	return strconv.ParseUint(s[2:], 16, bitSize)
+}
+
+//parseBytes decodes 0x prefixed hex bytes of the RPC
+func parseBytes(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, "0x") {
+		return nil, fmt.Errorf("Bytes %q without 0x", s)
+	}
+	return hex.DecodeString(s[2:])
+}
+
+func hexNumber(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+func hexBytes(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"log"
-	"math/big"
 	"reflect"
 	"sync"
 	"sync/atomic"
@@ -12,6 +11,8 @@ import (
 
 	"github.com/AGPFMiner/gominer/clients"
 	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
+	"github.com/AGPFMiner/gominer/difficulty"
 	"github.com/AGPFMiner/gominer/types"
 )
 
@@ -23,18 +24,8 @@ const (
 //Target declares what a solution should be smaller than to be accepted
 type Target [HashSize]byte
 
-type StratumJob struct {
-	JobID        string
-	PrevHash     []byte
-	Coinbase1    []byte
-	Coinbase2    []byte
-	MerkleBranch [][]byte
-	Version      []byte
-	NBits        []byte
-	NTime        []byte
-	CleanJobs    bool
-	ExtraNonce2  stratum.ExtraNonce2
-}
+//StratumJob is a job of the pool, skunk and odocrypt pools send bitcoin style jobs
+type StratumJob = jobs.BitcoinJob
 
 //StratumClient is a client using the stratum protocol
 type StratumClient struct {
@@ -45,11 +36,14 @@ type StratumClient struct {
 	User             string
 	Password         string
 	Algo             string
+	//Record is the file the stratum session is recorded to, empty for none
+	Record string
 
 	mutex           sync.Mutex // protects following
 	stratumclient   *stratum.Client
 	extranonce1     []byte
 	extranonce2Size uint
+	reserved        uint
 	target          Target
 	Difficulty      float64
 	currentJob      StratumJob
@@ -114,11 +108,15 @@ func (sc *StratumClient) PoolConnectionStates() types.PoolConnectionStates {
 //startPoolConn connects to the stratumserver and processes the notifications
 func (sc *StratumClient) startPoolConn() {
 	sc.DeprecateOutstandingJobs()
+	sc.RecordStart()
 
-	sc.stratumclient = &stratum.Client{}
+	sc.stratumclient = &stratum.Client{Record: sc.Record}
 	//In case of an error, drop the current stratumclient and restart
 	sc.stratumclient.ErrorCallback = func(err error) {
 	}
+	sc.stratumclient.UnhandledNotification = func(method interface{}, params []interface{}) {
+		sc.RecordOddity("unexpected notification %v", method)
+	}
 
 	sc.subscribeToStratumDifficultyChanges()
 	sc.subscribeToStratumJobNotifications()
@@ -129,36 +127,53 @@ func (sc *StratumClient) startPoolConn() {
 	if err != nil {
 		return
 	}
+	sc.RecordConnected()
 
 	//Subscribe for mining
 	//Close the connection on an error will cause the client to generate an error, resulting in te errorhandler to be triggered
 	result, err := sc.stratumclient.Call("mining.subscribe", []string{"AGPFminer"})
 	if err != nil {
 		log.Println("ERROR Error in response from stratum:", err)
+		sc.RecordOddity("mining.subscribe failed: %v", err)
 		return
 	}
 	reply, ok := result.([]interface{})
 	if !ok || len(reply) < 3 {
 		log.Println("ERROR Invalid response from stratum:", result)
+		sc.RecordOddity("invalid mining.subscribe reply %v", result)
 		return
 	}
 
 	//Keep the extranonce1 and extranonce2_size from the reply
-	if sc.extranonce1, err = stratum.HexStringToBytes(reply[1]); err != nil {
+	extranonce1, err := stratum.HexStringToBytes(reply[1])
+	if err != nil {
 		log.Println("ERROR Invalid extrannonce1 from startum")
+		sc.RecordOddity("invalid extranonce1 %v", reply[1])
 		return
 	}
 
 	extranonce2Size, ok := reply[2].(float64)
 	if !ok {
 		log.Println("ERROR Invalid extranonce2_size from stratum", reply[2], "type", reflect.TypeOf(reply[2]))
+		sc.RecordOddity("invalid extranonce2_size %v", reply[2])
 		return
 	}
+	//Jobs may arrive before the reply is processed, they get the extranonce2 size here
+	sc.mutex.Lock()
+	sc.extranonce1 = extranonce1
 	sc.extranonce2Size = uint(extranonce2Size)
+	sc.currentJob.ExtraNonce2.Size = sc.extranonce2Size
+	sc.currentJob.ExtraNonce2.Reserved = sc.reserved
+	sc.mutex.Unlock()
+	sc.RecordSubscribe(hex.EncodeToString(extranonce1), int(extranonce2Size))
+	if extranonce2Size == 0 {
+		sc.RecordOddity("extranonce2_size is 0, a job yields a single header")
+	}
 
 	//Authorize the miner
 	go func() {
 		result, err = sc.stratumclient.Call("mining.authorize", []string{sc.User, sc.Password})
+		sc.RecordAuthorize(result, err)
 		if err != nil {
 			log.Println("Unable to authorize:", err)
 			return
@@ -177,73 +192,27 @@ func (sc *StratumClient) subscribeToStratumDifficultyChanges() {
 		diff, ok := params[0].(float64)
 		if !ok {
 			log.Println("ERROR Invalid difficulty supplied by stratum server:", params[0])
+			sc.RecordOddity("invalid difficulty %v", params[0])
 			return
 		}
+		sc.RecordDifficulty(diff)
 		log.Println("Stratum server changed difficulty to", diff)
 		sc.setDifficulty(diff)
-		sc.Difficulty = diff
 	})
 }
 
 func (sc *StratumClient) subscribeToStratumJobNotifications() {
 	sc.stratumclient.SetNotificationHandler("mining.notify", func(params []interface{}, result interface{}) {
 		// log.Println("New job received from stratum server")
-		if params == nil || len(params) < 9 {
+		sc.RecordNotify()
+		if len(params) < jobs.BitcoinNotifyParams {
 			log.Println("ERROR Wrong number of parameters supplied by stratum server")
+			sc.RecordOddity("mining.notify has %d parameters, expected %d", len(params), jobs.BitcoinNotifyParams)
 			return
 		}
-
-		sj := StratumJob{}
-
-		sj.ExtraNonce2.Size = sc.extranonce2Size
-
-		var ok bool
-		var err error
-		if sj.JobID, ok = params[0].(string); !ok {
-			log.Println("ERROR Wrong job_id parameter supplied by stratum server")
-			return
-		}
-		if sj.PrevHash, err = stratum.HexStringToBytes(params[1]); err != nil {
-			log.Println("ERROR Wrong prevhash parameter supplied by stratum server")
-			return
-		}
-		if sj.Coinbase1, err = stratum.HexStringToBytes(params[2]); err != nil {
-			log.Println("ERROR Wrong coinb1 parameter supplied by stratum server")
-			return
-		}
-		if sj.Coinbase2, err = stratum.HexStringToBytes(params[3]); err != nil {
-			log.Println("ERROR Wrong coinb2 parameter supplied by stratum server")
-			return
-		}
-
-		//Convert the merklebranch parameter
-		merklebranch, ok := params[4].([]interface{})
-		if !ok {
-			log.Println("ERROR Wrong merkle_branch parameter supplied by stratum server")
-			return
-		}
-		sj.MerkleBranch = make([][]byte, len(merklebranch), len(merklebranch))
-		for i, branch := range merklebranch {
-			if sj.MerkleBranch[i], err = stratum.HexStringToBytes(branch); err != nil {
-				log.Println("ERROR Wrong merkle_branch parameter supplied by stratum server")
-				return
-			}
-		}
-
-		if sj.Version, err = stratum.HexStringToBytes(params[5]); err != nil {
-			log.Println("ERROR Wrong version parameter supplied by stratum server")
-			return
-		}
-		if sj.NBits, err = stratum.HexStringToBytes(params[6]); err != nil {
-			log.Println("ERROR Wrong nbits parameter supplied by stratum server")
-			return
-		}
-		if sj.NTime, err = stratum.HexStringToBytes(params[7]); err != nil {
-			log.Println("ERROR Wrong ntime parameter supplied by stratum server")
-			return
-		}
-		if sj.CleanJobs, ok = params[8].(bool); !ok {
-			log.Println("ERROR Wrong clean_jobs parameter supplied by stratum server")
+		sj, err := jobs.ParseBitcoinNotify(params)
+		if err != nil {
+			log.Println("ERROR", err, "supplied by stratum server")
 			return
 		}
 		sc.addNewStratumJob(sj)
@@ -253,59 +222,29 @@ func (sc *StratumClient) subscribeToStratumJobNotifications() {
 func (sc *StratumClient) addNewStratumJob(sj StratumJob) {
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
+	sj.ExtraNonce2.Size = sc.extranonce2Size
+	sj.ExtraNonce2.Reserved = sc.reserved
 	sc.currentJob = sj
 	if sj.CleanJobs {
 		sc.discard++
 		sc.DeprecateOutstandingJobs()
 	}
 	sc.AddJobToDeprecate(sj.JobID)
+	sc.RecordJob()
 }
 
-// IntToTarget converts a big.Int to a Target.
-func intToTarget(i *big.Int) (t Target, err error) {
-	// Check for negatives.
-	if i.Sign() < 0 {
-		err = errors.New("Negative target")
-		return
-	}
-	// In the event of overflow, return the maximum.
-	if i.BitLen() > 256 {
-		err = errors.New("Target is too high")
-		return
-	}
-	b := i.Bytes()
-	offset := len(t[:]) - len(b)
-	copy(t[offset:], b)
-	return
-}
-
-func difficultyToTarget(difficulty float64) (target Target, err error) {
-	diffAsBig := big.NewFloat(difficulty)
-
-	diffOneString := "0x00000000FFFF0000000000000000000000000000000000000000000000000000"
-	targetOneAsBigInt := &big.Int{}
-	targetOneAsBigInt.SetString(diffOneString, 0)
-
-	targetAsBigFloat := &big.Float{}
-	targetAsBigFloat.SetInt(targetOneAsBigInt)
-	targetAsBigFloat.Quo(targetAsBigFloat, diffAsBig)
-	targetAsBigInt, _ := targetAsBigFloat.Int(nil)
-	target, err = intToTarget(targetAsBigInt)
-	return
-}
-
-func (sc *StratumClient) setDifficulty(difficulty float64) {
+func (sc *StratumClient) setDifficulty(diff float64) {
 	var target Target
-	var err error
-	target, err = difficultyToTarget(difficulty)
-
+	t, err := difficulty.ToTarget(difficulty.Diff1, diff)
 	if err != nil {
-		log.Println("ERROR Error setting difficulty to ", difficulty)
+		log.Println("ERROR Error setting difficulty to ", diff)
 	}
+	copy(target[:], t)
 	sc.DeprecateOutstandingJobs()
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
 	sc.target = target
+	sc.Difficulty = diff
 }
 
 //GetHeaderForWork fetches new work from the stratum pool
@@ -324,36 +263,38 @@ func (sc *StratumClient) GetHeaderForWork() (target []byte, difficulty float64,
 	target = sc.target[:]
 	difficulty = sc.Difficulty
 
-	//Create the arbitrary transaction
-	en2 := sc.currentJob.ExtraNonce2.Bytes()
-	err = sc.currentJob.ExtraNonce2.Increment()
-
-	arbtx := []byte{}
-	arbtx = append(arbtx, sc.currentJob.Coinbase1...)
-	arbtx = append(arbtx, sc.extranonce1...)
-	arbtx = append(arbtx, en2...)
-	arbtx = append(arbtx, sc.currentJob.Coinbase2...)
-
-	coinbaseHash := stratum.SHA256d(arbtx)
+	en2, err := sc.currentJob.ExtraNonce2.Take()
+	if err != nil {
+		return
+	}
+	sj := sc.currentJob
+	sj.ExtraNonce2 = en2
+	job = sj
+	header = append(sj.Header(sc.extranonce1), sc.target[:]...)
+	return
+}
 
-	//Construct the merkleroot from the arbitrary transaction and the merklebranches
-	merkleRoot := coinbaseHash
-	for _, h := range sc.currentJob.MerkleBranch {
-		m := append(merkleRoot, h...)
-		merkleRoot = stratum.SHA256d(m)
+//SharedJob returns the current job with the extranonce1 of the connection and the pool difficulty,
+// for the proxy to hand out extranonce2 ranges of it to downstream miners
+func (sc *StratumClient) SharedJob() (job StratumJob, extranonce1 []byte, difficulty float64, err error) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.currentJob.JobID == "" {
+		err = errors.New("No job received from stratum server yet")
+		return
 	}
+	job = sc.currentJob
+	job.ExtraNonce2 = jobs.ExtraNonce2{Size: sc.extranonce2Size}
+	return job, append([]byte{}, sc.extranonce1...), sc.Difficulty, nil
+}
 
-	//Construct the header
-	header = make([]byte, 0, 80+HashSize)
-	header = append(header, sc.currentJob.Version...) //version
-	header = append(header, sc.currentJob.PrevHash...)
-	header = append(header, stratum.RevHash(merkleRoot)...)
-	header = append(header, sc.currentJob.NTime...)
-	header = append(header, sc.currentJob.NBits...)
-	header = append(header, []byte{0, 0, 0, 0}[:]...) //empty nonce
-	header = stratum.RevHash(header)
-	header = append(header, sc.target[:]...)
-	return
+//ReserveExtraNonce2 keeps the leading bytes of the extranonce2 of the headers of the boards at 0,
+// the proxy hands them out to downstream miners. 0 gives the whole extranonce2 back to the boards.
+func (sc *StratumClient) ReserveExtraNonce2(bytes uint) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.reserved = bytes
+	sc.currentJob.ExtraNonce2.Reserved = bytes
 }
 
 //SubmitHeader reports a solution to the stratum server
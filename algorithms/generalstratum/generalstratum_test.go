@@ -1,25 +1,31 @@
 package generalstratum
 
 import (
+	"bytes"
 	"encoding/hex"
+	"github.com/AGPFMiner/gominer/clients/pooltest"
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
+	"github.com/AGPFMiner/gominer/proxy"
 	"github.com/AGPFMiner/gominer/types"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
-func TestDifficultyToTarget(t *testing.T) {
+func TestSetDifficulty(t *testing.T) {
 	diff, _ := strconv.ParseFloat("65.32477875", 64)
 
-	expectedTarget := "0x00000000000404CB000000000000000000000000000000000000000000000000"
+	expectedTarget := "0x0000000003eb37d4fad091843301f5878dfa775ce91f986fef9ea627d7da3ec9"
 
-	target, err := difficultyToTarget(diff)
-	if err != nil {
-		t.Error(err)
-	}
+	sc := &StratumClient{}
+	sc.setDifficulty(diff)
 
-	if expectedTarget != ("0x" + hex.EncodeToString(target[:])) {
-		t.Error("0x"+hex.EncodeToString(target[:]), "returned instead of", expectedTarget)
+	if expectedTarget != ("0x" + hex.EncodeToString(sc.target[:])) {
+		t.Error("0x"+hex.EncodeToString(sc.target[:]), "returned instead of", expectedTarget)
+	}
+	if sc.Difficulty != diff {
+		t.Error("difficulty", sc.Difficulty)
 	}
 }
 
@@ -31,23 +37,118 @@ var odoPool = &types.Pool{
 }
 
 func TestGetHeaderForWork(t *testing.T) {
+	mock := pooltest.NewMockPool("odocrypt")
+	mock.Difficulty = 2
+	if err := mock.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	cw := &StratumClient{Connectionstring: mock.Addr(), User: "worker", Password: "x"}
+	go cw.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		target, difficulty, header, _, job, err := cw.GetHeaderForWork()
+		if err != nil {
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		//the 80 byte header is followed by the target of the difficulty
+		if len(header) != 80+len(target) || !bytes.Equal(header[80:], target) {
+			t.Errorf("header %02x", header)
+		}
+		if difficulty != 2 {
+			t.Error("difficulty", difficulty)
+		}
+		if sj, ok := job.(StratumJob); !ok || sj.JobID != "1" {
+			t.Error("job", job)
+		}
+		return
+	}
+}
+
+func TestLivePool(t *testing.T) {
+	if testing.Short() || !pooltest.Live() {
+		t.Skipf("set %s to test against %s", pooltest.LiveEnv, odoPool.URL)
+	}
 	pool := odoPool
 	cw := &StratumClient{Connectionstring: strings.TrimPrefix(pool.URL, "stratum+tcp://"), User: pool.User, Password: pool.Pass}
-	cw.SetDeprecatedJobCall(func(jobid string) {
+	cw.SubscribeJobs(t, func(jobid string) {
 
-	})
-	cw.Start()
+	}, nil)
+	go cw.Start()
 
+	deadline := time.Now().Add(30 * time.Second)
 	for {
 		_, _, header, _, job, err := cw.GetHeaderForWork()
 		if err != nil {
-			// t.Log(err)
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 		t.Logf("Job: \n%v", job)
 		t.Logf("Header: %02X\n", header)
-		// t.Logf("RevHeader: %02X\n", stratum.RevHash(header))
-		// spew.Dump(job)
-		// break
+		return
+	}
+}
+
+func TestSharedJob(t *testing.T) {
+	sc := &StratumClient{}
+	if _, _, _, err := sc.SharedJob(); err == nil {
+		t.Error("job shared before the pool sent one")
+	}
+	sc.extranonce1 = []byte{0x08, 0x00, 0x00, 0x02}
+	sc.extranonce2Size = 4
+	sc.setDifficulty(2)
+	sc.addNewStratumJob(StratumJob{JobID: "1", CleanJobs: true})
+	//the boards taking headers do not move the extranonce2 of the shared job
+	sc.GetHeaderForWork()
+	sc.GetHeaderForWork()
+
+	job, extranonce1, diff, err := sc.SharedJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.JobID != "1" || job.ExtraNonce2.Value != 0 || job.ExtraNonce2.Size != 4 {
+		t.Error("wrong job", job)
+	}
+	if hex.EncodeToString(extranonce1) != "08000002" || diff != 2 {
+		t.Error("extranonce1", extranonce1, "difficulty", diff)
+	}
+}
+
+func TestReserveExtraNonce2(t *testing.T) {
+	var _ proxy.Source = &StratumClient{}
+	sc := &StratumClient{}
+	sc.extranonce2Size = 3
+	sc.setDifficulty(2)
+	sc.ReserveExtraNonce2(proxy.ExtraNonceSize)
+	sc.addNewStratumJob(StratumJob{JobID: "1", CleanJobs: true})
+	//with the proxy on, the boards stop at the end of prefix 0 instead of mining the prefixes of the miners
+	for i := 0; i < 256; i++ {
+		_, _, _, _, job, err := sc.GetHeaderForWork()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if en := job.(StratumJob).ExtraNonce2; en.Value != uint64(i) || en.Size != 3 {
+			t.Fatal("header", i, "extranonce2", en)
+		}
+	}
+	if _, _, _, _, _, err := sc.GetHeaderForWork(); err != jobs.ErrExtraNonce2Exhausted {
+		t.Error("boards mine prefix 1", err)
+	}
+
+	//released, a new job rolls the whole extranonce2
+	sc.ReserveExtraNonce2(0)
+	sc.addNewStratumJob(StratumJob{JobID: "2", CleanJobs: true})
+	for i := 0; i <= 256; i++ {
+		if _, _, _, _, _, err := sc.GetHeaderForWork(); err != nil {
+			t.Fatal("header", i, err)
+		}
 	}
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/AGPFMiner/gominer/algorithms/generalstratum"
 	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/difficulty"
 	"github.com/AGPFMiner/gominer/driver"
 	"github.com/AGPFMiner/gominer/mining"
 
@@ -49,8 +50,10 @@ const (
 	addrJobID    = byte(0x30)
 )
 
+//DiffChecker tells whether the big endian hash meets the pool target. RegenHash has no odocrypt hash
+// to give it yet, the driver submits the golden nonces of the bitstream as it reports them, see difficulty.Algo.BoardChecked.
 func DiffChecker(hash []byte, work driver.MiningWork) bool {
-	return true
+	return difficulty.MeetsTarget(hash, work.Target)
 }
 
 func ConstructHeaderPackets(header []byte, boardJobID uint8) (fpgaPacket []byte) {
@@ -5,11 +5,16 @@ import (
 
 	"github.com/AGPFMiner/gominer/algorithms/generalstratum"
 	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/clients/gbt"
 	"github.com/AGPFMiner/gominer/types"
 )
 
-// NewClient creates a new SiadClient given a '[stratum+tcp://]host:port' connectionstring
+// NewClient creates a new SiadClient given a '[stratum+tcp://]host:port' connectionstring,
+// or a gbt client mining solo on the node at an 'http://host:port' RPC address
 func NewClient(pool *types.Pool) (sc clients.Client) {
-	sc = &generalstratum.StratumClient{Connectionstring: strings.TrimPrefix(pool.URL, "stratum+tcp://"), User: pool.User, Password: pool.Pass, Algo: pool.Algo}
+	if clients.IsNodeURL(pool.URL) {
+		return gbt.NewClient(pool)
+	}
+	sc = &generalstratum.StratumClient{Connectionstring: strings.TrimPrefix(pool.URL, "stratum+tcp://"), User: pool.User, Password: pool.Pass, Algo: pool.Algo, Record: pool.Record}
 	return
 }
@@ -0,0 +1,23 @@
+package skunk
+
+import (
+	"encoding/hex"
+
+	"github.com/AGPFMiner/gominer/driver"
+)
+
+//KnownAnswer is the header of TestSkunkSingle in the work layout of the stratum client:
+// 76 header bytes, an empty nonce and the target, its nonce F581DC49 gives a golden hash
+var KnownAnswer = driver.KnownAnswer{
+	Header: mustDecode("04000000EA9C403960428CC6BC886631703A218E461970FC97DBFF72347B0000000000008472ACD58EB351228A7667306C8E7EA10BC72F50CEF439F4B424CB13DBACA5933E55E15C8AEE001B" +
+		"00000000" + "00000000FFFF0000000000000000000000000000000000000000000000000000"),
+	Nonce: [8]byte{0, 0, 0, 0, 0x49, 0xdc, 0x81, 0xf5},
+}
+
+func mustDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
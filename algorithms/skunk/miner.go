@@ -3,8 +3,8 @@ package skunk
 import (
 	"github.com/AGPFMiner/gominer/algorithms/generalstratum"
 	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/difficulty"
 	"github.com/AGPFMiner/gominer/driver"
-	"math/big"
 	"time"
 
 	"github.com/AGPFMiner/gominer/mining"
@@ -34,17 +34,10 @@ func (m *Miner) Init(args mining.MinerArgs) {
 	m.logger = args.Logger
 }
 
+//DiffChecker tells whether hash meets the pool target. RegenHash reverses the little endian
+// skunk hash, so it is compared big endian like the target.
 func DiffChecker(hash []byte, work driver.MiningWork) bool {
-	// hashInt, diff := veoHash2Int(hash), int(work.Difficulty)
-	hashInt := new(big.Int)
-	targetInt := new(big.Int)
-	hashInt.SetBytes(hash)
-	targetInt.SetBytes(work.Target)
-	if hashInt.Cmp(targetInt) == -1 {
-		return true
-	} else {
-		return false
-	}
+	return difficulty.MeetsTarget(hash, work.Target)
 }
 
 //Halt stops all miners
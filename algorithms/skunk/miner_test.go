@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"encoding/hex"
 	"testing"
+
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/driver"
 )
 
 var provenSolutions = []struct {
@@ -61,3 +64,18 @@ func (v *submittedHeaderValidator) validate(t *testing.T) {
 		}
 	}
 }
+
+func TestDiffChecker(t *testing.T) {
+	hash, _ := hex.DecodeString(provenSolutions[0].hash)
+	target, _ := difficulty.ToTarget(difficulty.Diff1, 1e6)
+	if !DiffChecker(hash, driver.MiningWork{Target: target}) {
+		t.Error("share of block", provenSolutions[0].height, "rejected at difficulty 1e6")
+	}
+	target, _ = difficulty.ToTarget(difficulty.Diff1, 1e7)
+	if DiffChecker(hash, driver.MiningWork{Target: target}) {
+		t.Error("hash accepted at difficulty 1e7")
+	}
+	if DiffChecker(hash, driver.MiningWork{}) {
+		t.Error("hash accepted without a target")
+	}
+}
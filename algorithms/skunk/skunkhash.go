@@ -23,7 +23,7 @@ func Skunkhash(input []byte) (output []byte) {
 
 //RegenHash calculates skunk hash
 func RegenHash(input []byte) (output []byte) {
-	if len(input)<88{
+	if len(input)<120{
 		output = []byte{0xff,0xff,0xff,0xff,
 			0xff,0xff,0xff,0xff,
 			0xff,0xff,0xff,0xff,
@@ -75,3 +75,14 @@ func TestGroestl100Times(t *testing.T) {
 		t.Fatal("Wrong Hash.")
 	}
 }
+
+func TestKnownAnswer(t *testing.T) {
+	if !KnownAnswer.Check(&MiningFuncs{}, KnownAnswer.Nonce) {
+		t.Fatal("The known answer nonce does not give a golden hash.")
+	}
+	wrong := KnownAnswer.Nonce
+	wrong[7]++
+	if KnownAnswer.Check(&MiningFuncs{}, wrong) {
+		t.Fatal("A wrong nonce gives a golden hash.")
+	}
+}
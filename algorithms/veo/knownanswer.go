@@ -0,0 +1,22 @@
+package veo
+
+import (
+	"encoding/hex"
+
+	"github.com/AGPFMiner/gominer/driver"
+)
+
+//KnownAnswer is the block of TestVeoMidstate, its last 7 bytes are the winning nonce
+// and hash to 000000001D1292DC...
+var KnownAnswer = driver.KnownAnswer{
+	Header: mustDecode("f86f71c0ae8eb91206c8ed3b98df8357db5eab795550622bfeb16100e75b15fe2bd9fd30000000009de57a6900000000"),
+	Nonce:  [8]byte{0, 0x00, 0x06, 0x63, 0x00, 0x00, 0x43, 0xfd},
+}
+
+func mustDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -2,6 +2,7 @@ package veo
 
 import (
 	"crypto/sha256"
+	"github.com/AGPFMiner/gominer/difficulty"
 	"github.com/AGPFMiner/gominer/driver"
 	"log"
 	"time"
@@ -37,51 +38,12 @@ func RegenHash(input []byte) (output []byte) {
 	return
 }
 
-func veoHash2Int(hash []byte) int {
-	var h []uint32
-	for b := range hash {
-		h = append(h, uint32(hash[b]))
-	}
-	var x uint32
-	var z uint32
-	for i := 0; i < 31; i++ {
-		if h[i] == 0 {
-			x += 8
-			continue
-		} else if h[i] < 2 {
-			x += 7
-			z = h[i+1]
-		} else if h[i] < 4 {
-			x += 6
-			z = (h[i+1] / 2) + ((h[i] % 2) * 128)
-		} else if h[i] < 8 {
-			x += 5
-			z = (h[i+1] / 4) + ((h[i] % 4) * 64)
-		} else if h[i] < 16 {
-			x += 4
-			z = (h[i+1] / 8) + ((h[i] % 8) * 32)
-		} else if h[i] < 32 {
-			x += 3
-			z = (h[i+1] / 16) + ((h[i] % 16) * 16)
-		} else if h[i] < 64 {
-			x += 2
-			z = (h[i+1] / 32) + ((h[i] % 32) * 8)
-		} else if h[i] < 128 {
-			x++
-			z = (h[i+1] / 64) + ((h[i] % 64) * 4)
-		} else {
-			z = (h[i+1] / 128) + ((h[i] % 128) * 2)
-		}
-		break
-	}
-	var y [2]uint32
-	y[0] = x
-	y[1] = z
-	return int((256 * y[0]) + y[1])
-}
-
+//DiffChecker tells whether the work of hash reaches the pool difficulty, none does before the pool set one
 func DiffChecker(hash []byte, work driver.MiningWork) bool {
-	hashInt, diff := veoHash2Int(hash), int(work.Difficulty)
+	if work.Difficulty <= 0 {
+		return false
+	}
+	hashInt, diff := difficulty.VeoDifficulty(hash), int(work.Difficulty)
 	// log.Printf("DevDiff: %d, PoolDiff: %d\n", hashInt, diff)
 	return hashInt >= diff
 }
@@ -4,6 +4,9 @@ import (
 	"encoding/hex"
 	"log"
 	"testing"
+
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/driver"
 )
 
 func TestRegenHash(t *testing.T) {
@@ -14,6 +17,21 @@ func TestRegenHash(t *testing.T) {
 
 func TestHash2Int(t *testing.T) {
 	hash, _ := hex.DecodeString("000000000ffff68A71E3473341DF5C45DA627BF80CE87FB4B3F2CD30D4B737D4")
-	diff := veoHash2Int(hash)
-	log.Printf("Diff: %d\n", diff)
+	//36 leading zero bits, then 1111 1111 after the first one bit
+	if diff := difficulty.VeoDifficulty(hash); diff != 36*256+0xff {
+		t.Error("Diff:", diff)
+	}
+}
+
+func TestDiffChecker(t *testing.T) {
+	hash, _ := hex.DecodeString("000000000ffff68A71E3473341DF5C45DA627BF80CE87FB4B3F2CD30D4B737D4")
+	if !DiffChecker(hash, driver.MiningWork{Difficulty: 36 * 256}) {
+		t.Error("hash rejected at its difficulty")
+	}
+	if DiffChecker(hash, driver.MiningWork{Difficulty: 37 * 256}) {
+		t.Error("hash accepted above its difficulty")
+	}
+	if DiffChecker(hash, driver.MiningWork{}) {
+		t.Error("hash accepted before the pool set a difficulty")
+	}
 }
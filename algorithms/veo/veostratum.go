@@ -11,6 +11,7 @@ import (
 
 	"github.com/AGPFMiner/gominer/clients"
 	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
 	"github.com/AGPFMiner/gominer/types"
 
 	"github.com/mitchellh/mapstructure"
@@ -35,17 +36,14 @@ const (
 //Target declares what a solution should be smaller than to be accepted
 type Target [HashSize]byte
 
-type stratumJob struct {
-	JobID string
-	bHash string
-	nonce string
-}
+type stratumJob = jobs.VeoJob
 
 //StratumClient is a groestl client using the stratum protocol
 type StratumClient struct {
 	connectionstring string
 	User             string
 	Algo             string
+	Record           string     // file the stratum session is recorded to, empty for none
 	mutex            sync.Mutex // protects following
 	stratumclient    *stratum.Client
 	target           Target
@@ -119,24 +117,36 @@ func (sc *StratumClient) PoolConnectionStates() types.PoolConnectionStates {
 //startPoolConn connects to the stratumserver and processes the notifications
 func (sc *StratumClient) startPoolConn() {
 	sc.DeprecateOutstandingJobs()
+	sc.RecordStart()
 
-	sc.stratumclient = &stratum.Client{}
+	sc.stratumclient = &stratum.Client{Record: sc.Record}
 	sc.stratumclient.Veo = true
 	//In case of an error, drop the current stratumclient and restart
 	sc.stratumclient.ErrorCallback = func(err error) {
 	}
+	sc.stratumclient.UnhandledNotification = func(method interface{}, params []interface{}) {
+		sc.RecordOddity("unexpected notification %v", method)
+	}
 
 	sc.subscribeToStratumDifficultyChanges()
 	sc.subscribeToStratumJobNotifications()
 
 	//Connect to the stratum server
 	log.Println("Connecting to", sc.connectionstring)
-	sc.stratumclient.Dial(sc.connectionstring)
+	if err := sc.stratumclient.Dial(sc.connectionstring); err != nil {
+		return
+	}
+	sc.RecordConnected()
 
 	//Subscribe for mining
 	//Close the connection on an error will cause the client to generate an error, resulting in te errorhandler to be triggered
 
 	result, err := sc.stratumclient.Call(MethodIDSubscribe, VeoStratum{Id: sc.User})
+	//veo pools have no authorize, the subscription carries the user
+	if err == nil {
+		sc.RecordSubscribe("", 0)
+	}
+	sc.RecordAuthorize(err == nil, err)
 	// if err != nil {
 	// 	log.Println("ERROR Error in response from stratum:", err)
 	// 	sc.stratumclient.Close()
@@ -161,23 +171,30 @@ func (sc *StratumClient) subscribeToStratumDifficultyChanges() {
 
 		log.Println("Stratum server changed difficulty to", diff)
 		sc.setDifficulty(diff)
+		sc.RecordDifficulty(float64(diff))
 	})
 }
 
 func (sc *StratumClient) subscribeToStratumJobNotifications() {
 	sc.stratumclient.SetNotificationHandler(MethodIDNewBlockHash, func(params []interface{}, result interface{}) {
 		// log.Println("New job received from stratum server")
+		sc.RecordNotify()
 
-		sj := stratumJob{}
 		var reply VeoStratum
 		mapstructure.Decode(result, &reply)
-		sj.bHash = reply.BHash
-		sj.JobID = reply.BHash
+		bHash, err := base64.StdEncoding.DecodeString(reply.BHash)
+		if err != nil {
+			log.Println("ERROR Invalid block hash supplied by stratum server:", reply.BHash)
+			sc.RecordOddity("invalid block hash %q", reply.BHash)
+			return
+		}
+		sj := stratumJob{JobID: reply.BHash, BHash: bHash}
 		diff := reply.JDiff
 
 		if diff != 0 {
 			log.Println("Stratum server changed difficulty to", diff)
 			sc.setDifficulty(diff)
+			sc.RecordDifficulty(float64(diff))
 		}
 
 		sc.addNewStratumJob(sj)
@@ -190,6 +207,7 @@ func (sc *StratumClient) addNewStratumJob(sj stratumJob) {
 	sc.currentJob = sj
 	sc.DeprecateOutstandingJobs()
 	sc.AddJobToDeprecate(sj.JobID)
+	sc.RecordJob()
 }
 
 func (sc *StratumClient) setDifficulty(difficulty int) {
@@ -214,34 +232,11 @@ func (sc *StratumClient) GetHeaderForWork() (target []byte, difficulty float64,
 
 	deprecationChannel = sc.GetDeprecationChannel(sc.currentJob.JobID)
 
-	/*
-		7b28f9ec
-		00000000
-		c1930959
-		00000000
-		00045400000e6e
-	*/
 	target = sc.target[:]
 	difficulty = float64(sc.Difficulty)
-	bHashDecoded, _ := base64.StdEncoding.DecodeString(sc.currentJob.bHash)
-	// log.Printf("bHashDecoded: %02X\n", bHashDecoded)
-	// en2 := sc.currentJob.extraNonce.Bytes()
-	// err = sc.currentJob.extraNonce.Increment()
-	//Construct the header
-	header = make([]byte, 0, 55)
-	// log.Printf("Header1: %02x\n", header)
-
-	header = append(header, bHashDecoded...)
-	// log.Printf("Header2: %02x\n", header)
-	rd := make([]byte, 4)
-	rand.Read(rd)
-	header = append(header, rd...)
-	header = append(header, []byte{0x00, 0x00, 0x00, 0x00}...)
-	rand.Read(rd)
-	header = append(header, rd...)
-	header = append(header, []byte{0x00, 0x00, 0x00, 0x00}...)
-	// log.Printf("Header3: %02x,enSize: %d\n", header, sc.currentJob.extraNonce.Size)
-
+	var random [jobs.VeoRandomSize]byte
+	rand.Read(random[:])
+	header = jobs.VeoHeader(sc.currentJob.BHash, random)
 	return
 }
 
@@ -1,30 +1,77 @@
 package veo
 
 import (
-	"github.com/AGPFMiner/gominer/clients/stratum"
+	"encoding/hex"
 	"testing"
 	"time"
 
+	"github.com/AGPFMiner/gominer/clients/pooltest"
+	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/types"
+
 	"github.com/davecgh/go-spew/spew"
 )
 
+var veoPool = &types.Pool{URL: "stratum+tcp://stratum.amoveopool.com:8822", User: "BDnSmWXuhuaANFe2vSWo4q+nnPAnFIZ/MIiDnUYh8s3MsmgPAjVh5CUrAUArVsFBrRgCtlVyXFEoLLKnADd+0oU=.2", Algo: "veo"}
+
 func TestGetHeaderForWork(t *testing.T) {
-	cw := NewClient("stratum+tcp://stratum.amoveopool.com:8822", "BDnSmWXuhuaANFe2vSWo4q+nnPAnFIZ/MIiDnUYh8s3MsmgPAjVh5CUrAUArVsFBrRgCtlVyXFEoLLKnADd+0oU=.2", -1)
-	cw.SetDeprecatedJobCall(func(jobid string) {
+	mock := pooltest.NewMockPool("veo")
+	mock.Difficulty = 9216
+	if err := mock.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	cw := NewClient(&types.Pool{URL: mock.URL(), User: "worker", Algo: "veo"})
+	go cw.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, difficulty, header, _, job, err := cw.GetHeaderForWork()
+		if err != nil {
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		//the header starts with the block hash of the job
+		if hex.EncodeToString(header[:HashSize]) != "6e2b1a5c3d4f5e6a7b8c9d0e1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c" {
+			t.Errorf("header %02x", header)
+		}
+		if difficulty != 9216 {
+			t.Error("difficulty", difficulty)
+		}
+		if sj, ok := job.(stratumJob); !ok || hex.EncodeToString(sj.BHash) != hex.EncodeToString(header[:HashSize]) {
+			t.Error("job", job)
+		}
+		return
+	}
+}
+
+func TestLivePool(t *testing.T) {
+	if testing.Short() || !pooltest.Live() {
+		t.Skipf("set %s to test against %s", pooltest.LiveEnv, veoPool.URL)
+	}
+	cw := NewClient(veoPool)
+	cw.SubscribeJobs(t, func(jobid string) {
+
+	}, nil)
+	go cw.Start()
 
-	})
-	cw.Start()
+	deadline := time.Now().Add(30 * time.Second)
 	for {
 		_, _, header, _, job, err := cw.GetHeaderForWork()
-		time.Sleep(100 * time.Millisecond)
 		if err != nil {
-			t.Log(err)
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 		t.Logf("Job: \n%v", job)
 		t.Logf("Header: %02X\n", header)
 		t.Logf("RevHeader: %02X\n", stratum.RevHash(header))
 		spew.Dump(job)
-		break
+		return
 	}
 }
@@ -2,6 +2,7 @@ package verus
 
 import (
 	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/difficulty"
 	"github.com/AGPFMiner/gominer/driver"
 	"time"
 
@@ -32,12 +33,16 @@ func (m *Miner) Init(args mining.MinerArgs) {
 	m.logger = args.Logger
 }
 
+//DiffChecker tells whether the big endian hash meets the pool target. RegenHash has no VerusHash
+// to give it yet, the driver submits the golden nonces of the bitstream as it reports them, see difficulty.Algo.BoardChecked.
 func DiffChecker(hash []byte, work driver.MiningWork) bool {
-	return true
+	return difficulty.MeetsTarget(hash, work.Target)
 }
 
+//RegenHash stands in for VerusHash, the host has its haraka midstate and key but not the rest of it.
+// It returns no hash, the driver submits the golden nonces of the bitstream as it reports them, see difficulty.Algo.BoardChecked.
 func RegenHash(input []byte) (output []byte) {
-	return []byte{0x00, 0x00, 0x00, 0x00}
+	return nil
 }
 
 //Halt stops all miners
@@ -9,6 +9,6 @@ import (
 
 // NewClient creates a new SiadClient given a '[stratum+tcp://]host:port' connectionstring
 func NewClient(pool *types.Pool) (sc clients.Client) {
-	sc = &StratumClient{connectionstring: strings.TrimPrefix(pool.URL, "stratum+tcp://"), User: pool.User, Password: pool.Pass, Algo: pool.Algo}
+	sc = &StratumClient{connectionstring: strings.TrimPrefix(pool.URL, "stratum+tcp://"), User: pool.User, Password: pool.Pass, Algo: pool.Algo, Record: pool.Record}
 	return
 }
@@ -1,16 +1,16 @@
 package verus
 
 import (
-	"bytes"
 	"encoding/hex"
 	"errors"
 	"log"
-	"math/big"
 	"sync"
 	"time"
 
 	"github.com/AGPFMiner/gominer/clients"
 	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
+	"github.com/AGPFMiner/gominer/difficulty"
 	"github.com/AGPFMiner/gominer/types"
 )
 
@@ -22,17 +22,7 @@ const (
 //Target declares what a solution should be smaller than to be accepted
 type Target [HashSize]byte
 
-type stratumJob struct {
-	JobID       string
-	Version     []byte
-	Hash1       []byte
-	Hash2       []byte
-	Hash3       []byte
-	NBits       []byte
-	NTime       []byte
-	CleanJobs   bool
-	ExtraNonce2 stratum.ExtraNonce2
-}
+type stratumJob = jobs.VerusJob
 
 //StratumClient is a groestl client using the stratum protocol
 type StratumClient struct {
@@ -40,6 +30,8 @@ type StratumClient struct {
 	User             string
 	Password         string
 	Algo             string
+	//Record is the file the stratum session is recorded to, empty for none
+	Record string
 
 	mutex                   sync.Mutex // protects following
 	stratumclient           *stratum.Client
@@ -86,8 +78,9 @@ func (sc *StratumClient) Start() {
 	log.Println("before deprecate()")
 	sc.DeprecateOutstandingJobs()
 	log.Println("after mutex.Unlock()")
+	sc.RecordStart()
 
-	sc.stratumclient = &stratum.Client{}
+	sc.stratumclient = &stratum.Client{Record: sc.Record}
 	//In case of an error, drop the current stratumclient and restart
 	sc.stratumclient.ErrorCallback = func(err error) {
 		log.Println("Error in connection to stratumserver:", err)
@@ -96,25 +89,33 @@ func (sc *StratumClient) Start() {
 		sc.Start()
 		log.Println("Start()")
 	}
+	sc.stratumclient.UnhandledNotification = func(method interface{}, params []interface{}) {
+		sc.RecordOddity("unexpected notification %v", method)
+	}
 
 	sc.subscribeToStratumDifficultyChanges()
 	sc.subscribeToStratumJobNotifications()
 
 	//Connect to the stratum server
 	log.Println("Connecting to", sc.connectionstring)
-	sc.stratumclient.Dial(sc.connectionstring)
+	if err := sc.stratumclient.Dial(sc.connectionstring); err != nil {
+		return
+	}
+	sc.RecordConnected()
 
 	//Subscribe for mining
 	//Close the connection on an error will cause the client to generate an error, resulting in te errorhandler to be triggered
 	result, err := sc.stratumclient.Call("mining.subscribe", []string{"AGPFminer"})
 	if err != nil {
 		log.Println("ERROR Error in response from stratum:", err)
+		sc.RecordOddity("mining.subscribe failed: %v", err)
 		sc.stratumclient.Close()
 		return
 	}
 	reply, ok := result.([]interface{})
 	if !ok || len(reply) < 2 {
 		log.Println("ERROR Invalid response from stratum:", result)
+		sc.RecordOddity("invalid mining.subscribe reply %v", result)
 		sc.stratumclient.Close()
 		return
 	}
@@ -122,15 +123,18 @@ func (sc *StratumClient) Start() {
 	//Keep the extranonce1 and extranonce2_size from the reply
 	if sc.extranonce1, err = stratum.HexStringToBytes(reply[1]); err != nil {
 		log.Println("ERROR Invalid extrannonce1 from startum")
+		sc.RecordOddity("invalid extranonce1 %v", reply[1])
 		sc.stratumclient.Close()
 		return
 	}
 
-	sc.extranonce2Size = uint(32 - len(reply[1].(string))/2)
+	sc.extranonce2Size = uint(jobs.VerusNonceSize - len(sc.extranonce1))
+	sc.RecordSubscribe(hex.EncodeToString(sc.extranonce1), int(sc.extranonce2Size))
 
 	//Authorize the miner
 	go func() {
 		result, err = sc.stratumclient.Call("mining.authorize", []string{sc.User, sc.Password})
+		sc.RecordAuthorize(result, err)
 		if err != nil {
 			log.Println("Unable to authorize:", err)
 			sc.stratumclient.Close()
@@ -153,60 +157,29 @@ func (sc *StratumClient) subscribeToStratumDifficultyChanges() {
 			return
 		}
 		target, ok := params[0].(string)
-		if !ok {
+		if !ok || len(target) != 2*HashSize {
 			log.Println("ERROR Invalid target supplied by stratum server:", params[0])
+			sc.RecordOddity("invalid target %v", params[0])
 			return
 		}
 		log.Println("Stratum server changed difficulty to", target[:16])
+		sc.RecordTarget(target)
 		sc.setTarget(target)
-		sc.Difficulty = 1.0
 	})
 }
 
 func (sc *StratumClient) subscribeToStratumJobNotifications() {
 	sc.stratumclient.SetNotificationHandler("mining.notify", func(params []interface{}, result interface{}) {
 		// log.Println("New job received from stratum server")
-		if params == nil || len(params) < 8 {
+		sc.RecordNotify()
+		if len(params) < jobs.VerusNotifyParams {
 			log.Println("ERROR Wrong number of parameters supplied by stratum server")
+			sc.RecordOddity("mining.notify has %d parameters, expected %d", len(params), jobs.VerusNotifyParams)
 			return
 		}
-
-		sj := stratumJob{}
-
-		sj.ExtraNonce2.Size = sc.extranonce2Size
-
-		var ok bool
-		var err error
-		if sj.JobID, ok = params[0].(string); !ok {
-			log.Println("ERROR Wrong job_id parameter supplied by stratum server")
-			return
-		}
-		if sj.Version, err = stratum.HexStringToBytes(params[1]); err != nil {
-			log.Println("ERROR Wrong version parameter supplied by stratum server")
-			return
-		}
-		if sj.Hash1, err = stratum.HexStringToBytes(params[2]); err != nil {
-			log.Println("ERROR Wrong hash1 parameter supplied by stratum server")
-			return
-		}
-		if sj.Hash2, err = stratum.HexStringToBytes(params[3]); err != nil {
-			log.Println("ERROR Wrong hash2 parameter supplied by stratum server")
-			return
-		}
-		if sj.Hash3, err = stratum.HexStringToBytes(params[4]); err != nil {
-			log.Println("ERROR Wrong hash3 parameter supplied by stratum server")
-			return
-		}
-		if sj.NTime, err = stratum.HexStringToBytes(params[5]); err != nil {
-			log.Println("ERROR Wrong ntime parameter supplied by stratum server")
-			return
-		}
-		if sj.NBits, err = stratum.HexStringToBytes(params[6]); err != nil {
-			log.Println("ERROR Wrong nbits parameter supplied by stratum server")
-			return
-		}
-		if sj.CleanJobs, ok = params[7].(bool); !ok {
-			log.Println("ERROR Wrong clean_jobs parameter supplied by stratum server")
+		sj, err := jobs.ParseVerusNotify(params)
+		if err != nil {
+			log.Println("ERROR", err, "supplied by stratum server")
 			return
 		}
 		sc.addNewStratumJob(sj)
@@ -216,71 +189,44 @@ func (sc *StratumClient) subscribeToStratumJobNotifications() {
 func (sc *StratumClient) addNewStratumJob(sj stratumJob) {
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
+	sj.ExtraNonce2.Size = sc.extranonce2Size
 	sc.currentJob = sj
 	if sj.CleanJobs {
 		sc.discard++
 		sc.DeprecateOutstandingJobs()
 	}
 	sc.AddJobToDeprecate(sj.JobID)
+	sc.RecordJob()
 }
 
-// IntToTarget converts a big.Int to a Target.
-func intToTarget(i *big.Int) (t Target, err error) {
-	// Check for negatives.
-	if i.Sign() < 0 {
-		err = errors.New("Negative target")
-		return
-	}
-	// In the event of overflow, return the maximum.
-	if i.BitLen() > 256 {
-		err = errors.New("Target is too high")
-		return
-	}
-	b := i.Bytes()
-	offset := len(t[:]) - len(b)
-	copy(t[offset:], b)
-	return
-}
-
-func difficultyToTarget(difficulty float64) (target Target, err error) {
-	diffAsBig := big.NewFloat(difficulty)
-
-	diffOneString := "0x00000000FFFF0000000000000000000000000000000000000000000000000000"
-	targetOneAsBigInt := &big.Int{}
-	targetOneAsBigInt.SetString(diffOneString, 0)
-
-	targetAsBigFloat := &big.Float{}
-	targetAsBigFloat.SetInt(targetOneAsBigInt)
-	targetAsBigFloat.Quo(targetAsBigFloat, diffAsBig)
-	targetAsBigInt, _ := targetAsBigFloat.Int(nil)
-	target, err = intToTarget(targetAsBigInt)
-	return
-}
-
-func (sc *StratumClient) setDifficulty(difficulty float64) {
-	target, err := difficultyToTarget(difficulty)
-
+func (sc *StratumClient) setDifficulty(diff float64) {
+	var target Target
+	t, err := difficulty.ToTarget(difficulty.Diff1, diff)
 	if err != nil {
-		log.Println("ERROR Error setting difficulty to ", difficulty)
+		log.Println("ERROR Error setting difficulty to ", diff)
 	}
+	copy(target[:], t)
 	sc.DeprecateOutstandingJobs()
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
 	sc.target = target
+	sc.Difficulty = diff
 }
 
 func (sc *StratumClient) setTarget(targetStr string) {
-	targetAsBigInt := &big.Int{}
-	targetAsBigInt.SetString(targetStr, 16)
+	var target Target
+	t, err := hex.DecodeString(targetStr)
+	if err != nil || len(t) != HashSize {
+		log.Println("ERROR Error setting target to ", targetStr)
+		return
+	}
+	copy(target[:], t)
 
 	sc.DeprecateOutstandingJobs()
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
-	target, err := intToTarget(targetAsBigInt)
-	if err != nil {
-		log.Println("ERROR Error setting target to ", targetStr)
-	}
 	sc.target = target
+	sc.Difficulty = difficulty.FromTarget(difficulty.Diff1, t)
 }
 
 //GetHeaderForWork fetches new work from the stratum pool
@@ -299,33 +245,14 @@ func (sc *StratumClient) GetHeaderForWork() (target []byte, difficulty float64,
 	target = sc.target[:]
 	difficulty = sc.Difficulty
 
-	//Create the arbitrary transaction
-	en2 := sc.currentJob.ExtraNonce2.Bytes()
-	err = sc.currentJob.ExtraNonce2.Increment()
-
-	//Construct the header
-	/*
-		04000100
-		6D635EA1D8B9638AEE3D39FD7A7D040EAB56F8B7038DA6E59C010B0000000000
-		6BB089D021EB23B7AF029AE661690C32B8D79ECDC470EFD21EA77B244B61824F
-		EB7D01915EE56B0C75791FDBFDE8924DD1A379BA5C5442058DF9641BE627DB1C
-		298B305D
-		543D0E1B
-		0FFF252900000000000000000000000000000000000000000000000000000000
-		FD4005010000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000
-	*/
-	header = make([]byte, 0, 1487)
-	header = append(header, sc.currentJob.Version...) //version
-	header = append(header, sc.currentJob.Hash1...)
-	header = append(header, sc.currentJob.Hash2...)
-	header = append(header, sc.currentJob.Hash3...)
-	header = append(header, sc.currentJob.NTime...)
-	header = append(header, sc.currentJob.NBits...)
-	header = append(header, sc.extranonce1...)
-	header = append(header, en2...)
-	header = append(header, []byte{0xfd, 0x40, 0x05, 0x01}...)
-	emptySol := bytes.Repeat([]byte{0}, 1343)
-	header = append(header, emptySol...) //empty sol
+	en2, err := sc.currentJob.ExtraNonce2.Take()
+	if err != nil {
+		return
+	}
+	sj := sc.currentJob
+	sj.ExtraNonce2 = en2
+	job = sj
+	header = sj.Header(sc.extranonce1)
 	return
 }
 
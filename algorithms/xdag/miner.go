@@ -1,6 +1,7 @@
 package xdag
 
 import (
+	"github.com/AGPFMiner/gominer/difficulty"
 	"github.com/AGPFMiner/gominer/driver"
 	"github.com/AGPFMiner/gominer/mining"
 	"math/rand"
@@ -31,12 +32,16 @@ func (m *Miner) Init(args mining.MinerArgs) {
 	m.logger = args.Logger
 }
 
+//RegenHash stands in for the xdag hash, the daemon hands out register writes instead of the block.
+// It returns no hash, the driver submits the golden nonces of the bitstream as it reports them, see difficulty.Algo.BoardChecked.
 func RegenHash(input []byte) (output []byte) {
-	return []byte{0x00, 0x00, 0x00, 0x00}
+	return nil
 }
 
+//DiffChecker tells whether the big endian hash meets the target of the work. The xdag daemon
+// hands out no target and RegenHash no hash, the driver submits the golden nonces of the bitstream as it reports them, see difficulty.Algo.BoardChecked.
 func DiffChecker(hash []byte, work driver.MiningWork) bool {
-	return true
+	return difficulty.MeetsTarget(hash, work.Target)
 }
 
 //Halt stops all miners
@@ -80,11 +80,15 @@ func (sc *XdagClient) Stop() {
 	exec.Command("killall", "xdag").Run()
 }
 
-//SetDeprecatedJobCall does nothing
-func (sc *XdagClient) SetDeprecatedJobCall(call clients.DeprecatedJobCall) {}
+//SubscribeJobs does nothing
+func (sc *XdagClient) SubscribeJobs(subscriber interface{}, deprecated clients.DeprecatedJobCall, clean clients.CleanJobEventCall) {
+}
+
+//UnsubscribeJobs does nothing
+func (sc *XdagClient) UnsubscribeJobs(subscriber interface{}) {}
 
-//SetCleanJobEventCall does nothing
-func (sc *XdagClient) SetCleanJobEventCall(call clients.CleanJobEventCall) {}
+//SetNewJobCall does nothing
+func (sc *XdagClient) SetNewJobCall(call clients.NewJobCall) {}
 
 //GetHeaderForWork fetches new work from the SIA daemon
 func (sc *XdagClient) GetHeaderForWork() (target []byte, difficulty float64, header []byte, deprecationChannel chan bool, job interface{}, err error) {
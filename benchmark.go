@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var benchmarkCmd = &cobra.Command{
+	Use:   "benchmark [slot...]",
+	Short: "Mine synthetic work on the given slots and report hashrate, errors and timing.",
+	Long: "Mine synthetic work from a local generator on the given slots, all enabled slots if\n" +
+		"none are given, without a pool. Reports hashrate and nonce error rate per board, the\n" +
+		"latency from a clean job to its dispatch, and the serial traffic that bounds polldelay.\n" +
+		"The boards must already be programmed, see boards program.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, m, c := boardChain(cmd)
+		slots, err := selectSlots(c, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		algo, _ := cmd.Flags().GetString("algo")
+		if algo == "" {
+			algo = chainAlgo(cfg, c)
+		}
+		duration, _ := cmd.Flags().GetDuration("duration")
+		difficulty, _ := cmd.Flags().GetFloat64("difficulty")
+		jobInterval, _ := cmd.Flags().GetDuration("job-interval")
+
+		r, err := m.Benchmark(c, algo, slots, duration, difficulty, jobInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, b := range r.Boards {
+			fmt.Printf("slot %2d  %10.2f MH/s  nonces %d  invalid %d (%.2f%%)  job switch %v avg %v max\n",
+				b.Slot, b.Hashrate/1e6, b.Nonces, b.Invalid, b.ErrorRate*100, b.SwitchLatency, b.MaxSwitchLatency)
+		}
+		fmt.Printf("total    %10.2f MH/s  nonces %d  invalid %d (%.2f%%)  job switch %v avg %v max\n",
+			r.Hashrate/1e6, r.Nonces, r.Invalid, r.ErrorRate*100, r.SwitchLatency, r.MaxSwitchLatency)
+		fmt.Printf("jobs %d, shares %d at difficulty %g in %v\n", r.Jobs, r.Shares, difficulty, r.Elapsed.Round(time.Second))
+		fmt.Println()
+		s := r.Serial
+		fmt.Printf("serial   poll %d bytes %v, dispatch %d bytes %v at %d baud\n",
+			s.PollBytes, s.PollTime, s.DispatchBytes, s.DispatchTime, c.BaudRate)
+		fmt.Printf("polldelay  %d ms configured, %v needed to dispatch a header\n", c.PollDelay, s.MinPollDelay)
+		fmt.Printf("cycle      every board is polled every %v\n", s.Cycle)
+		if r.NonceRangeTime > 0 {
+			fmt.Printf("nonce range traversed in %v per board, noncetimeout is %d ms\n",
+				r.NonceRangeTime.Round(time.Millisecond), c.NonceTraverseTimeout)
+		}
+		if r.Dropped > 0 {
+			fmt.Println("warning:", r.Dropped, "events were dropped, the figures are too low")
+		}
+	},
+}
+
+func init() {
+	benchmarkCmd.Flags().String("chain", "", "name of the devices entry to benchmark, needed if several are configured")
+	benchmarkCmd.Flags().String("algo", "", "algorithm of the programmed bitstream, the one of the chain's pool by default")
+	benchmarkCmd.Flags().Duration("duration", 10*time.Minute, "how long to mine")
+	benchmarkCmd.Flags().Float64("difficulty", 1, "pseudo-difficulty shares are counted at")
+	benchmarkCmd.Flags().Duration("job-interval", 30*time.Second, "time between clean jobs")
+}
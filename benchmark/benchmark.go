@@ -0,0 +1,191 @@
+//Package benchmark runs the boards against synthetic work and measures what limits them:
+// hashrate and invalid nonces per board, how fast a clean job reaches every board
+// and how much of the poll delay the serial link needs.
+package benchmark
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/events"
+)
+
+//Benchmark describes a run
+type Benchmark struct {
+	Algo      string
+	Funcs     driver.MiningFuncs
+	Generator *Generator
+	//Slots are the slots under test, numbered from 1 like skipslots
+	Slots    []int
+	Duration time.Duration
+	//BaudRate and PollDelay are the settings the driver runs with
+	BaudRate  uint
+	PollDelay time.Duration
+}
+
+//Board is the outcome of one slot
+type Board struct {
+	Slot int
+	//Nonces is the number of nonces the board returned, Invalid those that gave a wrong hash
+	Nonces, Invalid int
+	ErrorRate       float64
+	//Hashrate is measured from the valid nonces in hashes per second
+	Hashrate float64
+	//Switches is the number of clean jobs dispatched to the board
+	Switches                        int
+	SwitchLatency, MaxSwitchLatency time.Duration
+}
+
+//Serial is the serial traffic of one poll
+type Serial struct {
+	//PollBytes are written to read the nonces of a board, DispatchBytes to also start a new header
+	PollBytes, DispatchBytes int
+	PollTime, DispatchTime   time.Duration
+	//MinPollDelay is the shortest poll delay that leaves time to dispatch a header
+	MinPollDelay time.Duration
+	//Cycle is the time between two polls of the same board with the configured poll delay
+	Cycle time.Duration
+}
+
+//Report is the outcome of a run
+type Report struct {
+	Elapsed time.Duration
+	Boards  []Board
+	//Nonces, Invalid, ErrorRate and Hashrate sum up the boards
+	Nonces, Invalid int
+	ErrorRate       float64
+	Hashrate        float64
+	//Jobs were issued by the generator, Shares met its pseudo-difficulty
+	Jobs   int
+	Shares uint64
+	//SwitchLatency is the mean time from a clean job to its dispatch on a board
+	SwitchLatency, MaxSwitchLatency time.Duration
+	//NonceRangeTime is how long a board takes to traverse the nonce range at the measured hashrate,
+	// noncetimeout should stay below it for the boards not to run idle
+	NonceRangeTime time.Duration
+	Serial         Serial
+	//Dropped counts events lost because the benchmark could not keep up, the figures are too low if it is not 0
+	Dropped uint64
+}
+
+//Run mines the synthetic work on drv and reports every slot under test.
+// drv must publish its events on bus and be initialized with the slots that are not under test skipped.
+func (b *Benchmark) Run(drv driver.Driver, bus *events.Bus) Report {
+	sub := bus.Subscribe(1<<14, events.NonceFound, events.NonceInvalid, events.JobClean, events.JobDispatched)
+	defer sub.Close()
+
+	boards := make(map[int]*Board)
+	for _, slot := range b.Slots {
+		boards[slot] = &Board{Slot: slot}
+	}
+	switchTotal := make(map[int]time.Duration)
+
+	gen := b.Generator
+	gen.SetNewJobCall(func(jobid string, clean bool) {
+		eventType := events.JobNew
+		if clean {
+			eventType = events.JobClean
+		}
+		bus.Publish(events.New(eventType, -1, -1, map[string]interface{}{"jobid": jobid}))
+	})
+	drv.RegisterMiningFuncs(b.Algo, b.Funcs)
+	drv.SetClient(gen)
+	start := time.Now()
+	gen.Start()
+	drv.Start()
+
+	var lastClean int64
+	deadline := time.After(b.Duration)
+	for done := false; !done; {
+		select {
+		case e := <-sub.C:
+			if e.Type == events.JobClean {
+				lastClean = e.Time
+				continue
+			}
+			board, ok := boards[e.Board+1]
+			if !ok {
+				continue
+			}
+			switch e.Type {
+			case events.NonceFound:
+				board.Nonces++
+			case events.NonceInvalid:
+				board.Invalid++
+			case events.JobDispatched:
+				if clean, _ := e.Data["clean"].(bool); clean && lastClean != 0 {
+					latency := time.Duration(e.Time-lastClean) * time.Millisecond
+					board.Switches++
+					switchTotal[board.Slot] += latency
+					if latency > board.MaxSwitchLatency {
+						board.MaxSwitchLatency = latency
+					}
+				}
+			}
+		case <-deadline:
+			done = true
+		}
+	}
+	drv.Stop()
+	gen.Stop()
+	elapsed := time.Since(start)
+
+	report := Report{Elapsed: elapsed, Jobs: gen.Jobs(), Shares: gen.Shares(), Dropped: sub.Dropped()}
+	hashesPerNonce := difficulty.HashesPerNonce(b.Algo, gen.difficulty)
+	var switches int
+	var switchSum time.Duration
+	for _, board := range boards {
+		if board.Nonces > 0 {
+			board.ErrorRate = float64(board.Invalid) / float64(board.Nonces)
+		}
+		board.Hashrate = float64(board.Nonces-board.Invalid) * hashesPerNonce / elapsed.Seconds()
+		if board.Switches > 0 {
+			board.SwitchLatency = switchTotal[board.Slot] / time.Duration(board.Switches)
+		}
+		if board.MaxSwitchLatency > report.MaxSwitchLatency {
+			report.MaxSwitchLatency = board.MaxSwitchLatency
+		}
+		switches += board.Switches
+		switchSum += switchTotal[board.Slot]
+		report.Nonces += board.Nonces
+		report.Invalid += board.Invalid
+		report.Hashrate += board.Hashrate
+		report.Boards = append(report.Boards, *board)
+	}
+	sort.Slice(report.Boards, func(i, j int) bool { return report.Boards[i].Slot < report.Boards[j].Slot })
+	if report.Nonces > 0 {
+		report.ErrorRate = float64(report.Invalid) / float64(report.Nonces)
+	}
+	if switches > 0 {
+		report.SwitchLatency = switchSum / time.Duration(switches)
+	}
+	if len(boards) > 0 && report.Hashrate > 0 {
+		perBoard := report.Hashrate / float64(len(boards))
+		report.NonceRangeTime = time.Duration(math.Pow(2, 32) / perBoard * float64(time.Second))
+	}
+	report.Serial = b.serial(gen.template.Header)
+	return report
+}
+
+//serial works out the time the serial link needs per poll, a byte takes 10 bits on the line
+func (b *Benchmark) serial(header []byte) (s Serial) {
+	s.PollBytes, s.DispatchBytes = driver.PacketSizes(b.Funcs, header)
+	if b.BaudRate == 0 {
+		return
+	}
+	lineTime := func(n int) time.Duration {
+		return time.Duration(n) * 10 * time.Second / time.Duration(b.BaudRate)
+	}
+	s.PollTime = lineTime(s.PollBytes)
+	s.DispatchTime = lineTime(s.DispatchBytes)
+	s.MinPollDelay = s.DispatchTime + driver.DispatchPause
+	pollDelay := b.PollDelay
+	if pollDelay < s.PollTime {
+		pollDelay = s.PollTime
+	}
+	s.Cycle = time.Duration(len(b.Slots)) * pollDelay
+	return
+}
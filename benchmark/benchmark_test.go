@@ -0,0 +1,159 @@
+package benchmark
+
+import (
+	"bytes"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/driver/protocol"
+	"github.com/AGPFMiner/gominer/events"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//packetFuncs builds a 40 byte header packet
+type packetFuncs struct{}
+
+func (packetFuncs) RegenHash(input []byte) []byte                        { return make([]byte, 32) }
+func (packetFuncs) DiffChecker(hash []byte, work driver.MiningWork) bool { return true }
+func (packetFuncs) ConstructHeaderPackets(header []byte, boardJobID uint8) []byte {
+	return make([]byte, 40)
+}
+
+//syntheticDriver returns a nonce per board every millisecond, board 1 returns a wrong one every other time,
+// and dispatches to every board when a clean job arrives
+type syntheticDriver struct {
+	bus    *events.Bus
+	boards int
+	client clients.Client
+	clean  chan bool
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (d *syntheticDriver) SetClient(c clients.Client) {
+	d.client = c
+	d.clean = make(chan bool, 16)
+	c.SubscribeJobs(d, nil, func() { d.clean <- true })
+}
+
+func (d *syntheticDriver) Start() {
+	d.quit = make(chan struct{})
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-d.quit:
+				return
+			case <-d.clean:
+				for board := 0; board < d.boards; board++ {
+					d.bus.Publish(events.New(events.JobDispatched, board, -1, map[string]interface{}{"clean": true}))
+				}
+			case <-time.After(time.Millisecond):
+				if _, _, _, _, _, err := d.client.GetHeaderForWork(); err != nil {
+					continue
+				}
+				for board := 0; board < d.boards; board++ {
+					d.bus.Publish(events.New(events.NonceFound, board, -1, nil))
+					if board == 1 && i%2 == 0 {
+						d.bus.Publish(events.New(events.NonceInvalid, board, -1, nil))
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (d *syntheticDriver) Stop() {
+	close(d.quit)
+	d.wg.Wait()
+}
+func (d *syntheticDriver) GetDriverStats() types.DriverStates             { return types.DriverStates{} }
+func (d *syntheticDriver) GetDriverStatsMulti() []*types.DriverStates     { return nil }
+func (d *syntheticDriver) RegisterMiningFuncs(string, driver.MiningFuncs) {}
+func (d *syntheticDriver) Init(interface{})                               {}
+func (d *syntheticDriver) ProgramBitstream(string) error                  { return nil }
+
+func TestRun(t *testing.T) {
+	bus := events.NewBus()
+	drv := &syntheticDriver{bus: bus, boards: 3}
+	template := Template{Header: make([]byte, 44), Random: []Span{{36, 8}}}
+	b := Benchmark{
+		Algo:      "ckb",
+		Funcs:     packetFuncs{},
+		Generator: NewGenerator("ckb", template, 1, 50*time.Millisecond),
+		Slots:     []int{1, 2},
+		Duration:  300 * time.Millisecond,
+		BaudRate:  115200,
+		PollDelay: 2 * time.Millisecond,
+	}
+	report := b.Run(drv, bus)
+
+	if len(report.Boards) != 2 || report.Boards[0].Slot != 1 || report.Boards[1].Slot != 2 {
+		t.Fatalf("Unexpected boards %+v", report.Boards)
+	}
+	first, second := report.Boards[0], report.Boards[1]
+	if first.Nonces == 0 || first.Invalid != 0 || first.Hashrate <= 0 {
+		t.Errorf("Unexpected slot 1 %+v", first)
+	}
+	if second.Invalid == 0 || second.ErrorRate < 0.4 || second.ErrorRate > 0.6 {
+		t.Errorf("Unexpected slot 2 %+v", second)
+	}
+	if report.Nonces != first.Nonces+second.Nonces || report.Hashrate != first.Hashrate+second.Hashrate {
+		t.Errorf("Totals do not add up %+v", report)
+	}
+	if report.Jobs < 3 || first.Switches == 0 || report.MaxSwitchLatency < report.SwitchLatency {
+		t.Errorf("Unexpected job switches %+v", report)
+	}
+	if report.NonceRangeTime <= 0 {
+		t.Error("No nonce range time")
+	}
+
+	serial := report.Serial
+	if serial.PollBytes == 0 || serial.DispatchBytes != serial.PollBytes+protocol.RegisterFrameLen+40+serial.PollBytes {
+		t.Errorf("Unexpected packet sizes %+v", serial)
+	}
+	if serial.DispatchTime != time.Duration(serial.DispatchBytes)*10*time.Second/115200 {
+		t.Errorf("Unexpected dispatch time %v", serial.DispatchTime)
+	}
+	if serial.MinPollDelay != serial.DispatchTime+driver.DispatchPause || serial.Cycle != 4*time.Millisecond {
+		t.Errorf("Unexpected poll timing %+v", serial)
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	template := Template{Header: bytes.Repeat([]byte{0xaa}, 48), Random: []Span{{32, 4}, {40, 4}}}
+	g := NewGenerator("veo", template, 2, 0)
+	if _, _, _, _, _, err := g.GetHeaderForWork(); err == nil {
+		t.Error("Got work before the generator started")
+	}
+	g.Start()
+	defer g.Stop()
+
+	_, _, first, _, _, err := g.GetHeaderForWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, diff, second, _, job, _ := g.GetHeaderForWork()
+	if diff != 2 || job.(Job).JobID != "1" {
+		t.Error("Unexpected job", diff, job)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("Two headers are equal")
+	}
+	for i := range second {
+		random := (i >= 32 && i < 36) || (i >= 40 && i < 44)
+		if !random && (first[i] != 0xaa || second[i] != 0xaa) {
+			t.Errorf("Byte %d outside the random spans changed", i)
+		}
+	}
+	half := new(big.Int).Rsh(difficulty.Diff1, 1)
+	if new(big.Int).SetBytes(target).Cmp(half) != 0 {
+		t.Errorf("Unexpected target %X", target)
+	}
+}
@@ -0,0 +1,174 @@
+package benchmark
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//Span is a range of header bytes
+type Span struct {
+	Offset, Size int
+}
+
+//Template is the work layout of an algorithm as its pool client hands it to the driver.
+// Random are the bytes a new extranonce2 changes, they are filled with random bytes for every header.
+type Template struct {
+	Header []byte
+	Random []Span
+}
+
+//Job is the job of a generated header
+type Job struct {
+	JobID string
+}
+
+//Generator produces synthetic work in place of a pool
+type Generator struct {
+	shares uint64
+
+	clients.BaseClient
+	algo        string
+	template    Template
+	target      []byte
+	difficulty  float64
+	jobInterval time.Duration
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+	jobID int
+	quit  chan struct{}
+}
+
+//NewGenerator creates a generator that starts a clean job every jobInterval, never if it is 0.
+// The target of the work is derived from difficulty like a stratum pool does.
+func NewGenerator(algo string, template Template, diff float64, jobInterval time.Duration) *Generator {
+	if diff <= 0 {
+		diff = 1
+	}
+	target, err := difficulty.ToTarget(difficulty.Diff1, diff)
+	if err != nil {
+		target, _ = difficulty.TargetBytes(difficulty.MaxTarget)
+	}
+	g := &Generator{
+		algo:        algo,
+		template:    template,
+		target:      target,
+		difficulty:  diff,
+		jobInterval: jobInterval,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	g.DeprecateOutstandingJobs()
+	return g
+}
+
+//Start issues the first job and starts a new one every job interval
+func (g *Generator) Start() {
+	g.mutex.Lock()
+	g.quit = make(chan struct{})
+	g.newJob(false)
+	quit := g.quit
+	g.mutex.Unlock()
+
+	if g.jobInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(g.jobInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				g.mutex.Lock()
+				g.newJob(true)
+				g.mutex.Unlock()
+			}
+		}
+	}()
+}
+
+//newJob must be called with the mutex held
+func (g *Generator) newJob(clean bool) {
+	if clean {
+		g.DeprecateOutstandingJobs()
+	}
+	g.jobID++
+	g.AddJobToDeprecate(strconv.Itoa(g.jobID))
+}
+
+//Stop stops issuing jobs
+func (g *Generator) Stop() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.quit != nil {
+		close(g.quit)
+		g.quit = nil
+	}
+}
+
+//SetNewJobCall sets the function to be called when a job is issued
+func (g *Generator) SetNewJobCall(call clients.NewJobCall) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.BaseClient.SetNewJobCall(call)
+}
+
+//GetHeaderForWork returns the template with fresh random bytes
+func (g *Generator) GetHeaderForWork() (target []byte, difficulty float64, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.jobID == 0 {
+		err = errors.New("No job generated yet")
+		return
+	}
+	jobID := strconv.Itoa(g.jobID)
+	header = append([]byte(nil), g.template.Header...)
+	for _, span := range g.template.Random {
+		g.rand.Read(header[span.Offset : span.Offset+span.Size])
+	}
+	return g.target, g.difficulty, header, g.GetDeprecationChannel(jobID), Job{JobID: jobID}, nil
+}
+
+//SubmitHeader counts the share, there is no pool to submit to
+func (g *Generator) SubmitHeader(nonce []byte, job interface{}) (err error) {
+	atomic.AddUint64(&g.shares, 1)
+	return nil
+}
+
+//Shares is the number of shares submitted so far
+func (g *Generator) Shares() uint64 {
+	return atomic.LoadUint64(&g.shares)
+}
+
+//Jobs is the number of jobs issued so far
+func (g *Generator) Jobs() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.jobID
+}
+
+func (g *Generator) AlgoName() string {
+	return g.algo
+}
+
+func (g *Generator) PoolConnectionStates() types.PoolConnectionStates {
+	return types.Alive
+}
+
+func (g *Generator) GetPoolStats() (info types.PoolStates) {
+	info.Status = types.Alive
+	info.PoolAddr = "synthetic"
+	info.Algo = g.algo
+	info.Diff = g.difficulty
+	info.Accept = int32(g.Shares())
+	return
+}
@@ -14,6 +14,9 @@ var (
 	gray = [13]uint8{0, 3, 2, 6, 7, 5, 4, 12, 13, 15, 14, 10, 11}
 )
 
+//MaxBoards is the number of slots the mux can address, slots are numbered from 1
+const MaxBoards = len(gray) - 1
+
 func SelectConsole(boardID uint8) {
 	selectPin(ConsolePins, gray[boardID])
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/AGPFMiner/gominer/config"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/miner"
+
+	"github.com/spf13/cobra"
+)
+
+// The boards command groups the board maintenance tools. They drive the
+// mux and openocd directly, so stop the miner before using them.
+// They work on one chain, select it with --chain if devices lists several.
+var boardsCmd = &cobra.Command{
+	Use:   "boards",
+	Short: "List, reset and program the boards.",
+}
+
+var boardsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the board slots, with --health read their temperature and voltage.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		_, _, c := boardChain(cmd)
+		health, _ := cmd.Flags().GetBool("health")
+		if health {
+			openBoards(c)
+		}
+		skipped := skippedSlots(c)
+		for slot := 1; slot <= c.MuxNums; slot++ {
+			line := fmt.Sprintf("slot %2d", slot)
+			switch {
+			case skipped[slot]:
+				line += "  skipped"
+			case health:
+				temp, voltage, err := driver.BoardHealth(slot-1, c.MuxNums)
+				if err != nil {
+					line += "  error: " + err.Error()
+				} else {
+					line += fmt.Sprintf("  %s C  %s V", temp, voltage)
+				}
+			default:
+				line += "  enabled"
+			}
+			fmt.Println(line)
+		}
+	},
+}
+
+var boardsResetCmd = &cobra.Command{
+	Use:   "reset [slot...]",
+	Short: "Pulse the reset line of the given slots, all enabled slots if none are given.",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, _, c := boardChain(cmd)
+		slots, err := selectSlots(c, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		openBoards(c)
+		for _, slot := range slots {
+			if err := driver.ResetBoard(slot-1, c.MuxNums); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("slot", slot, "reset")
+		}
+	},
+}
+
+var boardsProgramCmd = &cobra.Command{
+	Use:   "program [slot...]",
+	Short: "Load a bitstream onto the given slots, all enabled slots if none are given.",
+	Long: "Load a bitstream onto the given slots, all enabled slots if none are given.\n" +
+		"Without --bitstream the bitstream of the algorithm of the chain's pool is used.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, _, c := boardChain(cmd)
+		slots, err := selectSlots(c, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bitstream, _ := cmd.Flags().GetString("bitstream")
+		if bitstream == "" {
+			bitstream, err = defaultBitstream(cfg, c)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if _, err := os.Stat(driver.BitstreamPath(bitstream)); err != nil {
+			log.Fatal(err)
+		}
+		openBoards(c)
+		failed := false
+		for _, slot := range slots {
+			if err := driver.ProgramBoard(slot-1, c.MuxNums, bitstream); err != nil {
+				fmt.Println("slot", slot, "failed:", err)
+				failed = true
+				continue
+			}
+			fmt.Println("slot", slot, "programmed with", bitstream)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var boardsTestCmd = &cobra.Command{
+	Use:   "test [slot...]",
+	Short: "Mine a known-answer job on the given slots and report pass or fail per slot.",
+	Long: "Mine a known-answer job on the given slots, all enabled slots if none are given,\n" +
+		"and check every nonce the boards return. A slot passes when it finds the known\n" +
+		"winning nonce and stays under --max-error-rate. The boards must already be\n" +
+		"programmed, see boards program.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, m, c := boardChain(cmd)
+		slots, err := selectSlots(c, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		algo, _ := cmd.Flags().GetString("algo")
+		if algo == "" {
+			algo = chainAlgo(cfg, c)
+		}
+		duration, _ := cmd.Flags().GetDuration("duration")
+		maxErrorRate, _ := cmd.Flags().GetFloat64("max-error-rate")
+
+		results, err := m.TestBoards(c, algo, slots, duration, maxErrorRate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		failed := false
+		for _, r := range results {
+			verdict := "PASS"
+			if !r.Pass {
+				verdict = "FAIL"
+				failed = true
+			}
+			fmt.Printf("slot %2d  %s  nonces %d  invalid %d (%.1f%%)  known nonce %v  %.2f MH/s\n",
+				r.Slot, verdict, r.Nonces, r.Invalid, r.ErrorRate*100, r.Found, r.Hashrate/1e6)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	boardsCmd.PersistentFlags().String("chain", "", "name of the devices entry to work on, needed if several are configured")
+	boardsTestCmd.Flags().String("algo", "", "algorithm of the programmed bitstream, the one of the chain's pool by default")
+	boardsTestCmd.Flags().Duration("duration", time.Minute, "how long to mine the known-answer job")
+	boardsTestCmd.Flags().Float64("max-error-rate", 0.01, "share of invalid nonces a passing slot may return")
+	boardsListCmd.Flags().Bool("health", false, "read temperature and voltage through openocd")
+	boardsProgramCmd.Flags().String("bitstream", "", "bitstream file, relative to "+driver.BitStreamDir)
+	boardsCmd.AddCommand(boardsListCmd, boardsResetCmd, boardsProgramCmd, boardsTestCmd)
+}
+
+// boardChain loads the configuration and the chain selected by --chain
+func boardChain(cmd *cobra.Command) (*config.Config, *miner.Miner, miner.Chain) {
+	cfg := loadConfig()
+	m := &miner.Miner{}
+	cfg.Apply(m)
+	name, _ := cmd.Flags().GetString("chain")
+	c, err := m.BoardChain(name)
+	if err != nil {
+		log.Fatal(err, ", use --chain")
+	}
+	return cfg, m, c
+}
+
+func openBoards(c miner.Chain) {
+	if err := driver.OpenBoards(c.MuxNums); err != nil {
+		log.Fatal("Cannot open GPIO: ", err)
+	}
+}
+
+func skippedSlots(c miner.Chain) map[int]bool {
+	skipped := make(map[int]bool)
+	for _, slot := range c.SkipSlots {
+		skipped[slot] = true
+	}
+	return skipped
+}
+
+// selectSlots parses the slot arguments, numbered from 1 like skipslots.
+// Without arguments every slot of c that is not skipped is selected.
+func selectSlots(c miner.Chain, args []string) (slots []int, err error) {
+	if len(args) == 0 {
+		skipped := skippedSlots(c)
+		for slot := 1; slot <= c.MuxNums; slot++ {
+			if !skipped[slot] {
+				slots = append(slots, slot)
+			}
+		}
+		return
+	}
+	for _, arg := range args {
+		slot, err := strconv.Atoi(arg)
+		if err != nil || slot < 1 || slot > c.MuxNums {
+			return nil, fmt.Errorf("invalid slot %q, slots of chain %s are numbered 1-%d", arg, c.Name, c.MuxNums)
+		}
+		slots = append(slots, slot)
+	}
+	return
+}
+
+// chainAlgo is the algorithm of the pool c mines for, the active pool if c follows it
+func chainAlgo(cfg *config.Config, c miner.Chain) string {
+	if c.Pool >= 0 && c.Pool < len(cfg.Pools) {
+		return cfg.Pools[c.Pool].Algo
+	}
+	for _, pool := range cfg.Pools {
+		if pool.Active {
+			return pool.Algo
+		}
+	}
+	return ""
+}
+
+func defaultBitstream(cfg *config.Config, c miner.Chain) (string, error) {
+	algo := chainAlgo(cfg, c)
+	switch algo {
+	case "":
+		return "", errors.New("No active pool, use --bitstream")
+	case "odocrypt":
+		return "", errors.New("The odocrypt bitstream changes every 10 days, use --bitstream")
+	}
+	return algo + ".bit", nil
+}
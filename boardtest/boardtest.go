@@ -0,0 +1,100 @@
+//Package boardtest checks boards with known-answer jobs: every slot under test mines a fixed
+// header whose winning nonce is known, and the nonces the boards return are verified on the host.
+package boardtest
+
+import (
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/events"
+)
+
+//Test describes a known-answer run
+type Test struct {
+	Algo        string
+	KnownAnswer driver.KnownAnswer
+	Funcs       driver.MiningFuncs
+	//Slots are the slots under test, numbered from 1 like skipslots
+	Slots []int
+	//Duration should cover a few traversals of the nonce range
+	Duration time.Duration
+	//MaxErrorRate is the share of invalid nonces a passing board may return
+	MaxErrorRate float64
+}
+
+//Result is the outcome of one slot
+type Result struct {
+	Slot int
+	//Nonces is the number of nonces the board returned, Invalid those that are no golden nonce
+	Nonces, Invalid int
+	//Found tells whether the known winning nonce was returned
+	Found     bool
+	ErrorRate float64
+	//Hashrate is measured from the valid nonces in hashes per second
+	Hashrate float64
+	Pass     bool
+}
+
+//Run mines the known-answer job on drv and reports every slot under test.
+// drv must publish its nonces on bus and be initialized with the slots that are not under test skipped.
+func (t *Test) Run(drv driver.Driver, bus *events.Bus) []Result {
+	sub := bus.Subscribe(4096, events.NonceFound)
+	defer sub.Close()
+
+	results := make(map[int]*Result)
+	for _, slot := range t.Slots {
+		results[slot] = &Result{Slot: slot}
+	}
+
+	drv.RegisterMiningFuncs(t.Algo, t.Funcs)
+	drv.SetClient(NewClient(t.Algo, t.KnownAnswer))
+	start := time.Now()
+	drv.Start()
+	deadline := time.After(t.Duration)
+	for done := false; !done; {
+		select {
+		case e := <-sub.C:
+			if r, ok := results[e.Board+1]; ok {
+				t.count(r, e)
+			}
+		case <-deadline:
+			done = true
+		}
+	}
+	drv.Stop()
+	elapsed := time.Since(start).Seconds()
+
+	report := make([]Result, 0, len(results))
+	hashesPerNonce := difficulty.HashesPerNonce(t.Algo, 1)
+	for _, r := range results {
+		if r.Nonces > 0 {
+			r.ErrorRate = float64(r.Invalid) / float64(r.Nonces)
+		}
+		r.Hashrate = float64(r.Nonces-r.Invalid) * hashesPerNonce / elapsed
+		r.Pass = r.Found && r.ErrorRate <= t.MaxErrorRate
+		report = append(report, *r)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Slot < report[j].Slot })
+	return report
+}
+
+func (t *Test) count(r *Result, e events.Event) {
+	r.Nonces++
+	encoded, _ := e.Data["nonce"].(string)
+	raw, err := hex.DecodeString(encoded)
+	var nonce [8]byte
+	if err != nil || len(raw) != len(nonce) {
+		r.Invalid++
+		return
+	}
+	copy(nonce[:], raw)
+	if nonce == t.KnownAnswer.Nonce {
+		r.Found = true
+	}
+	if !t.KnownAnswer.Check(t.Funcs, nonce) {
+		r.Invalid++
+	}
+}
@@ -0,0 +1,96 @@
+package boardtest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/events"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//evenFuncs regenerates a golden hash for nonces ending in an even byte
+type evenFuncs struct{}
+
+func (evenFuncs) RegenHash(input []byte) []byte {
+	if input[len(input)-1]%2 == 0 {
+		return make([]byte, 32)
+	}
+	return []byte{0xff, 0xff, 0xff, 0xff}
+}
+
+func (evenFuncs) DiffChecker(hash []byte, work driver.MiningWork) bool { return true }
+
+func (evenFuncs) ConstructHeaderPackets(header []byte, boardJobID uint8) []byte { return nil }
+
+//replayDriver publishes a fixed list of nonces per board when started
+type replayDriver struct {
+	bus    *events.Bus
+	nonces map[int][]byte
+	client clients.Client
+}
+
+func (d *replayDriver) Start() {
+	for board, lastBytes := range d.nonces {
+		for _, last := range lastBytes {
+			nonce := fmt.Sprintf("00000000000000%02X", last)
+			d.bus.Publish(events.New(events.NonceFound, board, -1, map[string]interface{}{"jobid": 1, "nonce": nonce}))
+		}
+	}
+}
+func (d *replayDriver) Stop()                                          {}
+func (d *replayDriver) GetDriverStats() types.DriverStates             { return types.DriverStates{} }
+func (d *replayDriver) GetDriverStatsMulti() []*types.DriverStates     { return nil }
+func (d *replayDriver) RegisterMiningFuncs(string, driver.MiningFuncs) {}
+func (d *replayDriver) Init(interface{})                               {}
+func (d *replayDriver) ProgramBitstream(string) error                  { return nil }
+func (d *replayDriver) SetClient(c clients.Client)                     { d.client = c }
+
+func TestRun(t *testing.T) {
+	bus := events.NewBus()
+	drv := &replayDriver{bus: bus, nonces: map[int][]byte{
+		0: {0x02, 0x04, 0x42},       // slot 1 finds the known nonce
+		1: {0x02, 0x04, 0x06, 0x08}, // slot 2 works but misses it
+		2: {0x42, 0x01, 0x03},       // slot 3 mostly returns garbage
+		5: {0x42},                   // slot 6 is not under test
+	}}
+	test := Test{
+		Algo:         "ckb",
+		KnownAnswer:  driver.KnownAnswer{Header: []byte{1, 2, 3}, Nonce: [8]byte{7: 0x42}},
+		Funcs:        evenFuncs{},
+		Slots:        []int{1, 2, 3, 4},
+		Duration:     100 * time.Millisecond,
+		MaxErrorRate: 0.1,
+	}
+	results := test.Run(drv, bus)
+
+	if drv.client.AlgoName() != "ckb" {
+		t.Error("The known-answer client was not set")
+	}
+	expected := []struct {
+		nonces, invalid int
+		found, pass     bool
+	}{
+		{3, 0, true, true},
+		{4, 0, false, false},
+		{3, 2, true, false},
+		{0, 0, false, false},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Got %d results, expected %d", len(results), len(expected))
+	}
+	for i, e := range expected {
+		r := results[i]
+		if r.Slot != i+1 || r.Nonces != e.nonces || r.Invalid != e.invalid || r.Found != e.found || r.Pass != e.pass {
+			t.Errorf("slot %d: got %+v, expected %+v", i+1, r, e)
+		}
+	}
+	if results[0].Hashrate <= 0 || results[3].Hashrate != 0 {
+		t.Error("Unexpected hashrates", results[0].Hashrate, results[3].Hashrate)
+	}
+	if results[2].ErrorRate < 0.66 || results[2].ErrorRate > 0.67 {
+		t.Error("Unexpected error rate", results[2].ErrorRate)
+	}
+}
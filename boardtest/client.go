@@ -0,0 +1,58 @@
+package boardtest
+
+import (
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//Client hands out the known-answer job in place of a pool
+type Client struct {
+	clients.BaseClient
+	algo   string
+	answer driver.KnownAnswer
+}
+
+//NewClient creates a client that always returns the header of answer
+func NewClient(algo string, answer driver.KnownAnswer) *Client {
+	return &Client{algo: algo, answer: answer}
+}
+
+//anyTarget accepts every hash, shares are counted from the nonces and never submitted
+var anyTarget = []byte{
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+}
+
+//GetHeaderForWork returns a copy of the known-answer header
+func (c *Client) GetHeaderForWork() (target []byte, difficulty float64, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	header = append([]byte(nil), c.answer.Header...)
+	return anyTarget, 1, header, make(chan bool), nil, nil
+}
+
+//SubmitHeader does nothing, there is no pool to submit to
+func (c *Client) SubmitHeader(nonce []byte, job interface{}) (err error) {
+	return nil
+}
+
+//Start does nothing
+func (c *Client) Start() {}
+
+//Stop does nothing
+func (c *Client) Stop() {}
+
+func (c *Client) AlgoName() string {
+	return c.algo
+}
+
+func (c *Client) PoolConnectionStates() types.PoolConnectionStates {
+	return types.Alive
+}
+
+func (c *Client) GetPoolStats() (info types.PoolStates) {
+	info.Status = types.Alive
+	info.PoolAddr = "known-answer"
+	info.Algo = c.algo
+	info.Diff = 1
+	return
+}
@@ -1,7 +1,11 @@
 //Package clients provides some utilities and common code for specific client implementations
 package clients
 
-import "github.com/AGPFMiner/gominer/types"
+import (
+	"sync"
+
+	"github.com/AGPFMiner/gominer/types"
+)
 
 //HeaderReporter defines the required method a Groestl client or pool client should implement for miners to be able to report solved headers
 type HeaderReporter interface {
@@ -24,6 +28,10 @@ type DeprecatedJobCall func(jobid string)
 // cleanJob is true
 type CleanJobEventCall func()
 
+//NewJobCall is a function that can be registered on a client to be executed when
+// a job arrives, clean is true if the previous jobs were abandoned for it
+type NewJobCall func(jobid string, clean bool)
+
 // Client defines the interface for a client towards a work provider
 type Client interface {
 	HeaderProvider
@@ -33,16 +41,32 @@ type Client interface {
 	AlgoName() (algo string)
 	PoolConnectionStates() (stats types.PoolConnectionStates)
 	GetPoolStats() (stats types.PoolStates)
-	SetDeprecatedJobCall(call DeprecatedJobCall)
-	SetCleanJobEventCall(call CleanJobEventCall)
+	//SubscribeJobs registers the calls of subscriber, every driver mining on the client is one.
+	// Subscribing again replaces the calls of subscriber, nil calls are skipped.
+	SubscribeJobs(subscriber interface{}, deprecated DeprecatedJobCall, clean CleanJobEventCall)
+	//UnsubscribeJobs removes the calls of subscriber
+	UnsubscribeJobs(subscriber interface{})
+	SetNewJobCall(call NewJobCall)
+}
+
+//jobCalls are the calls of a subscriber of a client
+type jobCalls struct {
+	deprecated DeprecatedJobCall
+	clean      CleanJobEventCall
 }
 
 //BaseClient implements some common properties and functionality
 type BaseClient struct {
 	deprecationChannels map[string]chan bool
 
-	deprecatedJobCall DeprecatedJobCall
-	cleanJobEventCall CleanJobEventCall
+	newJobCall   NewJobCall
+	cleanPending bool
+
+	subscriberMutex sync.Mutex // protects following
+	subscribers     map[interface{}]jobCalls
+
+	sessionOnce sync.Once
+	sessionRec  *sessionRecorder
 }
 
 //DeprecateOutstandingJobs closes all deprecationChannels and removes them from the list
@@ -52,24 +76,43 @@ func (sc *BaseClient) DeprecateOutstandingJobs() {
 		sc.deprecationChannels = make(map[string]chan bool)
 	}
 
-	call := sc.deprecatedJobCall
+	calls := sc.jobCalls()
 
 	for jobid, deprecatedJob := range sc.deprecationChannels {
 		close(deprecatedJob)
 		delete(sc.deprecationChannels, jobid)
-		if call != nil {
-			go call(jobid)
+		for _, call := range calls {
+			if call.deprecated != nil {
+				go call.deprecated(jobid)
+			}
+		}
+	}
+	sc.cleanPending = true
+	for _, call := range calls {
+		if call.clean != nil {
+			go call.clean()
 		}
 	}
-	cleanJobEventCall := sc.cleanJobEventCall
-	if cleanJobEventCall != nil {
-		go cleanJobEventCall()
+}
+
+//jobCalls copies the calls of the subscribers
+func (sc *BaseClient) jobCalls() (calls []jobCalls) {
+	sc.subscriberMutex.Lock()
+	defer sc.subscriberMutex.Unlock()
+	for _, call := range sc.subscribers {
+		calls = append(calls, call)
 	}
+	return
 }
 
 // AddJobToDeprecate add the jobid to the list of jobs that should be deprecated when the times comes
 func (sc *BaseClient) AddJobToDeprecate(jobid string) {
 	sc.deprecationChannels[jobid] = make(chan bool)
+	clean := sc.cleanPending
+	sc.cleanPending = false
+	if call := sc.newJobCall; call != nil {
+		go call(jobid, clean)
+	}
 }
 
 // GetDeprecationChannel return the channel that will be closed when a job gets deprecated
@@ -77,12 +120,25 @@ func (sc *BaseClient) GetDeprecationChannel(jobid string) chan bool {
 	return sc.deprecationChannels[jobid]
 }
 
-//SetDeprecatedJobCall sets the function to be called when the previous jobs should be abandoned
-func (sc *BaseClient) SetDeprecatedJobCall(call DeprecatedJobCall) {
-	sc.deprecatedJobCall = call
+//SubscribeJobs has deprecated called for every abandoned job and clean once the previous jobs should be
+// abandoned, next to the calls of the other subscribers
+func (sc *BaseClient) SubscribeJobs(subscriber interface{}, deprecated DeprecatedJobCall, clean CleanJobEventCall) {
+	sc.subscriberMutex.Lock()
+	defer sc.subscriberMutex.Unlock()
+	if sc.subscribers == nil {
+		sc.subscribers = make(map[interface{}]jobCalls)
+	}
+	sc.subscribers[subscriber] = jobCalls{deprecated: deprecated, clean: clean}
+}
+
+//UnsubscribeJobs removes the calls of subscriber, the client no longer calls it
+func (sc *BaseClient) UnsubscribeJobs(subscriber interface{}) {
+	sc.subscriberMutex.Lock()
+	defer sc.subscriberMutex.Unlock()
+	delete(sc.subscribers, subscriber)
 }
 
-//SetCleanJobEventCall sets the function to be called when the previous jobs should be abandoned
-func (sc *BaseClient) SetCleanJobEventCall(call CleanJobEventCall) {
-	sc.cleanJobEventCall = call
+//SetNewJobCall sets the function to be called when a new job arrives
+func (sc *BaseClient) SetNewJobCall(call NewJobCall) {
+	sc.newJobCall = call
 }
@@ -1 +1,51 @@
 package clients
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscribeJobs(t *testing.T) {
+	var c BaseClient
+	var mutex sync.Mutex
+	deprecated, cleaned := make(map[string]int), make(map[string]int)
+	subscribe := func(name string) {
+		c.SubscribeJobs(name, func(jobid string) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			deprecated[name]++
+		}, func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+			cleaned[name]++
+		})
+	}
+	//every driver mining on the client hears of a clean job, not only the last one subscribed
+	subscribe("chain0")
+	subscribe("chain1")
+	subscribe("chain2")
+	c.UnsubscribeJobs("chain2")
+	c.DeprecateOutstandingJobs()
+	c.AddJobToDeprecate("1")
+	c.DeprecateOutstandingJobs()
+
+	for i := 0; ; i++ {
+		mutex.Lock()
+		done := deprecated["chain0"] == 1 && deprecated["chain1"] == 1 && cleaned["chain0"] == 2 && cleaned["chain1"] == 2
+		mutex.Unlock()
+		if done {
+			break
+		}
+		if i == 200 {
+			t.Fatal("deprecated", deprecated, "cleaned", cleaned)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if deprecated["chain2"] != 0 || cleaned["chain2"] != 0 {
+		t.Error("unsubscribed driver called")
+	}
+}
@@ -0,0 +1,251 @@
+package gbt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/difficulty"
+)
+
+//CoinbaseTag is written into the coinbase after the height and the extranonce
+const CoinbaseTag = "/gominer/"
+
+//ExtraNonceSize is the size of the extranonce in the coinbase, every header handed out gets its own
+const ExtraNonceSize = 8
+
+//Template is the reply of getblocktemplate, the fields the client uses
+type Template struct {
+	Version           uint32        `json:"version"`
+	PreviousBlockHash string        `json:"previousblockhash"`
+	Transactions      []Transaction `json:"transactions"`
+	CoinbaseValue     int64         `json:"coinbasevalue"`
+	Target            string        `json:"target"`
+	CurTime           uint32        `json:"curtime"`
+	Bits              string        `json:"bits"`
+	Height            int64         `json:"height"`
+	LongPollID        string        `json:"longpollid"`
+	//WitnessCommitment is the output script committing to the witnesses, only for segwit blocks
+	WitnessCommitment string `json:"default_witness_commitment"`
+}
+
+//Transaction is a transaction of a block template
+type Transaction struct {
+	Data string `json:"data"`
+	TxID string `json:"txid"`
+	//Hash is the witness hash, nodes without segwit send the txid here
+	Hash string `json:"hash"`
+}
+
+//block is a decoded template, hashes are in the byte order of the serialized block
+type block struct {
+	template   Template
+	prevHash   []byte
+	bits       []byte
+	target     []byte
+	commitment []byte
+	txids      [][]byte
+	txs        []byte
+}
+
+//newBlock decodes the hex fields of a template
+func newBlock(t Template) (b *block, err error) {
+	b = &block{template: t}
+	if b.prevHash, err = decodeHash("previousblockhash", t.PreviousBlockHash); err != nil {
+		return nil, err
+	}
+	if b.target, err = decodeHex("target", t.Target, 32); err != nil {
+		return nil, err
+	}
+	if b.bits, err = decodeHex("bits", t.Bits, 4); err != nil {
+		return nil, err
+	}
+	b.bits = stratum.ReverseByteSlice(b.bits)
+	if t.WitnessCommitment != "" {
+		if b.commitment, err = decodeHex("default_witness_commitment", t.WitnessCommitment, -1); err != nil {
+			return nil, err
+		}
+	}
+	if t.CoinbaseValue < 0 {
+		return nil, errors.New("Negative coinbasevalue in the block template")
+	}
+	for i, tx := range t.Transactions {
+		txid := tx.TxID
+		if txid == "" {
+			txid = tx.Hash
+		}
+		hash, err := decodeHash(fmt.Sprintf("transactions[%d].txid", i), txid)
+		if err != nil {
+			return nil, err
+		}
+		data, err := decodeHex(fmt.Sprintf("transactions[%d].data", i), tx.Data, -1)
+		if err != nil {
+			return nil, err
+		}
+		b.txids = append(b.txids, hash)
+		b.txs = append(b.txs, data...)
+	}
+	return
+}
+
+//decodeHex decodes the hex field name of a template, size is the expected length or -1 for any
+func decodeHex(name, s string, size int) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || (size >= 0 && len(b) != size) {
+		return nil, fmt.Errorf("Invalid %s %q in the block template", name, s)
+	}
+	return b, nil
+}
+
+//decodeHash decodes a hash as the node displays it into the byte order of the block
+func decodeHash(name, s string) ([]byte, error) {
+	b, err := decodeHex(name, s, 32)
+	if err != nil {
+		return nil, err
+	}
+	return stratum.ReverseByteSlice(b), nil
+}
+
+//coinbase serializes the coinbase transaction paying the block reward to script. The height goes first
+// in the script as BIP34 requires. witness adds the witness reserved value segwit blocks need, the txid
+// is the hash of the transaction without it.
+func (b *block) coinbase(script, extraNonce []byte, witness bool) []byte {
+	sigScript := append(scriptNumber(b.template.Height), pushData(extraNonce)...)
+	sigScript = append(sigScript, pushData([]byte(CoinbaseTag))...)
+
+	tx := []byte{1, 0, 0, 0}
+	if witness {
+		tx = append(tx, 0, 1) //marker and flag
+	}
+	tx = appendVarInt(tx, 1)
+	tx = append(tx, make([]byte, 32)...)
+	tx = append(tx, 0xff, 0xff, 0xff, 0xff)
+	tx = appendVarInt(tx, uint64(len(sigScript)))
+	tx = append(tx, sigScript...)
+	tx = append(tx, 0xff, 0xff, 0xff, 0xff)
+
+	outputs := uint64(1)
+	if b.commitment != nil {
+		outputs++
+	}
+	tx = appendVarInt(tx, outputs)
+	tx = appendOutput(tx, uint64(b.template.CoinbaseValue), script)
+	if b.commitment != nil {
+		tx = appendOutput(tx, 0, b.commitment)
+	}
+	if witness {
+		tx = appendVarInt(tx, 1)
+		tx = appendVarInt(tx, 32)
+		tx = append(tx, make([]byte, 32)...)
+	}
+	return append(tx, 0, 0, 0, 0) //lock time
+}
+
+//difficulty is the network difficulty of the block
+func (b *block) difficulty() float64 {
+	return difficulty.FromTarget(difficulty.Diff1, b.target)
+}
+
+//segwit tells whether the block commits to witnesses, its coinbase then carries the witness reserved value
+func (b *block) segwit() bool {
+	return b.commitment != nil
+}
+
+//merkleRoot is the root of the merkle tree of the coinbase txid and the transactions of the template
+func (b *block) merkleRoot(coinbaseTxID []byte) []byte {
+	return MerkleRoot(append([][]byte{coinbaseTxID}, b.txids...))
+}
+
+//header serializes the block header for merkleRoot with an empty nonce
+func (b *block) header(merkleRoot []byte) []byte {
+	header := make([]byte, 80)
+	binary.LittleEndian.PutUint32(header[0:], b.template.Version)
+	copy(header[4:], b.prevHash)
+	copy(header[36:], merkleRoot)
+	binary.LittleEndian.PutUint32(header[68:], b.template.CurTime)
+	copy(header[72:], b.bits)
+	return header
+}
+
+//serialize joins the solved header, the coinbase and the transactions of the template into a block
+func (b *block) serialize(header, coinbase []byte) []byte {
+	block := append([]byte{}, header...)
+	block = appendVarInt(block, uint64(1+len(b.txids)))
+	block = append(block, coinbase...)
+	return append(block, b.txs...)
+}
+
+//MerkleRoot folds the hashes of the transactions of a block into its merkle root, an odd hash
+// at the end of a level is paired with itself
+func MerkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return make([]byte, 32)
+	}
+	level := hashes
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, stratum.SHA256d(append(append([]byte{}, level[i]...), right...)))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+//txID is the hash of a transaction serialized without witnesses
+func txID(tx []byte) []byte {
+	return stratum.SHA256d(tx)
+}
+
+func appendVarInt(b []byte, n uint64) []byte {
+	switch {
+	case n < 0xfd:
+		return append(b, byte(n))
+	case n <= 0xffff:
+		return append(b, 0xfd, byte(n), byte(n>>8))
+	case n <= 0xffffffff:
+		b = append(b, 0xfe, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(n))
+		return b
+	}
+	b = append(b, 0xff, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(b[len(b)-8:], n)
+	return b
+}
+
+func appendOutput(b []byte, value uint64, script []byte) []byte {
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(b[len(b)-8:], value)
+	b = appendVarInt(b, uint64(len(script)))
+	return append(b, script...)
+}
+
+//pushData is the script pushing data of up to 75 bytes
+func pushData(data []byte) []byte {
+	return append([]byte{byte(len(data))}, data...)
+}
+
+//scriptNumber is the script pushing a non-negative number, OP_1 to OP_16 for the small ones as the nodes do
+func scriptNumber(n int64) []byte {
+	if n == 0 {
+		return []byte{0}
+	}
+	if n <= 16 {
+		return []byte{0x50 + byte(n)}
+	}
+	var num []byte
+	for ; n > 0; n >>= 8 {
+		num = append(num, byte(n))
+	}
+	//the top bit is the sign
+	if num[len(num)-1]&0x80 != 0 {
+		num = append(num, 0)
+	}
+	return pushData(num)
+}
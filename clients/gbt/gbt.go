@@ -0,0 +1,293 @@
+//Package gbt mines solo on a node of a bitcoin derived coin through getblocktemplate and submitblock.
+// The client builds the coinbase paying the block reward to a payout address, a header per extranonce,
+// and follows the node with longpoll to abandon the jobs once a new block arrives.
+package gbt
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+const (
+	//PollInterval is how often the template is fetched from nodes without longpoll
+	PollInterval = 5 * time.Second
+	//RetryInterval is the wait after a failed call to the node
+	RetryInterval = 10 * time.Second
+	//RequestTimeout bounds every call except the longpoll
+	RequestTimeout = 30 * time.Second
+)
+
+var (
+	//ErrNoTemplate is returned by GetHeaderForWork before the first template arrived
+	ErrNoTemplate = errors.New("No block template received from the node yet")
+	//ErrNoPayout is returned when no payout address is configured
+	ErrNoPayout = errors.New("No payout address configured")
+)
+
+//Job is the work of a header handed out by the client, the block is rebuilt from it on submit
+type Job struct {
+	ID string
+	//Header is the block header with an empty nonce
+	Header []byte
+	//Coinbase is the coinbase transaction as it goes into the block
+	Coinbase []byte
+
+	block *block
+}
+
+//Client mines on the block templates of a node
+type Client struct {
+	accept, reject, discard int32
+	lastAccept              int64
+
+	//URL is the 'http://host:port' address of the RPC server of the node
+	URL      string
+	User     string
+	Password string
+	Algo     string
+	//Payout is the address the block reward is paid to
+	Payout string
+
+	rpc    *clients.RPCClient
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex      sync.Mutex // protects following
+	script     []byte
+	block      *block
+	jobID      string
+	templates  int
+	extraNonce jobs.ExtraNonce2
+	status     types.PoolConnectionStates
+	clients.BaseClient
+}
+
+//NewClient creates a client for the node of pool, pool.URL is its RPC address and pool.User and
+// pool.Pass the RPC credentials
+func NewClient(pool *types.Pool) *Client {
+	c := &Client{URL: pool.URL, User: pool.User, Password: pool.Pass, Algo: pool.Algo, Payout: pool.Payout}
+	c.rpc = &clients.RPCClient{URL: pool.URL, User: pool.User, Password: pool.Pass, Version: "1.0"}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.status = types.NotReady
+	return c
+}
+
+//AlgoName returns the algorithm of the node
+func (c *Client) AlgoName() string {
+	return c.Algo
+}
+
+//Start resolves the payout address and follows the templates of the node until Stop is called
+func (c *Client) Start() {
+	for c.ctx.Err() == nil {
+		if err := c.resolvePayout(); err != nil {
+			log.Println("ERROR Unable to resolve the payout address:", err)
+			c.setStatus(types.Dead)
+			c.wait(RetryInterval)
+			continue
+		}
+		break
+	}
+	longpollID := ""
+	for c.ctx.Err() == nil {
+		t, err := c.getBlockTemplate(longpollID)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			log.Println("ERROR getblocktemplate failed:", err)
+			c.setStatus(types.Sick)
+			longpollID = ""
+			c.wait(RetryInterval)
+			continue
+		}
+		if err = c.setTemplate(t); err != nil {
+			log.Println("ERROR", err)
+			c.setStatus(types.Sick)
+			c.wait(RetryInterval)
+			continue
+		}
+		longpollID = t.LongPollID
+		if longpollID == "" {
+			c.wait(PollInterval)
+		}
+	}
+}
+
+//Stop ends Start and aborts a pending longpoll
+func (c *Client) Stop() {
+	c.cancel()
+}
+
+//wait sleeps for d unless the client is stopped
+func (c *Client) wait(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *Client) setStatus(status types.PoolConnectionStates) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.status = status
+}
+
+//PoolConnectionStates is alive while the node answers
+func (c *Client) PoolConnectionStates() types.PoolConnectionStates {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.status
+}
+
+//GetPoolStats counts the blocks accepted and rejected by the node, the difficulty is the one of the network
+func (c *Client) GetPoolStats() (info types.PoolStates) {
+	info.Status = c.PoolConnectionStates()
+	info.User = c.Payout
+	info.PoolAddr = c.URL
+	info.Algo = c.Algo
+	info.Accept = atomic.LoadInt32(&c.accept)
+	info.Reject = atomic.LoadInt32(&c.reject)
+	info.Discard = atomic.LoadInt32(&c.discard)
+	info.LastAccepted = atomic.LoadInt64(&c.lastAccept)
+	c.mutex.Lock()
+	if c.block != nil {
+		info.Diff = c.block.difficulty()
+	}
+	c.mutex.Unlock()
+	return
+}
+
+//resolvePayout asks the node for the output script of the payout address, so every address
+// format of the coin is supported
+func (c *Client) resolvePayout() error {
+	if c.Payout == "" {
+		return ErrNoPayout
+	}
+	var reply struct {
+		IsValid      bool   `json:"isvalid"`
+		ScriptPubKey string `json:"scriptPubKey"`
+	}
+	if err := c.call("validateaddress", []interface{}{c.Payout}, &reply); err != nil {
+		return err
+	}
+	script, err := hex.DecodeString(reply.ScriptPubKey)
+	if !reply.IsValid || err != nil || len(script) == 0 {
+		return fmt.Errorf("The node rejects the payout address %s", c.Payout)
+	}
+	c.mutex.Lock()
+	c.script = script
+	c.mutex.Unlock()
+	return nil
+}
+
+//getBlockTemplate fetches a template, with a longpollID the node answers once the template changes
+func (c *Client) getBlockTemplate(longpollID string) (t Template, err error) {
+	request := map[string]interface{}{"rules": []string{"segwit"}}
+	if longpollID == "" {
+		err = c.call("getblocktemplate", []interface{}{request}, &t)
+		return
+	}
+	request["longpollid"] = longpollID
+	err = c.rpc.Call(c.ctx, "getblocktemplate", []interface{}{request}, &t)
+	return
+}
+
+//setTemplate makes t the current job. A template on a new block abandons the jobs of the previous one,
+// one with new transactions on the same block just replaces the current job.
+func (c *Client) setTemplate(t Template) error {
+	b, err := newBlock(t)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.block == nil || !bytes.Equal(c.block.prevHash, b.prevHash) {
+		if c.block != nil {
+			log.Println("New block", t.Height-1, "on the node, abandoning the jobs of the previous one")
+			atomic.AddInt32(&c.discard, 1)
+		}
+		c.DeprecateOutstandingJobs()
+	}
+	c.templates++
+	c.jobID = strconv.Itoa(c.templates)
+	c.block = b
+	c.extraNonce = jobs.ExtraNonce2{Size: ExtraNonceSize}
+	c.status = types.Alive
+	c.AddJobToDeprecate(c.jobID)
+	return nil
+}
+
+//GetHeaderForWork builds a header with the next extranonce of the current template, the block target follows it
+func (c *Client) GetHeaderForWork() (target []byte, difficulty float64, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.block == nil {
+		err = ErrNoTemplate
+		return
+	}
+	en, err := c.extraNonce.Take()
+	if err != nil {
+		return
+	}
+	b := c.block
+	coinbase := b.coinbase(c.script, en.Bytes(), false)
+	root := b.merkleRoot(txID(coinbase))
+	if b.segwit() {
+		coinbase = b.coinbase(c.script, en.Bytes(), true)
+	}
+	j := Job{ID: c.jobID, Header: b.header(root), Coinbase: coinbase, block: b}
+
+	target = b.target
+	difficulty = b.difficulty()
+	header = append(append([]byte{}, j.Header...), b.target...)
+	deprecationChannel = c.GetDeprecationChannel(c.jobID)
+	job = j
+	return
+}
+
+//SubmitHeader submits the block of a solved header to the node. The nonce goes into the header as it
+// would through a stratum pool, its last 4 bytes are the big endian value.
+func (c *Client) SubmitHeader(nonce []byte, job interface{}) (err error) {
+	j, ok := job.(Job)
+	if !ok || j.block == nil || len(nonce) < 8 {
+		return errors.New("Invalid job submitted")
+	}
+	header := append(append([]byte{}, j.Header[:76]...), stratum.RevBytes(nonce[4:8])...)
+	blockHex := hex.EncodeToString(j.block.serialize(header, j.Coinbase))
+
+	var reason *string
+	err = c.call("submitblock", []interface{}{blockHex}, &reason)
+	if err == nil && reason != nil {
+		err = fmt.Errorf("Block rejected: %s", *reason)
+	}
+	if err != nil {
+		atomic.AddInt32(&c.reject, 1)
+		log.Println("ERROR submitblock at height", j.block.template.Height, "failed:", err)
+		return
+	}
+	atomic.AddInt32(&c.accept, 1)
+	atomic.StoreInt64(&c.lastAccept, time.Now().Unix())
+	log.Println("Block", j.block.template.Height, "accepted by the node")
+	return
+}
+
+//call calls method on the node, bounded by RequestTimeout
+func (c *Client) call(method string, params []interface{}, result interface{}) error {
+	ctx, cancel := context.WithTimeout(c.ctx, RequestTimeout)
+	defer cancel()
+	return c.rpc.Call(ctx, method, params, result)
+}
@@ -0,0 +1,342 @@
+package gbt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//block100000 is bitcoin block 100000, its four transactions and its header
+var block100000 = struct {
+	txids      []string
+	merkleRoot string
+	template   Template
+	nonce      uint32
+	hash       string
+}{
+	txids: []string{
+		"8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87",
+		"fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4",
+		"6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4",
+		"e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d",
+	},
+	merkleRoot: "f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766",
+	template: Template{
+		Version:           1,
+		PreviousBlockHash: "000000000002d01c1fccc21636b607dfd930d31d01c3a62104612a1719011250",
+		CurTime:           1293623863,
+		Bits:              "1b04864c",
+		Target:            "000000000004864c000000000000000000000000000000000000000000000000",
+		Height:            100000,
+	},
+	nonce: 274148111,
+	hash:  "000000000003ba27aa200b1cecaad478d2b00432346c3f1f3986da1afd33e506",
+}
+
+func reversedHash(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return stratum.ReverseByteSlice(b)
+}
+
+func TestMerkleRoot(t *testing.T) {
+	var hashes [][]byte
+	for _, txid := range block100000.txids {
+		hashes = append(hashes, reversedHash(txid))
+	}
+	if root := MerkleRoot(hashes); !bytes.Equal(root, reversedHash(block100000.merkleRoot)) {
+		t.Errorf("merkle root %x", stratum.ReverseByteSlice(root))
+	}
+	//the third hash is paired with itself
+	three := MerkleRoot(hashes[:3])
+	left := stratum.SHA256d(append(append([]byte{}, hashes[0]...), hashes[1]...))
+	right := stratum.SHA256d(append(append([]byte{}, hashes[2]...), hashes[2]...))
+	if !bytes.Equal(three, stratum.SHA256d(append(left, right...))) {
+		t.Error("odd level not paired with itself")
+	}
+	if !bytes.Equal(MerkleRoot(hashes[:1]), hashes[0]) {
+		t.Error("root of a single transaction")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	b, err := newBlock(block100000.template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := b.header(reversedHash(block100000.merkleRoot))
+	if len(header) != 80 {
+		t.Fatal("header of", len(header), "bytes")
+	}
+	//the nonce as the driver reports it, big endian in the last 4 bytes
+	nonce := []byte{0, 0, 0, 0, byte(block100000.nonce >> 24), byte(block100000.nonce >> 16), byte(block100000.nonce >> 8), byte(block100000.nonce)}
+	solved := append(header[:76:76], stratum.ReverseByteSlice(nonce[4:])...)
+	if hash := stratum.ReverseByteSlice(stratum.SHA256d(solved)); hex.EncodeToString(hash) != block100000.hash {
+		t.Errorf("block hash %x", hash)
+	}
+	if d := b.difficulty(); d < 14484 || d > 14485 {
+		t.Error("difficulty", d)
+	}
+}
+
+func TestScriptNumber(t *testing.T) {
+	for n, expected := range map[int64]string{
+		1:       "51",
+		16:      "60",
+		17:      "0111",
+		128:     "028000",
+		100000:  "03a08601",
+		8388608: "0400008000",
+	} {
+		if s := hex.EncodeToString(scriptNumber(n)); s != expected {
+			t.Error(n, "pushed as", s)
+		}
+	}
+}
+
+//stubNode answers getblocktemplate, validateaddress and submitblock like a node, a longpoll
+// is held until the next template is set
+type stubNode struct {
+	mutex     sync.Mutex
+	template  Template
+	changed   chan bool
+	submitted []string
+	longpolls int
+}
+
+const stubScript = "76a914000102030405060708090a0b0c0d0e0f1011121388ac"
+
+func newStubNode(t Template) *stubNode {
+	return &stubNode{template: t, changed: make(chan bool)}
+}
+
+//setTemplate replaces the template and answers the pending longpolls
+func (n *stubNode) setTemplate(t Template) {
+	n.mutex.Lock()
+	n.template = t
+	close(n.changed)
+	n.changed = make(chan bool)
+	n.mutex.Unlock()
+}
+
+func (n *stubNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "rpc" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		ID     int64             `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	var result interface{}
+	switch req.Method {
+	case "validateaddress":
+		var address string
+		json.Unmarshal(req.Params[0], &address)
+		result = map[string]interface{}{"isvalid": address == "payout", "scriptPubKey": stubScript}
+	case "getblocktemplate":
+		var request struct {
+			Rules      []string `json:"rules"`
+			LongPollID string   `json:"longpollid"`
+		}
+		json.Unmarshal(req.Params[0], &request)
+		n.mutex.Lock()
+		changed := n.changed
+		if request.LongPollID != "" {
+			n.longpolls++
+		}
+		n.mutex.Unlock()
+		if request.LongPollID != "" {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		n.mutex.Lock()
+		result = n.template
+		n.mutex.Unlock()
+	case "submitblock":
+		var block string
+		json.Unmarshal(req.Params[0], &block)
+		n.mutex.Lock()
+		n.submitted = append(n.submitted, block)
+		n.mutex.Unlock()
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": nil, "error": map[string]interface{}{"code": -32601, "message": "Method not found"}})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": result, "error": nil})
+}
+
+func stubTemplate(prevHash string) Template {
+	return Template{
+		Version:           0x20000000,
+		PreviousBlockHash: prevHash,
+		Transactions: []Transaction{{
+			Data: "0100000001" + block100000.txids[1] + "00000000" + "00" + "ffffffff" + "00" + "00000000",
+			TxID: block100000.txids[1],
+		}},
+		CoinbaseValue:     5000000000,
+		Target:            "7fffff0000000000000000000000000000000000000000000000000000000000",
+		CurTime:           1600000000,
+		Bits:              "207fffff",
+		Height:            1000,
+		LongPollID:        prevHash + "1",
+		WitnessCommitment: "6a24aa21a9ed" + block100000.merkleRoot,
+	}
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	for i := 0; i < 200; i++ {
+		if done() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for", what)
+}
+
+func TestClient(t *testing.T) {
+	node := newStubNode(stubTemplate(block100000.txids[0]))
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	c := NewClient(&types.Pool{URL: server.URL, User: "rpc", Pass: "secret", Algo: "skunk", Payout: "payout"})
+	go c.Start()
+	defer c.Stop()
+
+	var header []byte
+	var job interface{}
+	var deprecation chan bool
+	var err error
+	waitFor(t, "a template", func() bool {
+		_, _, header, deprecation, job, err = c.GetHeaderForWork()
+		return err == nil
+	})
+	target, _ := hex.DecodeString(stubTemplate("").Target)
+	if len(header) != 80+32 || !bytes.Equal(header[80:], target) {
+		t.Fatalf("header %x", header)
+	}
+	_, _, next, _, _, _ := c.GetHeaderForWork()
+	if bytes.Equal(header[:80], next[:80]) {
+		t.Error("two headers with the same extranonce")
+	}
+	if c.PoolConnectionStates() != types.Alive {
+		t.Error("node status", c.PoolConnectionStates())
+	}
+
+	if err = c.SubmitHeader([]byte{0, 0, 0, 0, 0x12, 0x34, 0x56, 0x78}, job); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.submitted) != 1 {
+		t.Fatal(len(node.submitted), "blocks submitted")
+	}
+	checkBlock(t, node.submitted[0], header[:80], job.(Job))
+	if stats := c.GetPoolStats(); stats.Accept != 1 || stats.PoolAddr != server.URL {
+		t.Error("pool stats", stats)
+	}
+
+	//new transactions on the same block keep the jobs
+	waitFor(t, "the longpoll", func() bool {
+		node.mutex.Lock()
+		defer node.mutex.Unlock()
+		return node.longpolls == 1
+	})
+	same := stubTemplate(block100000.txids[0])
+	same.CurTime++
+	node.setTemplate(same)
+	waitFor(t, "the next longpoll", func() bool {
+		node.mutex.Lock()
+		defer node.mutex.Unlock()
+		return node.longpolls == 2
+	})
+	select {
+	case <-deprecation:
+		t.Fatal("job deprecated without a new block")
+	default:
+	}
+	node.setTemplate(stubTemplate(block100000.txids[2]))
+	select {
+	case <-deprecation:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job not deprecated by a new block")
+	}
+}
+
+//checkBlock decodes the submitted block and checks its header, coinbase and transactions
+func checkBlock(t *testing.T, blockHex string, header []byte, job Job) {
+	block, err := hex.DecodeString(blockHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(block[:76], header[:76]) || !bytes.Equal(block[76:80], []byte{0x78, 0x56, 0x34, 0x12}) {
+		t.Errorf("block header %x", block[:80])
+	}
+	if block[80] != 2 {
+		t.Fatal(block[80], "transactions")
+	}
+	coinbase := block[81 : 81+len(job.Coinbase)]
+	if !bytes.Equal(coinbase, job.Coinbase) {
+		t.Error("coinbase differs from the job")
+	}
+	if !bytes.Equal(coinbase[4:6], []byte{0, 1}) {
+		t.Error("segwit coinbase without the witness marker")
+	}
+	script, _ := hex.DecodeString(stubScript)
+	if !bytes.Contains(coinbase, append([]byte{0x00, 0xf2, 0x05, 0x2a, 0x01, 0, 0, 0, byte(len(script))}, script...)) {
+		t.Error("coinbase does not pay 50 coins to the payout script")
+	}
+	commitment, _ := hex.DecodeString("6a24aa21a9ed" + block100000.merkleRoot)
+	if !bytes.Contains(coinbase, commitment) {
+		t.Error("coinbase without the witness commitment")
+	}
+	if !bytes.Contains(coinbase, []byte{0x02, 0xe8, 0x03}) {
+		t.Error("coinbase without the height")
+	}
+	//the merkle root is over the txid of the coinbase without the witness
+	stripped := job.block.coinbase(script, coinbase[6+1+32+4+1+3+1:6+1+32+4+1+3+1+ExtraNonceSize], false)
+	root := MerkleRoot([][]byte{txID(stripped), reversedHash(block100000.txids[1])})
+	if !bytes.Equal(block[36:68], root) {
+		t.Errorf("merkle root %x, expected %x", block[36:68], root)
+	}
+	if rest := block[81+len(job.Coinbase):]; hex.EncodeToString(rest) != stubTemplate("").Transactions[0].Data {
+		t.Errorf("transactions %x", rest)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	node := newStubNode(stubTemplate(block100000.txids[0]))
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	c := NewClient(&types.Pool{URL: server.URL, User: "rpc", Pass: "wrong", Payout: "payout"})
+	if err := c.resolvePayout(); err == nil {
+		t.Error("wrong credentials accepted")
+	}
+	c = NewClient(&types.Pool{URL: server.URL, User: "rpc", Pass: "secret", Payout: "other"})
+	if err := c.resolvePayout(); err == nil {
+		t.Error("invalid payout address accepted")
+	}
+	if err := c.call("getnetworkinfo", nil, nil); err == nil || err.Error() != "getnetworkinfo: Method not found (-32601)" {
+		t.Error("RPC error", err)
+	}
+	if _, _, _, _, _, err := c.GetHeaderForWork(); err != ErrNoTemplate {
+		t.Error("header without a template", err)
+	}
+	bad := stubTemplate(block100000.txids[0])
+	bad.Bits = "1d00"
+	if err := c.setTemplate(bad); err == nil {
+		t.Error("template with short bits accepted")
+	}
+}
@@ -0,0 +1,208 @@
+package pooltest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+//MockAlgorithms lists the algorithms the mock pool can speak
+var MockAlgorithms = []string{"skunk", "odocrypt", "ckb", "verus", "veo"}
+
+//ErrMockAlgorithm is returned for algorithms the mock pool does not speak
+var ErrMockAlgorithm = errors.New("The mock pool does not support this algorithm")
+
+const (
+	mockHash   = "6e2b1a5c3d4f5e6a7b8c9d0e1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c"
+	mockTarget = "00000000ffff0000000000000000000000000000000000000000000000000000"
+)
+
+//MockPool is a minimal stratum server handing out a fixed job, for testing the clients
+// and the pool tester without a real pool. It speaks the dialect of the configured algorithm:
+// mining.set_difficulty and bitcoin style jobs for skunk and odocrypt, mining.set_target and
+// the ckb or verus jobs for those, and the numbered methods of the veo pools.
+type MockPool struct {
+	Algo string
+	//Difficulty is sent to bitcoin style clients
+	Difficulty float64
+	//Reject refuses every authorization
+	Reject bool
+	//JobFirst sends the job before the difficulty, like some pools do
+	JobFirst bool
+
+	listener net.Listener
+	mutex    sync.Mutex // protects following
+	conns    map[net.Conn]bool
+}
+
+//NewMockPool creates a mock pool for algo, adjust its settings before calling Listen
+func NewMockPool(algo string) *MockPool {
+	return &MockPool{Algo: algo, Difficulty: 1}
+}
+
+//Listen starts serving on addr, use port 0 to pick a free port
+func (p *MockPool) Listen(addr string) (err error) {
+	if !mockAlgorithm(p.Algo) {
+		return ErrMockAlgorithm
+	}
+	p.listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	p.conns = make(map[net.Conn]bool)
+	go p.serve()
+	return
+}
+
+func mockAlgorithm(algo string) bool {
+	for _, a := range MockAlgorithms {
+		if a == algo {
+			return true
+		}
+	}
+	return false
+}
+
+//Addr returns the host:port the pool listens on
+func (p *MockPool) Addr() string {
+	return p.listener.Addr().String()
+}
+
+//URL returns the pool url to put in the configuration
+func (p *MockPool) URL() string {
+	return "stratum+tcp://" + p.Addr()
+}
+
+//Close stops listening and drops every connection
+func (p *MockPool) Close() error {
+	err := p.listener.Close()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+	return err
+}
+
+func (p *MockPool) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.mutex.Lock()
+		p.conns[conn] = true
+		p.mutex.Unlock()
+		go p.handle(conn)
+	}
+}
+
+//mockRequest is a request of the client, veo pools number their methods and take an object as params
+type mockRequest struct {
+	ID     interface{}     `json:"id"`
+	Method interface{}     `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type mockMessage struct {
+	ID     interface{}   `json:"id"`
+	Result interface{}   `json:"result"`
+	Error  interface{}   `json:"error,omitempty"`
+	Method interface{}   `json:"method,omitempty"`
+	Params []interface{} `json:"params,omitempty"`
+}
+
+//veo methods
+const (
+	veoSubscribe    = "0"
+	veoSubmit       = "1"
+	veoNewBlockHash = 2
+	veoNewJobDiff   = 3
+)
+
+func (p *MockPool) handle(conn net.Conn) {
+	defer func() {
+		p.mutex.Lock()
+		delete(p.conns, conn)
+		p.mutex.Unlock()
+		conn.Close()
+	}()
+	encoder := json.NewEncoder(conn)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req mockRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Print("Mock pool: invalid request: ", err)
+			return
+		}
+		var replies []mockMessage
+		switch fmt.Sprint(req.Method) {
+		case "mining.subscribe", veoSubscribe:
+			replies = append(replies, mockMessage{ID: req.ID, Result: p.subscribeResult()})
+			if p.JobFirst {
+				replies = append(replies, p.notify(), p.difficulty())
+			} else {
+				replies = append(replies, p.difficulty(), p.notify())
+			}
+		case "mining.authorize":
+			replies = append(replies, mockMessage{ID: req.ID, Result: !p.Reject})
+		case "mining.submit":
+			replies = append(replies, mockMessage{ID: req.ID, Result: true})
+		case veoSubmit:
+			replies = append(replies, mockMessage{ID: req.ID, Result: map[string]interface{}{"acc": 1}})
+		default:
+			replies = append(replies, mockMessage{ID: req.ID, Error: []interface{}{20, "Unknown method", nil}})
+		}
+		for _, reply := range replies {
+			if err := encoder.Encode(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (p *MockPool) subscribeResult() interface{} {
+	switch p.Algo {
+	case "ckb":
+		return []interface{}{nil, "e5f8c0a1", 12}
+	case "verus":
+		return []interface{}{nil, "81000001"}
+	case "veo":
+		return map[string]interface{}{"jId": "1"}
+	default:
+		return []interface{}{[]interface{}{[]interface{}{"mining.notify", "ae6812eb4cd7735a302a8a9dd95cf71f"}}, "08000002", 4}
+	}
+}
+
+func (p *MockPool) difficulty() mockMessage {
+	switch p.Algo {
+	case "ckb", "verus":
+		return mockMessage{Method: "mining.set_target", Params: []interface{}{mockTarget}}
+	case "veo":
+		return mockMessage{Method: veoNewJobDiff, Result: map[string]interface{}{"jDiff": int(p.Difficulty)}}
+	default:
+		return mockMessage{Method: "mining.set_difficulty", Params: []interface{}{p.Difficulty}}
+	}
+}
+
+func (p *MockPool) notify() mockMessage {
+	var params []interface{}
+	switch p.Algo {
+	case "veo":
+		bHash, _ := hex.DecodeString(mockHash)
+		return mockMessage{Method: veoNewBlockHash, Result: map[string]interface{}{"bHash": base64.StdEncoding.EncodeToString(bHash), "jDiff": int(p.Difficulty)}}
+	case "ckb":
+		params = []interface{}{"1", mockHash, 1000, mockHash, true}
+	case "verus":
+		params = []interface{}{"1", "04000100", mockHash, mockHash, mockHash, "5d8a7e2c", "1b1e2f3a", true}
+	default:
+		params = []interface{}{"1", mockHash, "01000000010000", "ffffffff00", []interface{}{}, "20000000", "1a0ffff0", "5d8a7e2c", true}
+	}
+	return mockMessage{Method: "mining.notify", Params: params}
+}
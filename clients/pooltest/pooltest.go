@@ -0,0 +1,147 @@
+//Package pooltest checks a pool connection without mining: it runs the pool client,
+// waits for the handshake, the first difficulty and the first job and reports what it saw.
+package pooltest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//LiveEnv is the environment variable enabling the tests against real pools, they are skipped without it
+const LiveEnv = "GOMINER_LIVE_POOLS"
+
+//Live tells whether tests may dial real pools
+func Live() bool {
+	return os.Getenv(LiveEnv) != ""
+}
+
+//Report is the outcome of a pool test, durations are measured from the start of the connection
+type Report struct {
+	URL  string
+	Algo string
+
+	Connect         time.Duration
+	Subscribe       time.Duration
+	Authorize       time.Duration
+	FirstDifficulty time.Duration
+	FirstJob        time.Duration
+
+	ExtraNonce1     string
+	ExtraNonce2Size int
+	Difficulty      float64
+	Target          string
+	Jobs            int
+
+	//Oddities are protocol deviations that do not prevent mining
+	Oddities []string
+	Err      error
+}
+
+//OK tells whether the pool can be mined on
+func (r *Report) OK() bool {
+	return r.Err == nil
+}
+
+//ErrNotTestable is returned for clients that do not record their session
+var ErrNotTestable = errors.New("This client can only be tested by mining")
+
+//pollInterval is how often the session of the client is checked
+var pollInterval = 50 * time.Millisecond
+
+//Run starts the client, waits at most timeout for the pool to authorize the worker and send
+// a difficulty and a job, then stops the client again. The client must not have been started.
+func Run(pool types.Pool, client clients.Client, timeout time.Duration) (r Report) {
+	r.URL, r.Algo = pool.URL, pool.Algo
+	reporter, ok := client.(clients.SessionReporter)
+	if !ok {
+		r.Err = ErrNotTestable
+		return
+	}
+
+	go client.Start()
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var s clients.Session
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			s = reporter.Session()
+			done = complete(s)
+		case <-deadline:
+			s = reporter.Session()
+			done = true
+			if !complete(s) {
+				r.Err = fmt.Errorf("Timeout after %v, no %s", timeout, strings.Join(missing(s), ", "))
+			}
+		}
+	}
+	//Stop can only be called once Start has set up the connection
+	if !s.Started.IsZero() {
+		go client.Stop()
+	}
+
+	r.fill(s)
+	if s.AuthorizeError != "" {
+		r.Err = fmt.Errorf("Authorization failed: %s", s.AuthorizeError)
+	}
+	return
+}
+
+func complete(s clients.Session) bool {
+	return s.AuthorizeError != "" || len(missing(s)) == 0
+}
+
+//missing lists the steps of the handshake that did not happen yet
+func missing(s clients.Session) (steps []string) {
+	if s.Connected.IsZero() {
+		return []string{"connection"}
+	}
+	if s.Subscribed.IsZero() {
+		steps = append(steps, "subscription")
+	}
+	if s.Authorized.IsZero() && s.AuthorizeError == "" {
+		steps = append(steps, "authorization")
+	}
+	if s.FirstDifficulty.IsZero() {
+		steps = append(steps, "difficulty")
+	}
+	if s.FirstJob.IsZero() {
+		steps = append(steps, "job")
+	}
+	return
+}
+
+func since(start, t time.Time) time.Duration {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Sub(start)
+}
+
+func (r *Report) fill(s clients.Session) {
+	r.Connect = since(s.Started, s.Connected)
+	r.Subscribe = since(s.Started, s.Subscribed)
+	r.Authorize = since(s.Started, s.Authorized)
+	r.FirstDifficulty = since(s.Started, s.FirstDifficulty)
+	r.FirstJob = since(s.Started, s.FirstJob)
+
+	r.ExtraNonce1, r.ExtraNonce2Size = s.ExtraNonce1, s.ExtraNonce2Size
+	r.Difficulty, r.Target = s.Difficulty, s.Target
+	r.Jobs = s.Jobs
+
+	r.Oddities = s.Oddities
+	if s.Notifies > s.Jobs {
+		r.Oddities = append(r.Oddities, fmt.Sprintf("%d of %d job notifications could not be parsed", s.Notifies-s.Jobs, s.Notifies))
+	}
+	if !s.FirstJob.IsZero() && !s.FirstDifficulty.IsZero() && s.FirstJob.Before(s.FirstDifficulty) {
+		r.Oddities = append(r.Oddities, "the first job came before the difficulty, it is mined at the previous target")
+	}
+}
@@ -0,0 +1,122 @@
+package pooltest_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/algorithms/ckb"
+	"github.com/AGPFMiner/gominer/algorithms/odocrypt"
+	"github.com/AGPFMiner/gominer/algorithms/skunk"
+	"github.com/AGPFMiner/gominer/algorithms/veo"
+	"github.com/AGPFMiner/gominer/algorithms/verus"
+	"github.com/AGPFMiner/gominer/algorithms/xdag"
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/clients/pooltest"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+func newClient(pool *types.Pool) clients.Client {
+	switch pool.Algo {
+	case "ckb":
+		return ckb.NewClient(pool)
+	case "odocrypt":
+		return odocrypt.NewClient(pool)
+	case "skunk":
+		return skunk.NewClient(pool)
+	case "verus":
+		return verus.NewClient(pool)
+	case "veo":
+		return veo.NewClient(pool)
+	default:
+		return xdag.NewClient(pool)
+	}
+}
+
+func startMockPool(t *testing.T, mock *pooltest.MockPool) types.Pool {
+	if err := mock.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return types.Pool{URL: mock.URL(), User: "worker", Pass: "x", Algo: mock.Algo}
+}
+
+func TestRunMockPools(t *testing.T) {
+	expectedNonce2 := map[string]int{"skunk": 4, "odocrypt": 4, "ckb": 12, "verus": 28}
+	for _, algo := range pooltest.MockAlgorithms {
+		mock := pooltest.NewMockPool(algo)
+		pool := startMockPool(t, mock)
+		r := pooltest.Run(pool, newClient(&pool), 5*time.Second)
+		mock.Close()
+
+		if !r.OK() {
+			t.Errorf("%s: %v", algo, r.Err)
+			continue
+		}
+		if r.Jobs != 1 || r.FirstJob <= 0 || r.FirstDifficulty <= 0 || r.Authorize <= 0 {
+			t.Errorf("%s: incomplete report %+v", algo, r)
+		}
+		//veo pools have no extranonce
+		if (r.ExtraNonce1 == "") != (algo == "veo") || r.ExtraNonce2Size != expectedNonce2[algo] {
+			t.Errorf("%s: extranonce %q/%d, expected size %d", algo, r.ExtraNonce1, r.ExtraNonce2Size, expectedNonce2[algo])
+		}
+		if r.Difficulty == 0 && r.Target == "" {
+			t.Errorf("%s: no difficulty or target reported", algo)
+		}
+		if len(r.Oddities) > 0 {
+			t.Errorf("%s: unexpected oddities %v", algo, r.Oddities)
+		}
+	}
+}
+
+func TestRunRejected(t *testing.T) {
+	mock := pooltest.NewMockPool("skunk")
+	mock.Reject = true
+	pool := startMockPool(t, mock)
+	defer mock.Close()
+
+	r := pooltest.Run(pool, newClient(&pool), 5*time.Second)
+	if r.OK() || !strings.Contains(r.Err.Error(), "Authorization failed") {
+		t.Error("Expected an authorization failure, got", r.Err)
+	}
+}
+
+func TestRunJobFirst(t *testing.T) {
+	mock := pooltest.NewMockPool("odocrypt")
+	mock.JobFirst = true
+	pool := startMockPool(t, mock)
+	defer mock.Close()
+
+	r := pooltest.Run(pool, newClient(&pool), 5*time.Second)
+	if !r.OK() {
+		t.Fatal(r.Err)
+	}
+	if len(r.Oddities) != 1 || !strings.Contains(r.Oddities[0], "before the difficulty") {
+		t.Error("Expected the job order to be reported, got", r.Oddities)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	//a listener that never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	pool := types.Pool{URL: "stratum+tcp://" + listener.Addr().String(), User: "worker", Algo: "ckb"}
+
+	r := pooltest.Run(pool, newClient(&pool), 300*time.Millisecond)
+	if r.OK() || !strings.Contains(r.Err.Error(), "no subscription") {
+		t.Error("Expected a timeout waiting for the subscription, got", r.Err)
+	}
+	if r.Connect <= 0 {
+		t.Error("The connection should have been reported")
+	}
+}
+
+func TestRunNotTestable(t *testing.T) {
+	pool := types.Pool{URL: "127.0.0.1:1", Algo: "xdag"}
+	if r := pooltest.Run(pool, newClient(&pool), time.Second); r.Err != pooltest.ErrNotTestable {
+		t.Error("Expected ErrNotTestable, got", r.Err)
+	}
+}
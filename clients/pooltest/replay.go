@@ -0,0 +1,411 @@
+package pooltest
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//Solver turns a header from GetHeaderForWork into what SubmitHeader of the client takes,
+// returning nil skips the submission
+type Solver func(header []byte) []byte
+
+//SolveNonce is the nonce the Solvers report, it is not meant to meet any target
+var SolveNonce = []byte{0, 0, 0, 0, 0x1b, 0x2c, 0x3d, 0x4e}
+
+//Solvers hand the clients what the driver would for SolveNonce: the 8 byte nonce,
+// or the header with the nonce appended for verus and veo. The veo solver clears the random
+// bytes veo puts into its headers, so its submissions can be compared.
+var Solvers = map[string]Solver{
+	"odocrypt": func(header []byte) []byte { return SolveNonce },
+	"skunk":    func(header []byte) []byte { return SolveNonce },
+	"ckb":      func(header []byte) []byte { return SolveNonce },
+	"verus":    func(header []byte) []byte { return append(header, SolveNonce[4:]...) },
+	"veo": func(header []byte) []byte {
+		if len(header) < 48 {
+			return nil
+		}
+		copy(header[32:36], []byte{0, 0, 0, 0})
+		copy(header[40:44], []byte{0, 0, 0, 0})
+		return append(header, SolveNonce...)
+	},
+}
+
+//ReplayedJob is what the client made of a job notification of the recording
+type ReplayedJob struct {
+	//Notify is the notification as the pool sent it
+	Notify     json.RawMessage `json:"notify"`
+	Target     string          `json:"target"`
+	Difficulty float64         `json:"difficulty"`
+	Header     string          `json:"header"`
+	Error      string          `json:"error,omitempty"`
+	//Submit is the request the client sent for the solved header, without its ID
+	Submit json.RawMessage `json:"submit,omitempty"`
+}
+
+//Transcript is what a client made of a replayed session. Marshalled it serves as a regression
+// fixture, a change to the header construction or the submissions shows up in its diff.
+type Transcript struct {
+	//Requests are the requests the client sent apart from the submissions, without their IDs
+	Requests []json.RawMessage `json:"requests"`
+	Jobs     []ReplayedJob     `json:"jobs"`
+	//Mismatches lists where the client deviated from the recorded session
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+//ErrNoSession is returned for a recording without a connection
+var ErrNoSession = errors.New("The recording holds no stratum session")
+
+//submitMethods are the methods of share submissions, veo pools number their methods
+var submitMethods = []interface{}{"mining.submit", float64(1)}
+
+//jobMethods are the methods of job notifications
+var jobMethods = []interface{}{"mining.notify", float64(2)}
+
+func isMethod(method interface{}, methods []interface{}) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+//replayMessage is a recorded line or a request of the client
+type replayMessage struct {
+	ID     interface{}     `json:"id"`
+	Method interface{}     `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+//withoutID is a request as it goes into a transcript
+func (m replayMessage) withoutID() json.RawMessage {
+	data, _ := json.Marshal(struct {
+		Method interface{}     `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}{m.Method, m.Params})
+	return data
+}
+
+//hasID tells responses from notifications, the clients send notifications without an ID or with ID 0
+func (m replayMessage) hasID() bool {
+	return m.ID != nil && m.ID != float64(0)
+}
+
+func idKey(id interface{}) string {
+	return fmt.Sprint(id)
+}
+
+//replaySession plays the pool side of a recording on a connection
+type replaySession struct {
+	script  []stratum.Line
+	conn    net.Conn
+	timeout time.Duration
+
+	requests chan replayMessage
+	pending  []replayMessage
+	jobs     chan json.RawMessage
+	solved   chan bool
+	done     chan bool
+
+	mutex      sync.Mutex // protects following
+	transcript Transcript
+	submit     json.RawMessage
+}
+
+func (s *replaySession) mismatch(format string, args ...interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.transcript.Mismatches = append(s.transcript.Mismatches, fmt.Sprintf(format, args...))
+}
+
+//read passes the requests of the client to the session
+func (s *replaySession) read() {
+	defer close(s.requests)
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var req replayMessage
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			s.mismatch("invalid request %q", scanner.Text())
+			continue
+		}
+		if !isMethod(req.Method, submitMethods) {
+			s.mutex.Lock()
+			s.transcript.Requests = append(s.transcript.Requests, req.withoutID())
+			s.mutex.Unlock()
+		}
+		select {
+		case s.requests <- req:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+//expect waits for a request of the client with method
+func (s *replaySession) expect(method interface{}) (replayMessage, bool) {
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	for {
+		for i, req := range s.pending {
+			if req.Method == method {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				return req, true
+			}
+		}
+		select {
+		case req, ok := <-s.requests:
+			if !ok {
+				return req, false
+			}
+			s.pending = append(s.pending, req)
+		case <-timer.C:
+			return replayMessage{}, false
+		}
+	}
+}
+
+//serveSubmits accepts the submissions of the client until the job is solved
+func (s *replaySession) serveSubmits() {
+	requests := s.requests
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				requests = nil
+				continue
+			}
+			if !isMethod(req.Method, submitMethods) {
+				s.pending = append(s.pending, req)
+				continue
+			}
+			s.mutex.Lock()
+			s.submit = req.withoutID()
+			s.mutex.Unlock()
+			reply, _ := json.Marshal(map[string]interface{}{"id": req.ID, "result": true, "error": nil})
+			s.conn.Write(append(reply, '\n'))
+		case <-s.solved:
+			return
+		}
+	}
+}
+
+//run walks the recording, it answers the requests of the client with the recorded replies
+// and sends the recorded notifications. Recorded submissions are left out, the client only
+// submits what the Solver hands it.
+func (s *replaySession) run() {
+	defer close(s.jobs)
+	defer close(s.done)
+	go s.read()
+
+	clientIDs := make(map[string]interface{})
+	skipped := make(map[string]bool)
+	for _, line := range s.script {
+		var msg replayMessage
+		if line.Msg == nil || json.Unmarshal(line.Msg, &msg) != nil {
+			if line.Dir == stratum.Received {
+				s.conn.Write(append(line.Text(), '\n'))
+			}
+			continue
+		}
+		id := idKey(msg.ID)
+
+		if line.Dir == stratum.Sent {
+			if isMethod(msg.Method, submitMethods) {
+				skipped[id] = true
+				delete(clientIDs, id)
+				continue
+			}
+			req, ok := s.expect(msg.Method)
+			if !ok {
+				s.mismatch("no %v request from the client", msg.Method)
+				continue
+			}
+			if !equalJSON(req.Params, msg.Params) {
+				s.mismatch("%v params %s, recorded %s", msg.Method, req.Params, msg.Params)
+			}
+			delete(skipped, id)
+			clientIDs[id] = req.ID
+			continue
+		}
+
+		if msg.hasID() {
+			if skipped[id] {
+				delete(skipped, id)
+				continue
+			}
+			if clientID, ok := clientIDs[id]; ok {
+				delete(clientIDs, id)
+				s.conn.Write(append(withID(line.Msg, clientID), '\n'))
+				continue
+			}
+		}
+		s.conn.Write(append(line.Text(), '\n'))
+		if !msg.hasID() && isMethod(msg.Method, jobMethods) {
+			s.jobs <- line.Msg
+			s.serveSubmits()
+		}
+	}
+	for _, req := range s.pending {
+		s.mismatch("unexpected %v request", req.Method)
+	}
+}
+
+//withID replaces the ID of a recorded reply with the ID the client used
+func withID(msg json.RawMessage, id interface{}) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(msg, &fields) != nil {
+		return msg
+	}
+	fields["id"], _ = json.Marshal(id)
+	data, _ := json.Marshal(fields)
+	return data
+}
+
+func equalJSON(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	json.Unmarshal(a, &va)
+	json.Unmarshal(b, &vb)
+	return reflect.DeepEqual(va, vb)
+}
+
+//recordedUser returns the user and password of the recorded mining.authorize,
+// veo pools take the user with the subscription
+func recordedUser(session []stratum.Line) (user, pass string) {
+	for _, line := range session {
+		var msg replayMessage
+		if line.Dir != stratum.Sent || json.Unmarshal(line.Msg, &msg) != nil {
+			continue
+		}
+		switch msg.Method {
+		case "mining.authorize":
+			var params []string
+			json.Unmarshal(msg.Params, &params)
+			if len(params) > 0 {
+				user = params[0]
+			}
+			if len(params) > 1 {
+				pass = params[1]
+			}
+			return
+		case float64(0):
+			var params struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			return params.ID, ""
+		}
+	}
+	return
+}
+
+//Replay plays the pool side of a recorded session, see stratum.Sessions, to the client newClient
+// creates for pool. The client gets the header of every job notification from GetHeaderForWork and
+// submits what solve makes of it, the transcript holds the results. Without a user in pool the
+// recorded one is used. timeout bounds every wait for the client.
+func Replay(session []stratum.Line, pool types.Pool, newClient func(pool *types.Pool) clients.Client, solve Solver, timeout time.Duration) (t Transcript, err error) {
+	if len(session) == 0 {
+		return t, ErrNoSession
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer listener.Close()
+	pool.URL = "stratum+tcp://" + listener.Addr().String()
+	if pool.User == "" {
+		pool.User, pool.Pass = recordedUser(session)
+	}
+	client := newClient(&pool)
+	reporter, ok := client.(clients.SessionReporter)
+	if !ok {
+		return t, ErrNotTestable
+	}
+
+	s := &replaySession{
+		script:   session,
+		timeout:  timeout,
+		requests: make(chan replayMessage, 16),
+		jobs:     make(chan json.RawMessage),
+		solved:   make(chan bool),
+		done:     make(chan bool),
+	}
+	accepted := make(chan error, 1)
+	go func() {
+		var err error
+		if s.conn, err = listener.Accept(); err == nil {
+			go s.run()
+		}
+		accepted <- err
+	}()
+	go client.Start()
+	select {
+	case err = <-accepted:
+		if err != nil {
+			return
+		}
+	case <-time.After(timeout):
+		return t, errors.New("The client did not connect")
+	}
+	defer s.conn.Close()
+	//Stop can only be called once Start has set up the connection
+	defer func() { go client.Stop() }()
+
+	var jobs []ReplayedJob
+	parsed := 0
+	for notify := range s.jobs {
+		jobs = append(jobs, replayJob(client, reporter, notify, parsed, solve, timeout, s))
+		parsed = reporter.Session().Jobs
+		s.solved <- true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t = s.transcript
+	t.Jobs = jobs
+	return
+}
+
+//replayJob waits until the client parsed one job more than before and runs its header through the client
+func replayJob(client clients.Client, reporter clients.SessionReporter, notify json.RawMessage, parsed int, solve Solver, timeout time.Duration, s *replaySession) (job ReplayedJob) {
+	job.Notify = notify
+	deadline := time.Now().Add(timeout)
+	for reporter.Session().Jobs <= parsed {
+		if time.Now().After(deadline) {
+			job.Error = "the job was not parsed"
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	target, difficulty, header, _, work, err := client.GetHeaderForWork()
+	job.Target, job.Difficulty, job.Header = hex.EncodeToString(target), difficulty, hex.EncodeToString(header)
+	if err != nil {
+		job.Error = err.Error()
+		return
+	}
+	if solve == nil {
+		return
+	}
+	solution := solve(append([]byte{}, header...))
+	if solution == nil {
+		return
+	}
+	if err = client.SubmitHeader(solution, work); err != nil {
+		job.Error = err.Error()
+	}
+	s.mutex.Lock()
+	job.Submit, s.submit = s.submit, nil
+	s.mutex.Unlock()
+	return
+}
@@ -0,0 +1,75 @@
+package pooltest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients/pooltest"
+	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+var update = flag.Bool("update", false, "rewrite the transcripts of the recorded sessions in testdata")
+
+//TestReplayRecordings replays every session recorded in testdata, named ALGO-NAME.jsonl,
+// and compares the transcript with ALGO-NAME.golden. A new recording gets its golden file with -update.
+func TestReplayRecordings(t *testing.T) {
+	recordings, _ := filepath.Glob("testdata/*.jsonl")
+	if len(recordings) == 0 {
+		t.Fatal("no recordings in testdata")
+	}
+	for _, recording := range recordings {
+		algo := strings.SplitN(filepath.Base(recording), "-", 2)[0]
+		f, err := os.Open(recording)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines, err := stratum.ReadRecording(f)
+		f.Close()
+		sessions := stratum.Sessions(lines)
+		if err != nil || len(sessions) == 0 {
+			t.Errorf("%s: unreadable recording %v", recording, err)
+			continue
+		}
+
+		transcript, err := pooltest.Replay(sessions[0], types.Pool{Algo: algo}, newClient, pooltest.Solvers[algo], 5*time.Second)
+		if err != nil {
+			t.Errorf("%s: %v", recording, err)
+			continue
+		}
+		if len(transcript.Mismatches) > 0 {
+			t.Errorf("%s: the client deviates from the recording: %v", recording, transcript.Mismatches)
+		}
+		//the random bytes of the veo headers are cleared like the solver does
+		for i, job := range transcript.Jobs {
+			if algo == "veo" && len(job.Header) >= 88 {
+				transcript.Jobs[i].Header = job.Header[:64] + "00000000" + job.Header[72:80] + "00000000" + job.Header[88:]
+			}
+		}
+		got, _ := json.MarshalIndent(transcript, "", "  ")
+		got = append(got, '\n')
+
+		golden := strings.TrimSuffix(recording, ".jsonl") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s: %v, run the test with -update to create it", recording, err)
+			continue
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("%s: transcript differs from %s:\n%s", recording, golden, got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+//IsNodeURL tells whether url is the RPC address of a node to mine solo on rather than a stratum pool
+func IsNodeURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+//ErrRPCCredentials is returned when the node refuses the RPC user and password
+var ErrRPCCredentials = errors.New("The node refused the RPC credentials")
+
+//RPCClient calls the JSON-RPC methods of a node over HTTP, for the solo mining clients
+type RPCClient struct {
+	URL      string
+	User     string
+	Password string
+	//Version is sent as the jsonrpc member, bitcoin style nodes take "1.0"
+	Version string
+	//HTTP sends the requests, http.DefaultClient if nil. Calls are bounded by their context.
+	HTTP *http.Client
+
+	id int64
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int64         `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+//Call sends a request to the node and decodes the result into result, a nil result ignores it
+func (r *RPCClient) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(rpcRequest{JSONRPC: r.Version, ID: atomic.AddInt64(&r.id, 1), Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", r.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if r.User != "" || r.Password != "" {
+		req.SetBasicAuth(r.User, r.Password)
+	}
+	client := r.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return ErrRPCCredentials
+	}
+	//bitcoin style nodes answer errors with a status 500 and the error in the body
+	var reply rpcResponse
+	if err = json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("Invalid reply to %s with status %s", method, resp.Status)
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("%s: %s (%d)", method, reply.Error.Message, reply.Error.Code)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
@@ -0,0 +1,133 @@
+package clients
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//Session describes the current connection to the pool as seen by a client.
+// Zero times mean the step did not happen (yet).
+type Session struct {
+	Started         time.Time
+	Connected       time.Time
+	Subscribed      time.Time
+	Authorized      time.Time
+	FirstDifficulty time.Time
+	FirstJob        time.Time
+
+	ExtraNonce1     string
+	ExtraNonce2Size int
+	AuthorizeError  string
+	Difficulty      float64
+	Target          string
+	//Notifies counts the job notifications received, Jobs those that could be parsed
+	Notifies, Jobs int
+	//Oddities lists protocol deviations noticed on the way
+	Oddities []string
+}
+
+//SessionReporter is implemented by clients that record their pool session
+type SessionReporter interface {
+	Session() Session
+}
+
+type sessionRecorder struct {
+	mutex   sync.Mutex
+	session Session
+}
+
+func (sc *BaseClient) recorder() *sessionRecorder {
+	sc.sessionOnce.Do(func() { sc.sessionRec = &sessionRecorder{} })
+	return sc.sessionRec
+}
+
+func (sc *BaseClient) record(update func(s *Session)) {
+	rec := sc.recorder()
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	update(&rec.session)
+}
+
+//Session returns a copy of the current session record
+func (sc *BaseClient) Session() Session {
+	rec := sc.recorder()
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	s := rec.session
+	s.Oddities = append([]string(nil), s.Oddities...)
+	return s
+}
+
+//RecordStart begins a new session record, called before every connection attempt
+func (sc *BaseClient) RecordStart() {
+	sc.record(func(s *Session) { *s = Session{Started: time.Now()} })
+}
+
+//RecordConnected notes that the TCP connection is up
+func (sc *BaseClient) RecordConnected() {
+	sc.record(func(s *Session) { s.Connected = time.Now() })
+}
+
+//RecordSubscribe notes the subscription and the extranonce parameters the pool assigned
+func (sc *BaseClient) RecordSubscribe(extraNonce1 string, extraNonce2Size int) {
+	sc.record(func(s *Session) {
+		s.Subscribed = time.Now()
+		s.ExtraNonce1, s.ExtraNonce2Size = extraNonce1, extraNonce2Size
+	})
+}
+
+//RecordAuthorize notes the reply to mining.authorize, anything but true is an error
+func (sc *BaseClient) RecordAuthorize(result interface{}, err error) {
+	sc.record(func(s *Session) {
+		switch {
+		case err != nil:
+			s.AuthorizeError = err.Error()
+		case result != true:
+			s.AuthorizeError = fmt.Sprintf("pool replied %v", result)
+		default:
+			s.Authorized = time.Now()
+		}
+	})
+}
+
+//RecordDifficulty notes a difficulty sent by the pool
+func (sc *BaseClient) RecordDifficulty(difficulty float64) {
+	sc.record(func(s *Session) {
+		if s.FirstDifficulty.IsZero() {
+			s.FirstDifficulty = time.Now()
+		}
+		s.Difficulty = difficulty
+	})
+}
+
+//RecordTarget notes a share target sent by the pool instead of a difficulty
+func (sc *BaseClient) RecordTarget(target string) {
+	sc.record(func(s *Session) {
+		if s.FirstDifficulty.IsZero() {
+			s.FirstDifficulty = time.Now()
+		}
+		s.Target = target
+	})
+}
+
+//RecordNotify counts a job notification before it is parsed
+func (sc *BaseClient) RecordNotify() {
+	sc.record(func(s *Session) { s.Notifies++ })
+}
+
+//RecordJob counts a successfully parsed job
+func (sc *BaseClient) RecordJob() {
+	sc.record(func(s *Session) {
+		if s.FirstJob.IsZero() {
+			s.FirstJob = time.Now()
+		}
+		s.Jobs++
+	})
+}
+
+//RecordOddity notes a protocol deviation
+func (sc *BaseClient) RecordOddity(format string, args ...interface{}) {
+	oddity := fmt.Sprintf(format, args...)
+	sc.record(func(s *Session) { s.Oddities = append(s.Oddities, oddity) })
+}
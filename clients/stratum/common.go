@@ -66,28 +66,6 @@ func SHA256d(data []byte) []byte {
 	return hash
 }
 
-//ExtraNonce2 is the nonce modified by the miner
-type ExtraNonce2 struct {
-	Value uint64
-	Size  uint
-}
-
-//Bytes is a bigendian representation of the extranonce2
-func (en *ExtraNonce2) Bytes() (b []byte) {
-	b = make([]byte, en.Size, en.Size)
-	for i := uint(0); i < en.Size; i++ {
-		b[(en.Size-1)-i] = byte(en.Value >> (i * 8))
-	}
-	return
-}
-
-//Increment increases the nonce with 1, an error is returned if the resulting is value is bigger than possible given the size
-func (en *ExtraNonce2) Increment() (err error) {
-	en.Value++
-	//TODO: check if does not overflow compared to the allowed size
-	return
-}
-
 func CheckDifficultyReal(blockhash, target []byte) (isSatisfied bool) {
 	blockhashint := new(big.Int)
 	blockhashint.SetBytes(blockhash)
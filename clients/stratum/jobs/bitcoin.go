@@ -0,0 +1,107 @@
+package jobs
+
+import (
+	"errors"
+
+	"github.com/AGPFMiner/gominer/clients/stratum"
+)
+
+//BitcoinNotifyParams is the number of parameters of a bitcoin style mining.notify
+const BitcoinNotifyParams = 9
+
+//BitcoinJob is a job of a bitcoin style pool, as sent by skunk and odocrypt pools.
+// The hashes are in the byte order of the stratum protocol.
+type BitcoinJob struct {
+	JobID        string
+	PrevHash     []byte
+	Coinbase1    []byte
+	Coinbase2    []byte
+	MerkleBranch [][]byte
+	Version      []byte
+	NBits        []byte
+	NTime        []byte
+	CleanJobs    bool
+	ExtraNonce2  ExtraNonce2
+}
+
+//ParseBitcoinNotify parses the parameters of a bitcoin style mining.notify:
+// job_id, prevhash, coinb1, coinb2, merkle_branch, version, nbits, ntime, clean_jobs
+func ParseBitcoinNotify(params []interface{}) (j BitcoinJob, err error) {
+	if err = checkParams(params, BitcoinNotifyParams); err != nil {
+		return
+	}
+	var ok bool
+	if j.JobID, ok = params[0].(string); !ok {
+		return j, errors.New("Wrong job_id parameter")
+	}
+	if j.PrevHash, err = hexParam(params, 1, "prevhash"); err != nil {
+		return
+	}
+	if j.Coinbase1, err = hexParam(params, 2, "coinb1"); err != nil {
+		return
+	}
+	if j.Coinbase2, err = hexParam(params, 3, "coinb2"); err != nil {
+		return
+	}
+	branch, ok := params[4].([]interface{})
+	if !ok {
+		return j, errors.New("Wrong merkle_branch parameter")
+	}
+	j.MerkleBranch = make([][]byte, len(branch))
+	for i := range branch {
+		if j.MerkleBranch[i], err = hexParam(branch, i, "merkle_branch"); err != nil {
+			return
+		}
+	}
+	if j.Version, err = hexParam(params, 5, "version"); err != nil {
+		return
+	}
+	if j.NBits, err = hexParam(params, 6, "nbits"); err != nil {
+		return
+	}
+	if j.NTime, err = hexParam(params, 7, "ntime"); err != nil {
+		return
+	}
+	if j.CleanJobs, ok = params[8].(bool); !ok {
+		return j, errors.New("Wrong clean_jobs parameter")
+	}
+	return
+}
+
+//Coinbase joins the coinbase transaction from its parts and the extranonces
+func (j *BitcoinJob) Coinbase(extranonce1 []byte) []byte {
+	en2 := j.ExtraNonce2.Bytes()
+	coinbase := make([]byte, 0, len(j.Coinbase1)+len(extranonce1)+len(en2)+len(j.Coinbase2))
+	coinbase = append(coinbase, j.Coinbase1...)
+	coinbase = append(coinbase, extranonce1...)
+	coinbase = append(coinbase, en2...)
+	return append(coinbase, j.Coinbase2...)
+}
+
+//MerkleRoot folds the merkle branch of a job into the hash of the coinbase transaction
+func MerkleRoot(coinbaseHash []byte, branch [][]byte) []byte {
+	root := coinbaseHash
+	for _, h := range branch {
+		root = stratum.SHA256d(append(append([]byte{}, root...), h...))
+	}
+	return root
+}
+
+//BitcoinHeader serializes the fields of a job and its merkle root into a block header with an empty nonce.
+// version, ntime and nbits are big endian as in the job, prevHash has its 4 byte words swapped.
+func BitcoinHeader(version, prevHash, merkleRoot, ntime, nbits []byte) []byte {
+	header := make([]byte, 0, 80)
+	header = append(header, version...)
+	header = append(header, prevHash...)
+	header = append(header, stratum.RevHash(merkleRoot)...)
+	header = append(header, ntime...)
+	header = append(header, nbits...)
+	header = append(header, 0, 0, 0, 0) //empty nonce
+	return stratum.RevHash(header)
+}
+
+//Header builds the block header of the job for extranonce1 and the extranonce2 of the job
+func (j *BitcoinJob) Header(extranonce1 []byte) []byte {
+	root := MerkleRoot(stratum.SHA256d(j.Coinbase(extranonce1)), j.MerkleBranch)
+	return BitcoinHeader(j.Version, j.PrevHash, root, j.NTime, j.NBits)
+}
@@ -0,0 +1,59 @@
+package jobs
+
+import "errors"
+
+//CKBNotifyParams is the number of parameters of a ckb mining.notify
+const CKBNotifyParams = 5
+
+//CKBBoardNonceSize is the part of the extranonce2 of a ckb pool the boards search,
+// the ExtraNonce2 of a CKBJob is the part in front of it
+const CKBBoardNonceSize = 4
+
+//CKBJob is a job of a ckb pool
+type CKBJob struct {
+	JobID string
+	//PowHash is the hash of the header without the nonce, the boards mine on it
+	PowHash []byte
+	//Height and ParentHash are informational, they are left empty if the pool sends something else
+	Height      uint64
+	ParentHash  []byte
+	CleanJobs   bool
+	ExtraNonce2 ExtraNonce2
+}
+
+//ParseCKBNotify parses the parameters of a ckb mining.notify: job_id, pow_hash, height, parent_hash, clean_jobs
+func ParseCKBNotify(params []interface{}) (j CKBJob, err error) {
+	if err = checkParams(params, CKBNotifyParams); err != nil {
+		return
+	}
+	var ok bool
+	if j.JobID, ok = params[0].(string); !ok {
+		return j, errors.New("Wrong job_id parameter")
+	}
+	if j.PowHash, err = hexParam(params, 1, "pow_hash"); err != nil {
+		return
+	}
+	if height, ok := params[2].(float64); ok && height >= 0 {
+		j.Height = uint64(height)
+	}
+	j.ParentHash, _ = hexParam(params, 3, "parent_hash")
+	if j.CleanJobs, ok = params[4].(bool); !ok {
+		return j, errors.New("Wrong clean_jobs parameter")
+	}
+	return
+}
+
+//CKBHeader is the work of the ckb boards: pow_hash, extranonce1 and the extranonce2 the client sets,
+// the boards append the CKBBoardNonceSize bytes they search
+func CKBHeader(powHash, extranonce1 []byte, extranonce2 ExtraNonce2) []byte {
+	en2 := extranonce2.Bytes()
+	header := make([]byte, 0, len(powHash)+len(extranonce1)+len(en2))
+	header = append(header, powHash...)
+	header = append(header, extranonce1...)
+	return append(header, en2...)
+}
+
+//Header builds the work of the job for extranonce1 and the extranonce2 of the job
+func (j *CKBJob) Header(extranonce1 []byte) []byte {
+	return CKBHeader(j.PowHash, extranonce1, j.ExtraNonce2)
+}
@@ -0,0 +1,94 @@
+//Package jobs turns the jobs stratum pools send into the headers the boards mine on.
+// Every algorithm has a typed job parsed from its mining.notify parameters and a header builder,
+// the clients only keep the connection state and the target.
+package jobs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/AGPFMiner/gominer/clients/stratum"
+)
+
+//ErrExtraNonce2Overflow is returned by Increment when the value does not fit in the size anymore
+var ErrExtraNonce2Overflow = errors.New("Extranonce2 overflows its size")
+
+//ErrExtraNonce2Exhausted is returned by Take once every extranonce2 of a job was handed out,
+// more headers would repeat work
+var ErrExtraNonce2Exhausted = errors.New("Every extranonce2 of the job is used, waiting for a new job")
+
+//ExtraNonce2 is the nonce modified by the miner
+type ExtraNonce2 struct {
+	Value uint64
+	Size  uint
+	//Reserved is the number of leading bytes kept at 0, they are handed out to others
+	Reserved uint
+
+	exhausted bool
+}
+
+//Bytes is a bigendian representation of the extranonce2
+func (en *ExtraNonce2) Bytes() (b []byte) {
+	b = make([]byte, en.Size, en.Size)
+	for i := uint(0); i < en.Size && i < 8; i++ {
+		b[(en.Size-1)-i] = byte(en.Value >> (i * 8))
+	}
+	return
+}
+
+//Increment increases the nonce with 1. If the result does not fit in the Size bytes that are not
+// Reserved the value wraps to 0 and ErrExtraNonce2Overflow is returned.
+func (en *ExtraNonce2) Increment() (err error) {
+	en.Value++
+	rolled := en.rolled()
+	if rolled < 8 && en.Value>>(8*rolled) != 0 {
+		en.Value = 0
+		return ErrExtraNonce2Overflow
+	}
+	if rolled >= 8 && en.Value == 0 {
+		return ErrExtraNonce2Overflow
+	}
+	return
+}
+
+//rolled is the number of bytes that are not Reserved
+func (en *ExtraNonce2) rolled() uint {
+	if en.Size > en.Reserved {
+		return en.Size - en.Reserved
+	}
+	return 0
+}
+
+//Take returns the extranonce2 for the next header and advances to the following one.
+// Once the value wrapped around, or does not fit the bytes that are not Reserved anymore,
+// it returns ErrExtraNonce2Exhausted, a pool with an extranonce2 size of 0 gives a single header per job.
+func (en *ExtraNonce2) Take() (taken ExtraNonce2, err error) {
+	if rolled := en.rolled(); rolled < 8 && en.Value>>(8*rolled) != 0 {
+		en.exhausted = true
+	}
+	if en.exhausted {
+		return taken, ErrExtraNonce2Exhausted
+	}
+	taken = *en
+	if en.Increment() == ErrExtraNonce2Overflow {
+		en.exhausted = true
+	}
+	return
+}
+
+//hexParam decodes the hex string params[i] of a notification
+func hexParam(params []interface{}, i int, name string) ([]byte, error) {
+	b, err := stratum.HexStringToBytes(params[i])
+	if err != nil {
+		return nil, fmt.Errorf("Wrong %s parameter %v", name, params[i])
+	}
+	return b, nil
+}
+
+//checkParams checks that a notification carries at least n parameters
+func checkParams(params []interface{}, n int) error {
+	if len(params) < n {
+		return fmt.Errorf("mining.notify has %d parameters, expected %d", len(params), n)
+	}
+	return nil
+}
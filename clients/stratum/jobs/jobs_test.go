@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/AGPFMiner/gominer/clients/stratum"
+)
+
+func mustDecode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+//blockHash is the hash of a header as block explorers show it
+func blockHash(header []byte) string {
+	return hex.EncodeToString(stratum.RevBytes(stratum.SHA256d(header)))
+}
+
+func TestExtraNonce2(t *testing.T) {
+	en := ExtraNonce2{Value: 1, Size: 4}
+	if result := hex.EncodeToString(en.Bytes()); result != "00000001" {
+		t.Error(result, "returned instead of 00000001")
+	}
+	if err := en.Increment(); err != nil || hex.EncodeToString(en.Bytes()) != "00000002" {
+		t.Error("increment failed", en, err)
+	}
+
+	en = ExtraNonce2{Value: 0xfe, Size: 1}
+	if err := en.Increment(); err != nil {
+		t.Error("0xff does not fit in a byte", err)
+	}
+	if err := en.Increment(); err != ErrExtraNonce2Overflow || en.Value != 0 {
+		t.Error("overflow not detected", en, err)
+	}
+
+	//a job hands out every value once
+	en = ExtraNonce2{Size: 1}
+	for i := 0; i < 256; i++ {
+		taken, err := en.Take()
+		if err != nil || taken.Value != uint64(i) {
+			t.Fatal("value", i, "not handed out", taken, err)
+		}
+	}
+	if _, err := en.Take(); err != ErrExtraNonce2Exhausted {
+		t.Error("value handed out twice", err)
+	}
+	en = ExtraNonce2{}
+	if taken, err := en.Take(); err != nil || len(taken.Bytes()) != 0 {
+		t.Error("no header for an extranonce2 size of 0", err)
+	}
+	if _, err := en.Take(); err != ErrExtraNonce2Exhausted {
+		t.Error("second header for an extranonce2 size of 0", err)
+	}
+	//the reserved leading bytes stay at 0
+	en = ExtraNonce2{Size: 3, Reserved: 2}
+	for i := 0; i < 256; i++ {
+		if taken, err := en.Take(); err != nil || hex.EncodeToString(taken.Bytes()[:2]) != "0000" {
+			t.Fatal("reserved bytes handed out", taken, err)
+		}
+	}
+	if _, err := en.Take(); err != ErrExtraNonce2Exhausted {
+		t.Error("value handed out twice", err)
+	}
+	//a value taken before the bytes were reserved ends the job
+	en = ExtraNonce2{Value: 0x100, Size: 3, Reserved: 2}
+	if _, err := en.Take(); err != ErrExtraNonce2Exhausted {
+		t.Error("value outside the reserved range handed out", err)
+	}
+	en = ExtraNonce2{Size: 2, Reserved: 2}
+	en.Take()
+	if _, err := en.Take(); err != ErrExtraNonce2Exhausted {
+		t.Error("second header with every byte reserved", err)
+	}
+	en = ExtraNonce2{Value: 1<<64 - 1, Size: 12}
+	if en.Increment() != ErrExtraNonce2Overflow || hex.EncodeToString(en.Bytes()) != "000000000000000000000000" {
+		t.Error("wide extranonce2 does not wrap", en)
+	}
+}
+
+//The genesis block of bitcoin, its coinbase split like a pool would around 4+4 extranonce bytes
+var (
+	genesisCoinbase1 = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d"
+	genesisCoinbase2 = "696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+	genesisHeader    = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d00000000"
+)
+
+func TestBitcoinGenesis(t *testing.T) {
+	j, err := ParseBitcoinNotify([]interface{}{
+		"1", "0000000000000000000000000000000000000000000000000000000000000000", genesisCoinbase1, genesisCoinbase2,
+		[]interface{}{}, "00000001", "1d00ffff", "495fab29", true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.ExtraNonce2 = ExtraNonce2{Value: 0x68652054, Size: 4}
+	extranonce1 := mustDecode("01044554")
+
+	if hash := hex.EncodeToString(stratum.SHA256d(j.Coinbase(extranonce1))); hash != "3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a" {
+		t.Error("coinbase hash", hash)
+	}
+	header := j.Header(extranonce1)
+	if hex.EncodeToString(header) != genesisHeader {
+		t.Errorf("header %x", header)
+	}
+	copy(header[76:], mustDecode("1dac2b7c"))
+	if hash := blockHash(header); hash != "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f" {
+		t.Error("block hash", hash)
+	}
+}
+
+func TestBitcoinMerkleBranch(t *testing.T) {
+	//block 170, the coinbase and the first transaction spending a coinbase
+	coinbase := mustDecode("82501c1178fa0b222c1f3d474ec726b832013f0a532b44bb620cce8624a5feb1")
+	branch := [][]byte{mustDecode("169e1e83e930853391bc6f35f605c6754cfead57cf8387639d3b4096c54f18f4")}
+	root := MerkleRoot(coinbase, branch)
+	if hex.EncodeToString(stratum.RevBytes(root)) != "7dac2c5666815c17a3b36427de37bb9d2e2c5ccec3f8633eb91a4205cb4c10ff" {
+		t.Errorf("merkle root %x", root)
+	}
+
+	prevHash := mustDecode("0a84bd55d08a7978683f85da183d4f97dbd12b3e1f2c846a2a22cfee00000000")
+	header := BitcoinHeader(mustDecode("00000001"), prevHash, root, mustDecode("496ab951"), mustDecode("1d00ffff"))
+	copy(header[76:], mustDecode("283e9e70"))
+	if hash := blockHash(header); hash != "00000000d1145790a8694403d4063f323d499e655c83426834d4ce2f8dd4a2ee" {
+		t.Error("block hash", hash)
+	}
+}
+
+func TestBitcoinNotifyErrors(t *testing.T) {
+	params := []interface{}{"1", "00", "00", "00", []interface{}{"zz"}, "00", "00", "00", true}
+	if _, err := ParseBitcoinNotify(params); err == nil {
+		t.Error("invalid merkle branch accepted")
+	}
+	if _, err := ParseBitcoinNotify(params[:8]); err == nil {
+		t.Error("short notification accepted")
+	}
+}
+
+func TestCKBHeader(t *testing.T) {
+	//the pow hash of the eaglesong known answer, the board finds nonce 0071E05D for it
+	j, err := ParseCKBNotify([]interface{}{"7d21", "d5a74fba920ad0d35ec5726f26327547cbc82180e356e5ccf6cf2e6bd75f8a66", 1000.0, "00", true})
+	if err != nil || j.Height != 1000 {
+		t.Fatal(j, err)
+	}
+	j.ExtraNonce2.Size = 12 - CKBBoardNonceSize
+	header := j.Header(mustDecode("00c904bd"))
+	if hex.EncodeToString(header) != "d5a74fba920ad0d35ec5726f26327547cbc82180e356e5ccf6cf2e6bd75f8a6600c904bd0000000000000000" || len(header) != 44 {
+		t.Errorf("header %x", header)
+	}
+}
+
+func TestVerusHeader(t *testing.T) {
+	//a job of a verus pool
+	j, err := ParseVerusNotify([]interface{}{
+		"d785", "04000100",
+		"6d635ea1d8b9638aee3d39fd7a7d040eab56f8b7038da6e59c010b0000000000",
+		"6bb089d021eb23b7af029ae661690c32b8d79ecdc470efd21ea77b244b61824f",
+		"eb7d01915ee56b0c75791fdbfde8924dd1a379ba5c5442058df9641be627db1c",
+		"298b305d", "543d0e1b", true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extranonce1 := mustDecode("0fff2529")
+	j.ExtraNonce2.Size = uint(VerusNonceSize - len(extranonce1))
+	header := j.Header(extranonce1)
+	expected := "04000100" +
+		"6d635ea1d8b9638aee3d39fd7a7d040eab56f8b7038da6e59c010b0000000000" +
+		"6bb089d021eb23b7af029ae661690c32b8d79ecdc470efd21ea77b244b61824f" +
+		"eb7d01915ee56b0c75791fdbfde8924dd1a379ba5c5442058df9641be627db1c" +
+		"298b305d" + "543d0e1b" +
+		"0fff252900000000000000000000000000000000000000000000000000000000" +
+		"fd400501"
+	if len(header) != 1487 || hex.EncodeToString(header[:len(expected)/2]) != expected || !bytes.Equal(header[len(expected)/2:], make([]byte, VerusSolutionSize)) {
+		t.Errorf("header %x", header[:len(expected)/2])
+	}
+}
+
+func TestVeoHeader(t *testing.T) {
+	//the block of the veo known answer, its random bytes were 2bd9fd30 and 9de57a69
+	random := [VeoRandomSize]byte{0x2b, 0xd9, 0xfd, 0x30, 0x9d, 0xe5, 0x7a, 0x69}
+	header := VeoHeader(mustDecode("f86f71c0ae8eb91206c8ed3b98df8357db5eab795550622bfeb16100e75b15fe"), random)
+	if hex.EncodeToString(header) != "f86f71c0ae8eb91206c8ed3b98df8357db5eab795550622bfeb16100e75b15fe2bd9fd30000000009de57a6900000000" {
+		t.Errorf("header %x", header)
+	}
+}
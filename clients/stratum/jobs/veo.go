@@ -0,0 +1,21 @@
+package jobs
+
+//VeoRandomSize is the number of random bytes in a veo header
+const VeoRandomSize = 8
+
+//VeoJob is a job of a veo pool, the block hash is its ID
+type VeoJob struct {
+	JobID string
+	BHash []byte
+}
+
+//VeoHeader is the work of the veo boards: the block hash and two words of 4 random bytes, each followed
+// by 4 zero bytes the boards count up. The random bytes keep the boards and rigs off each other's range.
+func VeoHeader(bHash []byte, random [VeoRandomSize]byte) []byte {
+	header := make([]byte, 0, len(bHash)+16)
+	header = append(header, bHash...)
+	header = append(header, random[:4]...)
+	header = append(header, 0, 0, 0, 0)
+	header = append(header, random[4:]...)
+	return append(header, 0, 0, 0, 0)
+}
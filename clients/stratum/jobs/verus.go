@@ -0,0 +1,78 @@
+package jobs
+
+import "errors"
+
+//VerusNotifyParams is the number of parameters of a verus mining.notify
+const VerusNotifyParams = 8
+
+//VerusNonceSize is the size of extranonce1 and extranonce2 together, the pool sets the extranonce2 size to the rest
+const VerusNonceSize = 32
+
+//verusSolutionPrefix starts the solution, the compact size of 1344 bytes and the solution version
+var verusSolutionPrefix = []byte{0xfd, 0x40, 0x05, 0x01}
+
+//VerusSolutionSize is the size of the empty solution after the prefix
+const VerusSolutionSize = 1343
+
+//VerusJob is a job of a verus pool
+type VerusJob struct {
+	JobID       string
+	Version     []byte
+	Hash1       []byte
+	Hash2       []byte
+	Hash3       []byte
+	NBits       []byte
+	NTime       []byte
+	CleanJobs   bool
+	ExtraNonce2 ExtraNonce2
+}
+
+//ParseVerusNotify parses the parameters of a verus mining.notify:
+// job_id, version, prevhash, merkleroot, finalsaplingroot, ntime, nbits, clean_jobs
+func ParseVerusNotify(params []interface{}) (j VerusJob, err error) {
+	if err = checkParams(params, VerusNotifyParams); err != nil {
+		return
+	}
+	var ok bool
+	if j.JobID, ok = params[0].(string); !ok {
+		return j, errors.New("Wrong job_id parameter")
+	}
+	if j.Version, err = hexParam(params, 1, "version"); err != nil {
+		return
+	}
+	if j.Hash1, err = hexParam(params, 2, "hash1"); err != nil {
+		return
+	}
+	if j.Hash2, err = hexParam(params, 3, "hash2"); err != nil {
+		return
+	}
+	if j.Hash3, err = hexParam(params, 4, "hash3"); err != nil {
+		return
+	}
+	if j.NTime, err = hexParam(params, 5, "ntime"); err != nil {
+		return
+	}
+	if j.NBits, err = hexParam(params, 6, "nbits"); err != nil {
+		return
+	}
+	if j.CleanJobs, ok = params[7].(bool); !ok {
+		return j, errors.New("Wrong clean_jobs parameter")
+	}
+	return
+}
+
+//Header builds the block header of the job for extranonce1 and the extranonce2 of the job,
+// followed by the solution prefix and an empty solution
+func (j *VerusJob) Header(extranonce1 []byte) []byte {
+	header := make([]byte, 0, 4+3*32+4+4+VerusNonceSize+len(verusSolutionPrefix)+VerusSolutionSize)
+	header = append(header, j.Version...)
+	header = append(header, j.Hash1...)
+	header = append(header, j.Hash2...)
+	header = append(header, j.Hash3...)
+	header = append(header, j.NTime...)
+	header = append(header, j.NBits...)
+	header = append(header, extranonce1...)
+	header = append(header, j.ExtraNonce2.Bytes()...)
+	header = append(header, verusSolutionPrefix...)
+	return append(header, make([]byte, VerusSolutionSize)...)
+}
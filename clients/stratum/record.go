@@ -0,0 +1,127 @@
+package stratum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//Directions of a recorded line, seen from the client
+const (
+	//Dialed starts the lines of a connection, its message is the address
+	Dialed = "dial"
+	//Sent lines were written to the pool
+	Sent = "sent"
+	//Received lines were read from the pool
+	Received = "received"
+)
+
+//Line is an entry of a recording
+type Line struct {
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`
+	//Msg holds lines that are valid JSON, Raw those that are not
+	Msg json.RawMessage `json:"msg,omitempty"`
+	Raw string          `json:"raw,omitempty"`
+}
+
+//Text returns the line as it went over the connection, without the newline
+func (l Line) Text() []byte {
+	if l.Msg != nil {
+		return l.Msg
+	}
+	return []byte(l.Raw)
+}
+
+//Recorder writes every line of a stratum session as a JSON line with a timestamp, it is safe for concurrent use
+type Recorder struct {
+	mutex sync.Mutex
+	w     io.Writer
+	err   error
+}
+
+//NewRecorder records to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+//OpenRecorder appends the recording to the file at path, so reconnections end up in the same file
+func OpenRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(file), nil
+}
+
+//Record appends a line exchanged in dir, once writing failed every later line is dropped
+func (r *Recorder) Record(dir string, line []byte) error {
+	entry := Line{Time: time.Now(), Dir: dir}
+	line = bytes.TrimRight(line, "\r\n")
+	if json.Valid(line) {
+		entry.Msg = append(json.RawMessage{}, line...)
+	} else {
+		entry.Raw = string(line)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	_, r.err = r.w.Write(append(data, '\n'))
+	return r.err
+}
+
+//Close closes the file of the recording, if it records to one
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err == nil {
+		r.err = os.ErrClosed
+	}
+	if closer, ok := r.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+//ReadRecording reads the lines of a recording
+func ReadRecording(r io.Reader) (lines []Line, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var line Line
+		if err = json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return
+		}
+		lines = append(lines, line)
+	}
+	err = scanner.Err()
+	return
+}
+
+//Sessions splits a recording into its connections, lines before the first Dialed one are dropped
+func Sessions(lines []Line) (sessions [][]Line) {
+	for _, line := range lines {
+		if line.Dir == Dialed {
+			sessions = append(sessions, nil)
+			continue
+		}
+		if len(sessions) > 0 {
+			sessions[len(sessions)-1] = append(sessions[len(sessions)-1], line)
+		}
+	}
+	return
+}
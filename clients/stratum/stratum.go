@@ -6,9 +6,11 @@ import (
 	"bufio"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AGPFMiner/gominer/types"
@@ -81,8 +83,15 @@ type Client struct {
 	ErrorCallback        ErrorCallback
 	notificationHandlers map[interface{}]NotificationHandler
 	Veo                  bool
-	poolstates           types.PoolConnectionStates
+	poolstates           int32 // types.PoolConnectionStates, accessed atomically
 	feedDog              chan bool
+
+	//UnhandledNotification is called for notifications without a registered handler
+	UnhandledNotification func(method interface{}, args []interface{})
+
+	//Record is the path of a file every line exchanged with the pool is appended to, see Recorder
+	Record   string
+	recorder *Recorder
 }
 
 func (c *Client) watchDog() {
@@ -93,30 +102,40 @@ func (c *Client) watchDog() {
 	for {
 		select {
 		case <-time.After(timeout):
-			c.poolstates = types.Sick
+			c.setPoolState(types.Sick)
 
 		case <-c.feedDog:
-			c.poolstates = types.Alive
+			c.setPoolState(types.Alive)
 		}
 	}
 }
 
 func (c *Client) PoolConnectionStates() types.PoolConnectionStates {
-	return c.poolstates
+	return types.PoolConnectionStates(atomic.LoadInt32(&c.poolstates))
+}
+
+func (c *Client) setPoolState(state types.PoolConnectionStates) {
+	atomic.StoreInt32(&c.poolstates, int32(state))
 }
 
 //Dial connects to a stratum+tcp at the specified network address.
 // This function is not threadsafe
 // If an error occurs, it is both returned here and through the ErrorCallback of the Client
 func (c *Client) Dial(host string) (err error) {
-	c.poolstates = types.NotReady
+	c.setPoolState(types.NotReady)
+	if c.Record != "" && c.recorder == nil {
+		if c.recorder, err = OpenRecorder(c.Record); err != nil {
+			log.Print("Not recording the stratum session: ", err)
+		}
+	}
 	for try := 0; try < 6; try++ {
 		c.socket, err = net.DialTimeout("tcp", host, time.Second*5)
 		if err != nil {
 			log.Print("TCP Dial err: ", err)
 			continue
 		} else {
-			c.poolstates = types.Alive
+			c.setPoolState(types.Alive)
+			c.record(Dialed, []byte(fmt.Sprintf("%q", host)))
 			c.feedDog = make(chan bool, 1)
 			go c.watchDog()
 			go c.Listen()
@@ -125,7 +144,7 @@ func (c *Client) Dial(host string) (err error) {
 	}
 	err = errors.New("TCP Dial Failed, pool has been dead")
 	log.Print(err)
-	c.poolstates = types.Dead
+	c.setPoolState(types.Dead)
 	c.dispatchError(err)
 	return
 }
@@ -135,6 +154,15 @@ func (c *Client) Close() {
 	if c.socket != nil {
 		c.socket.Close()
 	}
+	if c.recorder != nil {
+		c.recorder.Close()
+	}
+}
+
+func (c *Client) record(dir string, line []byte) {
+	if c.recorder != nil && len(line) > 0 {
+		c.recorder.Record(dir, line)
+	}
 }
 
 //SetNotificationHandler registers a function to handle notification for a specific method.
@@ -148,10 +176,6 @@ func (c *Client) SetNotificationHandler(method interface{}, handler Notification
 
 func (c *Client) dispatchNotification(n notification, r interface{}) {
 	// spew.Dump(c.notificationHandlers, n)
-	if c.notificationHandlers == nil {
-		return
-	}
-
 	var method interface{}
 	switch n.Method.(type) {
 	case string:
@@ -174,6 +198,8 @@ func (c *Client) dispatchNotification(n notification, r interface{}) {
 	// spew.Dump(n.Method, method)
 	if notificationHandler, exists := c.notificationHandlers[method]; exists {
 		notificationHandler(n.Params, r)
+	} else if c.UnhandledNotification != nil {
+		c.UnhandledNotification(n.Method, n.Params)
 	}
 }
 
@@ -187,9 +213,7 @@ func (c *Client) dispatch(r response) {
 	cb, found := c.pendingCalls[r.ID]
 	var result interface{}
 	if r.Error != nil {
-		message := "Oops"
-		message, _ = r.Error.(string)
-		result = errors.New(message)
+		result = errors.New(errorMessage(r.Error))
 	} else {
 		result = r.Result
 	}
@@ -198,6 +222,26 @@ func (c *Client) dispatch(r response) {
 	}
 }
 
+//errorMessage extracts the message of a stratum error, pools send either a plain string,
+// an array [code, message, traceback] or an object with a message field
+func errorMessage(e interface{}) string {
+	switch e := e.(type) {
+	case string:
+		return e
+	case []interface{}:
+		if len(e) > 1 {
+			if message, ok := e[1].(string); ok {
+				return message
+			}
+		}
+	case map[string]interface{}:
+		if message, ok := e["message"].(string); ok {
+			return message
+		}
+	}
+	return fmt.Sprint(e)
+}
+
 func (c *Client) dispatchError(err error) {
 	if c.ErrorCallback != nil {
 		c.ErrorCallback(err)
@@ -210,9 +254,10 @@ func (c *Client) Listen() {
 	reader := bufio.NewReader(c.socket)
 	for {
 		rawmessage, err := reader.ReadString('\n')
+		c.record(Received, []byte(rawmessage))
 		c.feedDog <- true
 		if err != nil {
-			c.poolstates = types.Sick
+			c.setPoolState(types.Sick)
 			c.dispatchError(err)
 			return
 		}
@@ -222,11 +267,11 @@ func (c *Client) Listen() {
 		// log.Println(err)
 		// spew.Dump(r)
 		if err != nil {
-			c.poolstates = types.Sick
+			c.setPoolState(types.Sick)
 			c.dispatchError(err)
 			return
 		}
-		c.poolstates = types.Alive
+		c.setPoolState(types.Alive)
 		c.dispatch(r)
 	}
 }
@@ -242,11 +287,11 @@ func (c *Client) registerRequest(requestID uint64) (cb chan interface{}) {
 	return
 }
 
-func (c *Client) cancelRequest(requestID uint64) {
+//cancelRequest drops the request if cb still waits for it, veo pools reuse the ID of every request
+func (c *Client) cancelRequest(requestID uint64, cb chan interface{}) {
 	c.callsMutex.Lock()
 	defer c.callsMutex.Unlock()
-	cb, found := c.pendingCalls[requestID]
-	if found {
+	if pending, found := c.pendingCalls[requestID]; found && pending == cb {
 		close(cb)
 		delete(c.pendingCalls, requestID)
 	}
@@ -270,20 +315,22 @@ func (c *Client) Call(serviceMethod interface{}, args interface{}) (reply interf
 		return
 	}
 	call := c.registerRequest(r.ID)
-	defer c.cancelRequest(r.ID)
+	defer c.cancelRequest(r.ID, call)
 
 	rawmsg = append(rawmsg, []byte("\n")...)
+	//recorded before the write, the reply may be read before the write returns
+	c.record(Sent, rawmsg)
 	_, err = c.socket.Write(rawmsg)
 	log.Print("[Stratum --->]", string(rawmsg), "err:", err)
 	if err != nil {
-		c.poolstates = types.Sick
+		c.setPoolState(types.Sick)
 		log.Print("Socket Write Error:", err)
 		return
 	}
 	//Make sure the request is cancelled if no response is given
 	go func() {
 		time.Sleep(10 * time.Second)
-		c.cancelRequest(r.ID)
+		c.cancelRequest(r.ID, call)
 	}()
 	reply = <-call
 
@@ -1 +1,83 @@
 package stratum
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorMessage(t *testing.T) {
+	cases := []struct {
+		err      interface{}
+		expected string
+	}{
+		{"Job not found", "Job not found"},
+		{[]interface{}{21.0, "Job not found", nil}, "Job not found"},
+		{map[string]interface{}{"code": 21.0, "message": "Job not found"}, "Job not found"},
+		{[]interface{}{21.0}, "[21]"},
+	}
+	for _, c := range cases {
+		if result := errorMessage(c.err); result != c.expected {
+			t.Errorf("errorMessage(%v) = %q, expected %q", c.err, result, c.expected)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("{\"id\":1,\"result\":true,\"error\":null}\nnot json\n"))
+	}()
+
+	f, err := ioutil.TempFile("", "stratum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	c := &Client{Record: f.Name()}
+	if err := c.Dial(listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := c.Call("mining.authorize", []string{"worker", "x"}); err != nil || reply != true {
+		t.Fatal("call failed", reply, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+
+	f, _ = os.Open(f.Name())
+	defer f.Close()
+	lines, err := ReadRecording(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := Sessions(lines)
+	if len(sessions) != 1 || len(sessions[0]) != 3 {
+		t.Fatal("lines not recorded", lines)
+	}
+	session := sessions[0]
+	if session[0].Dir != Sent || !strings.Contains(string(session[0].Text()), "mining.authorize") {
+		t.Error("request not recorded", session[0])
+	}
+	if session[1].Dir != Received || string(session[1].Text()) != "{\"id\":1,\"result\":true,\"error\":null}" {
+		t.Error("reply not recorded", session[1])
+	}
+	if session[2].Raw != "not json" || session[2].Time.Before(session[0].Time) {
+		t.Error("invalid line not recorded", session[2])
+	}
+}
@@ -0,0 +1,518 @@
+//Package config loads and validates the miner configuration
+package config
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AGPFMiner/gominer/boardman"
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/miner"
+	"github.com/AGPFMiner/gominer/proxy"
+	"github.com/AGPFMiner/gominer/types"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+//Config is the typed content of gominer.json
+type Config struct {
+	Driver       string `json:"driver" mapstructure:"driver"`
+	Device       string `json:"device" mapstructure:"device"`
+	BaudRate     uint   `json:"baudrate" mapstructure:"baudrate"`
+	MuxNum       int    `json:"muxnum" mapstructure:"muxnum"`
+	SkipSlots    []int  `json:"skipslots" mapstructure:"skipslots"`
+	PollDelay    int64  `json:"polldelay" mapstructure:"polldelay"`
+	NonceTimeout int64  `json:"noncetimeout" mapstructure:"noncetimeout"`
+	Debug        string `json:"debug" mapstructure:"debug"`
+	Capture      string `json:"capture" mapstructure:"capture"`
+
+	APIService bool                `json:"api-service" mapstructure:"api-service"`
+	APIListen  string              `json:"api-listen" mapstructure:"api-listen"`
+	APIAuth    miner.AuthConfig    `json:"api-auth" mapstructure:"api-auth"`
+	CGMinerAPI miner.CGMinerConfig `json:"cgminer-api" mapstructure:"cgminer-api"`
+	TempAlarm  float64             `json:"temp-alarm" mapstructure:"temp-alarm"`
+	Proxy      proxy.Config        `json:"proxy" mapstructure:"proxy"`
+
+	Pools   []types.Pool `json:"pools" mapstructure:"pools"`
+	Devices []Device     `json:"devices" mapstructure:"devices"`
+}
+
+//Device is an entry of devices, a chain of boards with its own driver.
+// Driver, baudrate, polldelay and noncetimeout default to the top level settings.
+type Device struct {
+	Name         string `json:"name" mapstructure:"name"`
+	Driver       string `json:"driver" mapstructure:"driver"`
+	Device       string `json:"device" mapstructure:"device"`
+	BaudRate     uint   `json:"baudrate" mapstructure:"baudrate"`
+	MuxNum       int    `json:"muxnum" mapstructure:"muxnum"`
+	SkipSlots    []int  `json:"skipslots" mapstructure:"skipslots"`
+	PollDelay    int64  `json:"polldelay" mapstructure:"polldelay"`
+	NonceTimeout int64  `json:"noncetimeout" mapstructure:"noncetimeout"`
+	//Pool is the index in pools the chain mines for, the active pool if unset
+	Pool *int `json:"pool" mapstructure:"pool"`
+}
+
+//Drivers lists the supported values of the driver setting
+var Drivers = []string{"thyroid"}
+
+//LogLevels lists the supported values of the debug setting
+var LogLevels = []string{"debug", "info", "error"}
+
+//BaudRates lists the serial speeds the boards can be configured with
+var BaudRates = []uint{9600, 19200, 38400, 57600, 115200, 230400, 460800, 500000, 576000, 921600,
+	1000000, 1152000, 1500000, 2000000, 2500000, 3000000, 3500000, 4000000}
+
+//flagKeys are bound from the command line and never appear in the file
+var flagKeys = map[string]bool{"cfg": true, "test": true}
+
+//SetDefaults registers the built-in defaults on v
+func SetDefaults(v *viper.Viper) {
+	v.SetDefault("device", "/dev/ttyAMA0")
+	v.SetDefault("baudrate", "115200")
+	v.SetDefault("driver", "thyroid")
+	v.SetDefault("muxnum", "1")
+	v.SetDefault("polldelay", "60")
+	v.SetDefault("noncetimeout", "1000")
+	v.SetDefault("debug", "error")
+	v.SetDefault("skipslots", []int{})
+	v.SetDefault("api-service", true)
+	v.SetDefault("api-listen", miner.DefaultWebListen)
+	v.SetDefault("temp-alarm", 85)
+}
+
+//Problem is a single issue found in the configuration
+type Problem struct {
+	Key     string
+	Message string
+	//Warning problems are reported but do not prevent mining
+	Warning bool
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	if p.Key == "" {
+		return level + ": " + p.Message
+	}
+	return level + ": " + p.Key + ": " + p.Message
+}
+
+//Problems is the result of checking a configuration
+type Problems []Problem
+
+//Fatal reports whether any problem is an error
+func (ps Problems) Fatal() bool {
+	for _, p := range ps {
+		if !p.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func (ps *Problems) errorf(key, format string, args ...interface{}) {
+	*ps = append(*ps, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+func (ps *Problems) warnf(key, format string, args ...interface{}) {
+	*ps = append(*ps, Problem{Key: key, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+//decodeErrorKey extracts the key from mapstructure's "'key' expected type ..." messages
+var decodeErrorKey = regexp.MustCompile(`'([^']*)' ?`)
+
+//unknownKeys lists the keys of value that have no field in t, recursing into structs and slices of structs
+func unknownKeys(prefix string, value interface{}, t reflect.Type) (keys []string) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return unknownKeys(prefix, value, t.Elem())
+	case reflect.Slice:
+		items, _ := value.([]interface{})
+		for i, item := range items {
+			keys = append(keys, unknownKeys(fmt.Sprintf("%s[%d]", prefix, i), item, t.Elem())...)
+		}
+	case reflect.Struct:
+		settings, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			fields[strings.ToLower(name)] = field.Type
+		}
+		for key, item := range settings {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			ft, ok := fields[strings.ToLower(key)]
+			if !ok {
+				keys = append(keys, name)
+				continue
+			}
+			keys = append(keys, unknownKeys(name, item, ft)...)
+		}
+	}
+	return
+}
+
+//Load decodes the settings of v, accepting numbers and booleans written as strings,
+// and validates the result. Every problem found is returned, not just the first one.
+func Load(v *viper.Viper) (*Config, Problems) {
+	var problems Problems
+	cfg := &Config{}
+	settings := v.AllSettings()
+	if err := v.Unmarshal(cfg); err != nil {
+		if merr, ok := err.(*mapstructure.Error); ok {
+			sort.Strings(merr.Errors)
+			for _, e := range merr.Errors {
+				if m := decodeErrorKey.FindStringSubmatch(e); m != nil {
+					problems.errorf(strings.ToLower(m[1]), "%s", strings.Replace(e, m[0], "", 1))
+				} else {
+					problems.errorf("", "%s", e)
+				}
+			}
+		} else {
+			problems.errorf("", "%v", err)
+		}
+	}
+	unknown := unknownKeys("", settings, reflect.TypeOf(cfg))
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		if !flagKeys[key] {
+			problems.warnf(key, "unknown setting, ignored")
+		}
+	}
+	cfg.inheritDevices()
+	problems = append(problems, cfg.Validate()...)
+	cfg.selectActivePool()
+	return cfg, problems
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func checkListen(problems *Problems, key, addr string) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		problems.errorf(key, "%q is not a host:port address", addr)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		problems.errorf(key, "%q has an invalid port", addr)
+	}
+}
+
+//validateProxy checks the proxy settings and warns when no pool can be shared with the downstream miners
+func (cfg *Config) validateProxy(problems *Problems) {
+	if !cfg.Proxy.Enable {
+		return
+	}
+	if cfg.Proxy.Listen != "" {
+		checkListen(problems, "proxy.listen", cfg.Proxy.Listen)
+	}
+	if cfg.Proxy.Difficulty < 0 {
+		problems.errorf("proxy.difficulty", "must not be negative")
+	}
+	if cfg.Proxy.ShareTime < 0 {
+		problems.errorf("proxy.sharetime", "must not be negative")
+	}
+	for _, pool := range cfg.Pools {
+		if contains(miner.ProxyAlgorithms, pool.Algo) && !clients.IsNodeURL(pool.URL) {
+			return
+		}
+	}
+	problems.warnf("proxy.enable", "no pool to share, the proxy serves the stratum pools of %s", strings.Join(miner.ProxyAlgorithms, ", "))
+}
+
+//checkPoolURL accepts '[stratum+tcp://]host:port', and 'http://host:port' for solo mining on a node
+func checkPoolURL(problems *Problems, key, poolURL string, solo bool) {
+	if poolURL == "" {
+		problems.errorf(key, "missing")
+		return
+	}
+	addr := strings.TrimPrefix(poolURL, "stratum+tcp://")
+	if solo {
+		addr = strings.TrimPrefix(strings.TrimPrefix(addr, "http://"), "https://")
+	}
+	if idx := strings.Index(addr, "://"); idx >= 0 {
+		use := "stratum+tcp://host:port"
+		if solo {
+			use += " or http://host:port"
+		}
+		problems.errorf(key, "scheme %q is not supported, use %s", addr[:idx], use)
+		return
+	}
+	host, port, err := net.SplitHostPort(strings.TrimSuffix(addr, "/"))
+	if err != nil || host == "" {
+		problems.errorf(key, "%q is not a host:port address", poolURL)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		problems.errorf(key, "%q has an invalid port", poolURL)
+	}
+}
+
+//Validate checks the semantics of every setting
+func (cfg *Config) Validate() (problems Problems) {
+	validateDevice(&problems, "", cfg.Driver, cfg.Device, cfg.BaudRate, cfg.MuxNum, cfg.SkipSlots)
+	cfg.validateDevices(&problems)
+
+	if cfg.PollDelay < 1 {
+		problems.errorf("polldelay", "must be at least 1 ms")
+	}
+	if cfg.NonceTimeout < 0 {
+		problems.errorf("noncetimeout", "must not be negative")
+	}
+	if !contains(LogLevels, cfg.Debug) {
+		problems.errorf("debug", "%q is not a log level, use one of %s", cfg.Debug, strings.Join(LogLevels, ", "))
+	}
+	if cfg.TempAlarm < 0 {
+		problems.errorf("temp-alarm", "must not be negative")
+	}
+
+	if cfg.APIService {
+		checkListen(&problems, "api-listen", cfg.APIListen)
+	}
+	cfg.validateAuth(&problems)
+	if cfg.CGMinerAPI.Enable {
+		if cfg.CGMinerAPI.Listen != "" {
+			checkListen(&problems, "cgminer-api.listen", cfg.CGMinerAPI.Listen)
+		}
+		if err := cfg.CGMinerAPI.Validate(); err != nil {
+			problems.errorf("cgminer-api", "%v", err)
+		}
+	}
+
+	cfg.validateProxy(&problems)
+
+	if len(cfg.Pools) == 0 {
+		problems.errorf("pools", "at least one pool is required")
+	}
+	active := 0
+	for i, pool := range cfg.Pools {
+		key := fmt.Sprintf("pools[%d]", i)
+		solo := contains(miner.SoloAlgorithms, pool.Algo)
+		checkPoolURL(&problems, key+".url", pool.URL, solo)
+		if solo && clients.IsNodeURL(pool.URL) {
+			payout := contains(miner.PayoutAlgorithms, pool.Algo)
+			if payout && pool.Payout == "" {
+				problems.errorf(key+".payout", "missing, solo mining needs the address the block reward is paid to")
+			}
+			if !payout && pool.Payout != "" {
+				problems.warnf(key+".payout", "ignored, the node pays the block reward to the address it is configured with")
+			}
+		}
+		if !contains(miner.Algorithms, pool.Algo) {
+			problems.errorf(key+".algo", "%q is not supported, use one of %s", pool.Algo, strings.Join(miner.Algorithms, ", "))
+		}
+		if pool.User == "" {
+			problems.errorf(key+".user", "missing")
+		}
+		if pool.Priority < 0 {
+			problems.errorf(key+".priority", "must not be negative")
+		}
+		if pool.Active {
+			active++
+		}
+	}
+	if active > 1 {
+		problems.errorf("pools", "%d pools are marked active, at most one may be", active)
+	}
+	return
+}
+
+//validateDevice checks the settings of one chain of boards, prefix is prepended to the keys
+func validateDevice(problems *Problems, prefix, driver, device string, baudRate uint, muxNum int, skipSlots []int) {
+	if !contains(Drivers, driver) {
+		problems.errorf(prefix+"driver", "%q is not supported, use one of %s", driver, strings.Join(Drivers, ", "))
+	}
+
+	if device == "" {
+		problems.errorf(prefix+"device", "missing")
+	} else if strings.HasPrefix(device, "@") {
+		checkListen(problems, prefix+"device", strings.TrimPrefix(device, "@"))
+	} else {
+		supported := false
+		for _, rate := range BaudRates {
+			supported = supported || rate == baudRate
+		}
+		if !supported {
+			problems.errorf(prefix+"baudrate", "%d is not a supported serial speed", baudRate)
+		}
+	}
+
+	if muxNum < 1 || muxNum > boardman.MaxBoards {
+		problems.errorf(prefix+"muxnum", "%d is out of range 1-%d", muxNum, boardman.MaxBoards)
+	}
+	seen := make(map[int]bool)
+	for i, slot := range skipSlots {
+		key := fmt.Sprintf("skipslots[%d]", i)
+		if slot < 1 || slot > muxNum {
+			problems.errorf(prefix+key, "slot %d does not exist, slots are numbered 1-%d", slot, muxNum)
+		} else if seen[slot] {
+			problems.warnf(prefix+key, "slot %d is listed twice", slot)
+		}
+		seen[slot] = true
+	}
+	if muxNum >= 1 && len(seen) >= muxNum {
+		problems.errorf(prefix+"skipslots", "every slot is skipped")
+	}
+}
+
+//validateDevices checks the devices entries.
+// The mux is driven by the GPIO pins of the host, so only one chain may have more than one slot.
+func (cfg *Config) validateDevices(problems *Problems) {
+	devices := make(map[string]bool)
+	muxed := -1
+	for i, d := range cfg.Devices {
+		prefix := fmt.Sprintf("devices[%d].", i)
+		validateDevice(problems, prefix, d.Driver, d.Device, d.BaudRate, d.MuxNum, d.SkipSlots)
+		if devices[d.Device] {
+			problems.errorf(prefix+"device", "%q is used by another chain", d.Device)
+		}
+		devices[d.Device] = true
+		if d.MuxNum > 1 {
+			if muxed >= 0 {
+				problems.errorf(prefix+"muxnum", "devices[%d] already uses the mux, every other chain must have a single board", muxed)
+			} else {
+				muxed = i
+			}
+		}
+		if d.PollDelay < 1 {
+			problems.errorf(prefix+"polldelay", "must be at least 1 ms")
+		}
+		if d.NonceTimeout < 0 {
+			problems.errorf(prefix+"noncetimeout", "must not be negative")
+		}
+		if d.Pool != nil && (*d.Pool < 0 || *d.Pool >= len(cfg.Pools)) {
+			problems.errorf(prefix+"pool", "pool %d does not exist, pools are numbered 0-%d", *d.Pool, len(cfg.Pools)-1)
+		}
+	}
+}
+
+//inheritDevices fills the unset settings of the devices entries from the top level ones
+func (cfg *Config) inheritDevices() {
+	for i := range cfg.Devices {
+		d := &cfg.Devices[i]
+		if d.Driver == "" {
+			d.Driver = cfg.Driver
+		}
+		if d.BaudRate == 0 {
+			d.BaudRate = cfg.BaudRate
+		}
+		if d.PollDelay == 0 {
+			d.PollDelay = cfg.PollDelay
+		}
+		if d.NonceTimeout == 0 {
+			d.NonceTimeout = cfg.NonceTimeout
+		}
+		if d.Name == "" {
+			d.Name = d.Device
+		}
+	}
+}
+
+func (cfg *Config) validateAuth(problems *Problems) {
+	auth := &cfg.APIAuth
+	for i, key := range auth.Keys {
+		if key.Key == "" {
+			problems.errorf(fmt.Sprintf("api-auth.keys[%d].key", i), "missing")
+		}
+		if miner.ParseRole(key.Role) == miner.RoleNone {
+			problems.errorf(fmt.Sprintf("api-auth.keys[%d].role", i), "%q is not a role, use admin or readonly", key.Role)
+		}
+	}
+	for i, user := range auth.Users {
+		if user.User == "" || user.Pass == "" {
+			problems.errorf(fmt.Sprintf("api-auth.users[%d]", i), "user and pass are required")
+		}
+		if miner.ParseRole(user.Role) == miner.RoleNone {
+			problems.errorf(fmt.Sprintf("api-auth.users[%d].role", i), "%q is not a role, use admin or readonly", user.Role)
+		}
+	}
+	for cn, role := range auth.CertRoles {
+		if miner.ParseRole(role) == miner.RoleNone {
+			problems.errorf("api-auth.certroles."+cn, "%q is not a role, use admin or readonly", role)
+		}
+	}
+	if (auth.TLSCert == "") != (auth.TLSKey == "") {
+		problems.errorf("api-auth", "tlscert and tlskey must be set together")
+	}
+	if auth.ClientCA != "" && auth.TLSCert == "" {
+		problems.errorf("api-auth.clientca", "requires tlscert and tlskey")
+	}
+}
+
+//selectActivePool marks the pool with the lowest priority active when none is
+func (cfg *Config) selectActivePool() {
+	best := -1
+	for i, pool := range cfg.Pools {
+		if pool.Active {
+			return
+		}
+		if best < 0 || pool.Priority < cfg.Pools[best].Priority {
+			best = i
+		}
+	}
+	if best >= 0 {
+		cfg.Pools[best].Active = true
+	}
+}
+
+//Apply copies the configuration onto m
+func (cfg *Config) Apply(m *miner.Miner) {
+	m.Pools = cfg.Pools
+	m.DevPath = cfg.Device
+	m.BaudRate = cfg.BaudRate
+	m.Driver = cfg.Driver
+	m.MuxNums = cfg.MuxNum
+	m.SkipSlots = cfg.SkipSlots
+	m.PollDelay = cfg.PollDelay
+	m.NonceTraverseTimeout = cfg.NonceTimeout
+	m.WebEnable = cfg.APIService
+	m.WebListen = cfg.APIListen
+	m.Auth = cfg.APIAuth
+	m.CGMiner = cfg.CGMinerAPI
+	m.TempAlarm = cfg.TempAlarm
+	m.Proxy = cfg.Proxy
+	m.LogLevel = cfg.Debug
+	m.Capture = cfg.Capture
+	m.Chains = nil
+	for _, d := range cfg.Devices {
+		chain := miner.Chain{
+			Name:                 d.Name,
+			Driver:               d.Driver,
+			DevPath:              d.Device,
+			BaudRate:             d.BaudRate,
+			MuxNums:              d.MuxNum,
+			SkipSlots:            append([]int{}, d.SkipSlots...),
+			PollDelay:            d.PollDelay,
+			NonceTraverseTimeout: d.NonceTimeout,
+			Pool:                 -1,
+		}
+		if d.Pool != nil {
+			chain.Pool = *d.Pool
+		}
+		m.Chains = append(m.Chains, chain)
+	}
+}
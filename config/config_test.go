@@ -0,0 +1,221 @@
+package config
+
+import (
+	"bytes"
+	j "encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AGPFMiner/gominer/miner"
+
+	"github.com/spf13/viper"
+)
+
+func load(t *testing.T, content []byte) (*Config, Problems) {
+	v := viper.New()
+	SetDefaults(v)
+	v.SetConfigType("json")
+	if err := v.ReadConfig(bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	return Load(v)
+}
+
+func TestSampleConfig(t *testing.T) {
+	content, err := ioutil.ReadFile("../gominer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, problems := load(t, content)
+	if len(problems) != 0 {
+		t.Fatal("sample config has problems:", problems)
+	}
+	if cfg.BaudRate != 2000000 || cfg.MuxNum != 12 || cfg.APIListen != ":1234" {
+		t.Error("settings not decoded", cfg)
+	}
+	if pool := cfg.Pools[0]; !pool.Active || pool.Priority != 2 {
+		t.Error("string active and priority not decoded", pool)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	_, problems := load(t, []byte(`{
+		"baudrate": "12345", "muxnum": 13, "skipslots": [0], "debug": "verbose", "speed": 1,
+		"api-auth": {"keys": [{"key": "k", "role": "root"}]},
+		"pools": [
+			{"url": "http://pool:80", "algo": "btc", "user": "", "active": "maybe"},
+			{"url": "stratum+tcp://pool", "algo": "ckb", "user": "u", "extra": 1}
+		]
+	}`))
+	expected := map[string]bool{
+		"baudrate":              false,
+		"muxnum":                false,
+		"skipslots[0]":          false,
+		"debug":                 false,
+		"speed":                 true,
+		"api-auth.keys[0].role": false,
+		"pools[0].url":          false,
+		"pools[0].algo":         false,
+		"pools[0].user":         false,
+		"pools[0].active":       false,
+		"pools[1].url":          false,
+		"pools[1].extra":        true,
+	}
+	for _, p := range problems {
+		warning, ok := expected[p.Key]
+		if !ok {
+			t.Error("unexpected problem", p)
+			continue
+		}
+		if warning != p.Warning {
+			t.Error("wrong severity", p)
+		}
+		delete(expected, p.Key)
+	}
+	for key := range expected {
+		t.Error("no problem reported for", key)
+	}
+	if !problems.Fatal() {
+		t.Error("invalid config not fatal")
+	}
+}
+
+func TestActivePoolByPriority(t *testing.T) {
+	cfg, problems := load(t, []byte(`{"pools": [
+		{"url": "a:1", "algo": "ckb", "user": "u", "priority": 3},
+		{"url": "b:1", "algo": "ckb", "user": "u", "priority": "1"},
+		{"url": "c:1", "algo": "ckb", "user": "u", "priority": 2}
+	]}`))
+	if len(problems) != 0 {
+		t.Fatal(problems)
+	}
+	for i, pool := range cfg.Pools {
+		if pool.Active != (i == 1) {
+			t.Error("pool", i, "active:", pool.Active)
+		}
+	}
+}
+
+func TestSoloPool(t *testing.T) {
+	cfg, problems := load(t, []byte(`{"pools": [
+		{"url": "http://127.0.0.1:14022", "algo": "odocrypt", "user": "rpc", "payout": "dgb1qexample"},
+		{"url": "http://127.0.0.1:14022", "algo": "skunk", "user": "rpc"},
+		{"url": "http://127.0.0.1:8114", "algo": "ckb", "user": "rpc"},
+		{"url": "http://127.0.0.1:8114", "algo": "ckb", "user": "rpc", "payout": "ckb1qexample"},
+		{"url": "http://127.0.0.1:8080", "algo": "veo", "user": "rpc"}
+	]}`))
+	if cfg.Pools[0].Payout != "dgb1qexample" {
+		t.Error("payout not decoded", cfg.Pools[0])
+	}
+	//the value tells whether the problem is a warning
+	expected := map[string]bool{"pools[1].payout": false, "pools[3].payout": true, "pools[4].url": false}
+	for _, p := range problems {
+		if warning, ok := expected[p.Key]; !ok || p.Warning != warning {
+			t.Error("unexpected problem", p)
+		}
+		delete(expected, p.Key)
+	}
+	for key := range expected {
+		t.Error("no problem reported for", key)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	cfg, problems := load(t, []byte(`{
+		"proxy": {"enable": "true", "listen": ":3333", "difficulty": "0.5", "sharetime": 15},
+		"pools": [{"url": "stratum+tcp://pool:3333", "algo": "skunk", "user": "u"}]
+	}`))
+	if len(problems) != 0 {
+		t.Error("unexpected problems", problems)
+	}
+	if !cfg.Proxy.Enable || cfg.Proxy.Difficulty != 0.5 || cfg.Proxy.ShareTime != 15 {
+		t.Error("proxy not decoded", cfg.Proxy)
+	}
+
+	_, problems = load(t, []byte(`{
+		"proxy": {"enable": true, "listen": "3333", "difficulty": -1},
+		"pools": [{"url": "stratum+tcp://pool:8888", "algo": "ckb", "user": "u"}]
+	}`))
+	expected := map[string]bool{"proxy.listen": false, "proxy.difficulty": false, "proxy.enable": true}
+	for _, p := range problems {
+		if warning, ok := expected[p.Key]; !ok || p.Warning != warning {
+			t.Error("unexpected problem", p)
+		}
+		delete(expected, p.Key)
+	}
+	for key := range expected {
+		t.Error("no problem reported for", key)
+	}
+}
+
+func TestSchemaCoversConfig(t *testing.T) {
+	var schema struct {
+		Properties map[string]j.RawMessage `json:"properties"`
+	}
+	if err := j.Unmarshal([]byte(Schema), &schema); err != nil {
+		t.Fatal("schema is not valid JSON:", err)
+	}
+	ct := reflect.TypeOf(Config{})
+	for i := 0; i < ct.NumField(); i++ {
+		key := strings.Split(ct.Field(i).Tag.Get("mapstructure"), ",")[0]
+		if _, ok := schema.Properties[key]; !ok {
+			t.Error("schema lacks", key)
+		}
+	}
+	if len(schema.Properties) != ct.NumField() {
+		t.Error("schema has", len(schema.Properties), "properties, config has", ct.NumField())
+	}
+}
+
+func TestDevices(t *testing.T) {
+	cfg, problems := load(t, []byte(`{
+		"baudrate": 2000000, "polldelay": 30,
+		"pools": [{"url": "a:1", "algo": "ckb", "user": "u"}, {"url": "b:1", "algo": "skunk", "user": "u"}],
+		"devices": [
+			{"device": "/dev/ttyAMA0", "muxnum": 12, "skipslots": [3]},
+			{"name": "usb", "device": "/dev/ttyUSB0", "muxnum": 1, "baudrate": "115200", "pool": "1"}
+		]
+	}`))
+	if len(problems) != 0 {
+		t.Fatal(problems)
+	}
+	m := &miner.Miner{}
+	cfg.Apply(m)
+	if len(m.Chains) != 2 {
+		t.Fatal("chains not applied", m.Chains)
+	}
+	first, second := m.Chains[0], m.Chains[1]
+	if first.Name != "/dev/ttyAMA0" || first.Driver != "thyroid" || first.BaudRate != 2000000 || first.PollDelay != 30 ||
+		first.Pool != -1 || !reflect.DeepEqual(first.SkipSlots, []int{3}) {
+		t.Error("first chain did not inherit the top level settings", first)
+	}
+	if second.Name != "usb" || second.BaudRate != 115200 || second.Pool != 1 || second.SkipSlots == nil {
+		t.Error("second chain settings not decoded", second)
+	}
+
+	_, problems = load(t, []byte(`{
+		"pools": [{"url": "a:1", "algo": "ckb", "user": "u"}],
+		"devices": [
+			{"device": "/dev/ttyUSB0", "muxnum": 4},
+			{"device": "/dev/ttyUSB0", "muxnum": 2, "pool": 1},
+			{"muxnum": 1}
+		]
+	}`))
+	expected := map[string]bool{
+		"devices[1].device": true,
+		"devices[1].muxnum": true,
+		"devices[1].pool":   true,
+		"devices[2].device": true,
+	}
+	for _, p := range problems {
+		if !expected[p.Key] || p.Warning {
+			t.Error("unexpected problem", p)
+		}
+		delete(expected, p.Key)
+	}
+	for key := range expected {
+		t.Error("no problem reported for", key)
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+//Schema is the JSON schema of gominer.json.
+// Numbers and booleans may also be written as strings, as the sample configuration does.
+const Schema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/AGPFMiner/gominer/gominer.schema.json",
+  "title": "gominer configuration",
+  "type": "object",
+  "definitions": {
+    "integer": {"anyOf": [{"type": "integer"}, {"type": "string", "pattern": "^-?[0-9]+$"}]},
+    "number": {"anyOf": [{"type": "number"}, {"type": "string", "pattern": "^-?[0-9]+(\\.[0-9]+)?$"}]},
+    "boolean": {"anyOf": [{"type": "boolean"}, {"type": "string", "enum": ["true", "false", "1", "0"]}]},
+    "listen": {"type": "string", "pattern": "^[^:]*:[0-9]+$"},
+    "role": {"type": "string", "enum": ["admin", "readonly", ""]}
+  },
+  "properties": {
+    "driver": {"type": "string", "enum": ["thyroid"], "default": "thyroid"},
+    "device": {"type": "string", "description": "serial port, @host:port for a TCP bridge, or usb[:pattern] for the first USB serial adapter", "default": "/dev/ttyAMA0"},
+    "baudrate": {"$ref": "#/definitions/integer", "default": 115200},
+    "muxnum": {"$ref": "#/definitions/integer", "description": "number of board slots, 1-12", "default": 1},
+    "skipslots": {"type": "array", "items": {"$ref": "#/definitions/integer"}, "description": "slots to leave idle, numbered from 1"},
+    "polldelay": {"$ref": "#/definitions/integer", "description": "milliseconds between board polls", "default": 60},
+    "noncetimeout": {"$ref": "#/definitions/integer", "description": "milliseconds before a board gets new work", "default": 1000},
+    "debug": {"type": "string", "enum": ["debug", "info", "error"], "default": "error"},
+    "capture": {"type": "string", "description": "file the traffic to the boards is recorded in for gominer replay, empty disables"},
+    "api-service": {"$ref": "#/definitions/boolean", "default": true},
+    "api-listen": {"$ref": "#/definitions/listen", "default": ":1234"},
+    "temp-alarm": {"$ref": "#/definitions/number", "description": "board temperature in degrees Celsius that raises an alarm, 0 disables", "default": 85},
+    "api-auth": {
+      "type": "object",
+      "properties": {
+        "keys": {"type": "array", "items": {"type": "object", "required": ["key"], "properties": {
+          "key": {"type": "string", "minLength": 1},
+          "role": {"$ref": "#/definitions/role"}
+        }}},
+        "users": {"type": "array", "items": {"type": "object", "required": ["user", "pass"], "properties": {
+          "user": {"type": "string", "minLength": 1},
+          "pass": {"type": "string", "minLength": 1},
+          "role": {"$ref": "#/definitions/role"}
+        }}},
+        "tlscert": {"type": "string"},
+        "tlskey": {"type": "string"},
+        "clientca": {"type": "string"},
+        "requireclientcert": {"$ref": "#/definitions/boolean"},
+        "certroles": {"type": "object", "additionalProperties": {"$ref": "#/definitions/role"}},
+        "maxfailures": {"$ref": "#/definitions/integer"},
+        "failurewindow": {"type": "string", "description": "Go duration, e.g. 1m"},
+        "lockout": {"type": "string", "description": "Go duration, e.g. 5m"},
+        "auditlog": {"type": "string"}
+      }
+    },
+    "cgminer-api": {
+      "type": "object",
+      "properties": {
+        "enable": {"$ref": "#/definitions/boolean"},
+        "listen": {"$ref": "#/definitions/listen", "default": ":4028"},
+        "allow": {"type": "array", "items": {"type": "string"}},
+        "writeallow": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "proxy": {
+      "type": "object",
+      "description": "stratum server handing the jobs of the active pool to downstream miners, skunk pools only",
+      "properties": {
+        "enable": {"$ref": "#/definitions/boolean"},
+        "listen": {"$ref": "#/definitions/listen", "default": ":3333"},
+        "difficulty": {"$ref": "#/definitions/number", "description": "difficulty the miners start at, 0 for the pool difficulty"},
+        "sharetime": {"$ref": "#/definitions/number", "description": "seconds between the shares of a miner its difficulty is adjusted to", "default": 10}
+      }
+    },
+    "pools": {
+      "type": "array",
+      "minItems": 1,
+      "items": {
+        "type": "object",
+        "required": ["url", "algo", "user"],
+        "properties": {
+          "url": {"type": "string", "pattern": "^(stratum\\+tcp://|https?://)?[^:/]+:[0-9]+/?$", "description": "stratum pool, or the RPC address of a node to mine solo on for ckb, odocrypt and skunk"},
+          "algo": {"type": "string", "enum": ["ckb", "odocrypt", "veo", "skunk", "xdag", "verus"]},
+          "user": {"type": "string", "minLength": 1},
+          "pass": {"type": "string"},
+          "active": {"$ref": "#/definitions/boolean"},
+          "priority": {"$ref": "#/definitions/integer", "description": "lower values are preferred when no pool is active"},
+          "record": {"type": "string", "description": "file the stratum session is appended to, see pools replay"},
+          "payout": {"type": "string", "description": "address the block reward is paid to when mining solo on a node, odocrypt and skunk only"}
+        }
+      }
+    },
+    "devices": {
+      "type": "array",
+      "description": "chains of boards, each with its own driver, replacing the top level device settings; only one chain may use the mux",
+      "items": {
+        "type": "object",
+        "required": ["device", "muxnum"],
+        "properties": {
+          "name": {"type": "string", "description": "label in the API, the device by default"},
+          "driver": {"type": "string", "enum": ["thyroid"], "description": "the top level driver by default"},
+          "device": {"type": "string", "description": "serial port, @host:port for a TCP bridge, or usb[:pattern]"},
+          "baudrate": {"$ref": "#/definitions/integer", "description": "the top level baudrate by default"},
+          "muxnum": {"$ref": "#/definitions/integer", "description": "number of board slots, 1-12"},
+          "skipslots": {"type": "array", "items": {"$ref": "#/definitions/integer"}, "description": "slots to leave idle, numbered from 1"},
+          "polldelay": {"$ref": "#/definitions/integer", "description": "the top level polldelay by default"},
+          "noncetimeout": {"$ref": "#/definitions/integer", "description": "the top level noncetimeout by default"},
+          "pool": {"$ref": "#/definitions/integer", "description": "index in pools the chain mines for, it follows the active pool if unset"}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,211 @@
+//Package difficulty converts between pool difficulties, compact nBits and 256 bit targets
+// and tells how many hashes a nonce returned by the boards stands for.
+// Targets are 32 byte big endian slices, as the pool clients hand them to the driver.
+package difficulty
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+//TargetSize is the size of a target in bytes
+const TargetSize = 32
+
+var (
+	//Diff1 is the target of difficulty 1 of bitcoin, the pools of skunk, odocrypt and verus use it too
+	Diff1 = CompactToTarget(0x1d00ffff)
+	//MaxTarget is the largest target, difficulty 1 of ckb
+	MaxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 8*TargetSize), big.NewInt(1))
+
+	hashSpace = new(big.Float).SetInt(new(big.Int).Lsh(big.NewInt(1), 8*TargetSize))
+)
+
+var (
+	//ErrNegativeTarget is returned for targets below 0
+	ErrNegativeTarget = errors.New("Negative target")
+	//ErrTargetTooHigh is returned for targets that do not fit in 256 bits
+	ErrTargetTooHigh = errors.New("Target is too high")
+	//ErrInvalidDifficulty is returned for difficulties that are not positive
+	ErrInvalidDifficulty = errors.New("Difficulty must be positive")
+	//ErrNoTarget is returned for algorithms whose pools send no targets
+	ErrNoTarget = errors.New("The difficulty of the algorithm is no target")
+)
+
+//TargetBytes serializes t into a 32 byte target
+func TargetBytes(t *big.Int) (target []byte, err error) {
+	if t.Sign() < 0 {
+		return nil, ErrNegativeTarget
+	}
+	if t.BitLen() > 8*TargetSize {
+		return nil, ErrTargetTooHigh
+	}
+	target = make([]byte, TargetSize)
+	b := t.Bytes()
+	copy(target[TargetSize-len(b):], b)
+	return
+}
+
+//ToTarget returns the target of difficulty for the difficulty 1 target diff1
+func ToTarget(diff1 *big.Int, difficulty float64) ([]byte, error) {
+	if !(difficulty > 0) || math.IsInf(difficulty, 1) {
+		return nil, ErrInvalidDifficulty
+	}
+	quotient := new(big.Float).Quo(new(big.Float).SetInt(diff1), big.NewFloat(difficulty))
+	t, _ := quotient.Int(nil)
+	return TargetBytes(t)
+}
+
+//FromTarget returns the difficulty of target for the difficulty 1 target diff1, 0 for a zero target
+func FromTarget(diff1 *big.Int, target []byte) float64 {
+	t := new(big.Int).SetBytes(target)
+	if t.Sign() == 0 {
+		return 0
+	}
+	d, _ := new(big.Float).Quo(new(big.Float).SetInt(diff1), new(big.Float).SetInt(t)).Float64()
+	return d
+}
+
+//CompactToTarget expands the compact nBits representation of a target.
+// A set sign bit gives a negative target, as in bitcoin.
+func CompactToTarget(nbits uint32) *big.Int {
+	mantissa := int64(nbits & 0x007fffff)
+	exponent := uint(nbits >> 24)
+	var t *big.Int
+	if exponent <= 3 {
+		t = big.NewInt(mantissa >> (8 * (3 - exponent)))
+	} else {
+		t = new(big.Int).Lsh(big.NewInt(mantissa), 8*(exponent-3))
+	}
+	if nbits&0x00800000 != 0 {
+		t.Neg(t)
+	}
+	return t
+}
+
+//TargetToCompact returns the compact nBits representation of a non-negative target, rounded down
+func TargetToCompact(t *big.Int) uint32 {
+	exponent := uint((t.BitLen() + 7) / 8)
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(t.Uint64() << (8 * (3 - exponent)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(t, 8*(exponent-3)).Uint64())
+	}
+	//the mantissa has a sign bit, move it out of the way
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent)<<24 | mantissa
+}
+
+//MeetsTarget tells whether a big endian hash is at most target, there are no shares without a target
+func MeetsTarget(hash, target []byte) bool {
+	if len(target) == 0 {
+		return false
+	}
+	return new(big.Int).SetBytes(hash).Cmp(new(big.Int).SetBytes(target)) <= 0
+}
+
+//Hashes is the expected number of hashes to find one that meets target
+func Hashes(target []byte) float64 {
+	t := new(big.Float).SetInt(new(big.Int).SetBytes(target))
+	h, _ := new(big.Float).Quo(hashSpace, t.Add(t, big.NewFloat(1))).Float64()
+	return h
+}
+
+//LeadingZeroBits counts the zero bits at the start of a big endian hash or target
+func LeadingZeroBits(hash []byte) (n uint) {
+	for _, b := range hash {
+		if b != 0 {
+			return n + uint(bits.LeadingZeros8(b))
+		}
+		n += 8
+	}
+	return
+}
+
+//Algo holds the difficulty conventions of an algorithm
+type Algo struct {
+	Name string
+	//Diff1 is the target of difficulty 1, nil if the pools of the algorithm send no targets
+	Diff1 *big.Int
+	//BoardBits is the number of leading zero bits of the hashes of the nonces the boards return,
+	// 0 if the boards return nonces at the pool target
+	BoardBits uint
+	//BoardChecked is set for the algorithms the host has no hash for yet. Their bitstreams compare the
+	// hashes with the target themselves, the golden nonces they return are submitted as reported.
+	BoardChecked bool
+}
+
+//algos are the conventions of every algorithm. The boards return verus nonces at the pool target.
+var algos = map[string]Algo{
+	"odocrypt": {Name: "odocrypt", Diff1: Diff1, BoardChecked: true},
+	"skunk":    {Name: "skunk", Diff1: Diff1, BoardBits: 24},
+	"verus":    {Name: "verus", Diff1: Diff1, BoardChecked: true},
+	"ckb":      {Name: "ckb", Diff1: MaxTarget, BoardBits: 32},
+	"veo":      {Name: "veo", BoardBits: 32},
+	"xdag":     {Name: "xdag", Diff1: Diff1, BoardBits: 32, BoardChecked: true},
+}
+
+//Lookup returns the conventions of algo
+func Lookup(algo string) (a Algo, ok bool) {
+	a, ok = algos[algo]
+	return
+}
+
+//Target returns the target of a pool difficulty
+func (a Algo) Target(difficulty float64) ([]byte, error) {
+	if a.Diff1 == nil {
+		return nil, ErrNoTarget
+	}
+	return ToTarget(a.Diff1, difficulty)
+}
+
+//Difficulty returns the pool difficulty of target
+func (a Algo) Difficulty(target []byte) float64 {
+	if a.Diff1 == nil {
+		return 0
+	}
+	return FromTarget(a.Diff1, target)
+}
+
+//ShareBits is the number of leading zero bits every share of a job has, the leading zero bits of its
+// target. Pools of algorithms without targets send the veo work of a hash, 256 per leading zero bit.
+func (a Algo) ShareBits(target []byte, poolDifficulty float64) uint {
+	if a.Diff1 == nil {
+		if poolDifficulty < 256 {
+			return 0
+		}
+		return uint(poolDifficulty) / 256
+	}
+	if len(target) == 0 {
+		return 0
+	}
+	return LeadingZeroBits(target)
+}
+
+//HashesPerNonce is the expected number of hashes behind every nonce the boards return.
+// poolDifficulty only matters for boards returning nonces at the pool target, 0 is returned
+// if it is not known yet.
+func (a Algo) HashesPerNonce(poolDifficulty float64) float64 {
+	if a.BoardBits > 0 {
+		return math.Ldexp(1, int(a.BoardBits))
+	}
+	target, err := a.Target(poolDifficulty)
+	if err != nil {
+		return 0
+	}
+	return Hashes(target)
+}
+
+//HashesPerNonce is the expected number of hashes behind every nonce the boards of algo return, 0 for unknown algorithms
+func HashesPerNonce(algo string, poolDifficulty float64) float64 {
+	a, ok := Lookup(algo)
+	if !ok {
+		return 0
+	}
+	return a.HashesPerNonce(poolDifficulty)
+}
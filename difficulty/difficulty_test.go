@@ -0,0 +1,175 @@
+package difficulty
+
+import (
+	"encoding/hex"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestToTarget(t *testing.T) {
+	target, err := ToTarget(Diff1, 65.32477875)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "0000000003eb37d4fad091843301f5878dfa775ce91f986fef9ea627d7da3ec9"; hex.EncodeToString(target) != expected {
+		t.Error(hex.EncodeToString(target), "returned instead of", expected)
+	}
+	if d := FromTarget(Diff1, target); math.Abs(d-65.32477875) > 1e-6 {
+		t.Error("difficulty of the target", d)
+	}
+
+	//the example of the bitcoin wiki
+	target, _ = TargetBytes(CompactToTarget(0x1b0404cb))
+	if d := FromTarget(Diff1, target); d != 16307.420938523983 {
+		t.Error("difficulty of 1b0404cb", d)
+	}
+
+	target, _ = ToTarget(Diff1, 1)
+	if hex.EncodeToString(target) != "00000000ffff0000000000000000000000000000000000000000000000000000" {
+		t.Errorf("difficulty 1 target %x", target)
+	}
+	if _, err := ToTarget(Diff1, 0); err != ErrInvalidDifficulty {
+		t.Error("difficulty 0 accepted", err)
+	}
+	if _, err := ToTarget(Diff1, 0.5); err != nil {
+		t.Error("difficulty below 1", err)
+	}
+	if _, err := ToTarget(MaxTarget, 0.5); err != ErrTargetTooHigh {
+		t.Error("target above 256 bits", err)
+	}
+	if FromTarget(Diff1, make([]byte, TargetSize)) != 0 {
+		t.Error("zero target has a difficulty")
+	}
+}
+
+func TestMeetsTarget(t *testing.T) {
+	target, _ := ToTarget(Diff1, 1)
+	hash := append([]byte{}, target...)
+	if !MeetsTarget(hash, target) {
+		t.Error("hash at the target rejected")
+	}
+	hash[6]++
+	if MeetsTarget(hash, target) {
+		t.Error("hash above the target accepted")
+	}
+	if !MeetsTarget([]byte{0, 0, 0, 0}, target) {
+		t.Error("short hash rejected")
+	}
+	if MeetsTarget(make([]byte, TargetSize), nil) {
+		t.Error("hash accepted without a target")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	for nbits, expected := range map[uint32]string{
+		0x1d00ffff: "ffff0000000000000000000000000000000000000000000000000000",
+		0x1b0404cb: "0404cb000000000000000000000000000000000000000000000000",
+		0x01120000: "12",
+		0x02123400: "1234",
+		0x05009234: "92340000",
+	} {
+		target := CompactToTarget(nbits)
+		if target.Text(16) != trimZeros(expected) {
+			t.Errorf("%08x expanded to %s", nbits, target.Text(16))
+		}
+		if back := TargetToCompact(target); back != nbits {
+			t.Errorf("%08x compacted to %08x", nbits, back)
+		}
+	}
+	if CompactToTarget(0x04923456).Cmp(big.NewInt(-0x12345600)) != 0 {
+		t.Error("sign bit ignored")
+	}
+	if TargetToCompact(big.NewInt(0x80)) != 0x02008000 {
+		t.Error("mantissa with the sign bit set")
+	}
+}
+
+func trimZeros(s string) string {
+	for len(s) > 1 && s[0] == '0' {
+		s = s[1:]
+	}
+	return s
+}
+
+func TestHashesPerNonce(t *testing.T) {
+	fourGiga := math.Ldexp(1, 32)
+	cases := []struct {
+		algo     string
+		poolDiff float64
+		expected float64
+	}{
+		{"skunk", 0, fourGiga / 256},
+		{"ckb", 0, fourGiga},
+		{"veo", 1000, fourGiga},
+		{"odocrypt", 2, 2 * fourGiga * 65536 / 65535},
+		{"verus", 0.5, 0.5 * fourGiga * 65536 / 65535},
+		{"odocrypt", 0, 0},
+		{"groestl", 1, 0},
+	}
+	for _, c := range cases {
+		if h := HashesPerNonce(c.algo, c.poolDiff); math.Abs(h-c.expected) > c.expected*1e-9 {
+			t.Error(c.algo, c.poolDiff, "gave", h, "expected", c.expected)
+		}
+	}
+}
+
+func TestCKBTarget(t *testing.T) {
+	ckb, _ := Lookup("ckb")
+	target, err := ckb.Target(1)
+	if err != nil || new(big.Int).SetBytes(target).Cmp(MaxTarget) != 0 {
+		t.Errorf("difficulty 1 target %x %v", target, err)
+	}
+	//ckb pools announce targets, difficulty 1e8 of a ckb pool
+	target, _ = hex.DecodeString("0000002af31dc4611873bf3f70834acdae9f0f4f534f5d60585a5f1c1a3ced1b")
+	if d := ckb.Difficulty(target); math.Abs(d-1e8)/1e8 > 1e-9 {
+		t.Error("pool difficulty", d)
+	}
+	veo, _ := Lookup("veo")
+	if _, err := veo.Target(1); err != ErrNoTarget {
+		t.Error("veo difficulty turned into a target", err)
+	}
+}
+
+func TestVeo(t *testing.T) {
+	hash := make([]byte, 32)
+	hash[2], hash[3] = 0x01, 0x80
+	if d := VeoDifficulty(hash); d != 23*256+0x80 {
+		t.Error("difficulty", d)
+	}
+	hash[2], hash[3] = 0xc0, 0x80
+	if d := VeoDifficulty(hash); d != 16*256+0x81 {
+		t.Error("difficulty", d)
+	}
+	for x := 0; x < 40; x++ {
+		if h := VeoHashes(256 * x); h != math.Ldexp(1, x) {
+			t.Error(x, "leading zero bits take", h, "hashes")
+		}
+	}
+	if VeoHashes(256*10+128) != 2048/1.5 {
+		t.Error("hashes of half a bit", VeoHashes(256*10+128))
+	}
+}
+
+func TestShareBits(t *testing.T) {
+	if n := LeadingZeroBits([]byte{0, 0, 0x01, 0xff}); n != 23 {
+		t.Error("leading zero bits", n)
+	}
+	if n := LeadingZeroBits(make([]byte, 4)); n != 32 {
+		t.Error("leading zero bits of zeros", n)
+	}
+	skunk, _ := Lookup("skunk")
+	for d, expected := range map[float64]uint{1: 32, 0.01: 25, 65536: 48} {
+		target, _ := skunk.Target(d)
+		if n := skunk.ShareBits(target, d); n != expected {
+			t.Error("difficulty", d, "shares have", n, "leading zero bits, expected", expected)
+		}
+	}
+	veo, _ := Lookup("veo")
+	if n := veo.ShareBits(nil, 36*256+0xff); n != 36 {
+		t.Error("veo shares have", n, "leading zero bits")
+	}
+	if n := skunk.ShareBits(nil, 1); n != 0 {
+		t.Error("job without target", n)
+	}
+}
@@ -0,0 +1,55 @@
+package difficulty
+
+import "math"
+
+//VeoDifficulty is the work of a veo hash: 256 times its leading zero bits plus the 8 bits after the first one bit.
+// Veo pools accept hashes whose work reaches the pool difficulty.
+func VeoDifficulty(hash []byte) int {
+	var h []uint32
+	for b := range hash {
+		h = append(h, uint32(hash[b]))
+	}
+	var x uint32
+	var z uint32
+	for i := 0; i < 31; i++ {
+		if h[i] == 0 {
+			x += 8
+			continue
+		} else if h[i] < 2 {
+			x += 7
+			z = h[i+1]
+		} else if h[i] < 4 {
+			x += 6
+			z = (h[i+1] / 2) + ((h[i] % 2) * 128)
+		} else if h[i] < 8 {
+			x += 5
+			z = (h[i+1] / 4) + ((h[i] % 4) * 64)
+		} else if h[i] < 16 {
+			x += 4
+			z = (h[i+1] / 8) + ((h[i] % 8) * 32)
+		} else if h[i] < 32 {
+			x += 3
+			z = (h[i+1] / 16) + ((h[i] % 16) * 16)
+		} else if h[i] < 64 {
+			x += 2
+			z = (h[i+1] / 32) + ((h[i] % 32) * 8)
+		} else if h[i] < 128 {
+			x++
+			z = (h[i+1] / 64) + ((h[i] % 64) * 4)
+		} else {
+			z = (h[i+1] / 128) + ((h[i] % 128) * 2)
+		}
+		break
+	}
+	return int((256 * x) + z)
+}
+
+//VeoHashes is the expected number of hashes to find one whose work reaches difficulty.
+// A hash reaches 256*x+z with more than x leading zero bits, or with x of them and at least z in the next 8 bits.
+func VeoHashes(difficulty int) float64 {
+	if difficulty <= 0 {
+		return 1
+	}
+	x, z := difficulty/256, difficulty%256
+	return math.Ldexp(256/float64(512-z), x+1)
+}
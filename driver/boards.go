@@ -0,0 +1,73 @@
+package driver
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/AGPFMiner/gominer/boardman"
+
+	"github.com/stianeikeland/go-rpio"
+)
+
+//The functions below give the command line tools access to the boards without a running driver.
+// Boards are numbered from 0, muxNums is the number of slots behind the mux.
+
+//ErrOpenocdBusy is returned when another openocd, usually the one of a running miner, holds the JTAG chain
+var ErrOpenocdBusy = errors.New("openocd is already running")
+
+//OpenBoards opens the GPIO pins driving the mux, it is not needed for a single board
+func OpenBoards(muxNums int) error {
+	if muxNums > 1 {
+		return rpio.Open()
+	}
+	return nil
+}
+
+//BitstreamPath resolves a bitstream name relative to BitStreamDir, absolute paths are kept
+func BitstreamPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(BitStreamDir, name)
+}
+
+//ProgramBoard loads bitstream onto a single board
+func ProgramBoard(board, muxNums int, bitstream string) error {
+	if isOpenocdRunning() {
+		return ErrOpenocdBusy
+	}
+	if muxNums > 1 {
+		boardman.SelectJTAG(uint8(board + 1))
+		time.Sleep(time.Millisecond * 10)
+	}
+	return programBit(BitstreamPath(bitstream))
+}
+
+//BoardHealth reads the temperature and core voltage of a single board
+func BoardHealth(board, muxNums int) (temp, voltage string, err error) {
+	if isOpenocdRunning() {
+		return "", "", ErrOpenocdBusy
+	}
+	if muxNums > 1 {
+		boardman.SelectJTAG(uint8(board + 1))
+		time.Sleep(time.Millisecond * 1)
+	}
+	temp, voltage, err = getTempeVolt()
+	if err == nil && temp == "" {
+		err = errors.New("No XADC report, is the board programmed?")
+	}
+	return
+}
+
+//ErrNoMux is returned for board resets on a single board setup, the reset lines are driven by the mux
+var ErrNoMux = errors.New("Board reset needs the mux, muxnum must be above 1")
+
+//ResetBoard pulses the reset line of a single board
+func ResetBoard(board, muxNums int) error {
+	if muxNums <= 1 {
+		return ErrNoMux
+	}
+	boardman.SelectReset(uint8(board + 1))
+	return nil
+}
@@ -0,0 +1,261 @@
+//Package capture records the traffic on the link to the boards so field issues can be replayed offline.
+// A capture file starts with Magic and holds one record per read, write or driver note. Every record
+// carries the time it was taken and the board the mux had selected at that moment.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AGPFMiner/gominer/driver/transport"
+)
+
+//Magic starts every capture file, the last byte is the version of the format
+const Magic = "GMCAP\x00\x00\x01"
+
+//Kind tells what a record holds
+type Kind uint8
+
+const (
+	//KindOut records bytes written to the boards
+	KindOut Kind = iota + 1
+	//KindIn records bytes read from the boards
+	KindIn
+	//KindMeta records a Meta as JSON, written whenever the driver starts
+	KindMeta
+	//KindWork records a Work as JSON, written for every header dispatched
+	KindWork
+	//KindClean records that a clean job made the dispatched work stale, it has no payload
+	KindClean
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindOut:
+		return "out"
+	case KindIn:
+		return "in"
+	case KindMeta:
+		return "meta"
+	case KindWork:
+		return "work"
+	case KindClean:
+		return "clean"
+	}
+	return "unknown"
+}
+
+//headerLen is the length of a record header: time in ns, kind, board, payload length
+const headerLen = 8 + 1 + 2 + 4
+
+//MaxPayload bounds the payload of a record, longer ones are taken for a corrupt file
+const MaxPayload = 1 << 20
+
+//ErrCorrupt is returned for a file that is not a capture or a record that cannot be read
+var ErrCorrupt = errors.New("Corrupt capture file")
+
+//Meta describes the driver that wrote the following records
+type Meta struct {
+	Algo   string `json:"algo"`
+	Device string `json:"device"`
+	//Format is the nonce frame format after the handshake, Version the bitstream version if it answered
+	Format  string `json:"format"`
+	Version string `json:"version,omitempty"`
+	//BoardOffset is added to the board of the records to number them across chains
+	BoardOffset int `json:"boardoffset"`
+}
+
+//Work is a header dispatched to the selected board under JobID
+type Work struct {
+	JobID      uint8   `json:"jobid"`
+	Header     []byte  `json:"header"`
+	Target     []byte  `json:"target"`
+	Difficulty float64 `json:"difficulty"`
+	//HardwareBits is the target the board was programmed to, 0 for bitstreams with a fixed target
+	HardwareBits uint `json:"hardwarebits,omitempty"`
+}
+
+//Record is an entry of a capture
+type Record struct {
+	Time  time.Time
+	Kind  Kind
+	Board int
+	Data  []byte
+}
+
+//Meta decodes the payload of a KindMeta record
+func (r Record) Meta() (m Meta, err error) {
+	err = json.Unmarshal(r.Data, &m)
+	return
+}
+
+//Work decodes the payload of a KindWork record
+func (r Record) Work() (w Work, err error) {
+	err = json.Unmarshal(r.Data, &w)
+	return
+}
+
+//Writer appends records to a capture, it is safe for concurrent use
+type Writer struct {
+	mutex sync.Mutex
+	w     io.Writer
+	board int
+	err   error
+}
+
+//NewWriter starts a capture on w
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := io.WriteString(w, Magic); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+//File is a capture written to a file
+type File struct {
+	*Writer
+	file *os.File
+}
+
+//Create opens the capture file at path. An existing capture is appended to, so restarts
+// of the driver end up in the same file.
+func Create(path string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() > 0 {
+		magic := make([]byte, len(Magic))
+		if _, err := file.ReadAt(magic, 0); err != nil || string(magic) != Magic {
+			file.Close()
+			return nil, ErrCorrupt
+		}
+		return &File{Writer: &Writer{w: file}, file: file}, nil
+	}
+	w, err := NewWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &File{Writer: w, file: file}, nil
+}
+
+//Close closes the file
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+//SetBoard sets the board the following records are tagged with
+func (w *Writer) SetBoard(board int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.board = board
+}
+
+//Record appends a record of kind with data, once writing failed every later record is dropped
+func (w *Writer) Record(kind Kind, data []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	//one write per record, a crash leaves at most the last one incomplete
+	b := make([]byte, headerLen, headerLen+len(data))
+	binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	b[8] = byte(kind)
+	binary.BigEndian.PutUint16(b[9:], uint16(int16(w.board)))
+	binary.BigEndian.PutUint32(b[11:], uint32(len(data)))
+	_, w.err = w.w.Write(append(b, data...))
+	return w.err
+}
+
+//Meta records the driver settings
+func (w *Writer) Meta(m Meta) error {
+	data, _ := json.Marshal(m)
+	return w.Record(KindMeta, data)
+}
+
+//Work records a header dispatched to the selected board
+func (w *Writer) Work(work Work) error {
+	data, _ := json.Marshal(work)
+	return w.Record(KindWork, data)
+}
+
+//Clean records a clean job
+func (w *Writer) Clean() error {
+	return w.Record(KindClean, nil)
+}
+
+//Reader reads the records of a capture
+type Reader struct {
+	r *bufio.Reader
+}
+
+//NewReader checks the magic at the start of r
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(reader.r, magic); err != nil || string(magic) != Magic {
+		return nil, ErrCorrupt
+	}
+	return reader, nil
+}
+
+//Next returns the next record. It returns io.EOF at the end of the capture and
+// io.ErrUnexpectedEOF if the last record was cut short, e.g. by a power loss.
+func (r *Reader) Next() (record Record, err error) {
+	header := make([]byte, headerLen)
+	if _, err = io.ReadFull(r.r, header); err != nil {
+		return
+	}
+	length := binary.BigEndian.Uint32(header[11:])
+	if length > MaxPayload {
+		return record, ErrCorrupt
+	}
+	record.Time = time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+	record.Kind = Kind(header[8])
+	record.Board = int(int16(binary.BigEndian.Uint16(header[9:])))
+	record.Data = make([]byte, length)
+	if _, err = io.ReadFull(r.r, record.Data); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+//Port records the traffic of a transport
+type Port struct {
+	transport.Transport
+	w *Writer
+}
+
+//NewPort records every byte read from and written to t on w
+func NewPort(t transport.Transport, w *Writer) *Port {
+	return &Port{Transport: t, w: w}
+}
+
+func (p *Port) Read(b []byte) (int, error) {
+	n, err := p.Transport.Read(b)
+	if n > 0 {
+		p.w.Record(KindIn, b[:n])
+	}
+	return n, err
+}
+
+func (p *Port) Write(b []byte) (int, error) {
+	n, err := p.Transport.Write(b)
+	if n > 0 {
+		p.w.Record(KindOut, b[:n])
+	}
+	return n, err
+}
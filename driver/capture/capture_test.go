@@ -0,0 +1,116 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/AGPFMiner/gominer/driver/transport"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Meta(Meta{Algo: "ckb", Format: "magic", BoardOffset: 4})
+	w.SetBoard(3)
+	w.Work(Work{JobID: 7, Header: []byte{1, 2, 3}, Difficulty: 2})
+	w.Record(KindIn, []byte{0x89, 0xab, 0xcd})
+	w.Clean()
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 4 {
+		t.Fatal("records lost", records)
+	}
+	if meta, err := records[0].Meta(); err != nil || meta.Algo != "ckb" || meta.BoardOffset != 4 || records[0].Board != 0 {
+		t.Error("meta does not round trip", meta, err)
+	}
+	if work, err := records[1].Work(); err != nil || work.JobID != 7 || !bytes.Equal(work.Header, []byte{1, 2, 3}) || records[1].Board != 3 {
+		t.Error("work does not round trip", work, err)
+	}
+	if records[2].Kind != KindIn || !bytes.Equal(records[2].Data, []byte{0x89, 0xab, 0xcd}) || records[3].Kind != KindClean {
+		t.Error("traffic does not round trip", records[2:])
+	}
+	if records[3].Time.Before(records[0].Time) {
+		t.Error("records not in time order", records[0].Time, records[3].Time)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf)
+	w.Record(KindOut, []byte{1, 2, 3, 4})
+	r, _ := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Error("cut record not reported", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("not a capture"))); err != ErrCorrupt {
+		t.Error("file without magic accepted", err)
+	}
+}
+
+func TestFileAppends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/rig.cap"
+
+	//the port records both directions, a second run appends to the same file
+	for run := 0; run < 2; run++ {
+		f, err := Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pipe := transport.NewPipe()
+		port := NewPort(pipe, f.Writer)
+		port.Open()
+		board := <-pipe.Boards
+		go func() {
+			b := make([]byte, 6)
+			io.ReadFull(board, b)
+			board.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0})
+		}()
+		port.Write([]byte{0x06, 0x0b, 0xff, 0xff, 0xff, 0xff})
+		io.ReadFull(port, make([]byte, 9))
+		port.Close()
+		f.Close()
+	}
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[Kind]int)
+	for {
+		record, err := r.Next()
+		if err != nil {
+			break
+		}
+		kinds[record.Kind]++
+	}
+	if kinds[KindOut] != 2 || kinds[KindIn] < 2 {
+		t.Error("traffic not recorded", kinds)
+	}
+}
@@ -1,19 +1,12 @@
 package driver
 
 import (
+	"time"
+
 	"github.com/AGPFMiner/gominer/clients"
 	"github.com/AGPFMiner/gominer/types"
 )
 
-const (
-	pullLow           = "00000000"
-	pullHigh          = "ffffffff"
-	nonceReadCtrlAddr = "060b"
-	startMineCtrlAddr = "0608"
-	initCnt0          = "0628"
-	initCnt1          = "0629"
-)
-
 type MiningWork struct {
 	Header     []byte
 	Offset     int
@@ -21,6 +14,8 @@ type MiningWork struct {
 	Difficulty float64
 	// Job        stratumJob
 	Job interface{}
+	//HardwareBits is the target the board was programmed to report nonces at, 0 if its bitstream has a fixed target
+	HardwareBits uint
 }
 
 type MiningFuncs interface {
@@ -39,3 +34,9 @@ type Driver interface {
 	ProgramBitstream(bitstreamPath string) (err error)
 	SetClient(clients.Client)
 }
+
+//Tuner is implemented by drivers whose timing can be changed while mining.
+// Both durations are in milliseconds, like MinerArgs.PollDelay.
+type Tuner interface {
+	Tune(pollDelay, nonceTraverseTimeout time.Duration)
+}
@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"math"
+
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/driver/protocol"
+)
+
+const (
+	//GoldenBits are the leading zero bits of the nonces bitstreams with a fixed target report
+	GoldenBits = 24
+	//MinHardwareBits is the lowest hardware target, below it the reports of a single board fill the link
+	MinHardwareBits = 16
+	//MaxHardwareBits is the highest hardware target, a board still reports about one nonce per
+	// traversal of the 32 bit nonce range so its hashrate and hardware errors stay visible
+	MaxHardwareBits = 32
+	//NonceBandwidth is the share of the link the nonce reports of a chain may take, the rest is
+	// left to the polls and headers
+	NonceBandwidth = 0.25
+)
+
+//hardwareBits is the number of leading zero bits the boards are programmed to report nonces at.
+// Every share of the job is reported unless the reports at its shareBits would take more than
+// NonceBandwidth of a link at baudRate for the hashrate of the chain, the target is raised until they fit.
+// Until the hashrate is known the boards report no more than a bitstream with a fixed target.
+func hardwareBits(shareBits uint, hashrate float64, baudRate uint) uint {
+	bits := shareBits
+	if hashrate <= 0 || baudRate == 0 {
+		if bits < GoldenBits {
+			bits = GoldenBits
+		}
+	} else if b := linkBits(hashrate, baudRate); b > bits {
+		bits = b
+	}
+	if bits < MinHardwareBits {
+		bits = MinHardwareBits
+	}
+	if bits > MaxHardwareBits {
+		bits = MaxHardwareBits
+	}
+	return bits
+}
+
+//linkBits is the fewest leading zero bits keeping the nonce reports of hashrate within NonceBandwidth
+// of a link at baudRate, with 10 bits on the line per byte
+func linkBits(hashrate float64, baudRate uint) uint {
+	reports := NonceBandwidth * float64(baudRate) / 10 / protocol.ReportLen
+	if hashrate <= reports {
+		return 0
+	}
+	return uint(math.Ceil(math.Log2(hashrate / reports)))
+}
+
+//NonceClass is what the hash of a nonce turned out to be
+type NonceClass int
+
+const (
+	//NonceHardwareError nonces miss the target the board filters on, it computed a wrong hash
+	NonceHardwareError NonceClass = iota
+	//NonceBelowTarget nonces meet the target of the board but not the one of the pool
+	NonceBelowTarget
+	//NonceShare nonces meet the pool target
+	NonceShare
+	//NonceUnhashed nonces are of an algorithm the host cannot hash, RegenHash gave no hash. They are dropped
+	// unless the algorithm is BoardChecked.
+	NonceUnhashed
+	//NonceGolden nonces are of a BoardChecked algorithm the host cannot hash, the bitstream compared their
+	// hash with the target. They are submitted as the board reported them.
+	NonceGolden
+)
+
+func (c NonceClass) String() string {
+	switch c {
+	case NonceHardwareError:
+		return "hardware error"
+	case NonceBelowTarget:
+		return "below target"
+	case NonceShare:
+		return "share"
+	case NonceUnhashed:
+		return "no host hash"
+	case NonceGolden:
+		return "golden"
+	}
+	return "unknown"
+}
+
+//classify checks the hash of a nonce against the hardware target of its work and the pool target.
+// Work dispatched without a hardware target was filtered on the golden nonces of the bitstream,
+// without a hash the nonce is golden if the bitstream of the algorithm is boardChecked.
+func classify(funcs MiningFuncs, hash []byte, work MiningWork, boardChecked bool) NonceClass {
+	if len(hash) == 0 {
+		if boardChecked {
+			return NonceGolden
+		}
+		return NonceUnhashed
+	}
+	bits := work.HardwareBits
+	if bits == 0 {
+		bits = GoldenBits
+	}
+	if difficulty.LeadingZeroBits(hash) < bits {
+		return NonceHardwareError
+	}
+	if !funcs.DiffChecker(hash, work) {
+		return NonceBelowTarget
+	}
+	return NonceShare
+}
@@ -0,0 +1,210 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients/gbt"
+	"github.com/AGPFMiner/gominer/mining"
+	"github.com/AGPFMiner/gominer/types"
+
+	"go.uber.org/zap"
+)
+
+func TestHardwareBits(t *testing.T) {
+	//a 115200 baud link takes 320 nonce reports per second
+	cases := []struct {
+		shareBits uint
+		hashrate  float64
+		baudRate  uint
+		expected  uint
+	}{
+		//unknown hashrate, no more nonces than a fixed bitstream
+		{18, 0, 115200, GoldenBits},
+		{28, 0, 115200, 28},
+		{18, 1e9, 0, GoldenBits},
+		//every share fits on the link
+		{20, 100e6, 115200, 20},
+		//1 GH/s needs 22 bits to stay within the budget
+		{18, 1e9, 115200, 22},
+		{23, 1e9, 115200, 23},
+		{0, 1e3, 115200, MinHardwareBits},
+		{48, 1e9, 115200, MaxHardwareBits},
+	}
+	for _, c := range cases {
+		if bits := hardwareBits(c.shareBits, c.hashrate, c.baudRate); bits != c.expected {
+			t.Error(c.shareBits, "share bits at", c.hashrate, "H/s and", c.baudRate, "baud gave", bits, "expected", c.expected)
+		}
+	}
+}
+
+//classFuncs takes hashes starting with a zero byte for shares
+type classFuncs struct{ emuFuncs }
+
+func (classFuncs) DiffChecker(hash []byte, work MiningWork) bool { return hash[0] == 0 }
+
+func TestClassify(t *testing.T) {
+	hash := func(zeroBits uint) []byte {
+		h := bytes.Repeat([]byte{0xff}, 32)
+		for i := uint(0); i < zeroBits; i++ {
+			h[i/8] &^= 0x80 >> (i % 8)
+		}
+		return h
+	}
+	cases := []struct {
+		hash     []byte
+		bits     uint
+		expected NonceClass
+	}{
+		{hash(16), 16, NonceShare},
+		{hash(15), 16, NonceHardwareError},
+		{hash(7), 16, NonceHardwareError},
+		{hash(20), 20, NonceShare},
+		//the pool asks for more than the board filters on
+		{hash(7), 4, NonceBelowTarget},
+		//the golden nonces of bitstreams with a fixed target
+		{hash(23), 0, NonceHardwareError},
+		{hash(24), 0, NonceShare},
+	}
+	for _, c := range cases {
+		if class := classify(classFuncs{}, c.hash, MiningWork{HardwareBits: c.bits}, false); class != c.expected {
+			t.Errorf("%x at %d bits is a %v, expected a %v", c.hash[:4], c.bits, class, c.expected)
+		}
+	}
+}
+
+func TestClassifyUnhashed(t *testing.T) {
+	//without a hash the nonce is dropped before the hardware target is checked
+	for _, hash := range [][]byte{nil, {}} {
+		if class := classify(emuFuncs{}, hash, MiningWork{HardwareBits: 0xff}, false); class != NonceUnhashed {
+			t.Error("nonce without a host hash is a", class)
+		}
+		//the bitstream of a board checked algorithm already compared the hash with the target
+		if class := classify(emuFuncs{}, hash, MiningWork{HardwareBits: 0xff}, true); class != NonceGolden {
+			t.Error("nonce of a board checked algorithm is a", class)
+		}
+	}
+}
+
+//nonceHashFuncs take the nonce for the hash, shares have 24 leading zero bits
+type nonceHashFuncs struct{ emuFuncs }
+
+func (nonceHashFuncs) RegenHash(input []byte) []byte {
+	return append(append([]byte{}, input[len(input)-8:]...), make([]byte, 24)...)
+}
+func (nonceHashFuncs) DiffChecker(hash []byte, work MiningWork) bool { return isGolden(hash) }
+
+func TestCheckAndSubmitJob(t *testing.T) {
+	client := &emuClient{submitted: make(map[uint32]int)}
+	thy := NewThyroid(mining.MinerArgs{MuxNums: 1, SkipSlots: []int{}, Logger: zap.NewNop()}).(*Thyroid)
+	thy.RegisterMiningFuncs("emu", nonceHashFuncs{})
+	thy.SetClient(client)
+
+	work := MiningWork{Header: []byte{0, 0, 0, 1}, Job: uint32(1), HardwareBits: 16}
+	for nonce, expected := range map[[8]byte]NonceClass{
+		{0, 0, 0, 0x80}: NonceShare,
+		{0, 0, 0x01}:    NonceBelowTarget,
+		{0, 0x01}:       NonceHardwareError,
+	} {
+		if class := thy.checkAndSubmitJob(SingleNonce{nonce: nonce, work: work}, work); class != expected {
+			t.Errorf("nonce %x is a %v, expected a %v", nonce, class, expected)
+		}
+	}
+	if client.submitted[1] != 1 {
+		t.Error("submitted", client.submitted[1], "shares instead of 1")
+	}
+	expected := types.NonceClasses{HardwareErrors: 1, BelowTarget: 1, Shares: 1}
+	if classes := thy.getNonceClasses(0); classes != expected {
+		t.Error("nonces counted as", classes)
+	}
+}
+
+//unhashedFuncs are the mining functions of an algorithm the host has no hash for
+type unhashedFuncs struct{ emuFuncs }
+
+func (unhashedFuncs) RegenHash(input []byte) []byte { return nil }
+
+//soloNode answers validateaddress, getblocktemplate and submitblock like an odocrypt node, without longpoll
+type soloNode struct {
+	mutex  sync.Mutex
+	blocks []string
+}
+
+func (n *soloNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     int64             `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	var result interface{}
+	switch req.Method {
+	case "validateaddress":
+		result = map[string]interface{}{"isvalid": true, "scriptPubKey": "76a914000102030405060708090a0b0c0d0e0f1011121388ac"}
+	case "getblocktemplate":
+		result = gbt.Template{
+			Version:           0x20000000,
+			PreviousBlockHash: "000000000002d01c1fccc21636b607dfd930d31d01c3a62104612a1719011250",
+			CoinbaseValue:     5000000000,
+			Target:            "7fffff0000000000000000000000000000000000000000000000000000000000",
+			CurTime:           1600000000,
+			Bits:              "207fffff",
+			Height:            1000,
+		}
+	case "submitblock":
+		var block string
+		json.Unmarshal(req.Params[0], &block)
+		n.mutex.Lock()
+		n.blocks = append(n.blocks, block)
+		n.mutex.Unlock()
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": result, "error": nil})
+}
+
+func TestCheckAndSubmitSolo(t *testing.T) {
+	node := &soloNode{}
+	server := httptest.NewServer(node)
+	defer server.Close()
+	client := gbt.NewClient(&types.Pool{URL: server.URL, User: "rpc", Algo: "odocrypt", Payout: "dgb1qexample"})
+	go client.Start()
+	defer client.Stop()
+
+	var work MiningWork
+	var err error
+	for i := 0; ; i++ {
+		if work.Target, work.Difficulty, work.Header, _, work.Job, err = client.GetHeaderForWork(); err == nil {
+			break
+		}
+		if i == 200 {
+			t.Fatal("no template", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//the host has no odocrypt hash, the golden nonce of the board goes to the node as reported
+	thy := NewThyroid(mining.MinerArgs{MuxNums: 1, SkipSlots: []int{}, Logger: zap.NewNop()}).(*Thyroid)
+	thy.RegisterMiningFuncs("odocrypt", unhashedFuncs{})
+	thy.SetClient(client)
+	nonce := [8]byte{0, 0, 0, 0, 0x12, 0x34, 0x56, 0x78}
+	if class := thy.checkAndSubmitJob(SingleNonce{nonce: nonce, work: work}, work); class != NonceGolden {
+		t.Fatal("odocrypt nonce is a", class)
+	}
+	if classes := thy.getNonceClasses(0); classes != (types.NonceClasses{Golden: 1}) {
+		t.Error("nonces counted as", classes)
+	}
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if len(node.blocks) != 1 {
+		t.Fatal(len(node.blocks), "blocks submitted")
+	}
+	block, _ := hex.DecodeString(node.blocks[0])
+	if len(block) < 80 || !bytes.Equal(block[:76], work.Header[:76]) || hex.EncodeToString(block[76:80]) != "78563412" {
+		t.Errorf("block %x", block)
+	}
+}
@@ -0,0 +1,24 @@
+package driver
+
+import "github.com/AGPFMiner/gominer/difficulty"
+
+//KnownAnswer is a job with a known winning nonce, a working board returns the nonce
+// every time it traverses the nonce range of the job
+type KnownAnswer struct {
+	//Header is the work as the pool client hands it to the driver
+	Header []byte
+	//Nonce is the winning nonce as read back from the board
+	Nonce [8]byte
+}
+
+//Check tells whether nonce is a golden nonce for the job
+func (ka *KnownAnswer) Check(funcs MiningFuncs, nonce [8]byte) bool {
+	input := make([]byte, 0, len(ka.Header)+len(nonce))
+	input = append(append(input, ka.Header...), nonce[:]...)
+	return isGolden(funcs.RegenHash(input))
+}
+
+//isGolden tells whether a regenerated hash meets the golden target of bitstreams with a fixed target
+func isGolden(hash []byte) bool {
+	return difficulty.LeadingZeroBits(hash) >= GoldenBits
+}
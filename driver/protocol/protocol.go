@@ -0,0 +1,378 @@
+//Package protocol encodes and decodes the frames on the UART link between the driver and the Thyroid boards.
+// The host writes 6 byte register frames. The boards report nonces in one of three formats:
+// legacy frames behind eight zero bytes, 8 byte frames behind the 89 ab cd magic, and checked frames
+// with a type, a length and a CRC-8. Which one a bitstream speaks is negotiated with the version
+// command, see Reader.Handshake.
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//Control bytes of the register frames
+const (
+	WriteCtrl byte = 0x06
+	ReadCtrl  byte = 0x05
+)
+
+//Registers of the boards
+const (
+	RegVersion   byte = 0x02
+	RegStartMine byte = 0x08
+	RegNonceRead byte = 0x0b
+	RegJunk      byte = 0x1c
+	RegInitCnt0  byte = 0x28
+	RegInitCnt1  byte = 0x29
+	//RegTarget holds the number of leading zero bits the hash of a nonce needs to be reported,
+	// bitstreams speaking checked frames read it when mining starts
+	RegTarget byte = 0x31
+)
+
+//Values the control registers are pulled to
+const (
+	PullLow  uint32 = 0x00000000
+	PullHigh uint32 = 0xffffffff
+)
+
+//RegisterFrameLen is the length of every frame the host writes
+const RegisterFrameLen = 6
+
+//Register is a register frame, Ctrl is WriteCtrl or ReadCtrl
+type Register struct {
+	Ctrl  byte
+	Addr  byte
+	Value uint32
+}
+
+//Write is the frame writing value to the register at addr
+func Write(addr byte, value uint32) Register {
+	return Register{Ctrl: WriteCtrl, Addr: addr, Value: value}
+}
+
+//Append appends the encoded frame to dst
+func (r Register) Append(dst []byte) []byte {
+	var value [4]byte
+	binary.BigEndian.PutUint32(value[:], r.Value)
+	return append(append(dst, r.Ctrl, r.Addr), value[:]...)
+}
+
+//Encode returns the encoded frame
+func (r Register) Encode() []byte {
+	return r.Append(make([]byte, 0, RegisterFrameLen))
+}
+
+//DecodeRegister decodes the register frame at the start of b
+func DecodeRegister(b []byte) (Register, error) {
+	if len(b) < RegisterFrameLen {
+		return Register{}, ErrIncomplete
+	}
+	if b[0] != WriteCtrl && b[0] != ReadCtrl {
+		return Register{}, ErrControl
+	}
+	return Register{Ctrl: b[0], Addr: b[1], Value: binary.BigEndian.Uint32(b[2:6])}, nil
+}
+
+//NonceRead asks the selected board for the nonces it found
+func NonceRead() []byte { return Write(RegNonceRead, PullHigh).Encode() }
+
+//StartMine starts the selected board on the header written before
+func StartMine() []byte { return Write(RegStartMine, PullHigh).Encode() }
+
+//InitCounters clears the nonce counters of the selected board
+func InitCounters() []byte {
+	return Write(RegInitCnt1, PullLow).Append(Write(RegInitCnt0, PullLow).Encode())
+}
+
+//Target makes the selected board report the nonces whose hash starts with bits zero bits
+func Target(bits uint) []byte { return Write(RegTarget, uint32(bits)).Encode() }
+
+//VersionRead asks the selected board for the version of its bitstream
+func VersionRead() []byte { return Register{Ctrl: ReadCtrl, Addr: RegVersion}.Encode() }
+
+//Format is the way a bitstream reports nonces
+type Format int
+
+const (
+	//FormatLegacy frames are eight zero bytes, the number of reports and 9 bytes per report
+	FormatLegacy Format = iota
+	//FormatMagic frames are the 89 ab cd magic, the job ID and 4 nonce bytes in reverse order
+	FormatMagic
+	//FormatChecked frames are the a5 5a sync, a type, the payload length, the payload and a CRC-8
+	FormatChecked
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatLegacy:
+		return "legacy"
+	case FormatMagic:
+		return "magic"
+	case FormatChecked:
+		return "checked"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+//ParseFormat returns the format called name
+func ParseFormat(name string) (Format, error) {
+	for _, f := range []Format{FormatLegacy, FormatMagic, FormatChecked} {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+	return FormatLegacy, fmt.Errorf("Unknown protocol format %q", name)
+}
+
+//Frame types of FormatChecked
+const (
+	TypeNonce   byte = 0x01
+	TypeVersion byte = 0x02
+)
+
+const (
+	//MaxLegacyReports bounds the report count of a legacy frame. It is far above what a board
+	// finds between two polls, a larger count is taken for a false sync inside nonce data.
+	MaxLegacyReports = 64
+	//MaxPayload is the longest payload of a checked frame
+	MaxPayload = 252
+	//ReportLen is the length of a nonce report in legacy and checked frames
+	ReportLen = 9
+	//MagicFrameLen is the length of a magic frame
+	MagicFrameLen = 8
+)
+
+var (
+	legacySync = []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	magicSync  = []byte{0x89, 0xab, 0xcd}
+	//CheckedSync starts every checked frame
+	CheckedSync = []byte{0xa5, 0x5a}
+)
+
+var (
+	//ErrIncomplete is returned while the data ends inside a frame, every other error is a malformed frame
+	ErrIncomplete = errors.New("Incomplete frame")
+	ErrChecksum   = errors.New("Frame checksum mismatch")
+	ErrLength     = errors.New("Bad frame length")
+	ErrJobID      = errors.New("Nonce report with job ID 0")
+	ErrType       = errors.New("Unknown frame type")
+	ErrControl    = errors.New("Unknown control byte")
+)
+
+//NonceReport is a nonce a board found for the work dispatched under JobID, which is never 0
+type NonceReport struct {
+	JobID uint8
+	Nonce [8]byte
+}
+
+//Version is the content of the version register of a bitstream
+type Version uint32
+
+func (v Version) String() string { return fmt.Sprintf("%08X", uint32(v)) }
+
+//Frame is a decoded frame from a board
+type Frame struct {
+	Type    byte
+	Reports []NonceReport
+	Version Version
+	//Skipped is the number of bytes dropped in front of the frame to find its start
+	Skipped int
+	//Dropped is the number of reports of the frame that failed a check
+	Dropped int
+}
+
+//Decode decodes the first frame of format in data and returns the number of bytes it used.
+// Bytes in front of the frame are skipped. On ErrIncomplete advance is the number of bytes that
+// cannot start a frame and may be dropped. On the other errors advance skips the start of the
+// malformed frame, the search for the next one starts right behind it.
+func Decode(format Format, data []byte) (frame Frame, advance int, err error) {
+	switch format {
+	case FormatLegacy:
+		return decodeLegacy(data)
+	case FormatMagic:
+		return decodeMagic(data)
+	case FormatChecked:
+		return decodeChecked(data)
+	}
+	return Frame{}, 0, fmt.Errorf("Unknown protocol format %d", int(format))
+}
+
+//syncAt finds sync in data. If it is missing, skip is the number of bytes that cannot be part of it.
+func syncAt(data, sync []byte) (index, skip int) {
+	for i := 0; i+len(sync) <= len(data); i++ {
+		if data[i] == sync[0] && string(data[i:i+len(sync)]) == string(sync) {
+			return i, i
+		}
+	}
+	//keep the tail that may be the start of sync
+	skip = len(data) - len(sync) + 1
+	if skip < 0 {
+		skip = 0
+	}
+	for ; skip < len(data); skip++ {
+		if string(data[skip:]) == string(sync[:len(data)-skip]) {
+			break
+		}
+	}
+	return -1, skip
+}
+
+func decodeLegacy(data []byte) (frame Frame, advance int, err error) {
+	index, skip := syncAt(data, legacySync)
+	frame.Skipped = skip
+	if index < 0 || len(data) < index+len(legacySync)+1 {
+		return frame, skip, ErrIncomplete
+	}
+	//a run of more than eight zero bytes is the sync of a frame without reports, the count is the ninth zero
+	count := int(data[index+len(legacySync)])
+	if count > MaxLegacyReports {
+		frame.Skipped = index + 1
+		return frame, index + 1, ErrLength
+	}
+	start := index + len(legacySync) + 1
+	end := start + count*ReportLen
+	if len(data) < end {
+		return frame, skip, ErrIncomplete
+	}
+	if count > 0 && data[start] == 0 {
+		//jobid will never be zero, the sync was a run of zeros inside nonce data
+		frame.Skipped = index + 1
+		return frame, index + 1, ErrJobID
+	}
+	frame.Type = TypeNonce
+	frame.Reports, frame.Dropped = decodeReports(data[start:end])
+	return frame, end, nil
+}
+
+//decodeReports decodes the 9 byte reports of a legacy or checked frame and drops those with job ID 0
+func decodeReports(b []byte) (reports []NonceReport, dropped int) {
+	for i := 0; i+ReportLen <= len(b); i += ReportLen {
+		if b[i] == 0 {
+			dropped++
+			continue
+		}
+		var report NonceReport
+		report.JobID = b[i]
+		copy(report.Nonce[:], b[i+1:i+ReportLen])
+		reports = append(reports, report)
+	}
+	return
+}
+
+func decodeMagic(data []byte) (frame Frame, advance int, err error) {
+	index, skip := syncAt(data, magicSync)
+	frame.Skipped = skip
+	if index < 0 || len(data) < index+MagicFrameLen {
+		return frame, skip, ErrIncomplete
+	}
+	b := data[index+len(magicSync) : index+MagicFrameLen]
+	if b[0] == 0 {
+		frame.Skipped = index + 1
+		return frame, index + 1, ErrJobID
+	}
+	//the nonce is 4 bytes, sent in reverse order
+	report := NonceReport{JobID: b[0]}
+	for i := 0; i < 4; i++ {
+		report.Nonce[4+i] = b[4-i]
+	}
+	frame.Type = TypeNonce
+	frame.Reports = []NonceReport{report}
+	return frame, index + MagicFrameLen, nil
+}
+
+func decodeChecked(data []byte) (frame Frame, advance int, err error) {
+	index, skip := syncAt(data, CheckedSync)
+	frame.Skipped = skip
+	header := index + len(CheckedSync)
+	if index < 0 || len(data) < header+2 {
+		return frame, skip, ErrIncomplete
+	}
+	frameType, length := data[header], int(data[header+1])
+	bad := func(err error) (Frame, int, error) {
+		frame.Skipped = index + 1
+		return frame, index + 1, err
+	}
+	if length > MaxPayload {
+		return bad(ErrLength)
+	}
+	end := header + 2 + length + 1
+	if len(data) < end {
+		return frame, skip, ErrIncomplete
+	}
+	if CRC8(data[header:end-1]) != data[end-1] {
+		return bad(ErrChecksum)
+	}
+	payload := data[header+2 : end-1]
+	switch frameType {
+	case TypeNonce:
+		if length%ReportLen != 0 {
+			return bad(ErrLength)
+		}
+		frame.Reports, frame.Dropped = decodeReports(payload)
+	case TypeVersion:
+		if length != 4 {
+			return bad(ErrLength)
+		}
+		frame.Version = Version(binary.BigEndian.Uint32(payload))
+	default:
+		return bad(ErrType)
+	}
+	frame.Type = frameType
+	return frame, end, nil
+}
+
+//EncodeReports encodes reports as the boards of format send them. Magic frames carry one report each
+// and only the last 4 nonce bytes.
+func EncodeReports(format Format, reports []NonceReport) (b []byte) {
+	switch format {
+	case FormatLegacy:
+		b = append(append(b, legacySync...), byte(len(reports)))
+		for _, report := range reports {
+			b = append(append(b, report.JobID), report.Nonce[:]...)
+		}
+	case FormatMagic:
+		for _, report := range reports {
+			b = append(append(b, magicSync...), report.JobID)
+			for i := 7; i >= 4; i-- {
+				b = append(b, report.Nonce[i])
+			}
+		}
+	case FormatChecked:
+		var payload []byte
+		for _, report := range reports {
+			payload = append(append(payload, report.JobID), report.Nonce[:]...)
+		}
+		b = EncodeChecked(TypeNonce, payload)
+	}
+	return
+}
+
+//EncodeVersion encodes the answer of a bitstream speaking checked frames to the version command
+func EncodeVersion(v Version) []byte {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], uint32(v))
+	return EncodeChecked(TypeVersion, payload[:])
+}
+
+//EncodeChecked encodes a checked frame, payload must not be longer than MaxPayload
+func EncodeChecked(frameType byte, payload []byte) []byte {
+	b := append(append([]byte{}, CheckedSync...), frameType, byte(len(payload)))
+	b = append(b, payload...)
+	return append(b, CRC8(b[len(CheckedSync):]))
+}
+
+//CRC8 is the CRC-8 with polynomial 0x07 over b, as used by SMBus
+func CRC8(b []byte) (crc byte) {
+	for _, c := range b {
+		crc ^= c
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return
+}
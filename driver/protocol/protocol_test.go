@@ -0,0 +1,220 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+var testReports = []NonceReport{
+	{JobID: 1, Nonce: [8]byte{0, 0, 0, 0, 0xde, 0xad, 0xbe, 0xef}},
+	{JobID: 0xff, Nonce: [8]byte{0, 0, 0, 0, 1, 2, 3, 4}},
+}
+
+func TestCommands(t *testing.T) {
+	for _, tc := range []struct {
+		packet []byte
+		hex    string
+	}{
+		{NonceRead(), "060bffffffff"},
+		{StartMine(), "0608ffffffff"},
+		{InitCounters(), "062800000000062900000000"},
+		{VersionRead(), "050200000000"},
+		{Target(27), "06310000001b"},
+	} {
+		if got := hex.EncodeToString(tc.packet); got != tc.hex {
+			t.Errorf("got %s, want %s", got, tc.hex)
+		}
+	}
+	if r, err := DecodeRegister(StartMine()); err != nil || r != Write(RegStartMine, PullHigh) {
+		t.Error("register frame does not round trip", r, err)
+	}
+}
+
+func TestDecodeFormats(t *testing.T) {
+	for _, format := range []Format{FormatLegacy, FormatMagic, FormatChecked} {
+		encoded := EncodeReports(format, testReports)
+		var got []NonceReport
+		for data := encoded; len(data) > 0; {
+			frame, advance, err := Decode(format, data)
+			if err != nil || frame.Skipped != 0 {
+				t.Fatal(format, err, frame)
+			}
+			got = append(got, frame.Reports...)
+			data = data[advance:]
+		}
+		if len(got) != len(testReports) || got[0] != testReports[0] || got[1] != testReports[1] {
+			t.Error(format, "reports do not round trip", got)
+		}
+		single := EncodeReports(format, testReports[:1])
+		if _, advance, err := Decode(format, single[:len(single)-1]); err != ErrIncomplete || advance != 0 {
+			t.Error(format, "truncated frame not incomplete", advance, err)
+		}
+	}
+}
+
+func TestDecodeResync(t *testing.T) {
+	//garbage, a run of zeros inside nonce data and a frame with a bad checksum in front of a good frame
+	good := EncodeReports(FormatChecked, testReports[:1])
+	bad := append([]byte{}, good...)
+	bad[len(bad)-1]++
+	data := append(append([]byte{0x12, 0xa5}, bad...), good...)
+	frame, advance, err := Decode(FormatChecked, data)
+	if err != ErrChecksum || advance != 3 {
+		t.Fatal("bad checksum not detected", advance, err)
+	}
+	frame, advance, err = Decode(FormatChecked, data[advance:])
+	if err != nil || frame.Skipped != len(bad)-1 || len(frame.Reports) != 1 {
+		t.Error("good frame not found behind the bad one", frame, err)
+	}
+
+	legacy := append(append([]byte{}, legacySync...), 1, 0, 1, 2, 3, 4, 5, 6, 7, 8)
+	if _, advance, err := Decode(FormatLegacy, legacy); err != ErrJobID || advance != 1 {
+		t.Error("zeros inside nonce data taken for a frame", advance, err)
+	}
+	legacy[len(legacySync)] = MaxLegacyReports + 1
+	if _, _, err := Decode(FormatLegacy, legacy); err != ErrLength {
+		t.Error("implausible report count accepted", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	host, board := net.Pipe()
+	defer host.Close()
+	reader := NewReader(host, FormatMagic)
+	go func() {
+		command := make([]byte, RegisterFrameLen)
+		io.ReadFull(board, command)
+		if !bytes.Equal(command, VersionRead()) {
+			board.Close()
+			return
+		}
+		board.Write(EncodeVersion(0x00030001))
+		//one byte at a time, with garbage between the frames
+		data := append(EncodeReports(FormatChecked, testReports[:1]), 0x42, 0x5a)
+		data = append(data, EncodeReports(FormatChecked, testReports[1:])...)
+		for i := range data {
+			board.Write(data[i : i+1])
+		}
+		board.Close()
+	}()
+
+	hello, err := reader.Handshake(host, time.Second)
+	if err != nil || !hello.Checked || hello.Version != 0x00030001 {
+		t.Fatal("handshake failed", hello, err)
+	}
+	reader.SetFormat(FormatChecked)
+	var reports []NonceReport
+	for {
+		frame, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		reports = append(reports, frame.Reports...)
+	}
+	stats := reader.Stats()
+	if len(reports) != 2 || stats.Frames != 2 || stats.Resyncs != 1 || stats.Skipped != 2 {
+		t.Error("unexpected frames", reports, stats)
+	}
+}
+
+func TestHandshakeLegacy(t *testing.T) {
+	host, board := net.Pipe()
+	defer host.Close()
+	defer board.Close()
+	reader := NewReader(host, FormatLegacy)
+	go func() {
+		io.ReadFull(board, make([]byte, RegisterFrameLen))
+		board.Write([]byte{0x20, 0x19, 0x08, 0x01})
+	}()
+	if hello, err := reader.Handshake(host, time.Second); err != nil || hello.Checked || hello.Version != 0x20190801 {
+		t.Error("legacy version not read", hello, err)
+	}
+
+	go io.ReadFull(board, make([]byte, RegisterFrameLen))
+	if _, err := reader.Handshake(host, 10*time.Millisecond); err != ErrNoVersion {
+		t.Error("silent board answered", err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, format := range []Format{FormatLegacy, FormatMagic, FormatChecked} {
+		f.Add(uint8(format), EncodeReports(format, testReports))
+	}
+	f.Add(uint8(FormatChecked), EncodeVersion(1))
+	f.Add(uint8(FormatLegacy), []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, f uint8, data []byte) {
+		format := Format(f % 3)
+		frame, advance, err := Decode(format, data)
+		if advance < 0 || advance > len(data) {
+			t.Fatal("advance out of range", advance, len(data))
+		}
+		if err != nil && err != ErrIncomplete && advance == 0 {
+			t.Fatal("malformed frame not skipped", err)
+		}
+		if err != nil {
+			return
+		}
+		if frame.Skipped > advance {
+			t.Fatal("skipped more than consumed", frame.Skipped, advance)
+		}
+		for _, report := range frame.Reports {
+			if report.JobID == 0 {
+				t.Fatal("report with job ID 0", frame)
+			}
+		}
+		//the reports of a frame encode to a frame that decodes to them again
+		if format == FormatMagic || frame.Type != TypeNonce {
+			return
+		}
+		again, _, err := Decode(format, EncodeReports(format, frame.Reports))
+		if err != nil || len(again.Reports) != len(frame.Reports) {
+			t.Fatal("reports do not round trip", frame, again, err)
+		}
+	})
+}
+
+func FuzzReader(f *testing.F) {
+	f.Add(EncodeReports(FormatLegacy, testReports), uint8(3))
+	f.Add(append([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0xff}, EncodeReports(FormatLegacy, testReports)...), uint8(1))
+	f.Fuzz(func(t *testing.T, data []byte, chunk uint8) {
+		size := int(chunk%16) + 1
+		reader := NewReader(&chunkReader{data: data, size: size}, FormatLegacy)
+		var reports uint64
+		for {
+			frame, err := reader.Next()
+			if err != nil {
+				break
+			}
+			reports += uint64(len(frame.Reports))
+		}
+		if stats := reader.Stats(); stats.Reports != reports || stats.Skipped > uint64(len(data)) {
+			t.Fatal("inconsistent stats", stats, reports, len(data))
+		}
+	})
+}
+
+//chunkReader returns data size bytes at a time
+type chunkReader struct {
+	data []byte
+	size int
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b[:min(len(b), r.size)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+//HandshakeTimeout is how long Handshake waits for the answer to the version command
+const HandshakeTimeout = 500 * time.Millisecond
+
+//ErrNoVersion is returned by Handshake if the board did not answer the version command in time
+var ErrNoVersion = errors.New("No answer to the version command")
+
+//Stats counts what a Reader decoded and what it had to drop
+type Stats struct {
+	Frames  uint64
+	Reports uint64
+	//Resyncs counts the frames found after dropping bytes, Skipped the bytes dropped
+	Resyncs uint64
+	Skipped uint64
+	//Malformed counts the frames and reports that failed a check
+	Malformed uint64
+}
+
+//Hello is the result of the version handshake
+type Hello struct {
+	Version Version
+	//Checked is set if the bitstream answered with a checked frame, it reports nonces in FormatChecked
+	Checked bool
+}
+
+//Reader decodes the frames a board sends. A single goroutine reads the link,
+// so a handshake that timed out does not lose the bytes that arrive late.
+type Reader struct {
+	frames, reports, resyncs, skipped, malformed uint64
+
+	format int32
+	chunks chan []byte
+	buf    []byte
+	err    error
+}
+
+//NewReader decodes the frames of format read from r, it reads r until r returns an error
+func NewReader(r io.Reader, format Format) *Reader {
+	reader := &Reader{format: int32(format), chunks: make(chan []byte, 64)}
+	go reader.pump(r)
+	return reader
+}
+
+func (r *Reader) pump(src io.Reader) {
+	defer close(r.chunks)
+	buf := make([]byte, 1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			r.chunks <- append([]byte{}, buf[:n]...)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+//SetFormat changes the format of the frames to decode, e.g. after the handshake
+func (r *Reader) SetFormat(format Format) {
+	atomic.StoreInt32(&r.format, int32(format))
+}
+
+//Format is the format of the frames the reader decodes
+func (r *Reader) Format() Format {
+	return Format(atomic.LoadInt32(&r.format))
+}
+
+//fill waits for more data, it returns false once the link is closed or timeout fires
+func (r *Reader) fill(timeout <-chan time.Time) bool {
+	select {
+	case chunk, ok := <-r.chunks:
+		if !ok {
+			r.err = io.EOF
+			return false
+		}
+		r.buf = append(r.buf, chunk...)
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+//Handshake writes the version command to w and waits up to timeout for the answer.
+// A bitstream that speaks checked frames answers with a version frame, older ones with
+// the 4 bytes of the version register. The handshake must run before anything else is
+// written to the board, the reader takes the first bytes it receives for the answer.
+func (r *Reader) Handshake(w io.Writer, timeout time.Duration) (hello Hello, err error) {
+	if _, err = w.Write(VersionRead()); err != nil {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	expired := false
+	for {
+		//a checked frame is waited for until it is complete, the register content may just start like one
+		pending := false
+		if len(r.buf) >= len(CheckedSync) && string(r.buf[:len(CheckedSync)]) == string(CheckedSync) {
+			frame, advance, err := decodeChecked(r.buf)
+			if err == nil && frame.Type == TypeVersion {
+				r.buf = r.buf[advance:]
+				return Hello{Version: frame.Version, Checked: true}, nil
+			}
+			pending = err == ErrIncomplete && !expired
+		}
+		if !pending && len(r.buf) >= 4 {
+			hello.Version = Version(binary.BigEndian.Uint32(r.buf))
+			r.buf = r.buf[4:]
+			return hello, nil
+		}
+		if expired {
+			return hello, ErrNoVersion
+		}
+		if !r.fill(timer.C) {
+			if r.err != nil {
+				return hello, r.err
+			}
+			expired = true
+		}
+	}
+}
+
+//Next returns the next frame. Bytes that do not decode are dropped and counted in Stats,
+// frames with Dropped reports are returned with the good ones. It returns io.EOF once the link is closed.
+func (r *Reader) Next() (Frame, error) {
+	var skipped int
+	for {
+		frame, advance, err := Decode(r.Format(), r.buf)
+		r.buf = r.buf[advance:]
+		skipped += frame.Skipped
+		atomic.AddUint64(&r.skipped, uint64(frame.Skipped))
+		switch err {
+		case nil:
+			frame.Skipped = skipped
+			if skipped > 0 {
+				atomic.AddUint64(&r.resyncs, 1)
+			}
+			atomic.AddUint64(&r.frames, 1)
+			atomic.AddUint64(&r.reports, uint64(len(frame.Reports)))
+			atomic.AddUint64(&r.malformed, uint64(frame.Dropped))
+			return frame, nil
+		case ErrIncomplete:
+			if !r.fill(nil) {
+				return Frame{Skipped: skipped}, r.err
+			}
+		default:
+			atomic.AddUint64(&r.malformed, 1)
+		}
+	}
+}
+
+//Stats returns the counters of the reader, it is safe to call while Next runs
+func (r *Reader) Stats() Stats {
+	return Stats{
+		Frames:    atomic.LoadUint64(&r.frames),
+		Reports:   atomic.LoadUint64(&r.reports),
+		Resyncs:   atomic.LoadUint64(&r.resyncs),
+		Skipped:   atomic.LoadUint64(&r.skipped),
+		Malformed: atomic.LoadUint64(&r.malformed),
+	}
+}
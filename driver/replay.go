@@ -0,0 +1,220 @@
+package driver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/driver/capture"
+	"github.com/AGPFMiner/gominer/driver/protocol"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//ReplayBoard counts what the nonces of a board in a capture turned out to be
+type ReplayBoard struct {
+	Board int
+	//Nonces were read from the board, Stale ones belonged to work replaced by a clean job,
+	// Unknown ones carried a job ID nothing was dispatched under and Duplicates were read before for the same work
+	Nonces, Stale, Unknown, Duplicates int
+	//WrongHash nonces missed the target the board filtered on, BelowTarget ones met it but not the
+	// difficulty of their work and Shares met the difficulty of their work
+	WrongHash, BelowTarget, Shares int
+}
+
+//ReplayNonce is a nonce that gave a wrong hash, with everything needed to check it again
+type ReplayNonce struct {
+	Time   time.Time
+	Board  int
+	JobID  uint8
+	Nonce  [8]byte
+	Header []byte
+}
+
+//ReplayReport is the outcome of replaying a capture
+type ReplayReport struct {
+	Algo string
+	//Records were read from the capture, Truncated is set if the last one was cut short
+	Records   int
+	Truncated bool
+	//Start and End are the times of the first and the last record
+	Start, End time.Time
+	Boards     []ReplayBoard
+	WrongHash  []ReplayNonce
+	//Resyncs, Skipped and Malformed count the data the nonce parser had to drop
+	Resyncs, Skipped, Malformed int
+}
+
+//replayClient counts the shares checkAndSubmitJob submits, nothing is sent anywhere
+type replayClient struct {
+	clients.BaseClient
+	mutex  sync.Mutex
+	algo   string
+	shares int
+}
+
+func (c *replayClient) GetHeaderForWork() (target []byte, difficulty float64, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	return nil, 0, nil, nil, nil, io.EOF
+}
+
+func (c *replayClient) SubmitHeader(nonce []byte, job interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.shares++
+	return nil
+}
+
+func (c *replayClient) submitted() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.shares
+}
+
+func (c *replayClient) Start()                                           {}
+func (c *replayClient) Stop()                                            {}
+func (c *replayClient) AlgoName() string                                 { return c.algo }
+func (c *replayClient) PoolConnectionStates() types.PoolConnectionStates { return types.Alive }
+func (c *replayClient) GetPoolStats() types.PoolStates                   { return types.PoolStates{Algo: c.algo} }
+
+//Replay feeds the records of a capture through the nonce parser and checkAndSubmitJob, in the order
+// they were taken. The work cache follows the recorded dispatches and clean jobs, so stale and wrong
+// nonces show up as they did on the rig. Shares are counted, not submitted.
+// The mining functions of the captured algorithm must be registered.
+func (thy *Thyroid) Replay(r *capture.Reader) (report ReplayReport, err error) {
+	client := &replayClient{}
+	thy.Client = client
+	boards := make(map[int]*ReplayBoard)
+	board := func(n int) *ReplayBoard {
+		if boards[n] == nil {
+			boards[n] = &ReplayBoard{Board: n}
+		}
+		return boards[n]
+	}
+
+	var format protocol.Format
+	var buf []byte
+	var offset int
+	started := false
+	for {
+		record, e := r.Next()
+		if e == io.ErrUnexpectedEOF {
+			report.Truncated = true
+			break
+		}
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return report, e
+		}
+		report.Records++
+		if report.Start.IsZero() {
+			report.Start = record.Time
+		}
+		report.End = record.Time
+
+		switch record.Kind {
+		case capture.KindMeta:
+			//the driver started again, the bytes before were the answer to the version command
+			meta, e := record.Meta()
+			if e != nil {
+				return report, e
+			}
+			if format, e = protocol.ParseFormat(meta.Format); e != nil {
+				return report, e
+			}
+			if _, ok := thy.MiningFuncs[meta.Algo]; !ok {
+				return report, fmt.Errorf("Algorithm %q of the capture is not supported", meta.Algo)
+			}
+			client.algo, report.Algo = meta.Algo, meta.Algo
+			offset = meta.BoardOffset
+			thy.works = NewWorkCache()
+			buf = nil
+			started = true
+		case capture.KindWork:
+			if !started {
+				continue
+			}
+			work, e := record.Work()
+			if e != nil {
+				return report, e
+			}
+			thy.works.Put(work.JobID, MiningWork{Header: work.Header, Target: work.Target, Difficulty: work.Difficulty, HardwareBits: work.HardwareBits}, record.Board, record.Time)
+		case capture.KindClean:
+			if started {
+				thy.works.Clean()
+			}
+		case capture.KindIn:
+			if !started {
+				continue
+			}
+			buf = append(buf, record.Data...)
+			for {
+				frame, advance, e := protocol.Decode(format, buf)
+				buf = buf[advance:]
+				report.Skipped += frame.Skipped
+				if e == protocol.ErrIncomplete {
+					break
+				}
+				if e != nil {
+					report.Malformed++
+					continue
+				}
+				if frame.Skipped > 0 {
+					report.Resyncs++
+				}
+				report.Malformed += frame.Dropped
+				for _, nonce := range frame.Reports {
+					thy.replayNonce(&report, board, record, offset, nonce)
+				}
+			}
+		}
+	}
+
+	for _, b := range boards {
+		report.Boards = append(report.Boards, *b)
+	}
+	sort.Slice(report.Boards, func(i, j int) bool { return report.Boards[i].Board < report.Boards[j].Board })
+	return report, nil
+}
+
+//replayNonce runs a nonce of the capture through checkAndSubmitJob like processNonce does
+func (thy *Thyroid) replayNonce(report *ReplayReport, board func(int) *ReplayBoard, record capture.Record, offset int, nonce protocol.NonceReport) {
+	singleNonce := SingleNonce{jobid: nonce.JobID, nonce: nonce.Nonce}
+	thy.lookupWork(&singleNonce)
+	if singleNonce.board < 0 {
+		//the board the mux had selected when the nonce arrived
+		singleNonce.board = record.Board
+	}
+	b := board(offset + singleNonce.board)
+	b.Nonces++
+	switch singleNonce.status {
+	case NonceUnknown:
+		b.Unknown++
+		return
+	case NonceStale:
+		b.Stale++
+		return
+	case NonceDuplicate:
+		b.Duplicates++
+		return
+	}
+	client := thy.Client.(*replayClient)
+	shares := client.submitted()
+	switch thy.checkAndSubmitJob(singleNonce, singleNonce.work) {
+	case NonceBelowTarget:
+		b.BelowTarget++
+	case NonceHardwareError:
+		b.WrongHash++
+		report.WrongHash = append(report.WrongHash, ReplayNonce{
+			Time:   record.Time,
+			Board:  offset + singleNonce.board,
+			JobID:  nonce.JobID,
+			Nonce:  nonce.Nonce,
+			Header: singleNonce.work.Header,
+		})
+	}
+	b.Shares += client.submitted() - shares
+}
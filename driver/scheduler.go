@@ -0,0 +1,229 @@
+package driver
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+//Action is what the scheduler asks the driver to do with a board
+type Action int
+
+const (
+	//Wait means no board is due yet
+	Wait Action = iota
+	//Read polls the board for nonces
+	Read
+	//Refresh gives the board new work, it may have traversed the nonce range of the old one
+	Refresh
+	//Clean gives the board work of a new clean job
+	Clean
+)
+
+//Task is the next thing the driver does on the link
+type Task struct {
+	Board  int
+	Action Action
+}
+
+const (
+	//maxReadStretch bounds how much less often than the plain round robin an idle board is read
+	maxReadStretch = 4
+	//rateWindow is the shortest time the nonce rate of a board is measured over
+	rateWindow = time.Second
+	//rateTimeConstant smooths the measured nonce rate of a board
+	rateTimeConstant = 10 * time.Second
+)
+
+type boardSchedule struct {
+	skipped          bool
+	quarantinedUntil time.Time
+	//clean is set while the board waits for the current clean job
+	clean                 bool
+	nextRead, nextRefresh time.Time
+
+	nonces      int
+	rate        float64
+	rateUpdated time.Time
+}
+
+//Scheduler decides which board the driver talks to next and what it does with it.
+// Clean jobs are broadcast to every board before anything else. Every board has its own deadlines,
+// one for the next nonce read and one for new work after NonceTraverseTimeout. Boards are read in
+// proportion to the nonces they find: with equal rates every board is read once per round of
+// pollDelay per board, an idle board down to maxReadStretch times less often.
+// Skipped boards are never scheduled, quarantined ones not until they are released.
+type Scheduler struct {
+	mutex              sync.Mutex
+	boards             []boardSchedule
+	pollDelay, refresh time.Duration
+	last               int
+}
+
+//NewScheduler schedules boards, skipped is indexed by slot number like the skipslots setting.
+// Every board is due for work right away.
+func NewScheduler(boards int, skipped map[int]bool, pollDelay, refresh time.Duration, now time.Time) *Scheduler {
+	s := &Scheduler{boards: make([]boardSchedule, boards), pollDelay: pollDelay, refresh: refresh, last: -1}
+	for i := range s.boards {
+		b := &s.boards[i]
+		b.skipped = skipped[i+1]
+		b.nextRead, b.nextRefresh, b.rateUpdated = now, now, now
+	}
+	return s
+}
+
+//Tune changes the time between two operations on the link and the time a board keeps its work
+func (s *Scheduler) Tune(pollDelay, refresh time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pollDelay, s.refresh = pollDelay, refresh
+}
+
+func (s *Scheduler) active(b *boardSchedule, now time.Time) bool {
+	return !b.skipped && !now.Before(b.quarantinedUntil)
+}
+
+//Next returns the task to run now. If nothing is due the task is Wait and wait is the time until
+// the next deadline, a new clean job or nonce may change the answer earlier.
+func (s *Scheduler) Next(now time.Time) (task Task, wait time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n := len(s.boards)
+	for i := 1; i <= n; i++ {
+		board := (s.last + i) % n
+		if b := &s.boards[board]; b.clean && s.active(b, now) {
+			return Task{Board: board, Action: Clean}, 0
+		}
+	}
+
+	task = Task{Board: -1, Action: Wait}
+	var deadline time.Time
+	for board := range s.boards {
+		b := &s.boards[board]
+		if !s.active(b, now) {
+			continue
+		}
+		//a refresh reads the nonces too, so it replaces a read that is due
+		due, action := b.nextRead, Read
+		if !b.nextRefresh.After(now) || !b.nextRefresh.After(due) {
+			action = Refresh
+			if b.nextRefresh.Before(due) {
+				due = b.nextRefresh
+			}
+		}
+		if task.Board < 0 || due.Before(deadline) {
+			task, deadline = Task{Board: board, Action: action}, due
+		}
+	}
+	if task.Board < 0 {
+		return Task{Board: -1, Action: Wait}, s.pollDelay
+	}
+	if deadline.After(now) {
+		return Task{Board: task.Board, Action: Wait}, deadline.Sub(now)
+	}
+	return task, 0
+}
+
+//Done records that task ran, dispatched tells whether the board got work.
+// A board that was due for work and did not get any is retried after pollDelay.
+func (s *Scheduler) Done(task Task, dispatched bool, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if task.Board < 0 || task.Board >= len(s.boards) || task.Action == Wait {
+		return
+	}
+	s.last = task.Board
+	b := &s.boards[task.Board]
+	s.updateRate(b, now)
+	b.nextRead = now.Add(s.readInterval(b, now))
+	if task.Action == Clean || task.Action == Refresh {
+		b.clean = false
+		if dispatched {
+			b.nextRefresh = now.Add(s.refresh)
+		} else {
+			b.nextRefresh = now.Add(s.pollDelay)
+		}
+	}
+}
+
+//Clean queues the broadcast of a new clean job to every board
+func (s *Scheduler) Clean() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.boards {
+		s.boards[i].clean = true
+	}
+}
+
+//Nonce counts a nonce found by board
+func (s *Scheduler) Nonce(board int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if board >= 0 && board < len(s.boards) {
+		s.boards[board].nonces++
+	}
+}
+
+//Quarantine keeps board idle until until, it gets new work when it is released
+func (s *Scheduler) Quarantine(board int, until time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if board < 0 || board >= len(s.boards) {
+		return
+	}
+	b := &s.boards[board]
+	b.quarantinedUntil = until
+	b.nextRead, b.nextRefresh = until, until
+}
+
+//Rate is the smoothed number of nonces per second board finds
+func (s *Scheduler) Rate(board int) float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if board < 0 || board >= len(s.boards) {
+		return 0
+	}
+	return s.boards[board].rate
+}
+
+//updateRate folds the nonces counted since the last update into the rate of b, once per rateWindow
+func (s *Scheduler) updateRate(b *boardSchedule, now time.Time) {
+	elapsed := now.Sub(b.rateUpdated)
+	if elapsed < rateWindow {
+		return
+	}
+	measured := float64(b.nonces) / elapsed.Seconds()
+	weight := 1 - math.Exp(-float64(elapsed)/float64(rateTimeConstant))
+	b.rate += weight * (measured - b.rate)
+	b.nonces, b.rateUpdated = 0, now
+}
+
+//readInterval is the time until b is read again: the round robin cycle over the active boards,
+// scaled by how the nonce rate of b compares with the mean rate
+func (s *Scheduler) readInterval(b *boardSchedule, now time.Time) time.Duration {
+	var active int
+	var total float64
+	for i := range s.boards {
+		if other := &s.boards[i]; s.active(other, now) {
+			active++
+			total += other.rate
+		}
+	}
+	cycle := s.pollDelay * time.Duration(active)
+	if active == 0 || total == 0 {
+		return cycle
+	}
+	longest := cycle * maxReadStretch
+	if b.rate <= 0 {
+		return longest
+	}
+	interval := time.Duration(float64(cycle) * total / float64(active) / b.rate)
+	if interval < s.pollDelay {
+		return s.pollDelay
+	}
+	if interval > longest {
+		return longest
+	}
+	return interval
+}
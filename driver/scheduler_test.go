@@ -0,0 +1,102 @@
+package driver
+
+import (
+	"testing"
+	"time"
+)
+
+const testPollDelay = 10 * time.Millisecond
+
+//runAll runs every task that is due at now, all of them get work
+func runAll(s *Scheduler, now time.Time) (tasks []Task) {
+	for {
+		task, _ := s.Next(now)
+		if task.Action == Wait {
+			return
+		}
+		s.Done(task, true, now)
+		tasks = append(tasks, task)
+	}
+}
+
+func TestSchedulerCleanFirst(t *testing.T) {
+	now := time.Now()
+	s := NewScheduler(3, map[int]bool{2: true}, testPollDelay, time.Second, now)
+	if tasks := runAll(s, now); len(tasks) != 2 || tasks[0] != (Task{0, Refresh}) || tasks[1] != (Task{2, Refresh}) {
+		t.Fatal("boards not given work at start", tasks)
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	s.Clean()
+	if tasks := runAll(s, now); len(tasks) != 2 || tasks[0] != (Task{0, Clean}) || tasks[1] != (Task{2, Clean}) {
+		t.Error("clean job not broadcast to every board", tasks)
+	}
+}
+
+func TestSchedulerDeadlines(t *testing.T) {
+	now := time.Now()
+	s := NewScheduler(2, nil, testPollDelay, 50*time.Millisecond, now)
+	runAll(s, now)
+	task, wait := s.Next(now)
+	if task.Action != Wait || wait != 2*testPollDelay {
+		t.Fatal("boards not read once per round", task, wait)
+	}
+
+	now = now.Add(2 * testPollDelay)
+	if tasks := runAll(s, now); len(tasks) != 2 || tasks[0].Action != Read {
+		t.Error("boards not read when due", tasks)
+	}
+	now = now.Add(30 * time.Millisecond)
+	if tasks := runAll(s, now); len(tasks) != 2 || tasks[0].Action != Refresh || tasks[1].Action != Refresh {
+		t.Error("boards not refreshed after the nonce traverse timeout", tasks)
+	}
+}
+
+func TestSchedulerRetryWithoutWork(t *testing.T) {
+	now := time.Now()
+	s := NewScheduler(1, nil, testPollDelay, time.Second, now)
+	task, _ := s.Next(now)
+	s.Done(task, false, now)
+	now = now.Add(testPollDelay)
+	if task, _ := s.Next(now); task.Action != Refresh {
+		t.Error("board without work not retried", task)
+	}
+}
+
+func TestSchedulerAdaptiveReads(t *testing.T) {
+	now := time.Now()
+	s := NewScheduler(2, nil, testPollDelay, time.Hour, now)
+	runAll(s, now)
+	for i := 0; i < 100; i++ {
+		s.Nonce(0)
+	}
+	now = now.Add(time.Second)
+	reads := make(map[int]int)
+	for end := now.Add(time.Second); now.Before(end); now = now.Add(testPollDelay) {
+		for _, task := range runAll(s, now) {
+			reads[task.Board]++
+		}
+	}
+	if s.Rate(0) <= 0 || s.Rate(1) != 0 {
+		t.Fatal("unexpected rates", s.Rate(0), s.Rate(1))
+	}
+	if reads[0] < 3*reads[1] || reads[1] == 0 {
+		t.Error("busy board not read more often", reads)
+	}
+}
+
+func TestSchedulerQuarantine(t *testing.T) {
+	now := time.Now()
+	s := NewScheduler(2, nil, testPollDelay, time.Hour, now)
+	runAll(s, now)
+	s.Quarantine(1, now.Add(time.Second))
+	s.Clean()
+	for _, task := range runAll(s, now.Add(time.Second/2)) {
+		if task.Board == 1 {
+			t.Fatal("quarantined board scheduled", task)
+		}
+	}
+	if task, _ := s.Next(now.Add(time.Second)); task != (Task{1, Clean}) {
+		t.Error("released board did not get the clean job", task)
+	}
+}
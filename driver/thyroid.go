@@ -4,11 +4,9 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"log"
-	"net"
+	"math"
 	"os/exec"
 	"path"
 	"strings"
@@ -19,27 +17,37 @@ import (
 
 	"github.com/AGPFMiner/gominer/boardman"
 	"github.com/AGPFMiner/gominer/clients"
-	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/driver/capture"
+	"github.com/AGPFMiner/gominer/driver/protocol"
+	"github.com/AGPFMiner/gominer/driver/transport"
+	"github.com/AGPFMiner/gominer/events"
 	"github.com/AGPFMiner/gominer/mining"
 	"github.com/AGPFMiner/gominer/statistics"
 	"github.com/AGPFMiner/gominer/types"
 	"github.com/spf13/viper"
 	"github.com/stianeikeland/go-rpio"
 
-	"github.com/jacobsa/go-serial/serial"
 	"github.com/jinzhu/copier"
 	"go.uber.org/zap"
 )
 
 type SingleNonce struct {
-	jobid uint8
-	nonce [8]byte
+	jobid  uint8
+	nonce  [8]byte
+	board  int
+	work   MiningWork
+	status NonceStatus
 }
 
 type Thyroid struct {
 	shareCounter       uint64
 	goldennonceCounter uint64
-	wronghashCounter   uint64
+	staleCounter       uint64
+	//hashrate is the recent hashrate of the chain and hardwareBits the target last programmed,
+	// they pick the hardware target of the next job
+	hashrate     uint64
+	hardwareBits uint64
 
 	driverQuit        chan struct{}
 	FPGADevice        string
@@ -50,25 +58,39 @@ type Thyroid struct {
 	muxNums           int
 	blockTimeField    []byte
 	skippedSlots      map[int]bool
+	//programTarget is set if the bitstream speaks checked frames, it takes a hardware target per job
+	programTarget bool
 
 	Client                          clients.Client
 	PollDelay, NonceTraverseTimeout time.Duration
 	logger                          *zap.Logger
-	port                            io.ReadWriteCloser
+	port                            transport.Transport
 	nonceChan                       chan SingleNonce
 
-	workCacheLock     *sync.RWMutex
 	readNoncePacket   []byte
-	boardJobID        uint8
-	jobBoardIDMap     map[uint8]int
 	chanSlot          map[int]chan bool
-	workCache         map[uint8]MiningWork
+	works             *WorkCache
+	nonceStatsLock    *sync.Mutex
 	nonceStats        map[int]uint64
+	nonceClasses      map[int]*types.NonceClasses
+	prevNonceStats    map[int]uint64
 	prevEpochEnd      time.Time
 	prevEpochNonceNum uint64
 	hr                *statistics.HashRate
+	history           *statistics.Store
+	events            *events.Bus
+	scheduler         *Scheduler
+	selectedBoard     int
+	boardOffset       int
+	totalSource       string
+	capturePath       string
+	capture           *capture.File
 	stats             types.HardwareStats
 	feedDog           chan bool
+	//portMutex guards swapping the port in Start against GetDriverStats,
+	// running counts mine and readNonces, the goroutines using the port and the capture
+	portMutex sync.Mutex
+	running   sync.WaitGroup
 }
 
 func NewThyroid(args mining.MinerArgs) (drv Driver) {
@@ -98,7 +120,15 @@ func (thy *Thyroid) GetDriverStats() (stats types.DriverStates) {
 	stats.NonceNum[0], stats.NonceNum[1], stats.NonceNum[2] = oneMin, fiveMin, oneHour
 	stats.Hashrate[0], stats.Hashrate[1], stats.Hashrate[2] = oneMin*FourGiga/60, fiveMin*FourGiga/300, oneHour*FourGiga/3600
 	stats.NonceStats = &thy.nonceStats
+	stats.Stale = atomic.LoadUint64(&thy.staleCounter)
+	stats.Nonces = thy.getNonceClasses(-1)
 	stats.Algo = thy.Client.AlgoName()
+	thy.portMutex.Lock()
+	if thy.port != nil {
+		transportStats := thy.port.Stats()
+		stats.Transport = &transportStats
+	}
+	thy.portMutex.Unlock()
 
 	if thy.muxNums > 1 {
 		stats.Temperature, stats.Voltage = "WIP", "WIP"
@@ -126,10 +156,13 @@ func (thy *Thyroid) GetDriverStatsMulti() (statsMulti []*types.DriverStates) {
 		stats.DriverName = "Thyroid"
 		stats.Status = thy.stats
 
+		thy.nonceStatsLock.Lock()
 		norm := float64(thy.nonceStats[board]) / float64(totalNonces)
+		thy.nonceStatsLock.Unlock()
 		stats.NonceNum[0], stats.NonceNum[1], stats.NonceNum[2] = oneMin*norm, fiveMin*norm, oneHour*norm
 		stats.Hashrate[0], stats.Hashrate[1], stats.Hashrate[2] = oneMin*FourGiga*norm/60, fiveMin*FourGiga*norm/300, oneHour*FourGiga*norm/3600
 		stats.NonceStats = &thy.nonceStats
+		stats.Nonces = thy.getNonceClasses(board)
 		stats.Algo = thy.Client.AlgoName()
 
 		if thy.stats != types.Programming || !isOpenocdRunning() {
@@ -165,6 +198,7 @@ func (thy *Thyroid) Init(args interface{}) {
 	thy.PollDelay = argsn.PollDelay
 	thy.NonceTraverseTimeout = argsn.NonceTraverseTimeout
 	thy.muxNums = argsn.MuxNums
+	thy.port = argsn.Transport
 	if thy.muxNums > 1 {
 		log.Println("Opening GPIO")
 		err := rpio.Open()
@@ -175,29 +209,41 @@ func (thy *Thyroid) Init(args interface{}) {
 	if thy.logger == nil {
 		thy.logger = argsn.Logger
 	}
+	thy.history = argsn.History
+	thy.events = argsn.Events
+	thy.boardOffset = argsn.BoardOffset
+	thy.capturePath = argsn.Capture
+	thy.totalSource = argsn.TotalSource
+	if thy.totalSource == "" {
+		thy.totalSource = statistics.SourceTotal
+	}
 
-	thy.readNoncePacket, _ = hex.DecodeString(nonceReadCtrlAddr + pullHigh)
+	thy.readNoncePacket = protocol.NonceRead()
 	thy.cleanJobChannel = make(chan bool)
 	thy.shareCounter = 0
 	thy.goldennonceCounter = 0
-	thy.wronghashCounter = 0
-	thy.boardJobID = 0
-	thy.jobBoardIDMap = make(map[uint8]int)
-	thy.workCacheLock = &sync.RWMutex{}
+	thy.staleCounter = 0
 	thy.chanSlot = make(map[int]chan bool)
 	thy.nonceChan = make(chan SingleNonce, 100)
-	thy.workCache = make(map[uint8]MiningWork)
+	thy.works = NewWorkCache()
+	thy.nonceStatsLock = &sync.Mutex{}
 	thy.nonceStats = make(map[int]uint64)
+	thy.nonceClasses = make(map[int]*types.NonceClasses)
+	thy.prevNonceStats = make(map[int]uint64)
 	thy.prevEpochEnd = time.Now()
 	thy.prevEpochNonceNum = 0
 	thy.hr = &statistics.HashRate{}
 	thy.blockTimeField = []byte{}
 	thy.skippedSlots = make(map[int]bool)
-	skipslots := viper.GetIntSlice("skipslots")
+	skipslots := argsn.SkipSlots
+	if skipslots == nil {
+		skipslots = viper.GetIntSlice("skipslots")
+	}
 	for _, slot := range skipslots {
 		thy.skippedSlots[slot] = true
 	}
-
+	thy.newScheduler()
+	thy.selectedBoard = -1
 }
 
 const (
@@ -297,10 +343,12 @@ func (thy *Thyroid) ProgramBitstream(bitstreamFilePath string) (err error) {
 			boardman.SelectJTAG(uint8(board + 1))
 			time.Sleep(time.Millisecond * 10)
 			err = programBit(path.Join(BitStreamDir, bitstreamName))
+			thy.publishProgrammed(board, bitstreamName, err)
 		}
 	}
 	if thy.muxNums == 1 {
 		err = programBit(path.Join(BitStreamDir, bitstreamName))
+		thy.publishProgrammed(0, bitstreamName, err)
 	}
 
 	thy.stats = types.Running
@@ -310,29 +358,58 @@ func (thy *Thyroid) ProgramBitstream(bitstreamFilePath string) (err error) {
 //Start spawns a seperate miner for each device defined in the FPGADevices and feeds it with work
 func (thy *Thyroid) Start() {
 	thy.driverQuit = make(chan struct{})
+	thy.newScheduler()
+	thy.selectedBoard = -1
 
 	go thy.nonceStatistic()
 	log.Println("Starting thyroid driver")
 	thy.miningWorkChannel = make(chan *MiningWork, 1)
+	thy.subscribeJobs()
 	go thy.createWork()
 
 	thy.initPort()
+	thy.openCapture()
 	time.Sleep(618 * time.Millisecond)
-	switch thy.Client.AlgoName() {
-	case "odocrypt", "ckb":
-		go thy.readNonceNewProtocol()
-	default:
-		go thy.readNonce()
-	}
+	reader := protocol.NewReader(thy.port, nonceFormat(thy.Client.AlgoName()))
+	thy.handshake(reader)
+	thy.running.Add(2)
+	go thy.readNonces(reader)
 	go thy.processNonce()
 
-	go thy.minePollVer()
+	go thy.mine()
 	go thy.watchDog()
 }
 
+//Tune changes the poll delay and nonce traverse timeout of a running driver
+func (thy *Thyroid) Tune(pollDelay, nonceTraverseTimeout time.Duration) {
+	atomic.StoreInt64((*int64)(&thy.PollDelay), int64(pollDelay))
+	atomic.StoreInt64((*int64)(&thy.NonceTraverseTimeout), int64(nonceTraverseTimeout))
+	thy.scheduler.Tune(pollDelay*time.Millisecond, nonceTraverseTimeout*time.Millisecond)
+}
+
+//newScheduler schedules the boards from scratch, every board that is not skipped is due for work
+func (thy *Thyroid) newScheduler() {
+	pollDelay := time.Duration(atomic.LoadInt64((*int64)(&thy.PollDelay)))
+	nonceTraverseTimeout := time.Duration(atomic.LoadInt64((*int64)(&thy.NonceTraverseTimeout)))
+	thy.scheduler = NewScheduler(thy.muxNums, thy.skippedSlots, pollDelay*time.Millisecond, nonceTraverseTimeout*time.Millisecond, time.Now())
+}
+
+//Quarantine keeps a board idle for d, e.g. while it returns invalid nonces, it gets new work afterwards
+func (thy *Thyroid) Quarantine(board int, d time.Duration) {
+	thy.scheduler.Quarantine(board, time.Now().Add(d))
+}
+
+//Stop stops mining and closes the port, the capture is closed once nothing writes to it anymore.
+// The client no longer calls the driver, a client the chain leaves cannot mark the work of the next one stale.
 func (thy *Thyroid) Stop() {
+	thy.Client.UnsubscribeJobs(thy)
 	close(thy.driverQuit)
 	thy.port.Close()
+	thy.running.Wait()
+	if thy.capture != nil {
+		thy.capture.Close()
+		thy.capture = nil
+	}
 }
 
 func (thy *Thyroid) selectBoard(board int) {
@@ -342,22 +419,36 @@ func (thy *Thyroid) selectBoard(board int) {
 	// cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf("sudo /home/pi/towc/PI console %d", board+1))
 	// cmd.Run()
 	boardman.SelectConsole(uint8(board + 1))
+	thy.selectedBoard = board
+	if thy.capture != nil {
+		thy.capture.SetBoard(board)
+	}
 }
 
-func (thy *Thyroid) createWork() {
-	//Register a function to clear the generated work if a job gets deprecated.
-	// It does not matter if we clear too many, it is worse to work on a stale job.
-	thy.Client.SetDeprecatedJobCall(func(jobid string) {
+//subscribeJobs has the client clear the generated work if a job gets deprecated and signal clean jobs,
+// until Stop unsubscribes. The other drivers mining on the client keep their own calls.
+func (thy *Thyroid) subscribeJobs() {
+	work, quit := thy.miningWorkChannel, thy.driverQuit
+	//It does not matter if we clear too many, it is worse to work on a stale job.
+	thy.Client.SubscribeJobs(thy, func(jobid string) {
 		// log.Println("createWork: Force cleanning job.")
-		numberOfWorkItemsToRemove := len(thy.miningWorkChannel) * 1
+		numberOfWorkItemsToRemove := len(work) * 1
 		for i := 0; i <= numberOfWorkItemsToRemove; i++ {
-			<-thy.miningWorkChannel
+			select {
+			case <-work:
+			case <-quit:
+				return
+			}
+		}
+	}, func() {
+		select {
+		case thy.cleanJobChannel <- true:
+		case <-quit:
 		}
 	})
+}
 
-	thy.Client.SetCleanJobEventCall(func() {
-		thy.cleanJobChannel <- true
-	})
+func (thy *Thyroid) createWork() {
 
 	var target, header []byte
 	var difficulty float64
@@ -400,16 +491,31 @@ func (thy *Thyroid) createWork() {
 		default:
 		}
 
-		thy.miningWorkChannel <- &MiningWork{header, 0, target, difficulty, job}
+		thy.miningWorkChannel <- &MiningWork{Header: header, Target: target, Difficulty: difficulty, Job: job}
 	}
 }
 
 var (
-	startMine, _ = hex.DecodeString(startMineCtrlAddr + pullHigh)
-	initcnt, _   = hex.DecodeString(initCnt0 + pullLow + initCnt1 + pullLow)
-	junkChunk, _ = hex.DecodeString("061c" + "aabbccdd")
+	startMine = protocol.StartMine()
+	initcnt   = protocol.InitCounters()
+	junkChunk = protocol.Write(protocol.RegJunk, 0xaabbccdd).Encode()
 )
 
+const (
+	preDispatchPause  = 100 * time.Microsecond
+	postDispatchPause = time.Millisecond
+	//DispatchPause is the time the driver waits around every header it writes
+	DispatchPause = preDispatchPause + postDispatchPause
+)
+
+//PacketSizes is the number of bytes written to the serial port to poll a board for nonces
+// and to dispatch header to it, including the hardware target of bitstreams speaking checked frames
+func PacketSizes(funcs MiningFuncs, header []byte) (poll, dispatch int) {
+	poll = len(protocol.NonceRead())
+	dispatch = poll + len(protocol.Target(GoldenBits)) + len(funcs.ConstructHeaderPackets(header, 1)) + len(startMine)
+	return
+}
+
 func (thy *Thyroid) writeInitCnt() {
 	thy.port.Write(initcnt)
 }
@@ -437,148 +543,210 @@ func min(a, b int) int {
 
 func (thy *Thyroid) getNonceSum() (sum uint64) {
 	sum = 0
+	thy.nonceStatsLock.Lock()
+	defer thy.nonceStatsLock.Unlock()
 	for _, v := range thy.nonceStats {
 		sum += v
 	}
 	return
 }
 
-func (thy *Thyroid) readNonce() {
-	thy.logger.Debug("start read nonce")
-	scanner := bufio.NewScanner(thy.port)
-	nonceStatsMutex := &sync.Mutex{}
-	split := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		thy.logger.Debug("UART Data", zap.String("Buffer", fmt.Sprintf("%02X", data)))
-		if len(data) < 9 {
-			return 0, nil, nil
+//countClass counts a checked nonce of board
+func (thy *Thyroid) countClass(board int, class NonceClass) {
+	thy.updateClasses(board, func(classes *types.NonceClasses) {
+		switch class {
+		case NonceHardwareError:
+			classes.HardwareErrors++
+		case NonceBelowTarget:
+			classes.BelowTarget++
+		case NonceShare:
+			classes.Shares++
+		case NonceUnhashed:
+			classes.Unhashed++
+		case NonceGolden:
+			classes.Golden++
 		}
-		index := 0
-		for index = 0; index < len(data)-8; index++ {
-			if bytes.Equal(data[index:index+8], []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}) {
-				nonceNum := int(data[index+8])
-				nonceLen := nonceNum * 9
-
-				if len(data) < index+9+nonceLen { // waiting for more data
-					return 0, nil, nil
-				}
+	})
+}
 
-				if nonceNum > 0 {
-					if int(data[index+9]) == 0 { // jobid will never be zero
-						return 1, nil, nil
-					}
-				}
-				return index + 9 + nonceLen, data[index+9 : index+9+nonceLen], nil
-			}
-		}
+//updateClasses changes the nonce counts of board
+func (thy *Thyroid) updateClasses(board int, update func(*types.NonceClasses)) {
+	thy.nonceStatsLock.Lock()
+	defer thy.nonceStatsLock.Unlock()
+	classes := thy.nonceClasses[board]
+	if classes == nil {
+		classes = &types.NonceClasses{}
+		thy.nonceClasses[board] = classes
+	}
+	update(classes)
+}
 
-		if atEOF {
-			return 0, nil, io.EOF
+//getNonceClasses returns the checked nonces of board, of all boards for -1
+func (thy *Thyroid) getNonceClasses(board int) (classes types.NonceClasses) {
+	thy.nonceStatsLock.Lock()
+	defer thy.nonceStatsLock.Unlock()
+	for b, c := range thy.nonceClasses {
+		if board < 0 || b == board {
+			classes.Add(*c)
 		}
-		return 0, nil, nil
 	}
-	scanner.Split(split)
-	for scanner.Scan() {
-		nonces := scanner.Bytes()
-		noncesLen := len(nonces)
-		for i := 0; i < noncesLen; i += 9 {
-			if nonces[i] == byte(0) {
-				continue
-			}
-			var singleNonce SingleNonce
-			singleNonce.jobid = uint8(nonces[i])
-			for j := 0; j < 8; j++ {
-				singleNonce.nonce[j] = nonces[i+1+j]
-			}
+	return
+}
 
-			go func(board int) {
-				nonceStatsMutex.Lock()
-				thy.nonceStats[board]++
-				nonceStatsMutex.Unlock()
-			}(thy.jobBoardIDMap[singleNonce.jobid])
-			thy.logger.Debug("Parsed Nonce", zap.Int("BoardID", thy.jobBoardIDMap[singleNonce.jobid]), zap.String("SingleNonce", fmt.Sprintf("%02X", singleNonce.nonce)), zap.Uint8("JobID", singleNonce.jobid))
+//lookupWork attaches the cached work of its job to a nonce read from the boards and records the nonce to tell duplicates
+func (thy *Thyroid) lookupWork(nonce *SingleNonce) {
+	nonce.work, nonce.board, nonce.status = thy.works.Report(nonce.jobid, nonce.nonce)
+}
 
-			thy.nonceChan <- singleNonce
-		}
+func (thy *Thyroid) countNonce(board int) {
+	if board < 0 {
+		return
 	}
-	thy.logger.Debug("Scanner exited.")
-
-	return
+	thy.nonceStatsLock.Lock()
+	thy.nonceStats[board]++
+	thy.nonceStatsLock.Unlock()
+	thy.scheduler.Nonce(board)
 }
 
-func (thy *Thyroid) readNonceNewProtocol() {
-	log.Print("start read nonce (new protocol)")
-	scanner := bufio.NewScanner(thy.port)
-	nonceStatsMutex := &sync.Mutex{}
-	split := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		thy.logger.Debug("UART Data", zap.String("Buffer", fmt.Sprintf("%02X", data)))
-		datalen := len(data)
-		if datalen < 8 {
-			return 0, nil, nil
+//readNonces passes the nonce reports of the boards on to processNonce until the port is closed
+func (thy *Thyroid) readNonces(reader *protocol.Reader) {
+	defer thy.running.Done()
+	defer thy.logger.Debug("Scanner exited.")
+	log.Print("start read nonce (", reader.Format(), " frames)")
+	for {
+		frame, err := reader.Next()
+		if frame.Skipped > 0 {
+			thy.port.ParseError()
+			thy.logger.Debug("Resync", zap.Int("Skipped", frame.Skipped))
 		}
-		first89abcd := bytes.Index(data, []byte{0x89, 0xab, 0xcd})
-
-		if first89abcd < 0 {
-			return 0, nil, nil
+		if err != nil {
+			return
 		}
-		if datalen-first89abcd < 8 {
-			return 0, nil, nil
+		for i := 0; i < frame.Dropped; i++ {
+			thy.port.ParseError()
 		}
-
-		return first89abcd + 8, data[first89abcd+3 : first89abcd+8], nil
-	}
-	scanner.Split(split)
-	for scanner.Scan() {
-		nonce := scanner.Bytes()
-		var singleNonce SingleNonce
-		singleNonce.jobid = uint8(nonce[0])
-		if singleNonce.jobid == 0 {
+		if frame.Type != protocol.TypeNonce {
 			continue
 		}
-		copy(singleNonce.nonce[4:], stratum.ReverseByteSlice(nonce[1:5]))
-
-		go func(board int) {
-			nonceStatsMutex.Lock()
-			thy.nonceStats[board]++
-			nonceStatsMutex.Unlock()
-		}(thy.jobBoardIDMap[singleNonce.jobid])
-		thy.logger.Debug("Parsed Nonce", zap.Int("BoardID", thy.jobBoardIDMap[singleNonce.jobid]), zap.String("SingleNonce", fmt.Sprintf("%02X", singleNonce.nonce)), zap.Uint8("JobID", singleNonce.jobid))
-
-		thy.nonceChan <- singleNonce
+		for _, report := range frame.Reports {
+			thy.port.FrameIn()
+			singleNonce := SingleNonce{jobid: report.JobID, nonce: report.Nonce}
+			thy.lookupWork(&singleNonce)
+			go thy.countNonce(singleNonce.board)
+			thy.logger.Debug("Parsed Nonce", zap.Int("BoardID", singleNonce.board), zap.String("SingleNonce", fmt.Sprintf("%02X", singleNonce.nonce)), zap.Uint8("JobID", singleNonce.jobid))
+
+			select {
+			case thy.nonceChan <- singleNonce:
+			case <-thy.driverQuit:
+				return
+			}
+		}
 	}
+}
 
-	thy.logger.Debug("Scanner exited.")
+//handshake asks the first board that is not skipped for the version of its bitstream and switches
+// the reader to checked frames if the bitstream speaks them. Boards that do not answer keep the format
+// of the algorithm.
+func (thy *Thyroid) handshake(reader *protocol.Reader) {
+	for board := 0; board < thy.muxNums; board++ {
+		if thy.skippedSlots[board+1] {
+			continue
+		}
+		thy.selectBoard(board)
+		break
+	}
+	hello, err := reader.Handshake(thy.port, protocol.HandshakeTimeout)
+	thy.port.FrameOut()
+	meta := capture.Meta{Algo: thy.Client.AlgoName(), Device: thy.port.Name(), BoardOffset: thy.boardOffset}
+	thy.programTarget = false
+	atomic.StoreUint64(&thy.hardwareBits, 0)
+	if err != nil {
+		thy.logger.Info("Bitstream", zap.String("Version", "unknown"), zap.Error(err))
+	} else {
+		thy.logger.Info("Bitstream", zap.Stringer("Version", hello.Version), zap.Bool("Checked", hello.Checked))
+		if hello.Checked {
+			reader.SetFormat(protocol.FormatChecked)
+			thy.programTarget = true
+		}
+		meta.Version = hello.Version.String()
+	}
+	if thy.capture != nil {
+		meta.Format = reader.Format().String()
+		thy.capture.Meta(meta)
+	}
+}
 
-	return
+//nonceFormat is the format the bitstreams of the algorithm report nonces in unless the handshake tells otherwise
+func nonceFormat(algo string) protocol.Format {
+	switch algo {
+	case "odocrypt", "ckb":
+		return protocol.FormatMagic
+	}
+	return protocol.FormatLegacy
 }
 
+//hashrateWindow is the number of seconds the hashrate picking the hardware target is averaged over
+const hashrateWindow = 10
+
 func (thy *Thyroid) nonceStatistic() {
+	samples := 0
 	for {
 		select {
 		case <-thy.driverQuit:
 			return
 		case <-time.After(time.Second * 1):
-			var diffMultiplier float64
-			switch thy.Client.AlgoName() {
-			case "odocrypt":
-				stats := thy.Client.GetPoolStats()
-				diffMultiplier = stats.Diff
-			case "veo":
-				diffMultiplier = 1.0
-			case "skunk":
-				diffMultiplier = 1.0 / 256
-			case "xdag":
-				diffMultiplier = 1.0
-			case "ckb":
-				diffMultiplier = 1.0
-			}
-			periodNonceCnt := thy.goldennonceCounter - thy.prevEpochNonceNum
+			//the nonces are weighted to the 4G hashes of a nonce at difficulty 1
+			diffMultiplier := thy.hashesPerNonce() / FourGiga
+			goldenNonces := atomic.LoadUint64(&thy.goldennonceCounter)
+			periodNonceCnt := goldenNonces - thy.prevEpochNonceNum
 			nonceCntWithWeight := float64(periodNonceCnt) * diffMultiplier
 			thy.hr.Add(nonceCntWithWeight)
-			thy.prevEpochNonceNum = thy.goldennonceCounter
+			if samples < hashrateWindow {
+				samples++
+			}
+			hashrate := thy.hr.RecentNSum(samples) * FourGiga / float64(samples)
+			atomic.StoreUint64(&thy.hashrate, math.Float64bits(hashrate))
+			thy.prevEpochNonceNum = goldenNonces
+			thy.recordHistory(diffMultiplier, nonceCntWithWeight)
 		}
 	}
 }
 
+//hashesPerNonce is the expected number of hashes behind a nonce of the boards, the pool difficulty
+// matters for boards returning nonces at the pool target. Boards taking a hardware target report nonces
+// at the one last programmed.
+func (thy *Thyroid) hashesPerNonce() float64 {
+	if bits := atomic.LoadUint64(&thy.hardwareBits); bits > 0 {
+		return math.Ldexp(1, int(bits))
+	}
+	a, ok := difficulty.Lookup(thy.Client.AlgoName())
+	if !ok {
+		return 0
+	}
+	var poolDiff float64
+	if a.BoardBits == 0 {
+		poolDiff = thy.Client.GetPoolStats().Diff
+	}
+	return a.HashesPerNonce(poolDiff)
+}
+
+//recordHistory stores the hashrate of the last second per board and in total
+func (thy *Thyroid) recordHistory(diffMultiplier, totalNonces float64) {
+	if thy.history == nil {
+		return
+	}
+	now := time.Now()
+	thy.history.Record(statistics.MetricHashrate, thy.totalSource, now, totalNonces*FourGiga)
+
+	thy.nonceStatsLock.Lock()
+	defer thy.nonceStatsLock.Unlock()
+	for board := 0; board < thy.muxNums; board++ {
+		delta := thy.nonceStats[board] - thy.prevNonceStats[board]
+		thy.prevNonceStats[board] = thy.nonceStats[board]
+		thy.history.Record(statistics.MetricHashrate, statistics.BoardSource(thy.boardOffset+board), now, float64(delta)*diffMultiplier*FourGiga)
+	}
+}
+
 func (thy *Thyroid) watchDog() {
 	timeout := time.Second * 30
 	for {
@@ -602,27 +770,43 @@ func (thy *Thyroid) processNonce() {
 		case <-thy.driverQuit:
 			return
 		case nNonce := <-thy.nonceChan:
-			// thy.workCacheLock.RLock()
-			cachedWork := thy.workCache[nNonce.jobid]
-			// thy.workCacheLock.RUnlock()
 			thy.feedDog <- true
-			thy.goldennonceCounter++
-			if cachedWork.Header != nil {
-				go thy.checkAndSubmitJob(nNonce, cachedWork)
+			atomic.AddUint64(&thy.goldennonceCounter, 1)
+			thy.events.Publish(events.New(events.NonceFound, thy.boardOffset+nNonce.board, -1, map[string]interface{}{
+				"jobid": nNonce.jobid,
+				"nonce": fmt.Sprintf("%02X", nNonce.nonce),
+			}))
+			if nNonce.status == NonceDuplicate {
+				thy.updateClasses(nNonce.board, func(classes *types.NonceClasses) { classes.Duplicates++ })
+				thy.events.Publish(events.New(events.NonceDuplicate, thy.boardOffset+nNonce.board, -1, map[string]interface{}{
+					"jobid": nNonce.jobid,
+					"nonce": fmt.Sprintf("%02X", nNonce.nonce),
+				}))
+				continue
+			}
+			if nNonce.status != NonceFresh {
+				//the job was replaced by a clean one, its shares would be rejected anyway
+				atomic.AddUint64(&thy.staleCounter, 1)
+				thy.events.Publish(events.New(events.NonceStale, thy.boardOffset+nNonce.board, -1, map[string]interface{}{
+					"jobid": nNonce.jobid,
+					"nonce": fmt.Sprintf("%02X", nNonce.nonce),
+				}))
+				continue
 			}
+			go thy.checkAndSubmitJob(nNonce, nNonce.work)
 		}
 	}
 }
 
-var measuredTime time.Time
-var polldelayMeasuredTime time.Time
-
-func (thy *Thyroid) singleMinerOnce(boardID int, cleanJob, timeout bool) {
+//singleMinerOnce reads the nonces of a board and gives it new work if cleanJob or timeout is set.
+// It returns once the link is free for the next board, dispatched tells whether the board got work.
+func (thy *Thyroid) singleMinerOnce(boardID int, cleanJob, timeout bool) (dispatched bool) {
 	// cleanJob, timeout = false, false //for debug
 	var work *MiningWork
 	var continueMining bool
-	polldelayMeasuredTime = time.Now()
-	if thy.muxNums > 1 {
+	var measuredTime time.Time
+	polldelayMeasuredTime := time.Now()
+	if thy.muxNums > 1 && boardID != thy.selectedBoard {
 		measuredTime = time.Now()
 		thy.selectBoard(boardID)
 		time.Sleep(time.Microsecond * 1)
@@ -640,6 +824,7 @@ func (thy *Thyroid) singleMinerOnce(boardID int, cleanJob, timeout bool) {
 
 	if !cleanJob && !timeout {
 		thy.port.Write(thy.readNoncePacket)
+		thy.port.FrameOut()
 		thy.logger.Debug("Work", zap.String("Stat", "Write readnonce only"))
 	}
 
@@ -660,26 +845,28 @@ func (thy *Thyroid) singleMinerOnce(boardID int, cleanJob, timeout bool) {
 		}
 		thy.logger.Debug("Execution", zap.Duration("fetchwork", time.Since(measuredTime)))
 
-		thy.boardJobID++
-		if thy.boardJobID == 0 {
-			thy.boardJobID = 1
-		}
-
 		measuredTime = time.Now()
+		var targetPacket []byte
+		if thy.programTarget {
+			work.HardwareBits = thy.workBits(work)
+			atomic.StoreUint64(&thy.hardwareBits, uint64(work.HardwareBits))
+			targetPacket = protocol.Target(work.HardwareBits)
+		}
 		var backupWork MiningWork
 		copier.Copy(&backupWork, work)
-		// thy.workCacheLock.Lock()
-		thy.workCache[thy.boardJobID] = backupWork // cache valid works
-		// thy.workCacheLock.Unlock()
+		jobID := thy.works.Add(backupWork, boardID, measuredTime) // cache valid works
+		if thy.capture != nil {
+			thy.capture.Work(capture.Work{JobID: jobID, Header: work.Header, Target: work.Target, Difficulty: work.Difficulty, HardwareBits: work.HardwareBits})
+		}
 		thy.logger.Debug("Execution", zap.Duration("cacheWork", time.Since(measuredTime)))
 
 		measuredTime = time.Now()
-		headerPacket := thy.MiningFuncs[thy.Client.AlgoName()].ConstructHeaderPackets(work.Header, thy.boardJobID)
+		headerPacket := thy.MiningFuncs[thy.Client.AlgoName()].ConstructHeaderPackets(work.Header, jobID)
 		thy.logger.Debug("Execution", zap.Duration("constructPacket", time.Since(measuredTime)))
 
 		thy.logger.Debug("Write Packet",
 			zap.Int("BoardID", boardID),
-			zap.Uint8("jobID", thy.boardJobID),
+			zap.Uint8("jobID", jobID),
 			zap.Bool("CleanJob", cleanJob),
 			zap.Bool("Timeout", timeout),
 			zap.String("Header", fmt.Sprintf("%02X", work.Header)))
@@ -687,155 +874,193 @@ func (thy *Thyroid) singleMinerOnce(boardID int, cleanJob, timeout bool) {
 		measuredTime = time.Now()
 		thy.logger.Debug("Execution", zap.Duration("constructPacket", time.Since(constructStart)))
 
-		time.Sleep(time.Microsecond * 100)
-		_, err := thy.port.Write(append(thy.readNoncePacket, append(headerPacket, startMine...)...))
-		time.Sleep(time.Millisecond * 1)
+		time.Sleep(preDispatchPause)
+		_, err := thy.port.Write(append(thy.readNoncePacket, append(targetPacket, append(headerPacket, startMine...)...)...))
+		thy.port.FrameOut()
+		time.Sleep(postDispatchPause)
 		if err != nil {
 			thy.logger.Error("port.Write", zap.Error(err))
 		}
 		thy.logger.Debug("Execution", zap.Duration("writeHeaderAndTrigger", time.Since(measuredTime)))
 
-		thy.jobBoardIDMap[thy.boardJobID] = boardID
+		dispatched = true
+		thy.events.Publish(events.New(events.JobDispatched, thy.boardOffset+boardID, -1, map[string]interface{}{
+			"jobid":   jobID,
+			"clean":   cleanJob,
+			"timeout": timeout,
+		}))
 	}
 DELAY:
 	// if !cleanJob {
 	instrConsume := time.Since(polldelayMeasuredTime)
-	if instrConsume < thy.PollDelay*time.Millisecond {
-		time.Sleep(time.Millisecond*thy.PollDelay - instrConsume)
+	pollDelay := time.Duration(atomic.LoadInt64((*int64)(&thy.PollDelay)))
+	if instrConsume < pollDelay*time.Millisecond {
+		time.Sleep(time.Millisecond*pollDelay - instrConsume)
 	}
 	// }
+	return
+}
+
+//workBits is the hardware target of work for the hashrate the chain was measured at
+func (thy *Thyroid) workBits(work *MiningWork) uint {
+	var shareBits uint
+	if a, ok := difficulty.Lookup(thy.Client.AlgoName()); ok {
+		shareBits = a.ShareBits(work.Target, work.Difficulty)
+	}
+	hashrate := math.Float64frombits(atomic.LoadUint64(&thy.hashrate))
+	return hardwareBits(shareBits, hashrate, thy.BaudRate)
 }
 
-func (thy *Thyroid) checkAndSubmitJob(nNonce SingleNonce, work MiningWork) (goodNonce bool) {
-	goodNonce = false
+//checkAndSubmitJob classifies a nonce of fresh work, counts it and submits it if it is a share
+func (thy *Thyroid) checkAndSubmitJob(nNonce SingleNonce, work MiningWork) (class NonceClass) {
 	nonce := nNonce.nonce[:]
 	jobid := nNonce.jobid
 	workHeader := append(work.Header, nonce...)
-	blockhash := thy.MiningFuncs[thy.Client.AlgoName()].RegenHash(workHeader)
+	funcs := thy.MiningFuncs[thy.Client.AlgoName()]
+	blockhash := funcs.RegenHash(workHeader)
 	thy.logger.Debug("SubmitJob",
 		zap.String("Block", fmt.Sprintf("%02X", workHeader)),
 		zap.String("BlockHash", fmt.Sprintf("%02X", blockhash)),
 		zap.String("Target", fmt.Sprintf("%02X", work.Target)),
 		zap.Float64("Difficulty", work.Difficulty),
+		zap.Uint("HardwareBits", work.HardwareBits),
 	)
-	if bytes.Equal(blockhash[0:3], []byte{0x00, 0x00, 0x00}) {
-		goodNonce = true
-		// thy.logger.Debug("SubmitJob", zap.String("Stat", "Golden nonce found!"))
-		// if stratum.CheckDifficultyReal(blockhash, work.Target) {
-		if thy.MiningFuncs[thy.Client.AlgoName()].DiffChecker(blockhash, work) {
-			thy.logger.Debug("SubmitJob", zap.String("Stat", "Share found!"))
-
-			var e error
-			if thy.Client.AlgoName() == "veo" {
-				nonce = workHeader
-			}
-			e = thy.Client.SubmitHeader(nonce, work.Job)
-			// }
-			if e != nil {
-				thy.logger.Info("SubmitJob",
-					zap.String("Stat", "Error submitting solution"),
-					zap.Uint8("jobID", jobid),
-					zap.Error(e),
-				)
-			} else {
-				thy.logger.Info("SubmitJob",
-					zap.String("Stat", "Accepted!"),
-					zap.Uint8("jobID", jobid),
-				)
-				atomic.AddUint64(&thy.shareCounter, 1)
-				//thy.shareCounter++
-			}
-			// }()
+	algo, _ := difficulty.Lookup(thy.Client.AlgoName())
+	class = classify(funcs, blockhash, work, algo.BoardChecked)
+	thy.countClass(nNonce.board, class)
+	switch class {
+	case NonceShare, NonceGolden:
+		thy.logger.Debug("SubmitJob", zap.String("Stat", "Share found!"), zap.Stringer("Class", class))
+
+		if thy.Client.AlgoName() == "veo" {
+			nonce = workHeader
+		}
+		e := thy.Client.SubmitHeader(nonce, work.Job)
+		thy.publishShare(nNonce, work.Difficulty, e)
+		if e != nil {
+			thy.logger.Info("SubmitJob",
+				zap.String("Stat", "Error submitting solution"),
+				zap.Uint8("jobID", jobid),
+				zap.Error(e),
+			)
 		} else {
-			// log.Println(miner.MinerID, "Correct hash but not satisfied with pool diff")
+			thy.logger.Info("SubmitJob",
+				zap.String("Stat", "Accepted!"),
+				zap.Uint8("jobID", jobid),
+			)
+			atomic.AddUint64(&thy.shareCounter, 1)
 		}
-		atomic.StoreUint64(&thy.wronghashCounter, 0)
-		// thy.wronghashCounter = 0
-	} else {
+	case NonceBelowTarget:
+		thy.logger.Debug("SubmitJob", zap.String("Stat", "Correct hash but not satisfied with pool diff"))
+	case NonceUnhashed:
+		thy.logger.Debug("SubmitJob", zap.String("Stat", "No host hash to check the nonce, dropped"), zap.String("Algo", thy.Client.AlgoName()))
+	case NonceHardwareError:
 		thy.logger.Warn("SubmitJob",
 			zap.String("WorkHeader", fmt.Sprintf("%02X", workHeader)),
 			zap.String("BlockHash", fmt.Sprintf("%02X", blockhash)),
 		)
 		thy.logger.Warn("SubmitJob", zap.String("Stat", "Wrong Hash"))
-		atomic.AddUint64(&thy.wronghashCounter, 1)
-		// thy.wronghashCounter++
+		thy.events.Publish(events.New(events.NonceInvalid, thy.boardOffset+nNonce.board, -1, map[string]interface{}{
+			"jobid": jobid,
+			"nonce": fmt.Sprintf("%02X", nNonce.nonce),
+		}))
 	}
 	return
 }
 
-func (thy *Thyroid) initPort() {
-	var err error
-	if strings.HasPrefix(thy.FPGADevice, "@") {
-		conn, err := net.Dial("tcp", strings.TrimPrefix(thy.FPGADevice, "@"))
-		if err != nil {
-			thy.logger.Error("initPort", zap.Error(err))
-		}
-		thy.port = conn
-	} else {
-		options := serial.OpenOptions{
-			PortName:        thy.FPGADevice,
-			BaudRate:        thy.BaudRate,
-			DataBits:        8,
-			StopBits:        1,
-			MinimumReadSize: 4,
-			// InterCharacterTimeout: 100,
-		}
-
-		// Open the port.
-		thy.port, err = serial.Open(options)
-		if err != nil {
-			thy.logger.Fatal("Port", zap.Error(err))
-		}
+func (thy *Thyroid) publishShare(nNonce SingleNonce, difficulty float64, err error) {
+	if thy.events == nil {
+		return
 	}
+	data := map[string]interface{}{
+		"jobid":      nNonce.jobid,
+		"algo":       thy.Client.AlgoName(),
+		"difficulty": difficulty,
+	}
+	eventType := events.ShareAccepted
+	if err != nil {
+		eventType = events.ShareRejected
+		data["reason"] = err.Error()
+	}
+	thy.events.Publish(events.New(eventType, thy.boardOffset+nNonce.board, -1, data))
 }
 
-func (thy *Thyroid) dispatchJob(cleanJob, timeout bool, startOffset int) {
-	for boardID := 0; boardID < thy.muxNums; boardID++ {
-		fixedBoardID := (boardID + startOffset) % thy.muxNums
-		// log.Printf("dispatch board: %d\n", fixedBoardID)
-		thy.singleMinerOnce(fixedBoardID, cleanJob, timeout)
+func (thy *Thyroid) publishProgrammed(board int, bitstream string, err error) {
+	data := map[string]interface{}{"bitstream": bitstream, "ok": err == nil}
+	if err != nil {
+		data["error"] = err.Error()
 	}
+	thy.events.Publish(events.New(events.BoardProgrammed, thy.boardOffset+board, -1, data))
 }
 
-func (thy *Thyroid) minePollVer() {
-	var cleanJob bool
-	var timeout bool
-	var lastRefresh []time.Time = make([]time.Time, thy.muxNums)
-	for i := range lastRefresh {
-		now := time.Now()
-		lastRefresh[i] = now
+func (thy *Thyroid) initPort() {
+	if thy.port == nil {
+		thy.portMutex.Lock()
+		thy.port = transport.New(thy.FPGADevice, thy.BaudRate)
+		thy.portMutex.Unlock()
+	}
+	if err := thy.port.Open(); err != nil {
+		thy.logger.Error("initPort", zap.String("device", thy.FPGADevice), zap.Error(err))
 	}
-	var boardID int = 0
+}
+
+//mine runs the tasks of the scheduler until the driver stops.
+// A clean job is queued for every board as soon as it arrives, even while waiting for a deadline.
+func (thy *Thyroid) mine() {
+	defer thy.running.Done()
 	for {
 		select {
 		case <-thy.driverQuit:
 			return
 		case <-thy.cleanJobChannel:
-			thy.workCache = make(map[uint8]MiningWork)
-			cleanJob, timeout = true, false
-			thy.dispatchJob(cleanJob, timeout, boardID)
-			for i := range lastRefresh {
-				now := time.Now()
-				lastRefresh[i] = now
-			}
+			thy.cleanWorks()
 			continue
 		default:
-			// log.Printf("mineonce board: %d\n", boardID)
-			if time.Now().Sub(lastRefresh[boardID]) > time.Millisecond*thy.NonceTraverseTimeout {
-				cleanJob, timeout = false, true
-				lastRefresh[boardID] = time.Now()
-				thy.singleMinerOnce(boardID, cleanJob, timeout)
-			} else {
-				cleanJob, timeout = false, false
-				thy.singleMinerOnce(boardID, cleanJob, timeout)
+		}
+
+		task, wait := thy.scheduler.Next(time.Now())
+		if task.Action == Wait {
+			select {
+			case <-thy.driverQuit:
+				return
+			case <-thy.cleanJobChannel:
+				thy.cleanWorks()
+			case <-time.After(wait):
 			}
+			continue
 		}
-		boardID++
-		boardID %= thy.muxNums
-		// if boardID == thy.muxNums {
-		// 	boardID = 0
-		// }
+		dispatched := thy.singleMinerOnce(task.Board, task.Action == Clean, task.Action == Refresh)
+		thy.scheduler.Done(task, dispatched, time.Now())
+	}
+}
+
+//cleanWorks turns the dispatched work stale and queues the clean job for every board
+func (thy *Thyroid) cleanWorks() {
+	thy.works.Clean()
+	if thy.capture != nil {
+		thy.capture.Clean()
+	}
+	thy.scheduler.Clean()
+}
+
+//openCapture records the traffic on the port if a capture file is set
+func (thy *Thyroid) openCapture() {
+	thy.portMutex.Lock()
+	defer thy.portMutex.Unlock()
+	if p, ok := thy.port.(*capture.Port); ok {
+		thy.port = p.Transport
+	}
+	if thy.capturePath == "" {
+		return
+	}
+	file, err := capture.Create(thy.capturePath)
+	if err != nil {
+		thy.logger.Error("openCapture", zap.String("file", thy.capturePath), zap.Error(err))
+		return
 	}
+	log.Print("Capturing the traffic to the boards in ", thy.capturePath)
+	thy.capture = file
+	thy.port = capture.NewPort(thy.port, file.Writer)
 }
 
 func (thy *Thyroid) readVersion() {
@@ -846,8 +1071,7 @@ func (thy *Thyroid) readVersion() {
 		thy.selectBoard(i)
 		log.Println("Now using board:", i)
 		time.Sleep(time.Millisecond * 200)
-		readVersion, _ := hex.DecodeString("050200000000")
-		thy.port.Write(readVersion)
+		thy.port.Write(protocol.VersionRead())
 		len, _ := thy.port.Read(data)
 		thy.logger.Info("Bitstream", zap.String("Version", fmt.Sprintf("%02X", data[:len])))
 		data = []byte{}
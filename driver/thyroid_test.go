@@ -0,0 +1,337 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/driver/capture"
+	"github.com/AGPFMiner/gominer/driver/protocol"
+	"github.com/AGPFMiner/gominer/driver/transport"
+	"github.com/AGPFMiner/gominer/events"
+	"github.com/AGPFMiner/gominer/mining"
+	"github.com/AGPFMiner/gominer/statistics"
+	"github.com/AGPFMiner/gominer/types"
+
+	"go.uber.org/zap"
+)
+
+const emuHeaderMark = 0x07
+
+//emuFuncs sends the 4 byte header behind a mark and the job ID, a nonce is golden if it repeats the header
+type emuFuncs struct{}
+
+func (emuFuncs) RegenHash(input []byte) []byte {
+	if bytes.Equal(input[:4], input[4:8]) {
+		return make([]byte, 32)
+	}
+	return bytes.Repeat([]byte{0xff}, 32)
+}
+func (emuFuncs) DiffChecker(hash []byte, work MiningWork) bool { return true }
+func (emuFuncs) ConstructHeaderPackets(header []byte, boardJobID uint8) []byte {
+	return append([]byte{emuHeaderMark, boardJobID}, header...)
+}
+
+//emuClient hands out the 4 byte big endian job number as header
+type emuClient struct {
+	clients.BaseClient
+	mutex     sync.Mutex
+	job       uint32
+	clean     map[interface{}]clients.CleanJobEventCall
+	submitted map[uint32]int
+	wrong     int
+}
+
+func (c *emuClient) GetHeaderForWork() (target []byte, difficulty float64, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	header = make([]byte, 4)
+	binary.BigEndian.PutUint32(header, c.job)
+	return nil, 1, header, nil, c.job, nil
+}
+
+func (c *emuClient) SubmitHeader(nonce []byte, job interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if binary.BigEndian.Uint32(nonce[:4]) != job.(uint32) {
+		c.wrong++
+	}
+	c.submitted[job.(uint32)]++
+	return nil
+}
+
+func (c *emuClient) SubscribeJobs(subscriber interface{}, deprecated clients.DeprecatedJobCall, clean clients.CleanJobEventCall) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.clean == nil {
+		c.clean = make(map[interface{}]clients.CleanJobEventCall)
+	}
+	c.clean[subscriber] = clean
+}
+
+func (c *emuClient) UnsubscribeJobs(subscriber interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.clean, subscriber)
+}
+
+//newJob starts a clean job
+func (c *emuClient) newJob() {
+	c.mutex.Lock()
+	c.job++
+	var calls []clients.CleanJobEventCall
+	for _, call := range c.clean {
+		calls = append(calls, call)
+	}
+	c.mutex.Unlock()
+	for _, call := range calls {
+		call()
+	}
+}
+
+func (c *emuClient) Start()                                           {}
+func (c *emuClient) Stop()                                            {}
+func (c *emuClient) AlgoName() string                                 { return "emu" }
+func (c *emuClient) PoolConnectionStates() types.PoolConnectionStates { return types.Alive }
+func (c *emuClient) GetPoolStats() types.PoolStates                   { return types.PoolStates{} }
+
+//emulate plays a board with a legacy bitstream, or one speaking checked frames that sends the
+// hardware targets written to it on targets. The nonce of every job is held back and sent on the
+// next poll after the following dispatch, so nonces of replaced jobs arrive late.
+func emulate(board net.Conn, format protocol.Format, targets chan<- uint32) {
+	readNonce, readVersion := protocol.NonceRead(), protocol.VersionRead()
+	var in []byte
+	var held, due []protocol.NonceReport
+	buf := make([]byte, 256)
+	for {
+		n, err := board.Read(buf)
+		if err != nil {
+			return
+		}
+		in = append(in, buf[:n]...)
+		for len(in) >= len(readNonce) {
+			switch {
+			case bytes.HasPrefix(in, readNonce):
+				in = in[len(readNonce):]
+				frame := protocol.EncodeReports(format, due)
+				due = nil
+				if _, err := board.Write(frame); err != nil {
+					return
+				}
+			case bytes.HasPrefix(in, readVersion):
+				in = in[len(readVersion):]
+				version := []byte{0x20, 0x19, 0x08, 0x01}
+				if format == protocol.FormatChecked {
+					version = protocol.EncodeVersion(0x20191001)
+				}
+				if _, err := board.Write(version); err != nil {
+					return
+				}
+			case in[0] == protocol.WriteCtrl && in[1] == protocol.RegTarget:
+				r, _ := protocol.DecodeRegister(in)
+				in = in[protocol.RegisterFrameLen:]
+				if format == protocol.FormatChecked {
+					targets <- r.Value
+				}
+			case in[0] == emuHeaderMark:
+				//job ID, then the header as the first half of the nonce
+				report := protocol.NonceReport{JobID: in[1]}
+				copy(report.Nonce[:], in[2:6])
+				in = in[6:]
+				due, held = append(due, held...), []protocol.NonceReport{report}
+			default:
+				in = in[1:]
+			}
+		}
+	}
+}
+
+func TestThyroidEmulated(t *testing.T) {
+	captured, err := ioutil.TempFile("", "gominer-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	captured.Close()
+	os.Remove(captured.Name())
+	defer os.Remove(captured.Name())
+
+	pipe := transport.NewPipe()
+	bus := events.NewBus()
+	sub := bus.Subscribe(4096, events.NonceFound, events.NonceInvalid, events.NonceStale)
+	defer sub.Close()
+	client := &emuClient{submitted: make(map[uint32]int)}
+
+	thy := NewThyroid(mining.MinerArgs{
+		MuxNums:              1,
+		SkipSlots:            []int{},
+		PollDelay:            1,
+		NonceTraverseTimeout: 3,
+		Logger:               zap.NewNop(),
+		History:              statistics.NewStore(),
+		Events:               bus,
+		Transport:            pipe,
+		Capture:              captured.Name(),
+	})
+	thy.RegisterMiningFuncs("emu", emuFuncs{})
+	thy.SetClient(client)
+	go func() {
+		for board := range pipe.Boards {
+			go emulate(board, protocol.FormatLegacy, nil)
+		}
+	}()
+	thy.Start()
+
+	end := time.Now().Add(2 * time.Second)
+	for time.Now().Before(end) {
+		time.Sleep(50 * time.Millisecond)
+		client.newJob()
+	}
+	thy.Stop()
+
+	counts := make(map[events.Type]int)
+	for len(sub.C) > 0 {
+		counts[(<-sub.C).Type]++
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if counts[events.NonceFound] < 300 {
+		t.Fatal("too few nonces to wrap the job IDs:", counts)
+	}
+	if counts[events.NonceInvalid] != 0 || client.wrong != 0 {
+		t.Error("late nonces checked against the wrong header:", counts, client.wrong)
+	}
+	if counts[events.NonceStale] == 0 || counts[events.NonceStale] != int(atomic.LoadUint64(&thy.(*Thyroid).staleCounter)) {
+		t.Error("stale nonces not counted:", counts)
+	}
+	if len(client.submitted) < 2 {
+		t.Error("shares of too few jobs submitted:", client.submitted)
+	}
+
+	//the capture replays to what the driver saw, plus the nonces still on the way when it stopped
+	f, err := os.Open(captured.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := capture.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer := NewThyroid(mining.MinerArgs{MuxNums: 1, SkipSlots: []int{}, Logger: zap.NewNop()})
+	replayer.RegisterMiningFuncs("emu", emuFuncs{})
+	report, err := replayer.(*Thyroid).Replay(r)
+	if err != nil || report.Algo != "emu" || len(report.Boards) != 1 {
+		t.Fatal("capture not replayed", report, err)
+	}
+	b := report.Boards[0]
+	if b.Nonces < counts[events.NonceFound] || b.Stale < counts[events.NonceStale] || b.WrongHash != 0 || b.Unknown != 0 || report.Malformed != 0 {
+		t.Error("replay differs from the live run", b, report.Malformed, counts)
+	}
+}
+
+func TestThyroidHardwareTarget(t *testing.T) {
+	pipe := transport.NewPipe()
+	bus := events.NewBus()
+	sub := bus.Subscribe(4096, events.NonceInvalid)
+	defer sub.Close()
+	client := &emuClient{submitted: make(map[uint32]int)}
+
+	thy := NewThyroid(mining.MinerArgs{
+		MuxNums:              1,
+		SkipSlots:            []int{},
+		PollDelay:            1,
+		NonceTraverseTimeout: 3,
+		Logger:               zap.NewNop(),
+		Events:               bus,
+		Transport:            pipe,
+		BaudRate:             115200,
+	})
+	thy.RegisterMiningFuncs("emu", emuFuncs{})
+	thy.SetClient(client)
+	targets := make(chan uint32, 4096)
+	go func() {
+		for board := range pipe.Boards {
+			go emulate(board, protocol.FormatChecked, targets)
+		}
+	}()
+	thy.Start()
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		client.newJob()
+	}
+	thy.Stop()
+
+	if len(targets) == 0 {
+		t.Fatal("no hardware target written")
+	}
+	//the emulated algorithm has no pool target, the golden target is kept until the hashrate is known
+	if bits := <-targets; bits != GoldenBits {
+		t.Error("first hardware target of", bits, "bits")
+	}
+	for len(targets) > 0 {
+		if bits := <-targets; bits < MinHardwareBits || bits > MaxHardwareBits {
+			t.Error("hardware target of", bits, "bits")
+		}
+	}
+	nonces := thy.(*Thyroid).getNonceClasses(-1)
+	if nonces.Shares == 0 || nonces.HardwareErrors != 0 || nonces.BelowTarget != 0 || len(sub.C) != 0 {
+		t.Error("nonces misclassified", nonces)
+	}
+	if board := thy.(*Thyroid).getNonceClasses(0); board != nonces {
+		t.Error("nonces of the board differ from the chain", board, nonces)
+	}
+}
+
+func TestThyroidSharedClient(t *testing.T) {
+	//two chains following the same pool mine on one client, both must abandon the work of a clean job
+	client := &emuClient{submitted: make(map[uint32]int)}
+	var chains []*Thyroid
+	for i := 0; i < 2; i++ {
+		pipe := transport.NewPipe()
+		thy := NewThyroid(mining.MinerArgs{
+			MuxNums:              1,
+			SkipSlots:            []int{},
+			PollDelay:            1,
+			NonceTraverseTimeout: 3,
+			Logger:               zap.NewNop(),
+			Transport:            pipe,
+		}).(*Thyroid)
+		thy.RegisterMiningFuncs("emu", emuFuncs{})
+		thy.SetClient(client)
+		go func() {
+			for board := range pipe.Boards {
+				go emulate(board, protocol.FormatLegacy, nil)
+			}
+		}()
+		chains = append(chains, thy)
+	}
+	for _, thy := range chains {
+		thy.Start()
+	}
+	end := time.Now().Add(time.Second)
+	for time.Now().Before(end) {
+		time.Sleep(50 * time.Millisecond)
+		client.newJob()
+	}
+	for _, thy := range chains {
+		thy.Stop()
+	}
+	for i, thy := range chains {
+		if atomic.LoadUint64(&thy.staleCounter) == 0 {
+			t.Error("chain", i, "kept mining the work of abandoned jobs")
+		}
+	}
+	//a chain that left the client is not called anymore, after a pool switch for one
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.clean) != 0 {
+		t.Error(len(client.clean), "stopped chains still subscribed")
+	}
+}
@@ -0,0 +1,25 @@
+package transport
+
+import (
+	"io"
+	"net"
+)
+
+//Pipe is an in-memory transport for tests, the board side of every connection is sent on Boards
+type Pipe struct {
+	*Port
+	//Boards receives the board end of each connection the port opens
+	Boards chan net.Conn
+}
+
+//NewPipe creates an in-memory transport. Closing the board end makes the port reconnect
+// and send a new board end, like replugging a cable.
+func NewPipe() *Pipe {
+	p := &Pipe{Boards: make(chan net.Conn, 16)}
+	p.Port = NewPort("pipe", func() (io.ReadWriteCloser, string, error) {
+		host, board := net.Pipe()
+		p.Boards <- board
+		return host, "pipe", nil
+	})
+	return p
+}
@@ -0,0 +1,26 @@
+package transport
+
+import (
+	"io"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+//openSerial opens device at baudRate, 8N1
+func openSerial(device string, baudRate uint) (io.ReadWriteCloser, error) {
+	return serial.Open(serial.OpenOptions{
+		PortName:        device,
+		BaudRate:        baudRate,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 4,
+	})
+}
+
+//NewSerial creates a transport over the serial port device
+func NewSerial(device string, baudRate uint) *Port {
+	return NewPort(device, func() (io.ReadWriteCloser, string, error) {
+		conn, err := openSerial(device, baudRate)
+		return conn, device, err
+	})
+}
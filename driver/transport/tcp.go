@@ -0,0 +1,21 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+//dialTimeout bounds a connection attempt to a TCP bridge
+const dialTimeout = 5 * time.Second
+
+//NewTCP creates a transport to a serial-over-TCP bridge at addr
+func NewTCP(addr string) *Port {
+	return NewPort("@"+addr, func() (io.ReadWriteCloser, string, error) {
+		conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+		if err != nil {
+			return nil, "", err
+		}
+		return conn, "@" + addr, nil
+	})
+}
@@ -0,0 +1,211 @@
+//Package transport carries the bytes between the driver and the FPGA boards.
+// Serial ports, TCP bridges, USB adapters and in-memory pipes share the same Port,
+// which reconnects on its own when the link breaks, e.g. when a USB cable is replugged.
+package transport
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//Transport is the link to the boards
+type Transport interface {
+	io.ReadWriteCloser
+	//Open connects, it must be called before the first Read or Write
+	Open() error
+	//Reconnect drops the current connection and opens a new one
+	Reconnect() error
+	//Name describes the link, e.g. the serial device in use
+	Name() string
+	Stats() types.TransportStats
+	//FrameIn, FrameOut and ParseError count the frames the driver parsed and wrote and the data it could not parse
+	FrameIn()
+	FrameOut()
+	ParseError()
+}
+
+//ErrClosed is returned by a closed transport
+var ErrClosed = errors.New("Transport closed")
+
+//DefaultRetryDelay is the time between two attempts to reopen a broken link
+const DefaultRetryDelay = time.Second
+
+//Opener opens a connection and tells what it connected to
+type Opener func() (conn io.ReadWriteCloser, name string, err error)
+
+//Port is a Transport over the connections returned by an Opener.
+// Read and Write reopen the connection when it fails, Read keeps waiting for the link to come back
+// and only returns io.EOF once the port is closed.
+type Port struct {
+	bytesIn, bytesOut, framesIn, framesOut, parseErrors, reconnects uint64
+
+	RetryDelay time.Duration
+
+	open   Opener
+	mutex  sync.Mutex
+	conn   io.ReadWriteCloser
+	name   string
+	closed bool
+}
+
+//NewPort creates a port that connects with open
+func NewPort(name string, open Opener) *Port {
+	return &Port{name: name, open: open, RetryDelay: DefaultRetryDelay}
+}
+
+//New creates the transport for a device setting:
+// @host:port for a TCP bridge, usb or usb:pattern for the first FTDI or CDC adapter found, otherwise a serial port
+func New(device string, baudRate uint) Transport {
+	switch {
+	case strings.HasPrefix(device, "@"):
+		return NewTCP(strings.TrimPrefix(device, "@"))
+	}
+	if pattern, ok := USBPattern(device); ok {
+		return NewUSB(pattern, baudRate)
+	}
+	return NewSerial(device, baudRate)
+}
+
+func (p *Port) Open() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conn != nil {
+		return nil
+	}
+	p.closed = false
+	conn, name, err := p.open()
+	if err != nil {
+		return err
+	}
+	p.conn, p.name = conn, name
+	return nil
+}
+
+func (p *Port) Reconnect() error {
+	p.mutex.Lock()
+	conn := p.conn
+	p.mutex.Unlock()
+	return p.reconnect(conn)
+}
+
+//reconnect replaces failed by a new connection and retries until it succeeds or the port is closed.
+// Nothing is done if failed was already replaced.
+func (p *Port) reconnect(failed io.ReadWriteCloser) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conn != failed {
+		return nil
+	}
+	if failed != nil {
+		failed.Close()
+		p.conn = nil
+	}
+	for {
+		if p.closed {
+			return ErrClosed
+		}
+		conn, name, err := p.open()
+		if err == nil {
+			p.conn, p.name = conn, name
+			atomic.AddUint64(&p.reconnects, 1)
+			return nil
+		}
+		p.mutex.Unlock()
+		time.Sleep(p.RetryDelay)
+		p.mutex.Lock()
+		if p.conn != nil {
+			return nil
+		}
+	}
+}
+
+func (p *Port) current() (io.ReadWriteCloser, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	return p.conn, nil
+}
+
+func (p *Port) Read(b []byte) (int, error) {
+	for {
+		conn, err := p.current()
+		if err != nil {
+			return 0, io.EOF
+		}
+		if conn != nil {
+			n, err := conn.Read(b)
+			atomic.AddUint64(&p.bytesIn, uint64(n))
+			if err == nil || n > 0 {
+				return n, nil
+			}
+		}
+		if err := p.reconnect(conn); err != nil {
+			return 0, io.EOF
+		}
+	}
+}
+
+//Write reopens a failed connection but does not write b again, the driver rewrites its packets on the next poll
+func (p *Port) Write(b []byte) (int, error) {
+	conn, err := p.current()
+	if err != nil {
+		return 0, err
+	}
+	if conn == nil {
+		if err := p.reconnect(nil); err != nil {
+			return 0, err
+		}
+		if conn, err = p.current(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := conn.Write(b)
+	atomic.AddUint64(&p.bytesOut, uint64(n))
+	if err != nil {
+		p.reconnect(conn)
+	}
+	return n, err
+}
+
+//Close closes the connection and stops reconnecting
+func (p *Port) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+func (p *Port) Name() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.name
+}
+
+func (p *Port) Stats() types.TransportStats {
+	return types.TransportStats{
+		Name:        p.Name(),
+		BytesIn:     atomic.LoadUint64(&p.bytesIn),
+		BytesOut:    atomic.LoadUint64(&p.bytesOut),
+		FramesIn:    atomic.LoadUint64(&p.framesIn),
+		FramesOut:   atomic.LoadUint64(&p.framesOut),
+		ParseErrors: atomic.LoadUint64(&p.parseErrors),
+		Reconnects:  atomic.LoadUint64(&p.reconnects),
+	}
+}
+
+func (p *Port) FrameIn()    { atomic.AddUint64(&p.framesIn, 1) }
+func (p *Port) FrameOut()   { atomic.AddUint64(&p.framesOut, 1) }
+func (p *Port) ParseError() { atomic.AddUint64(&p.parseErrors, 1) }
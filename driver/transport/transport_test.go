@@ -0,0 +1,167 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	p := NewPipe()
+	if err := p.Open(); err != nil {
+		t.Fatal(err)
+	}
+	board := <-p.Boards
+
+	go board.Write([]byte("nonce"))
+	buf := make([]byte, 16)
+	n, err := p.Read(buf)
+	if err != nil || string(buf[:n]) != "nonce" {
+		t.Fatalf("Read %q, %v", buf[:n], err)
+	}
+
+	received := make(chan string)
+	go func() {
+		b := make([]byte, 16)
+		n, _ := board.Read(b)
+		received <- string(b[:n])
+	}()
+	if _, err := p.Write([]byte("header")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "header" {
+		t.Fatalf("Board read %q", got)
+	}
+	p.FrameIn()
+	p.FrameOut()
+	p.ParseError()
+
+	stats := p.Stats()
+	if stats.Name != "pipe" || stats.BytesIn != 5 || stats.BytesOut != 6 ||
+		stats.FramesIn != 1 || stats.FramesOut != 1 || stats.ParseErrors != 1 || stats.Reconnects != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestPipeReplug(t *testing.T) {
+	p := NewPipe()
+	p.RetryDelay = time.Millisecond
+	if err := p.Open(); err != nil {
+		t.Fatal(err)
+	}
+	board := <-p.Boards
+
+	read := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		n, _ := p.Read(buf)
+		read <- string(buf[:n])
+	}()
+	board.Close()
+
+	select {
+	case board = <-p.Boards:
+	case <-time.After(time.Second):
+		t.Fatal("No reconnect after the board end was closed")
+	}
+	board.Write([]byte("back"))
+	if got := <-read; got != "back" {
+		t.Errorf("Read %q after the reconnect", got)
+	}
+	if p.Stats().Reconnects != 1 {
+		t.Errorf("Unexpected reconnects %d", p.Stats().Reconnects)
+	}
+}
+
+func TestClose(t *testing.T) {
+	p := NewPipe()
+	p.Open()
+	<-p.Boards
+
+	done := make(chan error)
+	go func() {
+		_, err := p.Read(make([]byte, 16))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	p.Close()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("Read returned %v after Close, expected EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after Close")
+	}
+	if _, err := p.Write([]byte{1}); err != ErrClosed {
+		t.Errorf("Write returned %v after Close", err)
+	}
+}
+
+func TestRetryUntilOpen(t *testing.T) {
+	attempts := 0
+	var opened *bytes.Buffer
+	p := NewPort("flaky", func() (io.ReadWriteCloser, string, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, "", errors.New("unplugged")
+		}
+		opened = &bytes.Buffer{}
+		return nopCloser{opened}, "flaky", nil
+	})
+	p.RetryDelay = time.Millisecond
+	if err := p.Open(); err == nil {
+		t.Fatal("Open succeeded while unplugged")
+	}
+	if _, err := p.Write([]byte("poll")); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || opened.String() != "poll" {
+		t.Errorf("Got %d attempts, wrote %q", attempts, opened.String())
+	}
+}
+
+type nopCloser struct{ io.ReadWriter }
+
+func (nopCloser) Close() error { return nil }
+
+func TestNew(t *testing.T) {
+	for device, name := range map[string]string{
+		"/dev/ttyAMA0":        "/dev/ttyAMA0",
+		"@127.0.0.1:4000":     "@127.0.0.1:4000",
+		"usb":                 USB,
+		"usb:/dev/ttyUSB*":    USB,
+		"/dev/serial/by-id/x": "/dev/serial/by-id/x",
+	} {
+		if got := New(device, 115200).Name(); got != name {
+			t.Errorf("%s: got %s, expected %s", device, got, name)
+		}
+	}
+}
+
+func TestUSBDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"ttyUSB1", "ttyUSB0", "ttyS0"} {
+		ioutil.WriteFile(filepath.Join(dir, name), nil, 0600)
+	}
+
+	devices := USBDevices(filepath.Join(dir, "ttyUSB*"))
+	if len(devices) != 2 || filepath.Base(devices[0]) != "ttyUSB0" || filepath.Base(devices[1]) != "ttyUSB1" {
+		t.Errorf("Unexpected devices %v", devices)
+	}
+	if pattern, ok := USBPattern("usb:" + dir + "/ttyACM*"); !ok || len(USBDevices(pattern)) != 0 {
+		t.Errorf("Unexpected pattern %q", pattern)
+	}
+	if _, ok := USBPattern("/dev/ttyUSB0"); ok {
+		t.Error("A serial device was taken for a USB pattern")
+	}
+}
@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//USB is the device setting that selects the first USB serial adapter found
+const USB = "usb"
+
+//USBPatterns match the FTDI and the CDC ACM serial devices
+var USBPatterns = []string{"/dev/ttyUSB*", "/dev/ttyACM*"}
+
+//ErrNoUSBDevice is returned when no USB serial adapter is plugged in
+var ErrNoUSBDevice = errors.New("No USB serial device found")
+
+//USBPattern tells whether device selects a USB adapter and returns the device pattern it names, if any
+func USBPattern(device string) (pattern string, ok bool) {
+	if device == USB {
+		return "", true
+	}
+	if strings.HasPrefix(device, USB+":") {
+		return strings.TrimPrefix(device, USB+":"), true
+	}
+	return "", false
+}
+
+//USBDevices lists the devices matching pattern, every USBPatterns if pattern is empty
+func USBDevices(pattern string) []string {
+	patterns := USBPatterns
+	if pattern != "" {
+		patterns = []string{pattern}
+	}
+	var devices []string
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(p)
+		sort.Strings(matches)
+		devices = append(devices, matches...)
+	}
+	return devices
+}
+
+//NewUSB creates a transport over the first USB serial adapter matching pattern.
+// The devices are enumerated again on every reconnect, a replugged adapter may come back under another name.
+func NewUSB(pattern string, baudRate uint) *Port {
+	return NewPort(USB, func() (io.ReadWriteCloser, string, error) {
+		var err error = ErrNoUSBDevice
+		for _, device := range USBDevices(pattern) {
+			var conn io.ReadWriteCloser
+			if conn, err = openSerial(device, baudRate); err == nil {
+				return conn, device, nil
+			}
+		}
+		return nil, "", err
+	})
+}
@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"sync"
+	"time"
+)
+
+//JobIDReuseDelay is how long a job ID stays unused after its board got other work,
+// so that nonces still on the wire are not checked against the header of a newer job
+const JobIDReuseDelay = 2 * time.Second
+
+//NonceStatus tells how a nonce relates to the cached work
+type NonceStatus int
+
+const (
+	//NonceFresh nonces belong to work of the current generation
+	NonceFresh NonceStatus = iota
+	//NonceStale nonces belong to work dispatched before the last clean job
+	NonceStale
+	//NonceUnknown nonces carry a job ID nothing was dispatched under
+	NonceUnknown
+	//NonceDuplicate nonces were reported before for the same work, the pool would reject them
+	NonceDuplicate
+)
+
+type cachedWork struct {
+	work       MiningWork
+	board      int
+	generation uint64
+	used, live bool
+	retired    time.Time
+	//nonces were reported for the work, they are forgotten with it
+	nonces map[[8]byte]bool
+}
+
+//WorkCache keeps the work dispatched to the boards under the 8 bit job IDs the boards echo back with their nonces.
+// It is safe for concurrent use. A clean job starts a new generation and the work of older generations
+// turns stale. IDs are handed out round robin, never while their work is the current work of a board
+// and not within JobIDReuseDelay of it being replaced, unless every ID is taken.
+type WorkCache struct {
+	mutex      sync.RWMutex
+	entries    [256]cachedWork
+	current    map[int]uint8
+	generation uint64
+	last       uint8
+}
+
+//NewWorkCache creates an empty cache
+func NewWorkCache() *WorkCache {
+	return &WorkCache{current: make(map[int]uint8)}
+}
+
+//Clean starts a new generation, the cached work stays around to tell stale nonces from unknown ones
+func (c *WorkCache) Clean() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+}
+
+//Add caches work dispatched to board and returns its job ID, which is never 0
+func (c *WorkCache) Add(work MiningWork, board int, now time.Time) (jobID uint8) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	jobID = c.allocate(now)
+	c.put(jobID, work, board, now)
+	return
+}
+
+//Put caches work dispatched to board under a job ID that was allocated before, e.g. by a replayed capture
+func (c *WorkCache) Put(jobID uint8, work MiningWork, board int, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.put(jobID, work, board, now)
+}
+
+func (c *WorkCache) put(jobID uint8, work MiningWork, board int, now time.Time) {
+	if prev, ok := c.current[board]; ok {
+		c.entries[prev].live = false
+		c.entries[prev].retired = now
+	}
+	c.entries[jobID] = cachedWork{work: work, board: board, generation: c.generation, used: true, live: true}
+	c.current[board] = jobID
+	c.last = jobID
+}
+
+//allocate picks the next free job ID after the last one
+func (c *WorkCache) allocate(now time.Time) uint8 {
+	var fallback uint8
+	for i := 1; i <= 255; i++ {
+		id := uint8((int(c.last)+i-1)%255 + 1)
+		e := &c.entries[id]
+		if e.live {
+			continue
+		}
+		if !e.used || now.Sub(e.retired) >= JobIDReuseDelay {
+			return id
+		}
+		if fallback == 0 || e.retired.Before(c.entries[fallback].retired) {
+			fallback = id
+		}
+	}
+	return fallback
+}
+
+//Report looks up the work of a nonce read from the boards like Lookup and records the nonce,
+// a fresh nonce reported again for the same work is a NonceDuplicate
+func (c *WorkCache) Report(jobID uint8, nonce [8]byte) (work MiningWork, board int, status NonceStatus) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	work, board, status = c.lookup(jobID)
+	if status != NonceFresh {
+		return
+	}
+	e := &c.entries[jobID]
+	if e.nonces[nonce] {
+		return work, board, NonceDuplicate
+	}
+	if e.nonces == nil {
+		e.nonces = make(map[[8]byte]bool)
+	}
+	e.nonces[nonce] = true
+	return
+}
+
+//Lookup finds the work a nonce with jobID was found for and the board it was dispatched to
+func (c *WorkCache) Lookup(jobID uint8) (work MiningWork, board int, status NonceStatus) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.lookup(jobID)
+}
+
+func (c *WorkCache) lookup(jobID uint8) (work MiningWork, board int, status NonceStatus) {
+	e := &c.entries[jobID]
+	if jobID == 0 || !e.used {
+		return MiningWork{}, -1, NonceUnknown
+	}
+	if e.generation != c.generation {
+		return e.work, e.board, NonceStale
+	}
+	return e.work, e.board, NonceFresh
+}
@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkCacheStale(t *testing.T) {
+	c := NewWorkCache()
+	now := time.Now()
+	old := c.Add(MiningWork{Header: []byte{1}}, 0, now)
+	c.Clean()
+	fresh := c.Add(MiningWork{Header: []byte{2}}, 0, now)
+
+	if work, board, status := c.Lookup(old); status != NonceStale || board != 0 || work.Header[0] != 1 {
+		t.Error("work of the old generation not stale", work, board, status)
+	}
+	if work, _, status := c.Lookup(fresh); status != NonceFresh || work.Header[0] != 2 {
+		t.Error("current work not fresh", work, status)
+	}
+	if _, board, status := c.Lookup(0); status != NonceUnknown || board != -1 {
+		t.Error("job ID 0 known", board, status)
+	}
+	if _, _, status := c.Lookup(fresh + 1); status != NonceUnknown {
+		t.Error("unused job ID known", status)
+	}
+}
+
+func TestWorkCacheReuse(t *testing.T) {
+	c := NewWorkCache()
+	now := time.Now()
+	live := c.Add(MiningWork{}, 1, now)
+	ids := make(map[uint8]bool)
+	for i := 0; i < 254; i++ {
+		id := c.Add(MiningWork{}, 0, now)
+		if id == 0 || id == live || ids[id] {
+			t.Fatal("job ID handed out twice", id)
+		}
+		ids[id] = true
+	}
+
+	//every ID but the live one was retired just now, the oldest is reused
+	first := c.Add(MiningWork{}, 0, now.Add(time.Millisecond))
+	if first == live || first == 0 {
+		t.Fatal("live job ID reused", first)
+	}
+	if id := c.Add(MiningWork{}, 0, now.Add(JobIDReuseDelay)); id == live || id == first {
+		t.Error("job ID reused while live", id)
+	}
+}
+
+func TestWorkCacheDuplicate(t *testing.T) {
+	c := NewWorkCache()
+	now := time.Now()
+	id := c.Add(MiningWork{Header: []byte{1}}, 0, now)
+	nonce := [8]byte{0, 0, 0, 0, 1, 2, 3, 4}
+	if work, board, status := c.Report(id, nonce); status != NonceFresh || board != 0 || work.Header[0] != 1 {
+		t.Error("first report of the nonce not fresh", work, board, status)
+	}
+	if _, _, status := c.Report(id, nonce); status != NonceDuplicate {
+		t.Error("second report of the nonce not a duplicate", status)
+	}
+	if _, _, status := c.Lookup(id); status != NonceFresh {
+		t.Error("lookup of reported work not fresh", status)
+	}
+	other := nonce
+	other[7]++
+	if _, _, status := c.Report(id, other); status != NonceFresh {
+		t.Error("other nonce of the work not fresh", status)
+	}
+
+	//new work under the same job ID forgets the nonces
+	c.Put(id, MiningWork{Header: []byte{2}}, 0, now)
+	if _, _, status := c.Report(id, nonce); status != NonceFresh {
+		t.Error("nonce of new work under a reused job ID not fresh", status)
+	}
+	c.Clean()
+	if _, _, status := c.Report(id, nonce); status != NonceStale {
+		t.Error("stale nonce reported again not stale", status)
+	}
+	if _, _, status := c.Report(0, nonce); status != NonceUnknown {
+		t.Error("unknown nonce reported", status)
+	}
+}
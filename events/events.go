@@ -0,0 +1,123 @@
+//Package events implements a small in-process bus for typed miner events
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Type names the kind of an event
+type Type string
+
+const (
+	JobNew           Type = "job.new"
+	JobClean         Type = "job.clean"
+	JobDispatched    Type = "job.dispatched"
+	NonceFound       Type = "nonce.found"
+	NonceInvalid     Type = "nonce.invalid"
+	NonceStale       Type = "nonce.stale"
+	NonceDuplicate   Type = "nonce.duplicate"
+	ShareAccepted    Type = "share.accepted"
+	ShareRejected    Type = "share.rejected"
+	PoolStateChanged Type = "pool.state"
+	BoardProgrammed  Type = "board.programmed"
+	TemperatureAlarm Type = "board.temperature"
+)
+
+//Event is a single occurrence published on the bus.
+// Board and Pool are -1 when the event is not tied to one.
+type Event struct {
+	Type  Type                   `json:"type"`
+	Time  int64                  `json:"time"`
+	Board int                    `json:"board"`
+	Pool  int                    `json:"pool"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+//New creates an event stamped with the current time
+func New(t Type, board, pool int, data map[string]interface{}) Event {
+	return Event{Type: t, Time: time.Now().UnixNano() / int64(time.Millisecond), Board: board, Pool: pool, Data: data}
+}
+
+//Subscription receives the events published after Subscribe was called
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	types   map[Type]bool
+	dropped uint64
+	bus     *Bus
+	id      int
+}
+
+//Dropped is the number of events discarded because the subscriber was too slow
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+//Close unsubscribes and closes C
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s.id)
+}
+
+func (s *Subscription) wants(t Type) bool {
+	return len(s.types) == 0 || s.types[t]
+}
+
+//Bus fans published events out to every subscriber.
+// Publishing never blocks: events are dropped for subscribers whose buffer is full.
+// A nil *Bus is valid and discards everything.
+type Bus struct {
+	mutex  sync.RWMutex
+	subs   map[int]*Subscription
+	nextID int
+}
+
+//NewBus creates an empty bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]*Subscription)}
+}
+
+//Subscribe registers a subscriber for the given types, all types if none are given
+func (b *Bus) Subscribe(buffer int, types ...Type) *Subscription {
+	c := make(chan Event, buffer)
+	sub := &Subscription{C: c, c: c, types: make(map[Type]bool), bus: b}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.nextID++
+	sub.id = b.nextID
+	b.subs[sub.id] = sub
+	return sub
+}
+
+func (b *Bus) unsubscribe(id int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if sub, ok := b.subs[id]; ok {
+		delete(b.subs, id)
+		close(sub.c)
+	}
+}
+
+//Publish delivers e to every interested subscriber
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, sub := range b.subs {
+		if !sub.wants(e.Type) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
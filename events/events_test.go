@@ -0,0 +1,48 @@
+package events
+
+import "testing"
+
+func TestBusFiltersTypes(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe(4)
+	shares := bus.Subscribe(4, ShareAccepted, ShareRejected)
+	defer all.Close()
+	defer shares.Close()
+
+	bus.Publish(New(JobNew, -1, 0, nil))
+	bus.Publish(New(ShareRejected, 2, -1, map[string]interface{}{"reason": "stale"}))
+
+	if len(all.C) != 2 {
+		t.Error(len(all.C), "events received instead of 2")
+	}
+	if len(shares.C) != 1 {
+		t.Fatal(len(shares.C), "events received instead of 1")
+	}
+	if e := <-shares.C; e.Type != ShareRejected || e.Board != 2 || e.Data["reason"] != "stale" {
+		t.Error("unexpected event", e)
+	}
+}
+
+func TestBusDropsForSlowSubscribers(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(1)
+	for i := 0; i < 3; i++ {
+		bus.Publish(New(NonceFound, 0, -1, nil))
+	}
+	if sub.Dropped() != 2 {
+		t.Error(sub.Dropped(), "events dropped instead of 2")
+	}
+	sub.Close()
+	if _, ok := <-sub.C; !ok {
+		t.Error("buffered event lost on close")
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("channel not closed")
+	}
+	bus.Publish(New(NonceFound, 0, -1, nil))
+}
+
+func TestNilBus(t *testing.T) {
+	var bus *Bus
+	bus.Publish(New(JobNew, -1, 0, nil))
+}
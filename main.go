@@ -13,16 +13,17 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"strings"
-	"time"
 
+	"github.com/AGPFMiner/gominer/config"
 	"github.com/AGPFMiner/gominer/miner"
-	"github.com/AGPFMiner/gominer/types"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/cobra"
-	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -31,8 +32,14 @@ import (
 
 const version = "0.1.6"
 
-// The main command describes the service and defaults to printing the
-// help message.
+// Set at build time with -ldflags "-X main.commit=... -X main.buildDate=..."
+var (
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// The main command describes the service and mines when run without a
+// subcommand, like earlier releases did.
 var mainCmd = &cobra.Command{
 	Use:   "gominer",
 	Short: "Gominer for AGPF miners",
@@ -42,13 +49,62 @@ var mainCmd = &cobra.Command{
 	},
 }
 
+// The mine command starts mining with the configured pools and boards.
+var mineCmd = &cobra.Command{
+	Use:   "mine",
+	Short: "Start mining.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mine()
+	},
+}
+
 // The version command prints this service.
 var versionCmd = &cobra.Command{
 	Use:   "version",
-	Short: "Print the version.",
-	Long:  "The version of the dispatch service.",
+	Short: "Print the version, build information and supported algorithms.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("gominer", version)
+		fmt.Println("commit:    ", commit)
+		fmt.Println("built:     ", buildDate)
+		fmt.Println("go:        ", runtime.Version(), runtime.GOOS+"/"+runtime.GOARCH)
+		if info, ok := debug.ReadBuildInfo(); ok {
+			fmt.Println("module:    ", info.Main.Path, info.Main.Version)
+		}
+		fmt.Println("algorithms:", strings.Join(miner.Algorithms, ", "))
+		fmt.Println("drivers:   ", strings.Join(config.Drivers, ", "))
+	},
+}
+
+// The config command groups the configuration tools.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration.",
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Validate the configuration and print every problem.",
+	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println(version)
+		_, problems := config.Load(viper.GetViper())
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if problems.Fatal() {
+			os.Exit(1)
+		}
+		fmt.Println("Configuration OK:", viper.ConfigFileUsed())
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON schema of the configuration file.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(config.Schema)
 	},
 }
 
@@ -57,28 +113,27 @@ var mainminer = &miner.Miner{}
 // Go special automatically executed init function
 func init() {
 	// exec.Command("genminerconfig").Run()
-	time.Sleep(1000 * time.Millisecond)
 
-	// mainCmd.AddCommand(versionCmd)
+	flags := mainCmd.PersistentFlags()
+	flags.String("cfg", "gominer.json", "config file path")
+	flags.Bool("test", false, "test mode, build test header packet")
+	viper.BindPFlags(flags)
+
+	config.SetDefaults(viper.GetViper())
+	cobra.OnInitialize(readConfig)
 
-	// flags := mainCmd.Flags()
+	mineCmd.Flags().String("capture", "", "record the traffic to the boards in this file, see replay")
+	viper.BindPFlag("capture", mineCmd.Flags().Lookup("capture"))
 
-	viper.SetDefault("device", "/dev/ttyAMA0")
-	viper.SetDefault("baudrate", "115200")
-	viper.SetDefault("driver", "thyroid")
-	viper.SetDefault("muxnum", "1")
-	viper.SetDefault("polldelay", "60")
-	viper.SetDefault("noncetimeout", "1000")
-	viper.SetDefault("debug", "error")
-	viper.SetDefault("skipslots", []int{})
+	configCmd.AddCommand(configCheckCmd, configSchemaCmd)
+	mainCmd.AddCommand(mineCmd, versionCmd, configCmd, boardsCmd, poolsCmd, selftestCmd, benchmarkCmd, replayCmd)
+}
 
+// readConfig reads the config file once the flags are parsed, every command shares it.
+func readConfig() {
 	// Viper supports reading from yaml, toml and/or json files. Viper can
 	// search multiple paths. Paths will be searched in the order they are
 	// provided. Searches stopped once Config File found.
-	pflag.String("cfg", "gominer.json", "config file path")
-	pflag.Bool("test", false, "test mode, build test header packet")
-	pflag.Parse()
-	viper.BindPFlags(pflag.CommandLine)
 	fullcfgname := viper.GetString("cfg")
 
 	log.Print("Config file: ", fullcfgname)
@@ -96,48 +151,53 @@ func init() {
 	if err != nil {
 		println("No config file found. Using built-in defaults.")
 	}
+}
 
-	viper.WatchConfig()
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		fmt.Println("Config file changed:", e.Name)
-		var pools []types.Pool
-		viper.UnmarshalKey("pools", &pools)
-		mainminer.Pools = pools
-
-		mainminer.DevPath = viper.GetString("device")
-		mainminer.BaudRate = viper.GetUint("baudrate")
-		mainminer.Driver = viper.GetString("driver")
-		mainminer.MuxNums = viper.GetInt("muxnum")
-		mainminer.PollDelay = viper.GetInt64("polldelay")
-		mainminer.NonceTraverseTimeout = viper.GetInt64("noncetimeout")
-
-		mainminer.LogLevel = viper.GetString("debug")
-		mainminer.Reload()
-	})
-
+// loadConfig validates the configuration, printing every problem and exiting on errors.
+func loadConfig() *config.Config {
+	cfg, problems := config.Load(viper.GetViper())
+	printProblems(problems)
+	if problems.Fatal() {
+		log.Fatal("Invalid configuration, run `gominer config check` for details")
+	}
+	return cfg
 }
 
 ////////////////////////////////////////////////////////////////////////////
 // Main
 
 func main() {
-	mainCmd.Execute()
+	if err := mainCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
 
 ////////////////////////////////////////////////////////////////////////////
 // Function definitions
 func mine() {
-	var pools []types.Pool
-	viper.UnmarshalKey("pools", &pools)
-	mainminer.Pools = pools
-
-	mainminer.DevPath = viper.GetString("device")
-	mainminer.BaudRate = viper.GetUint("baudrate")
-	mainminer.Driver = viper.GetString("driver")
-	mainminer.MuxNums = viper.GetInt("muxnum")
-	mainminer.PollDelay = viper.GetInt64("polldelay")
-	mainminer.NonceTraverseTimeout = viper.GetInt64("noncetimeout")
-
-	mainminer.LogLevel = viper.GetString("debug")
+	cfg := loadConfig()
+	cfg.Apply(mainminer)
+	mainminer.Version = version
+
+	viper.WatchConfig()
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		fmt.Println("Config file changed:", e.Name)
+		cfg, problems := config.Load(viper.GetViper())
+		printProblems(problems)
+		if problems.Fatal() {
+			log.Print("Invalid configuration, keeping the current one")
+			return
+		}
+		next := &miner.Miner{}
+		cfg.Apply(next)
+		mainminer.ApplyConfig(next)
+	})
+
 	mainminer.MinerMain()
 }
+
+func printProblems(problems config.Problems) {
+	for _, p := range problems {
+		log.Print(p)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/AGPFMiner/gominer/algorithms/odocrypt"
 	"github.com/AGPFMiner/gominer/algorithms/skunk"
 	"github.com/AGPFMiner/gominer/algorithms/veo"
+	"github.com/AGPFMiner/gominer/clients/pooltest"
 	"github.com/AGPFMiner/gominer/miner"
 	"github.com/AGPFMiner/gominer/types"
 	"log"
@@ -73,6 +74,9 @@ var odoPool = &types.Pool{
 }
 
 func TestMultiPool(t *testing.T) {
+	if testing.Short() || !pooltest.Live() {
+		t.Skipf("set %s to test against the pools", pooltest.LiveEnv)
+	}
 	// var pools []clients.Client
 	veoCli := veo.NewClient(veoPool)
 	skunkCli := skunk.NewClient(skunkPool)
@@ -91,6 +95,9 @@ func TestMultiPool(t *testing.T) {
 }
 
 func TestMultiPoolSingleDrv(t *testing.T) {
+	if testing.Short() || !pooltest.Live() {
+		t.Skipf("set %s to test against the pools", pooltest.LiveEnv)
+	}
 	// var pools []clients.Client
 	veoCli := veo.NewClient(veoPool)
 	skunkCli := skunk.NewClient(skunkPool)
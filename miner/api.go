@@ -0,0 +1,213 @@
+package miner
+
+import (
+	j "encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AGPFMiner/gominer/types"
+
+	"github.com/gorilla/mux"
+)
+
+//APIPrefix is the path prefix of the versioned REST API
+const APIPrefix = "/api/v1"
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	j.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, &types.APIError{Error: types.APIErrorBody{Code: status, Message: message}})
+}
+
+//registerAPI adds the /api/v1 endpoints to r
+func (m *Miner) registerAPI(r *mux.Router) {
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not found")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+
+	api := r.PathPrefix(APIPrefix).Subrouter()
+	api.Handle("/summary", m.guard(RoleReadOnly, m.apiSummary)).Methods(http.MethodGet)
+	api.Handle("/devices", m.guard(RoleReadOnly, m.apiDevices)).Methods(http.MethodGet)
+	api.Handle("/devices/{id:[0-9]+}", m.guard(RoleReadOnly, m.apiDevice)).Methods(http.MethodGet)
+	api.Handle("/chains", m.guard(RoleReadOnly, m.apiChains)).Methods(http.MethodGet)
+	api.Handle("/pools", m.guard(RoleReadOnly, m.apiPools)).Methods(http.MethodGet)
+	api.Handle("/pools/{id:[0-9]+}", m.guard(RoleReadOnly, m.apiPool)).Methods(http.MethodGet)
+	api.Handle("/config", m.guard(RoleReadOnly, m.apiConfig)).Methods(http.MethodGet)
+	api.Handle("/history", m.guard(RoleReadOnly, m.GetHistory)).Methods(http.MethodGet)
+	api.Handle("/events", m.guard(RoleReadOnly, m.GetEvents)).Methods(http.MethodGet)
+	api.Handle("/proxy", m.guard(RoleReadOnly, m.apiProxy)).Methods(http.MethodGet)
+
+	api.Handle("/pools/{id:[0-9]+}/switch", m.guard(RoleAdmin, m.apiSwitchPool)).Methods(http.MethodPost)
+	api.Handle("/devices/reprogram", m.guard(RoleAdmin, m.apiReprogram)).Methods(http.MethodPost)
+	api.Handle("/devices/{id:[0-9]+}/reset", m.guard(RoleAdmin, m.apiResetBoard)).Methods(http.MethodPost)
+	api.Handle("/actions/reload", m.guard(RoleAdmin, m.apiReload)).Methods(http.MethodPost)
+}
+
+func pathIndex(r *http.Request) int {
+	idx, _ := strconv.Atoi(mux.Vars(r)["id"])
+	return idx
+}
+
+func (m *Miner) poolsStats() (poolsInfo []*types.PoolStates) {
+	view := m.poolsSnapshot()
+	for i, client := range view.clients {
+		if client == nil {
+			continue
+		}
+		poolInfo := client.GetPoolStats()
+		poolInfo.Active = i == view.active
+		poolsInfo = append(poolsInfo, &poolInfo)
+	}
+	return
+}
+
+func (m *Miner) apiSummary(w http.ResponseWriter, r *http.Request) {
+	status, hashrate, _, _ := m.hardwareSummary()
+	view := m.poolsSnapshot()
+	summary := &types.Summary{
+		Version:    m.Version,
+		Uptime:     int64(time.Since(m.startTime) / time.Second),
+		Algo:       view.algo,
+		ActivePool: view.active,
+		Status:     status,
+		Devices:    m.boardCount(),
+		Hashrate:   hashrate,
+		Time:       time.Now().Unix(),
+		Chains:     len(m.chains),
+	}
+	for _, client := range view.clients {
+		if client == nil {
+			continue
+		}
+		stats := client.GetPoolStats()
+		summary.Accept += stats.Accept
+		summary.Reject += stats.Reject
+		summary.Discard += stats.Discard
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (m *Miner) apiDevices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.devicesStats())
+}
+
+func (m *Miner) apiDevice(w http.ResponseWriter, r *http.Request) {
+	devs := m.devicesStats()
+	idx := pathIndex(r)
+	if idx >= len(devs) {
+		writeError(w, http.StatusNotFound, ErrNoSuchBoard.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, devs[idx])
+}
+
+func (m *Miner) apiChains(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.chainsStats())
+}
+
+func (m *Miner) apiPools(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.poolsStats())
+}
+
+func (m *Miner) apiPool(w http.ResponseWriter, r *http.Request) {
+	idx := pathIndex(r)
+	view := m.poolsSnapshot()
+	if idx >= len(view.clients) || view.clients[idx] == nil {
+		writeError(w, http.StatusNotFound, ErrNoSuchPool.Error())
+		return
+	}
+	stats := view.clients[idx].GetPoolStats()
+	stats.Active = idx == view.active
+	writeJSON(w, http.StatusOK, &stats)
+}
+
+func (m *Miner) apiProxy(w http.ResponseWriter, r *http.Request) {
+	if m.proxy == nil {
+		writeError(w, http.StatusNotFound, "stratum proxy not enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, m.proxy.Stats())
+}
+
+func (m *Miner) apiConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.minerConfig())
+}
+
+//minerConfig is the running configuration without the pool passwords
+func (m *Miner) minerConfig() *types.MinerConfig {
+	m.ctrlMutex.Lock()
+	defer m.ctrlMutex.Unlock()
+	cfg := &types.MinerConfig{
+		Driver:       m.Driver,
+		Device:       m.DevPath,
+		BaudRate:     m.BaudRate,
+		MuxNum:       m.MuxNums,
+		PollDelay:    m.PollDelay,
+		NonceTimeout: m.NonceTraverseTimeout,
+		Debug:        m.LogLevel,
+		WebListen:    m.WebListen,
+	}
+	for _, c := range m.Chains {
+		cfg.Devices = append(cfg.Devices, types.ChainConfig{Name: c.Name, Driver: c.Driver, Device: c.DevPath, BaudRate: c.BaudRate, MuxNum: c.MuxNums,
+			SkipSlots: c.SkipSlots, PollDelay: c.PollDelay, NonceTimeout: c.NonceTraverseTimeout, Pool: c.Pool})
+	}
+	for _, pool := range m.Pools {
+		cfg.Pools = append(cfg.Pools, types.PoolConfig{URL: pool.URL, User: pool.User, Algo: pool.Algo, Active: pool.Active, Priority: pool.Priority, Payout: pool.Payout})
+	}
+	return cfg
+}
+
+func (m *Miner) apiSwitchPool(w http.ResponseWriter, r *http.Request) {
+	if err := m.SwitchPool(pathIndex(r)); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, &types.ActionResult{Action: "switchpool", OK: true})
+}
+
+type reprogramArgs struct {
+	Bitstream string `json:"bitstream"`
+}
+
+func (m *Miner) apiReprogram(w http.ResponseWriter, r *http.Request) {
+	var args reprogramArgs
+	if r.ContentLength != 0 {
+		if err := j.NewDecoder(r.Body).Decode(&args); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+	}
+	if err := m.Reprogram(args.Bitstream); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, &types.ActionResult{Action: "reprogram", OK: true})
+}
+
+func (m *Miner) apiResetBoard(w http.ResponseWriter, r *http.Request) {
+	if err := m.ResetBoard(pathIndex(r)); err != nil {
+		status := http.StatusConflict
+		if err == ErrNoSuchBoard {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, &types.ActionResult{Action: "reset", OK: true})
+}
+
+func (m *Miner) apiReload(w http.ResponseWriter, r *http.Request) {
+	if err := m.Reload(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, &types.ActionResult{Action: "reload", OK: true})
+}
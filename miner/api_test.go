@@ -0,0 +1,187 @@
+package miner
+
+import (
+	j "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/proxy"
+	"github.com/AGPFMiner/gominer/types"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeDriver struct {
+	client                  clients.Client
+	programmed              int
+	starts, stops, inits    int
+	pollDelay, nonceTimeout time.Duration
+}
+
+func (d *fakeDriver) Start() { d.starts++ }
+func (d *fakeDriver) Stop()  { d.stops++ }
+func (d *fakeDriver) GetDriverStats() types.DriverStates {
+	return types.DriverStates{DriverName: "fake", Status: types.Running, Hashrate: [3]float64{1, 2, 3}}
+}
+func (d *fakeDriver) GetDriverStatsMulti() []*types.DriverStates {
+	ds := d.GetDriverStats()
+	return []*types.DriverStates{&ds, &ds}
+}
+func (d *fakeDriver) RegisterMiningFuncs(string, driver.MiningFuncs) {}
+func (d *fakeDriver) Init(interface{})                               { d.inits++ }
+func (d *fakeDriver) ProgramBitstream(bitstreamPath string) (err error) {
+	d.programmed++
+	return
+}
+func (d *fakeDriver) SetClient(c clients.Client) { d.client = c }
+func (d *fakeDriver) Tune(pollDelay, nonceTimeout time.Duration) {
+	d.pollDelay, d.nonceTimeout = pollDelay, nonceTimeout
+}
+
+type fakeClient struct {
+	clients.BaseClient
+	algo    string
+	accept  int32
+	stopped bool
+}
+
+func (c *fakeClient) GetHeaderForWork() (target []byte, difficulty float64, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	return
+}
+func (c *fakeClient) SubmitHeader(nonce []byte, job interface{}) (err error) { return }
+func (c *fakeClient) Start()                                                 {}
+func (c *fakeClient) Stop()                                                  { c.stopped = true }
+func (c *fakeClient) AlgoName() string                                       { return c.algo }
+func (c *fakeClient) PoolConnectionStates() types.PoolConnectionStates       { return types.Alive }
+func (c *fakeClient) GetPoolStats() types.PoolStates {
+	return types.PoolStates{Status: types.Alive, Algo: c.algo, Accept: c.accept}
+}
+
+func newTestMiner() (*Miner, *fakeDriver) {
+	drv := &fakeDriver{}
+	m := &Miner{
+		Pools:   []types.Pool{{URL: "stratum+tcp://a:1", Algo: "ckb", Pass: "secret"}, {URL: "stratum+tcp://b:2", Algo: "skunk"}},
+		MuxNums: 2,
+		clients: []clients.Client{&fakeClient{algo: "ckb", accept: 3}, &fakeClient{algo: "skunk", accept: 4}},
+	}
+	m.chains = []*chain{{Chain: m.deviceChain(), driver: drv, algo: "ckb", running: true}}
+	m.currentAlgo = "ckb"
+	return m, drv
+}
+
+func serve(m *Miner, method, url string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	m.registerAPI(r)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	return w
+}
+
+func TestAPISummary(t *testing.T) {
+	m, _ := newTestMiner()
+	w := serve(m, http.MethodGet, "/api/v1/summary")
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code, "returned instead of", http.StatusOK)
+	}
+	var summary types.Summary
+	if err := j.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Accept != 7 || summary.Algo != "ckb" || summary.Devices != 2 {
+		t.Error("unexpected summary", summary)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	m, _ := newTestMiner()
+	testSet := []struct {
+		method, url string
+		code        int
+	}{
+		{http.MethodGet, "/api/v1/pools/5", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/devices/2", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/nothing", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/actions/reload", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/devices/9/reset", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/proxy", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/history?metric=hashrate&from=soon", http.StatusBadRequest},
+	}
+	for _, test := range testSet {
+		w := serve(m, test.method, test.url)
+		if w.Code != test.code {
+			t.Error(test.method, test.url, w.Code, "returned instead of", test.code)
+			continue
+		}
+		var apiErr types.APIError
+		if err := j.NewDecoder(w.Body).Decode(&apiErr); err != nil || apiErr.Error.Code != test.code {
+			t.Error(test.method, test.url, "returned no JSON error:", err)
+		}
+	}
+}
+
+func TestAPISwitchPool(t *testing.T) {
+	m, drv := newTestMiner()
+	w := serve(m, http.MethodPost, "/api/v1/pools/1/switch")
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code, "returned instead of", http.StatusOK)
+	}
+	if m.activeIdx != 1 || drv.client != m.clients[1] || drv.programmed != 1 {
+		t.Error("pool was not switched")
+	}
+}
+
+func TestAPIPoolsWhileSwitching(t *testing.T) {
+	m, _ := newTestMiner()
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			m.SwitchPool(i % 2)
+		}
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		for _, url := range []string{"/api/v1/summary", "/api/v1/pools", "/api/v1/pools/1"} {
+			if w := serve(m, http.MethodGet, url); w.Code != http.StatusOK {
+				t.Fatal(url, w.Code, "returned instead of", http.StatusOK)
+			}
+		}
+	}
+}
+
+func TestAPIProxy(t *testing.T) {
+	m, _ := newTestMiner()
+	m.proxy = proxy.New(proxy.Config{Enable: true})
+	//the fake clients have no jobs to share
+	m.shareActivePool()
+	w := serve(m, http.MethodGet, "/api/v1/proxy")
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code, "returned instead of", http.StatusOK)
+	}
+	var stats proxy.Stats
+	if err := j.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Listen != proxy.DefaultListen || stats.Pool != "" || stats.Miners != 0 {
+		t.Error("unexpected stats", stats)
+	}
+}
+
+func TestAPIConfigHidesPassword(t *testing.T) {
+	m, _ := newTestMiner()
+	w := serve(m, http.MethodGet, "/api/v1/config")
+	var raw map[string]interface{}
+	j.NewDecoder(w.Body).Decode(&raw)
+	pools := raw["pools"].([]interface{})
+	if _, ok := pools[0].(map[string]interface{})["pass"]; ok {
+		t.Error("pool password exposed")
+	}
+}
@@ -0,0 +1,345 @@
+package miner
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+//Role is the access level granted to an API caller
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleReadOnly
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleReadOnly:
+		return "readonly"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+//ParseRole maps a configured role name to a Role, RoleNone if the name is unknown
+func ParseRole(role string) Role {
+	switch role {
+	case "admin":
+		return RoleAdmin
+	case "readonly", "":
+		return RoleReadOnly
+	default:
+		return RoleNone
+	}
+}
+
+//APIKey grants Role to requests carrying Key in the X-API-Key header or as a bearer token
+type APIKey struct {
+	Key  string `json:"key"`
+	Role string `json:"role"`
+}
+
+//APIUser grants Role to requests using HTTP basic auth
+type APIUser struct {
+	User string `json:"user"`
+	Pass string `json:"pass"`
+	Role string `json:"role"`
+}
+
+//AuthConfig configures access control on the RPC and HTTP endpoints.
+// Without keys, users and client CA the API stays open as before.
+type AuthConfig struct {
+	Keys  []APIKey  `json:"keys"`
+	Users []APIUser `json:"users"`
+
+	TLSCert string `json:"tlscert"`
+	TLSKey  string `json:"tlskey"`
+	//ClientCA enables mutual TLS, verified client certificates get the role of their common name in CertRoles
+	ClientCA          string            `json:"clientca"`
+	RequireClientCert bool              `json:"requireclientcert"`
+	CertRoles         map[string]string `json:"certroles"`
+
+	//MaxFailures failed attempts within FailureWindow lock a client address out for Lockout
+	MaxFailures   int           `json:"maxfailures"`
+	FailureWindow time.Duration `json:"failurewindow"`
+	Lockout       time.Duration `json:"lockout"`
+
+	//AuditLog is the file control actions are appended to, stdout if empty
+	AuditLog string `json:"auditlog"`
+}
+
+//Enabled reports whether any credential is configured
+func (ac *AuthConfig) Enabled() bool {
+	return len(ac.Keys) > 0 || len(ac.Users) > 0 || ac.ClientCA != ""
+}
+
+var errTooManyFailures = errors.New("Too many failed attempts")
+
+type failureRecord struct {
+	count        int
+	firstFailure time.Time
+	lockedUntil  time.Time
+}
+
+//failureLimiter tracks failed authentication attempts per client address
+type failureLimiter struct {
+	mutex           sync.Mutex
+	max             int
+	window, lockout time.Duration
+	records         map[string]*failureRecord
+}
+
+func newFailureLimiter(max int, window, lockout time.Duration) *failureLimiter {
+	if max <= 0 {
+		max = 5
+	}
+	if window <= 0 {
+		window = time.Minute
+	}
+	if lockout <= 0 {
+		lockout = 5 * time.Minute
+	}
+	return &failureLimiter{max: max, window: window, lockout: lockout, records: make(map[string]*failureRecord)}
+}
+
+func (fl *failureLimiter) locked(addr string, now time.Time) bool {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	rec, ok := fl.records[addr]
+	return ok && now.Before(rec.lockedUntil)
+}
+
+func (fl *failureLimiter) fail(addr string, now time.Time) {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	rec, ok := fl.records[addr]
+	if !ok || now.Sub(rec.firstFailure) > fl.window {
+		rec = &failureRecord{firstFailure: now}
+		fl.records[addr] = rec
+	}
+	rec.count++
+	if rec.count >= fl.max {
+		rec.lockedUntil = now.Add(fl.lockout)
+		rec.count = 0
+		rec.firstFailure = now
+	}
+}
+
+func (fl *failureLimiter) succeed(addr string) {
+	fl.mutex.Lock()
+	defer fl.mutex.Unlock()
+	delete(fl.records, addr)
+}
+
+//authenticator resolves the role of a request and writes the audit log
+type authenticator struct {
+	config  AuthConfig
+	limiter *failureLimiter
+	audit   *zap.Logger
+}
+
+func newAuthenticator(config AuthConfig) (*authenticator, error) {
+	a := &authenticator{
+		config:  config,
+		limiter: newFailureLimiter(config.MaxFailures, config.FailureWindow, config.Lockout),
+	}
+	sink := zapcore.Lock(os.Stdout)
+	if config.AuditLog != "" {
+		out, _, err := zap.Open(config.AuditLog)
+		if err != nil {
+			return nil, err
+		}
+		sink = out
+	}
+	a.audit = zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), sink, zap.InfoLevel))
+	return a, nil
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+//identify returns the caller's identity and role, presented is false if no credential was sent at all
+func (a *authenticator) identify(r *http.Request) (who string, role Role, presented bool) {
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key != "" {
+		who, role = a.matchKey(key)
+		return who, role, true
+	}
+
+	if user, pass, ok := r.BasicAuth(); ok {
+		for _, u := range a.config.Users {
+			if secureEqual(user, u.User) && secureEqual(pass, u.Pass) {
+				return "user:" + u.User, ParseRole(u.Role), true
+			}
+		}
+		return "", RoleNone, true
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		role, ok := a.config.CertRoles[cn]
+		if !ok {
+			return "cert:" + cn, RoleReadOnly, true
+		}
+		return "cert:" + cn, ParseRole(role), true
+	}
+	return "", RoleNone, false
+}
+
+//matchKey returns the identity and role of an API key, RoleNone if it is not configured
+func (a *authenticator) matchKey(key string) (who string, role Role) {
+	for i, k := range a.config.Keys {
+		if secureEqual(key, k.Key) {
+			return "key#" + strconv.Itoa(i), ParseRole(k.Role)
+		}
+	}
+	return "", RoleNone
+}
+
+//identifyKey resolves the role of an API key sent outside of HTTP, as by the cgminer API.
+// Failures count towards the lockout of addr like those of the HTTP endpoints.
+func (a *authenticator) identifyKey(addr, key string) (who string, role Role) {
+	if !a.config.Enabled() {
+		return "anonymous", RoleAdmin
+	}
+	now := time.Now()
+	if a.limiter.locked(addr, now) {
+		return "", RoleNone
+	}
+	if key == "" {
+		return "", RoleNone
+	}
+	who, role = a.matchKey(key)
+	if role == RoleNone {
+		a.limiter.fail(addr, now)
+		return
+	}
+	a.limiter.succeed(addr)
+	return
+}
+
+//require wraps next so that it only runs for callers holding at least role.
+// Handlers requiring RoleAdmin are control actions and get audited.
+func (a *authenticator) require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := remoteHost(r)
+		now := time.Now()
+		if a.limiter.locked(addr, now) {
+			a.audit.Warn("auth", zap.String("remote", addr), zap.String("path", r.URL.Path), zap.Error(errTooManyFailures))
+			writeError(w, http.StatusTooManyRequests, errTooManyFailures.Error())
+			return
+		}
+
+		who, granted := "anonymous", RoleAdmin
+		if a.config.Enabled() {
+			var presented bool
+			who, granted, presented = a.identify(r)
+			if granted == RoleNone {
+				if presented {
+					a.limiter.fail(addr, now)
+					a.audit.Warn("auth", zap.String("remote", addr), zap.String("path", r.URL.Path), zap.String("result", "invalid credentials"))
+				}
+				w.Header().Set("WWW-Authenticate", `Basic realm="gominer"`)
+				writeError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			a.limiter.succeed(addr)
+		}
+
+		if granted < role {
+			a.audit.Warn("auth", zap.String("remote", addr), zap.String("who", who), zap.String("path", r.URL.Path), zap.String("result", "forbidden"))
+			writeError(w, http.StatusForbidden, "role "+granted.String()+" may not access this endpoint")
+			return
+		}
+		if role < RoleAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		a.audit.Info("control",
+			zap.String("remote", addr),
+			zap.String("who", who),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("query", r.URL.RawQuery),
+			zap.Int("status", rec.status),
+		)
+	})
+}
+
+//tlsConfig builds the server TLS configuration, nil if TLS is not configured
+func (a *authenticator) tlsConfig() (*tls.Config, error) {
+	if a.config.TLSCert == "" || a.config.TLSKey == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(a.config.TLSCert, a.config.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if a.config.ClientCA != "" {
+		pem, err := ioutil.ReadFile(a.config.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificate found in client CA file")
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if a.config.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return cfg, nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+//guard protects h with the miner's authenticator, if any
+func (m *Miner) guard(role Role, h http.HandlerFunc) http.Handler {
+	if m.auth == nil {
+		return h
+	}
+	return m.auth.require(role, h)
+}
@@ -0,0 +1,100 @@
+package miner
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newAuthMiner(t *testing.T, config AuthConfig) (*Miner, *mux.Router) {
+	m, _ := newTestMiner()
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.auth = auth
+	r := mux.NewRouter()
+	r.Handle("/gominer/f_miner", m.guard(RoleAdmin, m.MinerCtrl))
+	m.registerAPI(r)
+	return m, r
+}
+
+func do(r *mux.Router, method, url string, setup func(*http.Request)) int {
+	req := httptest.NewRequest(method, url, nil)
+	if setup != nil {
+		setup(req)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func withKey(key string) func(*http.Request) {
+	return func(req *http.Request) { req.Header.Set("X-API-Key", key) }
+}
+
+func TestAuthRoles(t *testing.T) {
+	_, r := newAuthMiner(t, AuthConfig{
+		Keys:  []APIKey{{Key: "viewer", Role: "readonly"}, {Key: "operator", Role: "admin"}},
+		Users: []APIUser{{User: "root", Pass: "pw", Role: "admin"}},
+	})
+	testSet := []struct {
+		method, url string
+		setup       func(*http.Request)
+		code        int
+	}{
+		{http.MethodGet, "/api/v1/summary", nil, http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/summary", withKey("viewer"), http.StatusOK},
+		{http.MethodPost, "/api/v1/pools/1/switch", withKey("viewer"), http.StatusForbidden},
+		{http.MethodPost, "/api/v1/pools/1/switch", withKey("operator"), http.StatusOK},
+		{http.MethodGet, "/gominer/f_miner?command=nothing", withKey("viewer"), http.StatusForbidden},
+		{http.MethodGet, "/api/v1/pools", func(req *http.Request) { req.Header.Set("Authorization", "Bearer viewer") }, http.StatusOK},
+		{http.MethodGet, "/api/v1/pools", func(req *http.Request) { req.SetBasicAuth("root", "pw") }, http.StatusOK},
+		{http.MethodGet, "/api/v1/pools", func(req *http.Request) { req.SetBasicAuth("root", "bad") }, http.StatusUnauthorized},
+	}
+	for _, test := range testSet {
+		if code := do(r, test.method, test.url, test.setup); code != test.code {
+			t.Error(test.method, test.url, code, "returned instead of", test.code)
+		}
+	}
+}
+
+func TestAuthLockout(t *testing.T) {
+	_, r := newAuthMiner(t, AuthConfig{Keys: []APIKey{{Key: "operator", Role: "admin"}}, MaxFailures: 3})
+	for i := 0; i < 3; i++ {
+		if code := do(r, http.MethodGet, "/api/v1/summary", withKey("guess")); code != http.StatusUnauthorized {
+			t.Fatal(code, "returned instead of", http.StatusUnauthorized)
+		}
+	}
+	if code := do(r, http.MethodGet, "/api/v1/summary", withKey("operator")); code != http.StatusTooManyRequests {
+		t.Error(code, "returned instead of", http.StatusTooManyRequests)
+	}
+}
+
+func TestAuthAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gominer-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	auditLog := filepath.Join(dir, "audit.log")
+
+	_, r := newAuthMiner(t, AuthConfig{Keys: []APIKey{{Key: "operator", Role: "admin"}}, AuditLog: auditLog})
+	do(r, http.MethodGet, "/api/v1/summary", withKey("operator"))
+	do(r, http.MethodPost, "/api/v1/pools/1/switch", withKey("operator"))
+
+	content, err := ioutil.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "/api/v1/pools/1/switch") || !strings.Contains(lines[0], "key#0") {
+		t.Error("unexpected audit log:", string(content))
+	}
+}
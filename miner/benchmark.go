@@ -0,0 +1,52 @@
+package miner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AGPFMiner/gominer/algorithms/ckb"
+	"github.com/AGPFMiner/gominer/algorithms/skunk"
+	"github.com/AGPFMiner/gominer/algorithms/veo"
+	"github.com/AGPFMiner/gominer/benchmark"
+	"github.com/AGPFMiner/gominer/driver"
+)
+
+//benchmarkTemplate returns the work layout of algo for the synthetic work generator
+func benchmarkTemplate(algo string) (benchmark.Template, driver.MiningFuncs, error) {
+	switch algo {
+	case "skunk":
+		//a random merkle root stands in for the one a new extranonce2 gives
+		return benchmark.Template{Header: skunk.KnownAnswer.Header, Random: []benchmark.Span{{Offset: 36, Size: 32}}}, &skunk.MiningFuncs{}, nil
+	case "ckb":
+		return benchmark.Template{Header: ckb.KnownAnswer.Header, Random: []benchmark.Span{{Offset: 36, Size: 8}}}, &ckb.MiningFuncs{}, nil
+	case "veo":
+		return benchmark.Template{Header: veo.KnownAnswer.Header, Random: []benchmark.Span{{Offset: 32, Size: 4}, {Offset: 40, Size: 4}}}, &veo.MiningFuncs{}, nil
+	case "odocrypt", "verus", "xdag":
+		return benchmark.Template{}, nil, fmt.Errorf("No synthetic work for %s, its hash is not computed on the host", algo)
+	}
+	return benchmark.Template{}, nil, fmt.Errorf("Unknown algorithm %q", algo)
+}
+
+//Benchmark mines synthetic work of algo on slots of c for duration, starting a clean job every jobInterval.
+// The boards must already be programmed with the bitstream of algo.
+func (m *Miner) Benchmark(c Chain, algo string, slots []int, duration time.Duration, difficulty float64, jobInterval time.Duration) (benchmark.Report, error) {
+	template, funcs, err := benchmarkTemplate(algo)
+	if err != nil {
+		return benchmark.Report{}, err
+	}
+	drv, err := m.slotsDriver(c, slots)
+	if err != nil {
+		return benchmark.Report{}, err
+	}
+
+	b := benchmark.Benchmark{
+		Algo:      algo,
+		Funcs:     funcs,
+		Generator: benchmark.NewGenerator(algo, template, difficulty, jobInterval),
+		Slots:     slots,
+		Duration:  duration,
+		BaudRate:  c.BaudRate,
+		PollDelay: time.Duration(c.PollDelay) * time.Millisecond,
+	}
+	return b.Run(drv, m.events), nil
+}
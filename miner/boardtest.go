@@ -0,0 +1,73 @@
+package miner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AGPFMiner/gominer/algorithms/ckb"
+	"github.com/AGPFMiner/gominer/algorithms/skunk"
+	"github.com/AGPFMiner/gominer/algorithms/veo"
+	"github.com/AGPFMiner/gominer/boardtest"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/events"
+)
+
+//knownAnswer returns the known-answer job of algo and the functions to verify it on the host
+func knownAnswer(algo string) (driver.KnownAnswer, driver.MiningFuncs, error) {
+	switch algo {
+	case "skunk":
+		return skunk.KnownAnswer, &skunk.MiningFuncs{}, nil
+	case "ckb":
+		return ckb.KnownAnswer, &ckb.MiningFuncs{}, nil
+	case "veo":
+		return veo.KnownAnswer, &veo.MiningFuncs{}, nil
+	case "odocrypt", "verus", "xdag":
+		return driver.KnownAnswer{}, nil, fmt.Errorf("No known-answer job for %s, its hash is not computed on the host", algo)
+	}
+	return driver.KnownAnswer{}, nil, fmt.Errorf("Unknown algorithm %q", algo)
+}
+
+//TestBoards mines the known-answer job of algo on slots of c for duration and reports every slot.
+// The boards must already be programmed with the bitstream of algo.
+func (m *Miner) TestBoards(c Chain, algo string, slots []int, duration time.Duration, maxErrorRate float64) ([]boardtest.Result, error) {
+	answer, funcs, err := knownAnswer(algo)
+	if err != nil {
+		return nil, err
+	}
+	drv, err := m.slotsDriver(c, slots)
+	if err != nil {
+		return nil, err
+	}
+
+	test := boardtest.Test{
+		Algo:         algo,
+		KnownAnswer:  answer,
+		Funcs:        funcs,
+		Slots:        slots,
+		Duration:     duration,
+		MaxErrorRate: maxErrorRate,
+	}
+	return test.Run(drv, m.events), nil
+}
+
+//slotsDriver creates a driver for c that skips every slot but slots and publishes on a new event bus
+func (m *Miner) slotsDriver(c Chain, slots []int) (driver.Driver, error) {
+	underTest := make(map[int]bool)
+	for _, slot := range slots {
+		if slot < 1 || slot > c.MuxNums {
+			return nil, fmt.Errorf("Slot %d out of range 1-%d of chain %s", slot, c.MuxNums, c.Name)
+		}
+		underTest[slot] = true
+	}
+
+	initLogger(m.LogLevel)
+	m.events = events.NewBus()
+	args := m.chainArgs(&chain{Chain: c})
+	args.SkipSlots = []int{}
+	for slot := 1; slot <= c.MuxNums; slot++ {
+		if !underTest[slot] {
+			args.SkipSlots = append(args.SkipSlots, slot)
+		}
+	}
+	return newDriver(c.Driver, args)
+}
@@ -0,0 +1,523 @@
+package miner
+
+import (
+	j "encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AGPFMiner/gominer/types"
+
+	"go.uber.org/zap"
+)
+
+//DefaultCGMinerListen is the port cgminer and bmminer serve their API on
+const DefaultCGMinerListen = ":4028"
+
+//cgminerAPIVersion is the cgminer API version the responses follow
+const cgminerAPIVersion = "3.7"
+
+//CGMinerConfig configures the cgminer compatible TCP API
+type CGMinerConfig struct {
+	Enable bool   `json:"enable"`
+	Listen string `json:"listen"`
+	//Allow lists the addresses or CIDRs allowed to query, WriteAllow those also allowed
+	// to switchpool, addpool and restart. Both default to localhost only, like cgminer.
+	// With credentials configured in Auth the writes also need an admin API key as "key" of the JSON request,
+	// the text requests have no room for it and are read only then.
+	Allow      []string `json:"allow"`
+	WriteAllow []string `json:"writeallow"`
+}
+
+//Validate checks the access lists
+func (cc *CGMinerConfig) Validate() error {
+	if _, err := newCGAccessList(cc.Allow); err != nil {
+		return err
+	}
+	_, err := newCGAccessList(cc.WriteAllow)
+	return err
+}
+
+//Status codes and messages as used by cgminer's api.c
+const (
+	cgCodePool     = 7
+	cgCodeNoPool   = 8
+	cgCodeDevs     = 9
+	cgCodeNoDevs   = 10
+	cgCodeSumm     = 11
+	cgCodeInvCmd   = 14
+	cgCodeMisID    = 15
+	cgCodeVersion  = 22
+	cgCodeMisPID   = 25
+	cgCodeInvPID   = 26
+	cgCodeSwitchP  = 27
+	cgCodeAccDeny  = 45
+	cgCodeMisPDP   = 51
+	cgCodeInvPDP   = 52
+	cgCodeAddPool  = 55
+	cgCodeMineStat = 70
+	cgCodeRestart  = 131
+)
+
+type cgStatus struct {
+	Status      string `json:"STATUS"`
+	When        int64  `json:"When"`
+	Code        int    `json:"Code"`
+	Msg         string `json:"Msg"`
+	Description string `json:"Description"`
+}
+
+//cgResponse is one command's reply: STATUS plus at most one named section
+type cgResponse map[string]interface{}
+
+type cgRequest struct {
+	Command   string `json:"command"`
+	Parameter string `json:"parameter"`
+	//Key is an API key of Auth, cgminer has no such field
+	Key string `json:"key"`
+}
+
+func (m *Miner) cgStatus(success bool, code int, msg string) []cgStatus {
+	status := "S"
+	if !success {
+		status = "E"
+	}
+	return []cgStatus{{Status: status, When: time.Now().Unix(), Code: code, Msg: msg, Description: "gominer " + m.Version}}
+}
+
+func (m *Miner) cgError(code int, msg string) cgResponse {
+	return cgResponse{"STATUS": m.cgStatus(false, code, msg), "id": 1}
+}
+
+func (m *Miner) cgOK(code int, msg, section string, body interface{}) cgResponse {
+	resp := cgResponse{"STATUS": m.cgStatus(true, code, msg), "id": 1}
+	if section != "" {
+		resp[section] = body
+	}
+	return resp
+}
+
+//privilegedCGCommands change the miner and need a WriteAllow entry
+var privilegedCGCommands = map[string]bool{
+	"switchpool": true,
+	"addpool":    true,
+	"restart":    true,
+}
+
+//cgAccessList matches client addresses against cgminer style allow entries
+type cgAccessList []*net.IPNet
+
+func newCGAccessList(entries []string) (acl cgAccessList, err error) {
+	if len(entries) == 0 {
+		entries = []string{"127.0.0.1", "::1"}
+	}
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		acl = append(acl, ipnet)
+	}
+	return
+}
+
+func (acl cgAccessList) allows(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipnet := range acl {
+		if ipnet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+//ServeCGMinerAPI answers cgminer API requests on listen until the listener fails
+func (m *Miner) ServeCGMinerAPI(config CGMinerConfig) error {
+	allow, err := newCGAccessList(config.Allow)
+	if err != nil {
+		return err
+	}
+	writeAllow, err := newCGAccessList(config.WriteAllow)
+	if err != nil {
+		return err
+	}
+	listen := config.Listen
+	if listen == "" {
+		listen = DefaultCGMinerListen
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	log.Print("cgminer API listening on ", listen)
+	return m.serveCGMiner(ln, allow, writeAllow)
+}
+
+func (m *Miner) serveCGMiner(ln net.Listener, allow, writeAllow cgAccessList) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go m.handleCGConn(conn, allow, writeAllow)
+	}
+}
+
+func (m *Miner) handleCGConn(conn net.Conn, allow, writeAllow cgAccessList) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if !allow.allows(conn.RemoteAddr()) && !writeAllow.allows(conn.RemoteAddr()) {
+		return
+	}
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	//like cgminer, a request is whatever arrives in the first read
+	buf := make([]byte, 8192)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return
+	}
+	raw := string(buf[:n])
+	if end := strings.IndexByte(raw, 0); end >= 0 {
+		raw = raw[:end]
+	}
+	raw = strings.TrimSpace(raw)
+
+	var reply []byte
+	if strings.HasPrefix(raw, "{") {
+		reply = m.cgJSONRequest(raw, host, writeAllow.allows(conn.RemoteAddr()))
+	} else {
+		reply = m.cgTextRequest(raw, host, writeAllow.allows(conn.RemoteAddr()))
+	}
+	conn.Write(append(reply, 0))
+}
+
+//cgPrivileged tells whether host may run the privileged commands and who it is for the audit log.
+// It needs a WriteAllow entry and, if the authenticator has credentials, an admin key.
+func (m *Miner) cgPrivileged(host, key string, writeAllowed bool) (who string, privileged bool) {
+	if !writeAllowed {
+		return "", false
+	}
+	if m.auth == nil {
+		return "anonymous", true
+	}
+	who, role := m.auth.identifyKey(host, key)
+	return who, role >= RoleAdmin
+}
+
+//cgAudit logs a privileged command to the audit log of the authenticator
+func (m *Miner) cgAudit(host, who, cmd string, resp cgResponse) {
+	if m.auth == nil {
+		return
+	}
+	status := resp["STATUS"].([]cgStatus)[0]
+	m.auth.audit.Info("control",
+		zap.String("remote", host),
+		zap.String("who", who),
+		zap.String("command", cmd),
+		zap.Int("code", status.Code),
+		zap.String("msg", status.Msg),
+	)
+}
+
+//cgJSONRequest handles {"command":"summary+pools","parameter":""}
+func (m *Miner) cgJSONRequest(raw, host string, writeAllowed bool) []byte {
+	var req cgRequest
+	if err := j.Unmarshal([]byte(raw), &req); err != nil || req.Command == "" {
+		res, _ := j.Marshal(m.cgError(cgCodeMisID, "Missing JSON 'command'"))
+		return res
+	}
+	cmds := strings.Split(req.Command, "+")
+	if len(cmds) == 1 {
+		privileged := false
+		who := ""
+		if privilegedCGCommands[cmds[0]] {
+			who, privileged = m.cgPrivileged(host, req.Key, writeAllowed)
+		}
+		resp := m.cgCommand(cmds[0], req.Parameter, privileged)
+		if privilegedCGCommands[cmds[0]] {
+			m.cgAudit(host, who, cmds[0], resp)
+		}
+		res, _ := j.Marshal(resp)
+		return res
+	}
+	joined := make(map[string]interface{})
+	for _, cmd := range cmds {
+		if privilegedCGCommands[cmd] {
+			continue
+		}
+		joined[cmd] = []cgResponse{m.cgCommand(cmd, req.Parameter, false)}
+	}
+	res, _ := j.Marshal(joined)
+	return res
+}
+
+//cgTextRequest handles summary|parameter and answers in cgminer's pipe separated format
+func (m *Miner) cgTextRequest(raw, host string, writeAllowed bool) []byte {
+	parts := strings.SplitN(raw, "|", 2)
+	param := ""
+	if len(parts) == 2 {
+		param = parts[1]
+	}
+	privileged := false
+	who := ""
+	if privilegedCGCommands[parts[0]] {
+		who, privileged = m.cgPrivileged(host, "", writeAllowed)
+	}
+	resp := m.cgCommand(parts[0], param, privileged)
+	if privilegedCGCommands[parts[0]] {
+		m.cgAudit(host, who, parts[0], resp)
+	}
+
+	var sb strings.Builder
+	for _, status := range resp["STATUS"].([]cgStatus) {
+		fmt.Fprintf(&sb, "STATUS=%s,When=%d,Code=%d,Msg=%s,Description=%s|", status.Status, status.When, status.Code, status.Msg, status.Description)
+	}
+	for key, section := range resp {
+		if key == "STATUS" || key == "id" {
+			continue
+		}
+		rows, _ := j.Marshal(section)
+		var decoded []map[string]interface{}
+		j.Unmarshal(rows, &decoded)
+		for _, row := range decoded {
+			keys := make([]string, 0, len(row))
+			for k := range row {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for n, k := range keys {
+				if n > 0 {
+					sb.WriteString(",")
+				}
+				fmt.Fprintf(&sb, "%s=%v", k, row[k])
+			}
+			sb.WriteString("|")
+		}
+	}
+	return []byte(sb.String())
+}
+
+func (m *Miner) cgCommand(cmd, param string, privileged bool) cgResponse {
+	if privilegedCGCommands[cmd] && !privileged {
+		return m.cgError(cgCodeAccDeny, fmt.Sprintf("Access denied to '%s' command", cmd))
+	}
+	switch cmd {
+	case "version":
+		return m.cgOK(cgCodeVersion, "CGMiner versions", "VERSION", []map[string]string{{"CGMiner": m.Version, "API": cgminerAPIVersion, "Miner": "gominer " + m.Version}})
+	case "summary":
+		return m.cgOK(cgCodeSumm, "Summary", "SUMMARY", []map[string]interface{}{m.cgSummary()})
+	case "devs":
+		devs := m.cgDevs()
+		if len(devs) == 0 {
+			return m.cgError(cgCodeNoDevs, "No PGAs")
+		}
+		return m.cgOK(cgCodeDevs, fmt.Sprintf("%d PGA(s)", len(devs)), "DEVS", devs)
+	case "pools":
+		pools := m.cgPools()
+		if len(pools) == 0 {
+			return m.cgError(cgCodeNoPool, "No pools")
+		}
+		return m.cgOK(cgCodePool, fmt.Sprintf("%d Pool(s)", len(pools)), "POOLS", pools)
+	case "stats":
+		return m.cgOK(cgCodeMineStat, "CGMiner stats", "STATS", m.cgStats())
+	case "switchpool":
+		if param == "" {
+			return m.cgError(cgCodeMisPID, "Missing pool id parameter")
+		}
+		idx, err := strconv.Atoi(param)
+		if err != nil {
+			return m.cgError(cgCodeInvPID, fmt.Sprintf("Invalid pool id %s", param))
+		}
+		if err := m.SwitchPool(idx); err == ErrNoSuchPool {
+			return m.cgError(cgCodeInvPID, fmt.Sprintf("Invalid pool id %d - range is 0 - %d", idx, len(m.poolsSnapshot().clients)-1))
+		} else if err != nil {
+			return m.cgError(cgCodeInvPID, err.Error())
+		}
+		url := ""
+		if pools := m.poolsSnapshot().pools; idx < len(pools) {
+			url = pools[idx].URL
+		}
+		return m.cgOK(cgCodeSwitchP, fmt.Sprintf("Switching to pool %d:'%s'", idx, url), "", nil)
+	case "addpool":
+		if param == "" {
+			return m.cgError(cgCodeMisPDP, "Missing addpool details")
+		}
+		details := strings.SplitN(param, ",", 3)
+		if len(details) < 2 {
+			return m.cgError(cgCodeInvPDP, fmt.Sprintf("Invalid addpool details '%s'", param))
+		}
+		pool := types.Pool{URL: details[0], User: details[1]}
+		if len(details) == 3 {
+			pool.Pass = details[2]
+		}
+		idx, err := m.AddPool(pool)
+		if err != nil {
+			return m.cgError(cgCodeInvPDP, err.Error())
+		}
+		return m.cgOK(cgCodeAddPool, fmt.Sprintf("Added pool %d: '%s'", idx, pool.URL), "", nil)
+	case "restart":
+		go m.Reload()
+		return m.cgOK(cgCodeRestart, "Restart", "", nil)
+	default:
+		return m.cgError(cgCodeInvCmd, "Invalid command")
+	}
+}
+
+const mega = 1000 * 1000
+
+func (m *Miner) cgSummary() map[string]interface{} {
+	_, hashrate, stale, nonces := m.hardwareSummary()
+	var accept, reject, discard int32
+	var lastShare int64
+	for _, client := range m.poolsSnapshot().clients {
+		if client == nil {
+			continue
+		}
+		stats := client.GetPoolStats()
+		accept += stats.Accept
+		reject += stats.Reject
+		discard += stats.Discard
+		if stats.LastAccepted > lastShare {
+			lastShare = stats.LastAccepted
+		}
+	}
+	elapsed := int64(time.Since(m.startTime) / time.Second)
+	utility := 0.0
+	if elapsed > 0 {
+		utility = float64(accept) * 60 / float64(elapsed)
+	}
+	return map[string]interface{}{
+		"Elapsed":         elapsed,
+		"MHS av":          hashrate[2] / mega,
+		"MHS 1m":          hashrate[0] / mega,
+		"MHS 5m":          hashrate[1] / mega,
+		"Found Blocks":    0,
+		"Getworks":        0,
+		"Accepted":        accept,
+		"Rejected":        reject,
+		"Hardware Errors": nonces.HardwareErrors,
+		"Utility":         utility,
+		"Discarded":       discard,
+		"Stale":           stale,
+		"Local Work":      0,
+		"Last getwork":    lastShare,
+	}
+}
+
+func cgDeviceStatus(status types.HardwareStats) string {
+	switch status {
+	case types.Running:
+		return "Alive"
+	case types.NoResponse:
+		return "Sick"
+	case types.Stopped:
+		return "Dead"
+	case types.Programming:
+		return "Initialising"
+	default:
+		return "Unknown"
+	}
+}
+
+func (m *Miner) cgDevs() (devs []map[string]interface{}) {
+	for i, ds := range m.devicesStats() {
+		temp, _ := strconv.ParseFloat(ds.Temperature, 64)
+		devs = append(devs, map[string]interface{}{
+			"PGA":             i,
+			"Name":            "THY",
+			"ID":              i,
+			"Enabled":         "Y",
+			"Status":          cgDeviceStatus(ds.Status),
+			"Temperature":     temp,
+			"MHS av":          ds.Hashrate[2] / mega,
+			"MHS 1m":          ds.Hashrate[0] / mega,
+			"MHS 5m":          ds.Hashrate[1] / mega,
+			"Hardware Errors": ds.Nonces.HardwareErrors,
+			"Algorithm":       ds.Algo,
+		})
+	}
+	return
+}
+
+func cgPoolStatus(status types.PoolConnectionStates) string {
+	switch status {
+	case types.Alive:
+		return "Alive"
+	case types.Sick:
+		return "Sick"
+	case types.Dead:
+		return "Dead"
+	default:
+		return "Disabled"
+	}
+}
+
+func (m *Miner) cgPools() (pools []map[string]interface{}) {
+	view := m.poolsSnapshot()
+	for i, client := range view.clients {
+		if client == nil {
+			continue
+		}
+		stats := client.GetPoolStats()
+		pools = append(pools, map[string]interface{}{
+			"POOL":               i,
+			"URL":                view.pools[i].URL,
+			"Status":             cgPoolStatus(stats.Status),
+			"Priority":           view.pools[i].Priority,
+			"Quota":              1,
+			"Long Poll":          "N",
+			"Getworks":           0,
+			"Accepted":           stats.Accept,
+			"Rejected":           stats.Reject,
+			"Discarded":          stats.Discard,
+			"Stale":              0,
+			"Get Failures":       0,
+			"Remote Failures":    0,
+			"User":               stats.User,
+			"Last Share Time":    stats.LastAccepted,
+			"Stratum Active":     i == view.active,
+			"Stratum URL":        strings.TrimPrefix(stats.PoolAddr, "stratum+tcp://"),
+			"Stratum Difficulty": stats.Diff,
+			"Algorithm":          stats.Algo,
+		})
+	}
+	return
+}
+
+func (m *Miner) cgStats() (stats []map[string]interface{}) {
+	elapsed := int64(time.Since(m.startTime) / time.Second)
+	for i, ds := range m.devicesStats() {
+		stats = append(stats, map[string]interface{}{
+			"STATS":       i,
+			"ID":          fmt.Sprintf("THY%d", i),
+			"Elapsed":     elapsed,
+			"Calls":       0,
+			"Wait":        0,
+			"Max":         0,
+			"Min":         99999999,
+			"temp":        ds.Temperature,
+			"voltage":     ds.Voltage,
+			"GHS 1m":      ds.Hashrate[0] / 1000 / mega,
+			"GHS 5m":      ds.Hashrate[1] / 1000 / mega,
+			"GHS 60m":     ds.Hashrate[2] / 1000 / mega,
+			"Algorithm":   ds.Algo,
+			"Driver Name": ds.DriverName,
+		})
+	}
+	return
+}
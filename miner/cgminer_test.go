@@ -0,0 +1,120 @@
+package miner
+
+import (
+	"bufio"
+	j "encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func cgQuery(t *testing.T, addr, request string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(request))
+	reply, _ := bufio.NewReader(conn).ReadString(0)
+	return strings.TrimRight(reply, "\x00")
+}
+
+func startCGMiner(t *testing.T, m *Miner, writeAllow []string) string {
+	allow, _ := newCGAccessList(nil)
+	wa, err := newCGAccessList(writeAllow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go m.serveCGMiner(ln, allow, wa)
+	return ln.Addr().String()
+}
+
+func TestCGMinerSummaryAndPools(t *testing.T) {
+	m, _ := newTestMiner()
+	m.Pools[0].Priority, m.Pools[1].Priority = 1, 0
+	addr := startCGMiner(t, m, nil)
+
+	var summary struct {
+		Status  []cgStatus               `json:"STATUS"`
+		Summary []map[string]interface{} `json:"SUMMARY"`
+	}
+	if err := j.Unmarshal([]byte(cgQuery(t, addr, `{"command":"summary"}`)), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status[0].Code != cgCodeSumm || summary.Summary[0]["Accepted"].(float64) != 7 {
+		t.Error("unexpected summary", summary)
+	}
+
+	var joined map[string][]struct {
+		Pools []map[string]interface{} `json:"POOLS"`
+		Devs  []map[string]interface{} `json:"DEVS"`
+	}
+	if err := j.Unmarshal([]byte(cgQuery(t, addr, `{"command":"pools+devs"}`)), &joined); err != nil {
+		t.Fatal(err)
+	}
+	if len(joined["pools"][0].Pools) != 2 || len(joined["devs"][0].Devs) != 2 {
+		t.Fatal("unexpected joined reply", joined)
+	}
+	if pools := joined["pools"][0].Pools; pools[0]["Priority"] != float64(1) || pools[1]["Priority"] != float64(0) {
+		t.Error("pool priorities", pools[0]["Priority"], pools[1]["Priority"])
+	}
+	if _, ok := joined["devs"][0].Devs[0]["Accepted"]; ok {
+		t.Error("boards report accepted shares they do not count")
+	}
+
+	if reply := cgQuery(t, addr, "pools|"); !strings.HasPrefix(reply, "STATUS=S,") || !strings.Contains(reply, "POOL=1") {
+		t.Error("unexpected text reply", reply)
+	}
+}
+
+func TestCGMinerPrivileged(t *testing.T) {
+	m, drv := newTestMiner()
+	addr := startCGMiner(t, m, []string{"10.0.0.0/8"})
+	if reply := cgQuery(t, addr, `{"command":"switchpool","parameter":"1"}`); !strings.Contains(reply, `"Code":45`) {
+		t.Error("switchpool allowed without write access:", reply)
+	}
+
+	addr = startCGMiner(t, m, []string{"127.0.0.0/8"})
+	if reply := cgQuery(t, addr, `{"command":"switchpool","parameter":"7"}`); !strings.Contains(reply, `"Code":26`) {
+		t.Error("invalid pool id accepted:", reply)
+	}
+	if reply := cgQuery(t, addr, `{"command":"switchpool","parameter":"1"}`); !strings.Contains(reply, `"Code":27`) {
+		t.Error("switchpool failed:", reply)
+	}
+	if m.activeIdx != 1 || drv.client != m.clients[1] {
+		t.Error("pool was not switched")
+	}
+}
+
+func TestCGMinerPrivilegedKey(t *testing.T) {
+	m, drv := newTestMiner()
+	auth, err := newAuthenticator(AuthConfig{Keys: []APIKey{{Key: "operator", Role: "admin"}, {Key: "viewer", Role: "readonly"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.auth = auth
+	addr := startCGMiner(t, m, []string{"127.0.0.0/8"})
+
+	for _, request := range []string{
+		`{"command":"switchpool","parameter":"1"}`,
+		`{"command":"switchpool","parameter":"1","key":"viewer"}`,
+		`switchpool|1`,
+	} {
+		if reply := cgQuery(t, addr, request); !strings.Contains(reply, `"Code":45`) && !strings.Contains(reply, "Code=45") {
+			t.Error(request, "allowed without an admin key:", reply)
+		}
+	}
+	if reply := cgQuery(t, addr, `{"command":"summary"}`); !strings.Contains(reply, `"Code":11`) {
+		t.Error("summary needs a key:", reply)
+	}
+	if reply := cgQuery(t, addr, `{"command":"switchpool","parameter":"1","key":"operator"}`); !strings.Contains(reply, `"Code":27`) || !strings.Contains(reply, "stratum+tcp://b:2") {
+		t.Error("switchpool failed:", reply)
+	}
+	if m.activeIdx != 1 || drv.client != m.clients[1] {
+		t.Error("pool was not switched")
+	}
+}
@@ -0,0 +1,293 @@
+package miner
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/AGPFMiner/gominer/algorithms/ckb"
+	"github.com/AGPFMiner/gominer/algorithms/odocrypt"
+	"github.com/AGPFMiner/gominer/algorithms/skunk"
+	"github.com/AGPFMiner/gominer/algorithms/veo"
+	"github.com/AGPFMiner/gominer/algorithms/verus"
+	"github.com/AGPFMiner/gominer/algorithms/xdag"
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/mining"
+	"github.com/AGPFMiner/gominer/statistics"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//Chain is a link to a mux of boards, driven by its own driver.
+// A host with several UART or USB ports runs one chain per port, each may mine for its own pool.
+type Chain struct {
+	Name                            string
+	Driver, DevPath                 string
+	BaudRate                        uint
+	MuxNums                         int
+	SkipSlots                       []int
+	PollDelay, NonceTraverseTimeout int64
+	//Pool is the index in Pools the chain mines for, -1 follows the active pool
+	Pool int
+}
+
+//chain is a running Chain
+type chain struct {
+	Chain
+	driver driver.Driver
+	//index is the position in Miner.chains, offset the number of its first board across all chains
+	index, offset int
+	//algo is the algorithm the boards were last programmed for
+	algo string
+	//running is set between the Start and the Stop of the driver, a stopped driver is not stopped again
+	running bool
+}
+
+//deviceChain is the chain of the top level device settings, it follows the active pool
+func (m *Miner) deviceChain() Chain {
+	return Chain{
+		Name:                 m.DevPath,
+		Driver:               m.Driver,
+		DevPath:              m.DevPath,
+		BaudRate:             m.BaudRate,
+		MuxNums:              m.MuxNums,
+		SkipSlots:            m.SkipSlots,
+		PollDelay:            m.PollDelay,
+		NonceTraverseTimeout: m.NonceTraverseTimeout,
+		Pool:                 -1,
+	}
+}
+
+//chainSettings returns Chains, or the chain of the top level device settings if none are configured
+func (m *Miner) chainSettings() []Chain {
+	if len(m.Chains) > 0 {
+		return m.Chains
+	}
+	return []Chain{m.deviceChain()}
+}
+
+func (m *Miner) chainArgs(c *chain) mining.MinerArgs {
+	args := mining.MinerArgs{}
+	args.FPGADevice = c.DevPath
+	args.BaudRate = c.BaudRate
+	args.MuxNums = c.MuxNums
+	args.SkipSlots = c.SkipSlots
+	args.PollDelay = time.Duration(c.PollDelay)
+	if c.NonceTraverseTimeout != 0 {
+		args.NonceTraverseTimeout = time.Duration(c.NonceTraverseTimeout)
+	}
+	args.Logger = logger
+	args.History = m.history
+	args.Events = m.events
+	args.BoardOffset = c.offset
+	if len(m.chains) > 1 {
+		args.TotalSource = statistics.ChainSource(c.index)
+	}
+	args.Capture = m.Capture
+	if m.Capture != "" && len(m.chains) > 1 {
+		//one file per chain, the boards of each chain share its port
+		args.Capture = fmt.Sprintf("%s.%d", m.Capture, c.index)
+	}
+	return args
+}
+
+//BoardChain returns the chain called name, the board tools work on one chain at a time.
+// name may be empty if a single chain is configured.
+func (m *Miner) BoardChain(name string) (Chain, error) {
+	settings := m.chainSettings()
+	if name == "" {
+		if len(settings) > 1 {
+			return Chain{}, fmt.Errorf("%d chains configured, select one by name", len(settings))
+		}
+		return settings[0], nil
+	}
+	for _, c := range settings {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Chain{}, fmt.Errorf("No chain called %q", name)
+}
+
+//newDriver creates the driver called name
+func newDriver(name string, args mining.MinerArgs) (driver.Driver, error) {
+	switch name {
+	case "thyroid":
+		//the link to the boards, USB adapters included, is chosen by the device setting
+		return driver.NewThyroid(args), nil
+	}
+	return nil, fmt.Errorf("Driver %q is not supported", name)
+}
+
+//algoFuncs are the mining functions of every algorithm, the drivers and the proxy hash with them
+var algoFuncs = map[string]driver.MiningFuncs{
+	"ckb":      &ckb.MiningFuncs{},
+	"odocrypt": &odocrypt.MiningFuncs{},
+	"veo":      &veo.MiningFuncs{},
+	"skunk":    &skunk.MiningFuncs{},
+	"xdag":     &xdag.MiningFuncs{},
+	"verus":    &verus.MiningFuncs{},
+}
+
+func registerMiningFuncs(drv driver.Driver) {
+	for algo, funcs := range algoFuncs {
+		drv.RegisterMiningFuncs(algo, funcs)
+	}
+}
+
+//newChains creates a driver for every configured chain, boards are numbered across the chains in order
+func (m *Miner) newChains() error {
+	settings := m.chainSettings()
+	m.chains = make([]*chain, len(settings))
+	offset := 0
+	for i, s := range settings {
+		m.chains[i] = &chain{Chain: s, index: i, offset: offset}
+		offset += s.MuxNums
+	}
+	for _, c := range m.chains {
+		drv, err := newDriver(c.Driver, m.chainArgs(c))
+		if err != nil {
+			return fmt.Errorf("Chain %s: %v", c.Name, err)
+		}
+		registerMiningFuncs(drv)
+		c.driver = drv
+	}
+	return nil
+}
+
+//initChains applies the chain settings to the stopped drivers.
+// New drivers are created if chains were added or removed or a driver changed.
+func (m *Miner) initChains() error {
+	settings := m.chainSettings()
+	same := len(settings) == len(m.chains)
+	for i := 0; same && i < len(settings); i++ {
+		same = settings[i].Driver == m.chains[i].Driver
+	}
+	if !same {
+		return m.newChains()
+	}
+	offset := 0
+	for i, c := range m.chains {
+		c.Chain, c.offset = settings[i], offset
+		offset += c.MuxNums
+		switch c.Driver {
+		case "thyroid":
+			c.driver.Init(m.chainArgs(c))
+		}
+		registerMiningFuncs(c.driver)
+	}
+	return nil
+}
+
+//chainPool is the index of the pool c mines for
+func (m *Miner) chainPool(c *chain) int {
+	return poolsView{clients: m.clients, active: m.activeIdx}.chainPool(c)
+}
+
+//startChain starts the driver of c on its pool.
+// The boards are reprogrammed if forced or if the algorithm of the pool is not the programmed one.
+func (m *Miner) startChain(c *chain, forceProgram bool) {
+	client := m.clients[m.chainPool(c)]
+	algo := client.AlgoName()
+	c.driver.SetClient(client)
+	if (forceProgram || algo != c.algo) && algo != "odocrypt" {
+		if err := c.driver.ProgramBitstream(""); err != nil {
+			log.Print("Programming bitstream failed:", err)
+		}
+	}
+	c.algo = algo
+	c.driver.Start()
+	c.running = true
+}
+
+//stop stops the driver of c unless it is stopped already
+func (c *chain) stop() {
+	if !c.running {
+		return
+	}
+	c.driver.Stop()
+	c.running = false
+}
+
+//restartChains moves the chains whose pool client is no longer the one in prev to their current client
+func (m *Miner) restartChains(prev []clients.Client) {
+	for i, c := range m.chains {
+		if m.clients[m.chainPool(c)] == prev[i] {
+			continue
+		}
+		log.Print("Switching chain ", c.Name, " to pool:", m.Pools[m.chainPool(c)].URL)
+		c.stop()
+		m.startChain(c, false)
+	}
+}
+
+//chainClients lists the client every chain mines for
+func (m *Miner) chainClients() []clients.Client {
+	cs := make([]clients.Client, len(m.chains))
+	for i, c := range m.chains {
+		cs[i] = m.clients[m.chainPool(c)]
+	}
+	return cs
+}
+
+func (m *Miner) stopChains() {
+	for _, c := range m.chains {
+		c.stop()
+	}
+}
+
+//boardChain finds the chain of a board numbered across all chains and its number within the chain
+func (m *Miner) boardChain(board int) (*chain, int, bool) {
+	for _, c := range m.chains {
+		if board >= c.offset && board < c.offset+c.MuxNums {
+			return c, board - c.offset, true
+		}
+	}
+	return nil, 0, false
+}
+
+//boardCount is the number of boards over all chains
+func (m *Miner) boardCount() (boards int) {
+	for _, c := range m.chains {
+		boards += c.MuxNums
+	}
+	return
+}
+
+func (m *Miner) chainsStats() (chainsInfo []*types.ChainStates) {
+	view := m.poolsSnapshot()
+	for _, c := range m.chains {
+		ds := c.driver.GetDriverStats()
+		chainsInfo = append(chainsInfo, &types.ChainStates{
+			Name:          c.Name,
+			Driver:        c.Driver,
+			Device:        c.DevPath,
+			Algo:          ds.Algo,
+			Status:        ds.Status,
+			Pool:          view.chainPool(c),
+			FollowsActive: c.Pool < 0,
+			FirstBoard:    c.offset,
+			Boards:        c.MuxNums,
+			Hashrate:      ds.Hashrate,
+			Stale:         ds.Stale,
+			Nonces:        ds.Nonces,
+			Transport:     ds.Transport,
+		})
+	}
+	return
+}
+
+//hardwareSummary sums the hashrate, stale and checked nonces of all chains, status is the one of the first chain that is not running
+func (m *Miner) hardwareSummary() (status types.HardwareStats, hashrate [3]float64, stale uint64, nonces types.NonceClasses) {
+	for i, cs := range m.chainsStats() {
+		if i == 0 || status == types.Running {
+			status = cs.Status
+		}
+		for j := range hashrate {
+			hashrate[j] += cs.Hashrate[j]
+		}
+		stale += cs.Stale
+		nonces.Add(cs.Nonces)
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package miner
+
+import (
+	j "encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//newChainsMiner adds a single board chain bound to the first pool to the test miner
+func newChainsMiner() (m *Miner, follower, bound *fakeDriver) {
+	m, follower = newTestMiner()
+	bound = &fakeDriver{}
+	m.chains[0].Name = "mux"
+	m.chains = append(m.chains, &chain{Chain: Chain{Name: "usb", MuxNums: 1, Pool: 0}, driver: bound, index: 1, offset: 2, algo: "ckb", running: true})
+	return
+}
+
+func TestChainsAPI(t *testing.T) {
+	m, _, _ := newChainsMiner()
+
+	var summary types.Summary
+	j.NewDecoder(serve(m, http.MethodGet, "/api/v1/summary").Body).Decode(&summary)
+	if summary.Devices != 3 || summary.Chains != 2 || summary.Hashrate != [3]float64{2, 4, 6} {
+		t.Error("chains not aggregated in the summary", summary)
+	}
+
+	var chains []types.ChainStates
+	j.NewDecoder(serve(m, http.MethodGet, "/api/v1/chains").Body).Decode(&chains)
+	if len(chains) != 2 || chains[1].Name != "usb" || chains[1].FirstBoard != 2 || chains[1].FollowsActive || !chains[0].FollowsActive {
+		t.Error("unexpected chains", chains)
+	}
+
+	var devices []types.DriverStates
+	j.NewDecoder(serve(m, http.MethodGet, "/api/v1/devices").Body).Decode(&devices)
+	if len(devices) != 3 || devices[1].Chain != "mux" || devices[2].Chain != "usb" {
+		t.Error("boards not listed across chains", devices)
+	}
+	if w := serve(m, http.MethodPost, "/api/v1/devices/3/reset"); w.Code != http.StatusNotFound {
+		t.Error("reset of a missing board returned", w.Code)
+	}
+}
+
+func TestSwitchPoolKeepsBoundChains(t *testing.T) {
+	m, follower, bound := newChainsMiner()
+	bound.client = m.clients[0]
+	if err := m.SwitchPool(1); err != nil {
+		t.Fatal(err)
+	}
+	if follower.client != m.clients[1] || follower.programmed != 1 || follower.starts != 1 {
+		t.Error("following chain not switched")
+	}
+	if bound.client != m.clients[0] || bound.stops != 0 || bound.programmed != 0 {
+		t.Error("bound chain was switched")
+	}
+}
+
+func TestBoardChain(t *testing.T) {
+	m, _ := newTestMiner()
+	m.Driver, m.DevPath = "thyroid", "/dev/ttyAMA0"
+	if c, err := m.BoardChain(""); err != nil || c.DevPath != "/dev/ttyAMA0" || c.MuxNums != 2 {
+		t.Error("top level device not selected", c, err)
+	}
+
+	m.Chains = []Chain{{Name: "mux", Driver: "thyroid", DevPath: "/dev/ttyAMA0", MuxNums: 2}, {Name: "usb", Driver: "thyroid", DevPath: "/dev/ttyUSB0", MuxNums: 1}}
+	if _, err := m.BoardChain(""); err == nil {
+		t.Error("no chain selected out of two")
+	}
+	if _, err := m.BoardChain("none"); err == nil {
+		t.Error("unknown chain selected")
+	}
+	c, err := m.BoardChain("usb")
+	if err != nil || c.DevPath != "/dev/ttyUSB0" || c.MuxNums != 1 {
+		t.Fatal("usb chain not selected", c, err)
+	}
+	if _, err := m.slotsDriver(c, []int{2}); err == nil {
+		t.Error("slot outside the chain accepted")
+	}
+}
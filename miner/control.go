@@ -0,0 +1,116 @@
+package miner
+
+import (
+	"errors"
+	"log"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//ErrNoSuchPool is returned when a control action references an unknown pool index
+var ErrNoSuchPool = errors.New("No such pool")
+
+//ErrNoSuchBoard is returned when a control action references an unknown board index
+var ErrNoSuchBoard = errors.New("No such board")
+
+//poolsView is a consistent copy of the pools, taken under the control lock
+type poolsView struct {
+	clients []clients.Client
+	pools   []types.Pool
+	active  int
+	algo    string
+}
+
+//poolsSnapshot copies the pool state SwitchPool, AddPool and reload change, readers range over the copy without the lock
+func (m *Miner) poolsSnapshot() poolsView {
+	m.ctrlMutex.Lock()
+	defer m.ctrlMutex.Unlock()
+	return poolsView{
+		clients: append([]clients.Client(nil), m.clients...),
+		pools:   append([]types.Pool(nil), m.Pools...),
+		active:  m.activeIdx,
+		algo:    m.currentAlgo,
+	}
+}
+
+//chainPool is the index of the pool c mines for
+func (v poolsView) chainPool(c *chain) int {
+	if c.Pool >= 0 && c.Pool < len(v.clients) {
+		return c.Pool
+	}
+	return v.active
+}
+
+//SwitchPool makes the pool at idx the active one and moves the chains that follow it, reprogramming the boards if the algorithm changes
+func (m *Miner) SwitchPool(idx int) error {
+	m.ctrlMutex.Lock()
+	defer m.ctrlMutex.Unlock()
+
+	if idx < 0 || idx >= len(m.clients) || m.clients[idx] == nil {
+		return ErrNoSuchPool
+	}
+	if idx == m.activeIdx {
+		return nil
+	}
+	client := m.clients[idx]
+	log.Print("Switching to pool:", client.GetPoolStats().PoolAddr)
+
+	m.activeIdx = idx
+	m.currentAlgo = client.AlgoName()
+	m.shareActivePool()
+	for _, c := range m.chains {
+		if c.Pool >= 0 {
+			continue
+		}
+		c.stop()
+		m.startChain(c, false)
+	}
+	return nil
+}
+
+//Reprogram loads bitstream onto every board of every chain, an empty path selects the bitstream of the chain's algorithm
+func (m *Miner) Reprogram(bitstream string) (err error) {
+	m.ctrlMutex.Lock()
+	defer m.ctrlMutex.Unlock()
+	for _, c := range m.chains {
+		if cerr := c.driver.ProgramBitstream(bitstream); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+//ResetBoard pulses the reset line of a single board, boards are numbered across all chains
+func (m *Miner) ResetBoard(board int) error {
+	m.ctrlMutex.Lock()
+	defer m.ctrlMutex.Unlock()
+
+	c, local, ok := m.boardChain(board)
+	if !ok {
+		return ErrNoSuchBoard
+	}
+	log.Print("Resetting board:", board)
+	return driver.ResetBoard(local, c.MuxNums)
+}
+
+//AddPool starts a client for pool and appends it to the pool list, an empty algorithm means the active one
+func (m *Miner) AddPool(pool types.Pool) (idx int, err error) {
+	m.ctrlMutex.Lock()
+	defer m.ctrlMutex.Unlock()
+
+	if pool.Algo == "" {
+		pool.Algo = m.currentAlgo
+	}
+	pool.Active = false
+	idx = len(m.clients)
+	client, err := m.startClient(idx, pool)
+	if err != nil {
+		return
+	}
+	m.Pools = append(m.Pools, pool)
+	m.clients = append(m.clients, client)
+	log.Print("Added pool:", pool.URL)
+	return
+}
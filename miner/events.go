@@ -0,0 +1,75 @@
+package miner
+
+import (
+	j "encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/events"
+)
+
+const (
+	eventBuffer    = 256
+	eventKeepalive = 15 * time.Second
+)
+
+//watchJobs publishes the jobs received by the client of pool idx
+func (m *Miner) watchJobs(idx int, client clients.Client) {
+	client.SetNewJobCall(func(jobid string, clean bool) {
+		eventType := events.JobNew
+		if clean {
+			eventType = events.JobClean
+		}
+		m.events.Publish(events.New(eventType, -1, idx, map[string]interface{}{"jobid": jobid}))
+		if m.proxy != nil {
+			m.proxy.JobChanged(client, clean)
+		}
+	})
+}
+
+//GetEvents streams events as server-sent events until the client disconnects.
+// ?types=share.accepted,share.rejected restricts the stream to the given event types.
+func (m *Miner) GetEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || m.events == nil {
+		writeError(w, http.StatusNotImplemented, "event stream not available")
+		return
+	}
+	var types []events.Type
+	if v := r.URL.Query().Get("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			types = append(types, events.Type(strings.TrimSpace(t)))
+		}
+	}
+	sub := m.events.Subscribe(eventBuffer, types...)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-sub.C:
+			data, err := j.Marshal(&e)
+			if err != nil {
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,93 @@
+package miner
+
+import (
+	"bufio"
+	j "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/events"
+
+	"github.com/gorilla/mux"
+)
+
+func TestEventStream(t *testing.T) {
+	m, _ := newTestMiner()
+	m.events = events.NewBus()
+	r := mux.NewRouter()
+	m.registerAPI(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/events?types=share.accepted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatal("unexpected content type", ct)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		m.events.Publish(events.New(events.JobNew, -1, 0, nil))
+		m.events.Publish(events.New(events.ShareAccepted, 1, -1, map[string]interface{}{"jobid": 7}))
+	}()
+
+	reader := bufio.NewReader(resp.Body)
+	var name, data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "event: ") {
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if name != string(events.ShareAccepted) {
+		t.Fatal("unexpected event", name)
+	}
+	var e events.Event
+	if err := j.Unmarshal([]byte(data), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Board != 1 || e.Data["jobid"] != float64(7) {
+		t.Error("unexpected event data", data)
+	}
+}
+
+func TestJobEvents(t *testing.T) {
+	m, _ := newTestMiner()
+	m.events = events.NewBus()
+	sub := m.events.Subscribe(4)
+	defer sub.Close()
+
+	client := m.clients[1].(*fakeClient)
+	m.watchJobs(1, client)
+	client.DeprecateOutstandingJobs()
+	client.AddJobToDeprecate("a")
+	client.AddJobToDeprecate("b")
+
+	got := map[string]events.Type{}
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-sub.C:
+			if e.Pool != 1 {
+				t.Error("job event for pool", e.Pool)
+			}
+			got[e.Data["jobid"].(string)] = e.Type
+		case <-time.After(time.Second):
+			t.Fatal("job event missing")
+		}
+	}
+	if got["a"] != events.JobClean || got["b"] != events.JobNew {
+		t.Error("unexpected job events", got)
+	}
+}
@@ -0,0 +1,195 @@
+package miner
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/events"
+	"github.com/AGPFMiner/gominer/statistics"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+const (
+	poolSampleInterval     = time.Second
+	hardwareSampleInterval = time.Minute
+)
+
+//recordHistory samples pool shares every second and board temperature/voltage every minute.
+// Hashrate is recorded by the drivers themselves, summed here if there are several chains.
+// Pool state changes and temperature alarms noticed while sampling are published as events.
+// Share counters are tracked per client, a replaced client starts counting from zero.
+func (m *Miner) recordHistory() {
+	prevShares := make(map[clients.Client]shareCounts)
+	prevStatus := make(map[int]types.PoolConnectionStates)
+	poolTicker := time.NewTicker(poolSampleInterval)
+	hardwareTicker := time.NewTicker(hardwareSampleInterval)
+	defer poolTicker.Stop()
+	defer hardwareTicker.Stop()
+	for {
+		select {
+		case now := <-poolTicker.C:
+			poolClients := m.poolsSnapshot().clients
+			for i, client := range poolClients {
+				if client == nil {
+					continue
+				}
+				stats := client.GetPoolStats()
+				if prev, ok := prevStatus[i]; !ok || prev != stats.Status {
+					m.events.Publish(events.New(events.PoolStateChanged, -1, i, map[string]interface{}{
+						"pooladdr": stats.PoolAddr,
+						"from":     prev.String(),
+						"to":       stats.Status.String(),
+					}))
+					prevStatus[i] = stats.Status
+				}
+			}
+			prevShares = m.recordShares(now, poolClients, prevShares)
+			if len(m.chains) > 1 {
+				m.recordTotalHashrate(now)
+			}
+		case now := <-hardwareTicker.C:
+			for board, ds := range m.devicesStats() {
+				if temp, err := strconv.ParseFloat(ds.Temperature, 64); err == nil {
+					m.history.Record(statistics.MetricTemperature, statistics.BoardSource(board), now, temp)
+					if m.TempAlarm > 0 && temp >= m.TempAlarm {
+						m.events.Publish(events.New(events.TemperatureAlarm, board, -1, map[string]interface{}{
+							"temperature": temp,
+							"threshold":   m.TempAlarm,
+						}))
+					}
+				}
+				if volt, err := strconv.ParseFloat(ds.Voltage, 64); err == nil {
+					m.history.Record(statistics.MetricVoltage, statistics.BoardSource(board), now, volt)
+				}
+			}
+		}
+	}
+}
+
+//recordShares records the shares every client got since prev, per pool and in total.
+// It returns the counters the next sample is compared with.
+func (m *Miner) recordShares(now time.Time, poolClients []clients.Client, prev map[clients.Client]shareCounts) map[clients.Client]shareCounts {
+	var accepted, rejected float64
+	shares := make(map[clients.Client]shareCounts)
+	for i, client := range poolClients {
+		if client == nil {
+			continue
+		}
+		stats := client.GetPoolStats()
+		shares[client] = shareCounts{stats.Accept, stats.Reject}
+		deltaAccept := counterDelta(prev[client].accept, stats.Accept)
+		deltaReject := counterDelta(prev[client].reject, stats.Reject)
+		m.history.Record(statistics.MetricAccepted, statistics.PoolSource(i), now, float64(deltaAccept))
+		m.history.Record(statistics.MetricRejected, statistics.PoolSource(i), now, float64(deltaReject))
+		accepted += float64(deltaAccept)
+		rejected += float64(deltaReject)
+	}
+	m.history.Record(statistics.MetricAccepted, statistics.SourceTotal, now, accepted)
+	m.history.Record(statistics.MetricRejected, statistics.SourceTotal, now, rejected)
+	return shares
+}
+
+type shareCounts struct {
+	accept, reject int32
+}
+
+//counterDelta is the increase of a share counter since prev, a counter below prev was reset
+func counterDelta(prev, cur int32) int32 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+//recordTotalHashrate sums the hashrate the chains recorded in the last complete second.
+// A single chain records the total itself, several record their own source each.
+func (m *Miner) recordTotalHashrate(now time.Time) {
+	second := now.Truncate(time.Second).Add(-time.Second)
+	var total float64
+	for _, c := range m.chains {
+		if _, points, ok := m.history.Query(statistics.MetricHashrate, statistics.ChainSource(c.index), second, second); ok && len(points) > 0 {
+			total += points[0].Value
+		}
+	}
+	m.history.Record(statistics.MetricHashrate, statistics.SourceTotal, second, total)
+}
+
+//devicesStats lists the boards of every chain in order
+func (m *Miner) devicesStats() (devsInfo []*types.DriverStates) {
+	for _, c := range m.chains {
+		var chainInfo []*types.DriverStates
+		if c.MuxNums > 1 {
+			chainInfo = c.driver.GetDriverStatsMulti()
+		} else if c.MuxNums == 1 {
+			ds := c.driver.GetDriverStats()
+			chainInfo = append(chainInfo, &ds)
+		}
+		for _, ds := range chainInfo {
+			ds.Chain = c.Name
+		}
+		devsInfo = append(devsInfo, chainInfo...)
+	}
+	return
+}
+
+type historyReply struct {
+	Metric string             `json:"metric"`
+	Source string             `json:"source"`
+	Step   int64              `json:"step"`
+	Points []statistics.Point `json:"points"`
+}
+
+//GetHistory answers range queries on the recorded time series.
+// Without a metric it lists the available series.
+// ?metric=hashrate&source=board/0&from=<unix>&to=<unix>, from defaults to one hour ago and to to now
+func (m *Miner) GetHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	if metric == "" {
+		writeJSON(w, http.StatusOK, m.history.Keys())
+		return
+	}
+	source := query.Get("source")
+	if source == "" {
+		source = statistics.SourceTotal
+	}
+
+	to := time.Now()
+	from := to.Add(-time.Hour)
+	var err error
+	if v := query.Get("from"); v != "" {
+		if from, err = parseUnix(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid from")
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if to, err = parseUnix(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid to")
+			return
+		}
+	}
+
+	step, points, ok := m.history.Query(metric, source, from, to)
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown series")
+		return
+	}
+	writeJSON(w, http.StatusOK, &historyReply{
+		Metric: metric,
+		Source: source,
+		Step:   int64(step / time.Second),
+		Points: points,
+	})
+}
+
+func parseUnix(v string) (t time.Time, err error) {
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return
+	}
+	t = time.Unix(sec, 0)
+	return
+}
@@ -0,0 +1,46 @@
+package miner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/statistics"
+)
+
+func TestRecordSharesPerClient(t *testing.T) {
+	m, _ := newTestMiner()
+	m.history = statistics.NewStore()
+	now := time.Now().Truncate(time.Second).Add(-time.Minute)
+	accepted := func() float64 {
+		_, points, _ := m.history.Query(statistics.MetricAccepted, statistics.PoolSource(0), now, now)
+		if len(points) == 0 {
+			return -1
+		}
+		return points[0].Sum
+	}
+
+	prev := m.recordShares(now, m.clients, nil)
+	if accepted() != 3 {
+		t.Error("first sample recorded", accepted())
+	}
+
+	//a new client at the same index counts from zero
+	now = now.Add(time.Second)
+	replaced := &fakeClient{algo: "ckb", accept: 1}
+	prev = m.recordShares(now, []clients.Client{replaced, m.clients[1]}, prev)
+	if accepted() != 1 {
+		t.Error("replaced client recorded", accepted())
+	}
+
+	//a counter below the previous sample was reset
+	now = now.Add(time.Second)
+	replaced.accept = 5
+	prev = m.recordShares(now, []clients.Client{replaced, m.clients[1]}, prev)
+	now = now.Add(time.Second)
+	replaced.accept = 2
+	m.recordShares(now, []clients.Client{replaced, m.clients[1]}, prev)
+	if accepted() != 2 {
+		t.Error("reset counter recorded", accepted())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/AGPFMiner/gominer/algorithms/ckb"
@@ -14,8 +15,10 @@ import (
 	"github.com/AGPFMiner/gominer/algorithms/verus"
 	"github.com/AGPFMiner/gominer/algorithms/xdag"
 	"github.com/AGPFMiner/gominer/clients"
-	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/events"
 	"github.com/AGPFMiner/gominer/mining"
+	"github.com/AGPFMiner/gominer/proxy"
+	"github.com/AGPFMiner/gominer/statistics"
 	"github.com/AGPFMiner/gominer/types"
 
 	"github.com/gorilla/mux"
@@ -66,20 +69,53 @@ type Miner struct {
 	Driver, DevPath                 string
 	BaudRate                        uint
 	MuxNums                         int
+	SkipSlots                       []int
 	PollDelay, NonceTraverseTimeout int64
+	//Chains replaces the device settings above when several chains of boards are attached
+	Chains []Chain
+	//Capture is the file the traffic to the boards is recorded in for gominer replay, empty disables it
+	Capture string
 
 	WebEnable bool
 	WebListen string
+	Auth      AuthConfig
+	CGMiner   CGMinerConfig
+	//TempAlarm is the board temperature in °C above which an alarm event is published, 0 disables it
+	TempAlarm float64
+	//Proxy serves the jobs of the active pool to downstream miners
+	Proxy proxy.Config
 
 	LogLevel    string
+	Version     string
 	currentAlgo string
+	startTime   time.Time
+	ctrlMutex   sync.Mutex
 
-	driver    driver.Driver
+	chains    []*chain
 	clients   []clients.Client
 	miners    []mining.Miner
 	activeIdx int
+	history   *statistics.Store
+	events    *events.Bus
+	auth      *authenticator
+	proxy     *proxy.Server
 }
 
+//Algorithms lists the algorithms a pool can be configured with
+var Algorithms = []string{"ckb", "odocrypt", "veo", "skunk", "xdag", "verus"}
+
+//SoloAlgorithms lists the algorithms that can mine solo on a node, through getblocktemplate
+// or the get_block_template of ckb
+var SoloAlgorithms = []string{"ckb", "odocrypt", "skunk"}
+
+//ProxyAlgorithms lists the algorithms whose stratum pools the proxy can share with downstream miners,
+// the ones with a stratum client the host can also check the shares of
+var ProxyAlgorithms = []string{"skunk"}
+
+//PayoutAlgorithms lists the solo algorithms that build the coinbase themselves and need the payout address,
+// a ckb node pays the block assembler of its own configuration
+var PayoutAlgorithms = []string{"odocrypt", "skunk"}
+
 func getMinerByName(pool *types.Pool) (mining.Miner, clients.Client, error) {
 	switch pool.Algo {
 	case "ckb":
@@ -95,128 +131,221 @@ func getMinerByName(pool *types.Pool) (mining.Miner, clients.Client, error) {
 	case "verus":
 		return &verus.Miner{}, verus.NewClient(pool), nil
 	default:
-		return nil, nil, errors.New("Not supported")
+		return nil, nil, errors.New("Algorithm " + pool.Algo + " is not supported")
 	}
 }
 
-//Reload the main miner
-func (m *Miner) Reload() {
-	m.driver.Stop()
-	log.Print("Reloading miner")
-	loglvl := selectZapLevel(m.LogLevel)
-	atom.SetLevel(loglvl)
-	for _, cli := range m.clients {
-		log.Print("Stopping pool:", cli.GetPoolStats().PoolAddr)
-		cli.Stop()
-	}
-	m.clients = make([]clients.Client, len(m.Pools))
-	// m.miners = make([]*mining.Miner, len(m.Pools))
+//newPoolClient creates the client for a pool, tests replace it to avoid network connections
+var newPoolClient = func(pool *types.Pool) (clients.Client, error) {
+	_, client, err := getMinerByName(pool)
+	return client, err
+}
 
-	prevAlgo := m.currentAlgo
+//NewClient creates the client for a pool without starting it
+func NewClient(pool *types.Pool) (clients.Client, error) {
+	return newPoolClient(pool)
+}
 
-	for i, pool := range m.Pools {
-		_, client, err := getMinerByName(&pool)
+//startClient creates and starts the client of the pool at idx
+func (m *Miner) startClient(idx int, pool types.Pool) (clients.Client, error) {
+	client, err := newPoolClient(&pool)
+	if err != nil {
+		return nil, err
+	}
+	m.watchJobs(idx, client)
+	go client.Start()
+	return client, nil
+}
+
+//ErrNoPools is returned when no configured pool can be used
+var ErrNoPools = errors.New("No usable pool configured")
+
+//startClients creates and starts a client for every pool.
+// Pools with an unsupported algorithm are dropped, so m.clients never holds nil
+// and stays index aligned with m.Pools.
+func (m *Miner) startClients() error {
+	var pools []types.Pool
+	m.clients = nil
+	m.activeIdx = 0
+	m.currentAlgo = ""
+	for _, pool := range m.Pools {
+		idx := len(m.clients)
+		client, err := m.startClient(idx, pool)
 		if err != nil {
+			log.Print("Skipping pool ", pool.URL, ": ", err)
 			continue
 		}
 		if pool.Active {
-			m.activeIdx = i
+			m.activeIdx = idx
 			m.currentAlgo = pool.Algo
 		}
-		go client.Start()
-		m.clients[i] = client
-		// m.miners[i] = &miner
+		pools = append(pools, pool)
+		m.clients = append(m.clients, client)
 	}
-
-	driverArgs := &mining.MinerArgs{}
-	driverArgs.FPGADevice = m.DevPath
-	driverArgs.BaudRate = m.BaudRate
-	driverArgs.MuxNums = m.MuxNums
-	driverArgs.PollDelay = time.Duration(m.PollDelay)
-	if m.NonceTraverseTimeout != 0 {
-		driverArgs.NonceTraverseTimeout = time.Duration(m.NonceTraverseTimeout)
+	m.Pools = pools
+	if len(m.clients) == 0 {
+		return ErrNoPools
 	}
-
-	switch m.Driver {
-	case "thyroid":
-		m.driver.Init(*driverArgs)
+	if m.currentAlgo == "" {
+		m.currentAlgo = m.Pools[0].Algo
 	}
+	return nil
+}
+
+//DefaultWebListen is used when WebListen is empty
+const DefaultWebListen = ":1234"
 
-	m.driver.SetClient(m.clients[m.activeIdx])
-	if prevAlgo != m.currentAlgo {
-		prevAlgo = m.currentAlgo
+//Reload restarts the pool clients and the driver, reprogramming the boards if the active algorithm changed.
+// If it fails the miner keeps mining as before and the error is returned.
+func (m *Miner) Reload() error {
+	m.ctrlMutex.Lock()
+	defer m.ctrlMutex.Unlock()
+	return m.reload(false)
+}
+
+//reload is Reload without locking, forceProgram reprograms the boards even if the algorithm is unchanged.
+// The new clients are started first, the running ones are only replaced once they are.
+func (m *Miner) reload(forceProgram bool) error {
+	log.Print("Reloading miner")
+	loglvl := selectZapLevel(m.LogLevel)
+	atom.SetLevel(loglvl)
+	prevPools, prevClients, prevActive, prevAlgo := m.Pools, m.clients, m.activeIdx, m.currentAlgo
+	prevChains := m.chains
+
+	if err := m.startClients(); err != nil {
+		log.Print("Reload aborted: ", err)
+		m.Pools, m.clients, m.activeIdx, m.currentAlgo = prevPools, prevClients, prevActive, prevAlgo
+		return err
+	}
+	m.stopChains()
+	for _, cli := range prevClients {
+		if cli == nil {
+			continue
+		}
+		log.Print("Stopping pool:", cli.GetPoolStats().PoolAddr)
+		cli.Stop()
 	}
 
-	m.driver.Start()
+	m.shareActivePool()
 
+	err := m.initChains()
+	if err != nil {
+		log.Print("Reload aborted, restarting the previous chains: ", err)
+		m.chains = prevChains
+	}
+	for _, c := range m.chains {
+		m.startChain(c, forceProgram)
+	}
+	return err
+}
+
+//shareActivePool makes the active pool the one the proxy shares, if it is enabled
+func (m *Miner) shareActivePool() {
+	if m.proxy == nil {
+		return
+	}
+	client := m.clients[m.activeIdx]
+	if err := m.proxy.SetUpstream(client, algoFuncs[client.AlgoName()]); err != nil {
+		log.Print("Stratum proxy: ", client.GetPoolStats().PoolAddr, ": ", err)
+	}
 }
 
 //MinerMain starts the miner
 func (m *Miner) MinerMain() {
 	log.SetOutput(os.Stdout)
+	m.startTime = time.Now()
 
-	m.clients = make([]clients.Client, len(m.Pools))
 	m.miners = make([]mining.Miner, len(m.Pools))
 
 	logger := initLogger(m.LogLevel)
+	m.history = statistics.NewStore()
+	m.events = events.NewBus()
 
-	driverArgs := &mining.MinerArgs{}
-	driverArgs.FPGADevice = m.DevPath
-	driverArgs.BaudRate = m.BaudRate
-	driverArgs.MuxNums = m.MuxNums
-	driverArgs.PollDelay = time.Duration(m.PollDelay)
-	if m.NonceTraverseTimeout != 0 {
-		driverArgs.NonceTraverseTimeout = time.Duration(m.NonceTraverseTimeout)
+	if err := m.newChains(); err != nil {
+		logger.Fatal("Driver", zap.Error(err))
 	}
-	driverArgs.Logger = logger
 
-	switch m.Driver {
-	case "thyroid":
-		m.driver = driver.NewThyroid(*driverArgs)
-	case "thyroidUSB":
-		// m.driver = driver.NewThyroidUSB(*driverArgs)
+	if m.Proxy.Enable {
+		m.proxy = proxy.New(m.Proxy)
 	}
-
-	for i, pool := range m.Pools {
-		_, client, err := getMinerByName(&pool)
-		if err != nil {
-			continue
-		}
-		if pool.Active {
-			m.activeIdx = i
-			m.currentAlgo = pool.Algo
+	if err := m.startClients(); err != nil {
+		logger.Fatal("Pools", zap.Error(err))
+	}
+	m.shareActivePool()
+
+	for _, c := range m.chains {
+		client := m.clients[m.chainPool(c)]
+		c.algo = client.AlgoName()
+		c.driver.SetClient(client)
+		switch c.algo {
+		case "odocrypt":
+			// let driver manage odo bit
+		default:
+			go c.driver.ProgramBitstream("")
 		}
-		go client.Start()
-		m.clients[i] = client
-		// m.miners[i] = &miner
+		c.driver.Start()
+		c.running = true
 	}
+	go m.recordHistory()
 
-	m.driver.RegisterMiningFuncs("ckb", &ckb.MiningFuncs{})
-	m.driver.RegisterMiningFuncs("odocrypt", &odocrypt.MiningFuncs{})
-	m.driver.RegisterMiningFuncs("veo", &veo.MiningFuncs{})
-	m.driver.RegisterMiningFuncs("skunk", &skunk.MiningFuncs{})
-	m.driver.RegisterMiningFuncs("xdag", &xdag.MiningFuncs{})
-
-	m.driver.SetClient(m.clients[m.activeIdx])
-
-	switch m.currentAlgo {
-	case "odocrypt":
-		// let driver manage odo bit
-	default:
-		go m.driver.ProgramBitstream("")
+	auth, err := newAuthenticator(m.Auth)
+	if err != nil {
+		logger.Fatal("Web API", zap.Error(err))
+	}
+	m.auth = auth
+	if !m.Auth.Enabled() {
+		logger.Warn("Web API", zap.String("auth", "no credentials configured, control endpoints are open"))
 	}
-	m.driver.Start()
 
 	s := rpc.NewServer()
 	s.RegisterCodec(json.NewCodec(), "application/json")
 	s.RegisterCodec(json.NewCodec(), "application/json;charset=UTF-8")
 	s.RegisterService(m, "miner")
 	r := mux.NewRouter()
-	r.Handle("/rpc", s)
+	r.Handle("/rpc", m.auth.require(RoleReadOnly, s))
+
+	r.Handle("/gominer/f_status", m.guard(RoleReadOnly, m.GetScriptaStatus))
+	r.Handle("/gominer/f_miner", m.guard(RoleAdmin, m.MinerCtrl))
+	r.Handle("/gominer/f_history", m.guard(RoleReadOnly, m.GetHistory))
+	m.registerAPI(r)
+
+	if m.CGMiner.Enable {
+		go func() {
+			if err := m.ServeCGMinerAPI(m.CGMiner); err != nil {
+				logger.Error("cgminer API", zap.Error(err))
+			}
+		}()
+	}
+	if m.proxy != nil {
+		go func() {
+			if err := m.proxy.ListenAndServe(); err != nil {
+				logger.Error("Stratum proxy", zap.Error(err))
+			}
+		}()
+	}
 
-	r.HandleFunc("/gominer/f_status", m.GetScriptaStatus)
-	r.HandleFunc("/gominer/f_miner", m.MinerCtrl)
-	http.ListenAndServe(":1234", r)
+	if !m.WebEnable {
+		log.Print("Web API disabled")
+		select {}
+	}
+	listen := m.WebListen
+	if listen == "" {
+		listen = DefaultWebListen
+	}
+	tlsConfig, err := m.auth.tlsConfig()
+	if err != nil {
+		logger.Fatal("Web API", zap.Error(err))
+	}
+	server := &http.Server{Addr: listen, Handler: r, TLSConfig: tlsConfig}
+	log.Print("Web API listening on ", listen)
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		logger.Fatal("Web API", zap.Error(err))
+	}
 }
 
 type MinerRPCArgs struct {
@@ -230,14 +359,15 @@ type MinerRPCReply struct {
 
 func (m *Miner) GetPoolsStats(r *http.Request, args *MinerRPCArgs, reply *MinerRPCReply) error {
 	var poolsInfo []*types.PoolStates
-	for _, client := range m.clients {
+	view := m.poolsSnapshot()
+	for _, client := range view.clients {
 		poolInfo := client.GetPoolStats()
 		poolsInfo = append(poolsInfo, &poolInfo)
 	}
 	res, _ := j.Marshal(poolsInfo)
 	// spew.Dump(string(res))
 	reply.PoolsInfo = string(res)
-	reply.Activated = m.activeIdx
+	reply.Activated = view.active
 	return nil
 }
 
@@ -246,73 +376,48 @@ type DriverRPCReply struct {
 }
 
 func (m *Miner) GetHardwareStats(r *http.Request, args *MinerRPCArgs, reply *DriverRPCReply) error {
-	driverStats := m.driver.GetDriverStats()
+	driverStats := m.chains[0].driver.GetDriverStats()
 	res, _ := j.Marshal(driverStats)
 	reply.DriverInfo = string(res)
 	return nil
 }
 
 func (m *Miner) GetScriptaStatus(w http.ResponseWriter, r *http.Request) {
-	var devsInfo []*types.DriverStates
-	if m.MuxNums > 1 {
-		devsInfo = m.driver.GetDriverStatsMulti()
-	} else if m.MuxNums == 1 {
-		ds := m.driver.GetDriverStats()
-		var devsInfo []*types.DriverStates
-		devsInfo = append(devsInfo, &ds)
-	}
-
-	var poolsInfo []*types.PoolStates
-	for i, client := range m.clients {
-		poolInfo := client.GetPoolStats()
-		if i == m.activeIdx {
-			poolInfo.Active = true
-		} else {
-			poolInfo.Active = false
-		}
-		poolsInfo = append(poolsInfo, &poolInfo)
-	}
-
 	data := &types.ScriptaStatus{
 		Status: &types.ScriptaMinerStatus{
-			Devs:      devsInfo,
-			Pools:     poolsInfo,
+			Devs:      m.devicesStats(),
+			Pools:     m.poolsStats(),
 			MinerUp:   true,
 			MinerDown: false,
 			Time:      time.Now().Unix(),
 		},
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	j.NewEncoder(w).Encode(data)
-	return
+	writeJSON(w, http.StatusOK, data)
 }
 
+//MinerCtrl is the legacy control endpoint, prefer the POST endpoints under /api/v1
 func (m *Miner) MinerCtrl(w http.ResponseWriter, r *http.Request) {
-	cmds, ok := r.URL.Query()["command"]
-
-	if !ok || len(cmds[0]) < 1 {
-		log.Println("Url Param 'cmd' is missing")
+	cmd := r.URL.Query().Get("command")
+	if cmd == "" {
+		writeError(w, http.StatusBadRequest, "Url Param 'command' is missing")
 		return
 	}
 
-	log.Print(cmds)
-	cmd := cmds[0]
+	log.Print("Control command: ", cmd)
 	switch cmd {
 	case "programbitstream":
-		err := m.driver.ProgramBitstream("")
-		log.Print(err)
-		if err != nil {
-			w.WriteHeader(http.StatusOK)
+		if err := m.Reprogram(""); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 	case "reload":
-		m.Reload()
+		if err := m.Reload(); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unknown command: "+cmd)
+		return
 	}
-	// err := m.driver.ProgramBitstream("")
-	// if err != nil {
-	// 	w.WriteHeader(http.StatusOK)
-	// 	return
-	// }
+	writeJSON(w, http.StatusOK, &types.ActionResult{Action: cmd, OK: true})
 }
@@ -0,0 +1,165 @@
+package miner
+
+import (
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//ApplyConfig moves the running miner to the settings of next with the smallest possible change.
+// A new log level, poll delay or nonce timeout is applied live, edited pools restart only their
+// own client, and device or algorithm changes restart the drivers and reprogram the boards.
+// API listener, authentication and stratum proxy settings only take effect after a restart of the process.
+func (m *Miner) ApplyConfig(next *Miner) {
+	m.ctrlMutex.Lock()
+	defer m.ctrlMutex.Unlock()
+
+	if m.chains == nil {
+		m.copySettings(next)
+		return
+	}
+
+	if next.LogLevel != m.LogLevel {
+		log.Print("Log level: ", next.LogLevel)
+		atom.SetLevel(selectZapLevel(next.LogLevel))
+	}
+	if next.WebEnable != m.WebEnable || next.WebListen != m.WebListen ||
+		!reflect.DeepEqual(next.Auth, m.Auth) || !reflect.DeepEqual(next.CGMiner, m.CGMiner) {
+		log.Print("API settings changed, they take effect after a restart")
+	}
+	if next.Proxy != m.Proxy {
+		log.Print("Stratum proxy settings changed, they take effect after a restart")
+	}
+
+	prevChains, nextChains := m.chainSettings(), next.chainSettings()
+	deviceChanged := len(prevChains) != len(nextChains) || next.Capture != m.Capture
+	tuned, canTune := false, true
+	for i := 0; !deviceChanged && i < len(nextChains); i++ {
+		deviceChanged = !sameDevice(prevChains[i], nextChains[i])
+		tuned = tuned || prevChains[i].PollDelay != nextChains[i].PollDelay ||
+			prevChains[i].NonceTraverseTimeout != nextChains[i].NonceTraverseTimeout
+		_, ok := m.chains[i].driver.(driver.Tuner)
+		canTune = canTune && ok
+	}
+	algoChanged := activeAlgo(next.Pools) != m.currentAlgo
+	prevPools := m.Pools
+	prev := &Miner{}
+	prev.copySettings(m)
+	m.copySettings(next)
+
+	if deviceChanged || algoChanged || (tuned && !canTune) {
+		log.Print("Device or algorithm changed, restarting")
+		prevClients := m.clients
+		if err := m.reload(deviceChanged); err != nil {
+			//the previous chains are back, keep the pools of the clients they mine for
+			pools := m.Pools
+			m.copySettings(prev)
+			if len(prevClients) == 0 || m.clients[0] != prevClients[0] {
+				m.Pools = pools
+			}
+		}
+		return
+	}
+	if tuned {
+		for i, c := range m.chains {
+			c.Chain = nextChains[i]
+			log.Print("Chain ", c.Name, " poll delay: ", c.PollDelay, "ms, nonce timeout: ", c.NonceTraverseTimeout, "ms")
+			c.driver.(driver.Tuner).Tune(time.Duration(c.PollDelay), time.Duration(c.NonceTraverseTimeout))
+		}
+	}
+	m.updatePools(prevPools)
+}
+
+func (m *Miner) copySettings(next *Miner) {
+	m.Pools = next.Pools
+	m.Driver, m.DevPath = next.Driver, next.DevPath
+	m.BaudRate = next.BaudRate
+	m.MuxNums = next.MuxNums
+	m.SkipSlots = next.SkipSlots
+	m.PollDelay, m.NonceTraverseTimeout = next.PollDelay, next.NonceTraverseTimeout
+	m.Chains = next.Chains
+	m.Capture = next.Capture
+	m.TempAlarm = next.TempAlarm
+	m.LogLevel = next.LogLevel
+}
+
+//sameDevice reports whether a and b only differ in the settings a running driver can be tuned with
+func sameDevice(a, b Chain) bool {
+	a.PollDelay, a.NonceTraverseTimeout = b.PollDelay, b.NonceTraverseTimeout
+	return reflect.DeepEqual(a, b)
+}
+
+//activeAlgo is the algorithm of the pool marked active, the first pool if none is
+func activeAlgo(pools []types.Pool) string {
+	for _, pool := range pools {
+		if pool.Active {
+			return pool.Algo
+		}
+	}
+	if len(pools) > 0 {
+		return pools[0].Algo
+	}
+	return ""
+}
+
+//samePool reports whether a and b can be served by the same client
+func samePool(a, b types.Pool) bool {
+	return a.URL == b.URL && a.User == b.User && a.Pass == b.Pass && a.Algo == b.Algo && a.Payout == b.Payout
+}
+
+//updatePools keeps the clients of unchanged pools, restarts the edited ones and
+// switches only the chains whose client was replaced
+func (m *Miner) updatePools(prevPools []types.Pool) {
+	prevClients, prevActive := m.clients, m.activeIdx
+	chainClients := m.chainClients()
+	reused := make([]bool, len(prevClients))
+
+	var pools []types.Pool
+	m.clients = nil
+	m.activeIdx = 0
+	for _, pool := range m.Pools {
+		idx := len(m.clients)
+		var client clients.Client
+		for i, prev := range prevPools {
+			if !reused[i] && i < len(prevClients) && samePool(prev, pool) {
+				reused[i] = true
+				client = prevClients[i]
+				m.watchJobs(idx, client)
+				break
+			}
+		}
+		if client == nil {
+			var err error
+			if client, err = m.startClient(idx, pool); err != nil {
+				log.Print("Skipping pool ", pool.URL, ": ", err)
+				continue
+			}
+			log.Print("Started pool:", pool.URL)
+		}
+		if pool.Active {
+			m.activeIdx = idx
+		}
+		pools = append(pools, pool)
+		m.clients = append(m.clients, client)
+	}
+	if len(m.clients) == 0 {
+		log.Print("Reload aborted: ", ErrNoPools)
+		m.Pools, m.clients, m.activeIdx = prevPools, prevClients, prevActive
+		return
+	}
+	m.Pools = pools
+
+	for i, client := range prevClients {
+		if !reused[i] {
+			log.Print("Stopping pool:", client.GetPoolStats().PoolAddr)
+			client.Stop()
+		}
+	}
+
+	m.shareActivePool()
+	m.restartChains(chainClients)
+}
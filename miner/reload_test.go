@@ -0,0 +1,157 @@
+package miner
+
+import (
+	j "encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/types"
+
+	"go.uber.org/zap"
+)
+
+//stubPoolClients makes new pools get fake clients, the returned function restores the factory
+func stubPoolClients() func() {
+	prevFactory := newPoolClient
+	newPoolClient = func(pool *types.Pool) (clients.Client, error) {
+		return &fakeClient{algo: pool.Algo}, nil
+	}
+	return func() { newPoolClient = prevFactory }
+}
+
+func newReloadMiner() (*Miner, *fakeDriver) {
+	m, drv := newTestMiner()
+	m.Pools[0].Active = true
+	m.Driver = "thyroid"
+	m.MuxNums = 2
+	m.PollDelay = 60
+	m.NonceTraverseTimeout = 1000
+	m.LogLevel = "error"
+	m.chains[0].Chain = m.deviceChain()
+	return m, drv
+}
+
+//nextConfig copies the settings of m, as a config file reload would produce them
+func nextConfig(m *Miner) *Miner {
+	next := &Miner{}
+	next.copySettings(m)
+	next.Pools = append([]types.Pool(nil), m.Pools...)
+	return next
+}
+
+func TestApplyLogLevel(t *testing.T) {
+	defer stubPoolClients()()
+	m, drv := newReloadMiner()
+	prevClients := append([]clients.Client(nil), m.clients...)
+	next := nextConfig(m)
+	next.LogLevel = "debug"
+	m.ApplyConfig(next)
+	if !atom.Enabled(zap.DebugLevel) {
+		t.Error("log level not applied")
+	}
+	if drv.stops != 0 || drv.starts != 0 || m.clients[0] != prevClients[0] || m.clients[1] != prevClients[1] {
+		t.Error("log level change restarted the miner")
+	}
+	atom.SetLevel(zap.InfoLevel)
+}
+
+func TestApplyPollDelay(t *testing.T) {
+	defer stubPoolClients()()
+	m, drv := newReloadMiner()
+	next := nextConfig(m)
+	next.PollDelay = 5
+	m.ApplyConfig(next)
+	if drv.pollDelay != 5 || drv.nonceTimeout != 1000 {
+		t.Error("poll delay not tuned", drv.pollDelay, drv.nonceTimeout)
+	}
+	if drv.stops != 0 {
+		t.Error("poll delay change restarted the driver")
+	}
+}
+
+func TestApplyPoolCredentials(t *testing.T) {
+	defer stubPoolClients()()
+	m, drv := newReloadMiner()
+	prevClients := append([]clients.Client(nil), m.clients...)
+
+	next := nextConfig(m)
+	next.Pools[1].Pass = "changed"
+	m.ApplyConfig(next)
+	if m.clients[0] != prevClients[0] || m.clients[1] == prevClients[1] {
+		t.Error("only the edited pool should get a new client")
+	}
+	if !prevClients[1].(*fakeClient).stopped || prevClients[0].(*fakeClient).stopped {
+		t.Error("wrong clients stopped")
+	}
+	if drv.stops != 0 {
+		t.Error("inactive pool change restarted the driver")
+	}
+
+	next = nextConfig(m)
+	next.Pools[0].User = "other"
+	m.ApplyConfig(next)
+	if drv.stops != 1 || drv.starts != 1 || drv.client != m.clients[0] || drv.client == prevClients[0] {
+		t.Error("driver not moved to the new active client")
+	}
+	if drv.inits != 0 || drv.programmed != 0 {
+		t.Error("pool change reinitialised the boards")
+	}
+}
+
+func TestApplyDeviceAndAlgo(t *testing.T) {
+	defer stubPoolClients()()
+	m, drv := newReloadMiner()
+	next := nextConfig(m)
+	next.BaudRate = 2000000
+	m.ApplyConfig(next)
+	if drv.inits != 1 || drv.programmed != 1 || drv.starts != 1 {
+		t.Error("device change did not restart and reprogram", drv.inits, drv.programmed, drv.starts)
+	}
+
+	next = nextConfig(m)
+	next.Pools[0].Active, next.Pools[1].Active = false, true
+	m.ApplyConfig(next)
+	if m.currentAlgo != "skunk" || drv.inits != 2 || drv.programmed != 2 || drv.client != m.clients[1] {
+		t.Error("algorithm change did not restart and reprogram")
+	}
+}
+
+func TestReloadFailureKeepsChains(t *testing.T) {
+	defer stubPoolClients()()
+	m, drv := newReloadMiner()
+	prevClients := append([]clients.Client(nil), m.clients...)
+
+	restore := newPoolClient
+	newPoolClient = func(pool *types.Pool) (clients.Client, error) {
+		return nil, errors.New("Unreachable")
+	}
+	w := serve(m, http.MethodPost, "/api/v1/actions/reload")
+	newPoolClient = restore
+	var apiErr types.APIError
+	if w.Code != http.StatusInternalServerError || j.NewDecoder(w.Body).Decode(&apiErr) != nil || apiErr.Error.Message != ErrNoPools.Error() {
+		t.Fatal("failed reload answered", w.Code)
+	}
+	if drv.stops != 0 || !m.chains[0].running || m.clients[0] != prevClients[0] || len(m.Pools) != 2 {
+		t.Error("failed reload did not keep the miner running")
+	}
+
+	m.Driver = "none"
+	if err := m.Reload(); err == nil {
+		t.Fatal("reload with an unknown driver succeeded")
+	}
+	if m.chains[0].driver != drv || !m.chains[0].running || drv.stops != 1 || drv.starts != 1 || drv.client != m.clients[0] {
+		t.Error("previous chain not restarted on the new clients", drv.stops, drv.starts)
+	}
+
+	m.stopChains()
+	m.stopChains()
+	m.Driver = "thyroid"
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if drv.stops != 2 || drv.starts != 2 {
+		t.Error("stopped driver stopped again", drv.stops, drv.starts)
+	}
+}
@@ -0,0 +1,34 @@
+package miner
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/driver/capture"
+	"github.com/AGPFMiner/gominer/events"
+	"github.com/AGPFMiner/gominer/mining"
+)
+
+//Replay runs a capture written with the capture setting through the nonce parser and the share
+// checks of the driver, offline and without a pool
+func Replay(path string, logLevel string) (driver.ReplayReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return driver.ReplayReport{}, err
+	}
+	defer f.Close()
+	r, err := capture.NewReader(f)
+	if err != nil {
+		return driver.ReplayReport{}, fmt.Errorf("%s: %v", path, err)
+	}
+
+	initLogger(logLevel)
+	//no mux, the boards are taken from the records
+	drv, err := newDriver("thyroid", mining.MinerArgs{MuxNums: 1, SkipSlots: []int{}, Logger: logger, Events: events.NewBus()})
+	if err != nil {
+		return driver.ReplayReport{}, err
+	}
+	registerMiningFuncs(drv)
+	return drv.(*driver.Thyroid).Replay(r)
+}
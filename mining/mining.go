@@ -4,6 +4,9 @@ import (
 	"time"
 
 	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/driver/transport"
+	"github.com/AGPFMiner/gominer/events"
+	"github.com/AGPFMiner/gominer/statistics"
 
 	"go.uber.org/zap"
 )
@@ -34,9 +37,20 @@ type MinerArgs struct {
 	BaudRate             uint
 	Client               *clients.Client
 	MuxNums              int
+	SkipSlots            []int
 	PollDelay            time.Duration
 	NonceTraverseTimeout time.Duration
 	Logger               *zap.Logger
+	History              *statistics.Store
+	Events               *events.Bus
+	//Transport replaces the link opened from FPGADevice, e.g. with a transport.Pipe in tests
+	Transport transport.Transport
+	//BoardOffset is added to the board numbers in history and events when several chains share them
+	BoardOffset int
+	//TotalSource is the history source of the total hashrate, statistics.SourceTotal if empty
+	TotalSource string
+	//Capture is the file the traffic to the boards is recorded in, nothing is recorded if empty
+	Capture string
 }
 
 //Miner declares the common 'Mine' method
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/clients/pooltest"
+	"github.com/AGPFMiner/gominer/clients/stratum"
+	"github.com/AGPFMiner/gominer/miner"
+	"github.com/AGPFMiner/gominer/types"
+
+	"github.com/spf13/cobra"
+)
+
+// The pools command groups the pool tools.
+var poolsCmd = &cobra.Command{
+	Use:   "pools",
+	Short: "Inspect the configured pools.",
+}
+
+var poolsTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Connect, subscribe and authorize to every configured pool and wait for the first job.",
+	Long: "Connect, subscribe and authorize to every configured pool and wait for the first job.\n" +
+		"The boards are not touched, so this can run next to a mining gominer.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := loadConfig()
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		failed := false
+		for i, pool := range cfg.Pools {
+			client, err := miner.NewClient(&pool)
+			if err != nil {
+				fmt.Printf("pool %d %s: FAIL %v\n", i, pool.URL, err)
+				failed = true
+				continue
+			}
+			r := pooltest.Run(pool, client, timeout)
+			printReport(i, &r)
+			if !r.OK() {
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var poolsMockCmd = &cobra.Command{
+	Use:   "mock",
+	Short: "Serve a mock stratum pool handing out a fixed job, to test against.",
+	Long: "Serve a mock stratum pool handing out a fixed job, to test against.\n" +
+		"Supported algorithms: " + strings.Join(pooltest.MockAlgorithms, ", "),
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		listen, _ := cmd.Flags().GetString("listen")
+		algo, _ := cmd.Flags().GetString("algo")
+		mock := pooltest.NewMockPool(algo)
+		mock.Difficulty, _ = cmd.Flags().GetFloat64("difficulty")
+		mock.Reject, _ = cmd.Flags().GetBool("reject")
+		if err := mock.Listen(listen); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Mock", algo, "pool listening on", mock.URL())
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		mock.Close()
+	},
+}
+
+var poolsReplayCmd = &cobra.Command{
+	Use:   "replay FILE",
+	Short: "Replay a stratum session recorded with the record pool setting against the pool client.",
+	Long: "Replay a stratum session recorded with the record pool setting against the pool client.\n" +
+		"The client builds the header of every recorded job and submits a fixed nonce for it, the\n" +
+		"transcript is printed as JSON. Put the recording into clients/pooltest/testdata as\n" +
+		"ALGO-NAME.jsonl and run its test with -update to keep it as a regression fixture.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		algo, _ := cmd.Flags().GetString("algo")
+		index, _ := cmd.Flags().GetInt("session")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		lines, err := stratum.ReadRecording(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sessions := stratum.Sessions(lines)
+		if index < 0 || index >= len(sessions) {
+			log.Fatalf("Session %d does not exist, the recording holds %d", index, len(sessions))
+		}
+
+		newClient := func(pool *types.Pool) clients.Client {
+			client, err := miner.NewClient(pool)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return client
+		}
+		t, err := pooltest.Replay(sessions[index], types.Pool{Algo: algo}, newClient, pooltest.Solvers[algo], timeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, _ := json.MarshalIndent(t, "", "  ")
+		fmt.Println(string(out))
+		if len(t.Mismatches) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	poolsTestCmd.Flags().Duration("timeout", 30*time.Second, "time allowed per pool")
+	poolsMockCmd.Flags().String("listen", "127.0.0.1:3333", "address to listen on")
+	poolsMockCmd.Flags().String("algo", "skunk", "stratum dialect to speak")
+	poolsMockCmd.Flags().Float64("difficulty", 1, "difficulty sent to skunk and odocrypt miners")
+	poolsMockCmd.Flags().Bool("reject", false, "refuse every authorization")
+	poolsReplayCmd.Flags().String("algo", "", "algorithm of the recorded pool")
+	poolsReplayCmd.MarkFlagRequired("algo")
+	poolsReplayCmd.Flags().Int("session", 0, "connection of the recording to replay, counted from 0")
+	poolsReplayCmd.Flags().Duration("timeout", 5*time.Second, "time allowed for every step of the client")
+	poolsCmd.AddCommand(poolsTestCmd, poolsMockCmd, poolsReplayCmd)
+}
+
+func printReport(i int, r *pooltest.Report) {
+	status := "ok"
+	if !r.OK() {
+		status = "FAIL " + r.Err.Error()
+	}
+	fmt.Printf("pool %d %s (%s): %s\n", i, r.URL, r.Algo, status)
+	steps := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"connected", r.Connect},
+		{"subscribed", r.Subscribe},
+		{"authorized", r.Authorize},
+		{"difficulty", r.FirstDifficulty},
+		{"first job", r.FirstJob},
+	}
+	for _, step := range steps {
+		if step.d > 0 {
+			fmt.Printf("  %-12s %v\n", step.name, step.d.Round(time.Millisecond))
+		}
+	}
+	if r.ExtraNonce1 != "" || r.ExtraNonce2Size > 0 {
+		fmt.Printf("  %-12s %s, extranonce2 %d bytes\n", "extranonce1", r.ExtraNonce1, r.ExtraNonce2Size)
+	}
+	if r.Target != "" {
+		fmt.Printf("  %-12s %s\n", "target", r.Target)
+	} else if r.Difficulty != 0 {
+		fmt.Printf("  %-12s %v\n", "difficulty", r.Difficulty)
+	}
+	for _, oddity := range r.Oddities {
+		fmt.Printf("  %-12s %s\n", "oddity", oddity)
+	}
+}
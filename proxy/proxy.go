@@ -0,0 +1,347 @@
+//Package proxy serves the jobs of one pool connection to downstream miners over stratum.
+// Every miner gets its own range of the extranonce2 of the pool and its own difficulty,
+// its shares are checked on the host and the ones meeting the pool target are forwarded.
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/driver"
+)
+
+//DefaultListen is the port stratum pools commonly serve on
+const DefaultListen = ":3333"
+
+//DefaultShareTime is the number of seconds between the shares of a miner its difficulty is adjusted to
+const DefaultShareTime = 10
+
+//ExtraNonceSize is the number of leading bytes of the pool extranonce2 that tell the miners apart.
+// The downstream extranonce1 is the one of the pool followed by them, the miners roll the rest.
+// Prefix 0 is left to the boards of the proxy host, SetUpstream reserves the prefix bytes so their headers keep it.
+const ExtraNonceSize = 2
+
+//MinDifficulty is the lowest difficulty a miner is retargeted to
+const MinDifficulty = 1.0 / 1024
+
+//recentJobs is the number of jobs shares are accepted for until the pool cleans them
+const recentJobs = 16
+
+//Config configures the stratum proxy
+type Config struct {
+	Enable bool   `json:"enable"`
+	Listen string `json:"listen"`
+	//Difficulty is the difficulty miners start at, the pool difficulty if 0
+	Difficulty float64 `json:"difficulty"`
+	//ShareTime is the number of seconds between shares of a miner, DefaultShareTime if 0
+	ShareTime float64 `json:"sharetime"`
+}
+
+//Source is implemented by the pool clients whose jobs the proxy can share, the bitcoin style stratum clients
+type Source interface {
+	//SharedJob returns the current job with its extranonce2 at 0, the extranonce1 of the connection and the pool difficulty
+	SharedJob() (job jobs.BitcoinJob, extranonce1 []byte, difficulty float64, err error)
+	//ReserveExtraNonce2 keeps the leading bytes of the extranonce2 of the headers of the host at 0, 0 releases them
+	ReserveExtraNonce2(bytes uint)
+}
+
+//ErrNotShareable is returned by SetUpstream for pool clients that do not implement Source
+var ErrNotShareable = errors.New("The jobs of this pool cannot be shared with downstream miners")
+
+//ErrNoHostHash is returned by SetUpstream for algorithms the host cannot hash, their shares cannot be checked
+var ErrNoHostHash = errors.New("The host cannot hash the shares of this pool, they are not forwarded unchecked")
+
+//WorkerStats is the accounting of a worker name across its connections
+type WorkerStats struct {
+	Name        string  `json:"name"`
+	Connections int     `json:"connections"`
+	Difficulty  float64 `json:"difficulty"`
+	//Accepted shares met the difficulty of the miner and were not refused by the pool,
+	// ShareDifficulty is the sum of the difficulties they were mined at
+	Accepted        uint64  `json:"accepted"`
+	ShareDifficulty float64 `json:"share_difficulty"`
+	//Rejected shares were below the difficulty of the miner or malformed
+	Rejected   uint64 `json:"rejected"`
+	Stale      uint64 `json:"stale"`
+	Duplicates uint64 `json:"duplicates"`
+	//Forwarded shares met the pool target and were accepted by the pool, PoolRejected were refused by it
+	Forwarded    uint64 `json:"forwarded"`
+	PoolRejected uint64 `json:"pool_rejected"`
+	LastShare    int64  `json:"last_share"`
+}
+
+//Stats is the state of the proxy
+type Stats struct {
+	Listen string `json:"listen"`
+	//Pool is the address of the pool the shares go to, empty while there is none to share
+	Pool           string        `json:"pool"`
+	PoolDifficulty float64       `json:"pool_difficulty"`
+	Miners         int           `json:"miners"`
+	Workers        []WorkerStats `json:"workers"`
+}
+
+//Server is the stratum proxy
+type Server struct {
+	Config Config
+
+	listener net.Listener
+	mutex    sync.Mutex // protects following
+	upstream clients.Client
+	source   Source
+	funcs    driver.MiningFuncs
+	//job is the current job of the pool, recent the ones shares are accepted for in the order they came
+	job            jobs.BitcoinJob
+	recent         []jobs.BitcoinJob
+	extranonce1    []byte
+	poolDifficulty float64
+	sessions       map[*session]bool
+	prefixes       map[uint64]bool
+	workers        map[string]*WorkerStats
+	stop           chan bool
+}
+
+//New creates a proxy, SetUpstream gives it the pool to share
+func New(config Config) *Server {
+	return &Server{
+		Config:   config,
+		sessions: make(map[*session]bool),
+		prefixes: make(map[uint64]bool),
+		workers:  make(map[string]*WorkerStats),
+	}
+}
+
+func (s *Server) shareTime() time.Duration {
+	if s.Config.ShareTime <= 0 {
+		return DefaultShareTime * time.Second
+	}
+	return time.Duration(s.Config.ShareTime * float64(time.Second))
+}
+
+//ListenAndServe serves downstream miners on the configured address until Close
+func (s *Server) ListenAndServe() error {
+	listen := s.Config.Listen
+	if listen == "" {
+		listen = DefaultListen
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	log.Print("Stratum proxy listening on ", listen)
+	return s.Serve(ln)
+}
+
+//Serve accepts downstream miners on ln until Close
+func (s *Server) Serve(ln net.Listener) error {
+	s.mutex.Lock()
+	s.listener = ln
+	s.stop = make(chan bool)
+	s.mutex.Unlock()
+	go s.retargetLoop()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		sess := newSession(s, conn)
+		s.mutex.Lock()
+		s.sessions[sess] = true
+		s.mutex.Unlock()
+		go sess.serve()
+	}
+}
+
+//Close stops listening and drops every miner
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	close(s.stop)
+	s.listener = nil
+	for sess := range s.sessions {
+		sess.close()
+	}
+	return err
+}
+
+//SetUpstream makes client the pool the shares go to, funcs check them against the targets.
+// The headers of the host keep extranonce2 prefix 0, the previous upstream gets its whole extranonce2 back.
+// The miners are dropped when the extranonce1 changes, they reconnect and subscribe again.
+func (s *Server) SetUpstream(client clients.Client, funcs driver.MiningFuncs) error {
+	source, ok := client.(Source)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if ok && client == s.upstream {
+		return nil
+	}
+	if s.source != nil {
+		s.source.ReserveExtraNonce2(0)
+	}
+	s.upstream, s.source, s.funcs = nil, nil, nil
+	s.job, s.recent = jobs.BitcoinJob{}, nil
+	if !ok {
+		s.dropSessions()
+		return ErrNotShareable
+	}
+	if !hostHashes(funcs) {
+		s.dropSessions()
+		return ErrNoHostHash
+	}
+	s.upstream, s.source, s.funcs = client, source, funcs
+	source.ReserveExtraNonce2(ExtraNonceSize)
+	s.refresh(true)
+	return nil
+}
+
+//JobChanged tells the proxy that client has a new job, clean if the previous ones were abandoned.
+// Calls for clients other than the upstream are ignored.
+func (s *Server) JobChanged(client clients.Client, clean bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.upstream == nil || client != s.upstream {
+		return
+	}
+	s.refresh(clean)
+}
+
+//refresh fetches the current job of the pool and sends it to the miners
+func (s *Server) refresh(clean bool) {
+	job, extranonce1, poolDifficulty, err := s.source.SharedJob()
+	if err != nil {
+		return
+	}
+	if s.extranonce1 != nil && string(extranonce1) != string(s.extranonce1) {
+		log.Print("Stratum proxy: the pool changed the extranonce1, dropping the miners")
+		s.dropSessions()
+		clean = true
+	}
+	s.extranonce1 = extranonce1
+	s.poolDifficulty = poolDifficulty
+	if job.JobID == s.job.JobID && !clean {
+		return
+	}
+	if clean {
+		s.recent = nil
+	}
+	s.job = job
+	s.recent = append(s.recent, job)
+	if len(s.recent) > recentJobs {
+		s.recent = s.recent[len(s.recent)-recentJobs:]
+	}
+	for sess := range s.sessions {
+		sess.sendJob(job, clean)
+	}
+}
+
+//recentJob finds a job shares are accepted for
+func (s *Server) recentJob(jobID string) (jobs.BitcoinJob, bool) {
+	for _, job := range s.recent {
+		if job.JobID == jobID {
+			return job, true
+		}
+	}
+	return jobs.BitcoinJob{}, false
+}
+
+//hostHashes tells whether the host can hash the shares of funcs, RegenHash gives no hash for the algorithms it cannot
+func hostHashes(funcs driver.MiningFuncs) bool {
+	return funcs != nil && len(funcs.RegenHash(make([]byte, 80+32+8))) != 0
+}
+
+//clampDifficulty keeps the difficulty of a miner at most the pool difficulty, a higher one would drop pool shares
+func (s *Server) clampDifficulty(d float64) float64 {
+	if d <= 0 || d > s.poolDifficulty {
+		return s.poolDifficulty
+	}
+	if d < MinDifficulty {
+		return MinDifficulty
+	}
+	return d
+}
+
+//allocatePrefix returns the lowest free extranonce2 prefix, 0 if all are taken
+func (s *Server) allocatePrefix() uint64 {
+	for p := uint64(1); p < 1<<(8*ExtraNonceSize); p++ {
+		if !s.prefixes[p] {
+			s.prefixes[p] = true
+			return p
+		}
+	}
+	return 0
+}
+
+func (s *Server) dropSessions() {
+	for sess := range s.sessions {
+		sess.close()
+	}
+}
+
+//worker returns the accounting of name, creating it
+func (s *Server) worker(name string) *WorkerStats {
+	w, ok := s.workers[name]
+	if !ok {
+		w = &WorkerStats{Name: name}
+		s.workers[name] = w
+	}
+	return w
+}
+
+func (s *Server) retargetLoop() {
+	s.mutex.Lock()
+	stop := s.stop
+	s.mutex.Unlock()
+	ticker := time.NewTicker(s.shareTime())
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			for sess := range s.sessions {
+				sess.retarget(false, now)
+			}
+			s.mutex.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+//Stats returns the state of the proxy with the workers sorted by name
+func (s *Server) Stats() (stats Stats) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats.Listen = s.Config.Listen
+	if stats.Listen == "" {
+		stats.Listen = DefaultListen
+	}
+	if s.upstream != nil {
+		stats.Pool = s.upstream.GetPoolStats().PoolAddr
+	}
+	stats.PoolDifficulty = s.poolDifficulty
+	for sess := range s.sessions {
+		if sess.subscribed {
+			stats.Miners++
+		}
+	}
+	for _, w := range s.workers {
+		stats.Workers = append(stats.Workers, *w)
+	}
+	sort.Slice(stats.Workers, func(i, j int) bool { return stats.Workers[i].Name < stats.Workers[j].Name })
+	return
+}
+
+//target is the share target of a difficulty, as the bitcoin style clients compute it
+func target(d float64) []byte {
+	t, _ := difficulty.ToTarget(difficulty.Diff1, d)
+	return t
+}
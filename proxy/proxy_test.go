@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients"
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
+	"github.com/AGPFMiner/gominer/difficulty"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/types"
+)
+
+//fakePool is a pool client with a settable job that records the submitted shares
+type fakePool struct {
+	clients.BaseClient
+	mutex     sync.Mutex
+	job       jobs.BitcoinJob
+	submitted []jobs.BitcoinJob
+	nonces    []string
+	reject    bool
+	reserved  uint
+}
+
+func newFakePool() *fakePool {
+	return &fakePool{job: fakeJob("1")}
+}
+
+func fakeJob(id string) jobs.BitcoinJob {
+	return jobs.BitcoinJob{
+		JobID:       id,
+		PrevHash:    make([]byte, 32),
+		Coinbase1:   []byte{0x01, 0x00},
+		Coinbase2:   []byte{0xff},
+		Version:     []byte{0x20, 0, 0, 0},
+		NBits:       []byte{0x1a, 0x0f, 0xff, 0xf0},
+		NTime:       []byte{0x5d, 0x8a, 0x7e, 0x2c},
+		ExtraNonce2: jobs.ExtraNonce2{Size: 4},
+	}
+}
+
+func (p *fakePool) SharedJob() (jobs.BitcoinJob, []byte, float64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.job, []byte{0x08, 0x00, 0x00, 0x02}, 1, nil
+}
+
+func (p *fakePool) ReserveExtraNonce2(bytes uint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.reserved = bytes
+}
+
+func (p *fakePool) SubmitHeader(nonce []byte, job interface{}) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.reject {
+		return errFakeReject
+	}
+	p.submitted = append(p.submitted, job.(jobs.BitcoinJob))
+	p.nonces = append(p.nonces, hex.EncodeToString(nonce))
+	return nil
+}
+
+var errFakeReject = errors.New("Stale share")
+
+func (p *fakePool) GetHeaderForWork() (target []byte, difficulty float64, header []byte, deprecationChannel chan bool, job interface{}, err error) {
+	return
+}
+func (p *fakePool) Start()                                           {}
+func (p *fakePool) Stop()                                            {}
+func (p *fakePool) AlgoName() string                                 { return "skunk" }
+func (p *fakePool) PoolConnectionStates() types.PoolConnectionStates { return types.Alive }
+func (p *fakePool) GetPoolStats() types.PoolStates                   { return types.PoolStates{PoolAddr: "fake"} }
+
+//nonceFuncs take the nonce for the first bytes of the hash
+type nonceFuncs struct{}
+
+func (nonceFuncs) RegenHash(input []byte) []byte {
+	return append(append([]byte{}, input[116:120]...), make([]byte, 28)...)
+}
+func (nonceFuncs) DiffChecker(hash []byte, work driver.MiningWork) bool {
+	return difficulty.MeetsTarget(hash, work.Target)
+}
+func (nonceFuncs) ConstructHeaderPackets(header []byte, boardJobID uint8) []byte { return nil }
+
+//unhashedFuncs are the mining functions of an algorithm the host cannot hash
+type unhashedFuncs struct{ nonceFuncs }
+
+func (unhashedFuncs) RegenHash(input []byte) []byte { return nil }
+
+//testMiner speaks stratum to the proxy, keeping the notifications it gets
+type testMiner struct {
+	t       *testing.T
+	conn    net.Conn
+	scanner *bufio.Scanner
+	id      int
+	notes   []notification
+}
+
+type testReply struct {
+	ID     *int            `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  []interface{}   `json:"error"`
+	Method string          `json:"method"`
+	Params []interface{}   `json:"params"`
+}
+
+func dialMiner(t *testing.T, addr string) *testMiner {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testMiner{t: t, conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+func (m *testMiner) read() testReply {
+	if !m.scanner.Scan() {
+		m.t.Fatal("connection closed", m.scanner.Err())
+	}
+	var r testReply
+	if err := json.Unmarshal(m.scanner.Bytes(), &r); err != nil {
+		m.t.Fatal(err)
+	}
+	return r
+}
+
+//call sends a request and returns its reply, notifications in between are kept
+func (m *testMiner) call(method string, params ...interface{}) testReply {
+	m.id++
+	b, _ := json.Marshal(request{ID: m.id, Method: method, Params: params})
+	m.conn.Write(append(b, '\n'))
+	for {
+		r := m.read()
+		if r.ID != nil && *r.ID == m.id {
+			return r
+		}
+		m.notes = append(m.notes, notification{Method: r.Method, Params: r.Params})
+	}
+}
+
+//notification reads until the next notification of method
+func (m *testMiner) notification(method string) notification {
+	for len(m.notes) > 0 {
+		n := m.notes[0]
+		m.notes = m.notes[1:]
+		if n.Method == method {
+			return n
+		}
+	}
+	for {
+		r := m.read()
+		if r.Method == method {
+			return notification{Method: r.Method, Params: r.Params}
+		}
+	}
+}
+
+//startProxy serves a proxy for pool, it returns the address the miners dial
+func startProxy(t *testing.T, config Config, pool *fakePool, funcs driver.MiningFuncs) (*Server, string) {
+	s := New(config)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	if err := s.SetUpstream(pool, funcs); err != nil {
+		t.Fatal(err)
+	}
+	return s, ln.Addr().String()
+}
+
+func TestProxy(t *testing.T) {
+	pool := newFakePool()
+	s, addr := startProxy(t, Config{Difficulty: 1.0 / 256}, pool, nonceFuncs{})
+	defer s.Close()
+
+	miners := []*testMiner{dialMiner(t, addr), dialMiner(t, addr)}
+	for i, m := range miners {
+		r := m.call("mining.subscribe", "cgminer")
+		var result []interface{}
+		json.Unmarshal(r.Result, &result)
+		expected := []string{"080000020001", "080000020002"}[i]
+		if len(result) != 3 || result[1] != expected || result[2] != float64(2) {
+			t.Fatal("miner", i, "subscribed with", string(r.Result))
+		}
+		if d := m.notification("mining.set_difficulty"); d.Params[0] != 1.0/256 {
+			t.Error("miner", i, "difficulty", d.Params)
+		}
+		if n := m.notification("mining.notify"); n.Params[0] != "1" || n.Params[2] != "0100" || n.Params[8] != true {
+			t.Error("miner", i, "job", n.Params)
+		}
+		if r := m.call("mining.authorize", "rig"+string('a'+rune(i)), "x"); string(r.Result) != "true" {
+			t.Error("miner", i, "not authorized", r)
+		}
+	}
+
+	m := miners[1]
+	if r := m.call("mining.submit", "rigb", "1", "0102", "5d8a7e2d", "00000000"); string(r.Result) != "true" {
+		t.Error("pool share refused", r.Error)
+	}
+	if r := m.call("mining.submit", "rigb", "1", "0102", "5d8a7e2d", "00000000"); r.Error == nil || r.Error[0] != float64(22) {
+		t.Error("duplicate accepted", r)
+	}
+	if r := m.call("mining.submit", "rigb", "1", "0103", "5d8a7e2c", "000000ff"); string(r.Result) != "true" {
+		t.Error("miner share refused", r.Error)
+	}
+	if r := m.call("mining.submit", "rigb", "1", "0104", "5d8a7e2c", "0000ff00"); r.Error == nil || r.Error[0] != float64(23) {
+		t.Error("low difficulty share accepted", r)
+	}
+	if r := m.call("mining.submit", "rigb", "1", "0104", "5d8a7e2c", "0000ff00"); r.Error == nil || r.Error[0] != float64(23) {
+		t.Error("resent low difficulty share not rejected as such", r)
+	}
+	if r := m.call("mining.submit", "riga", "1", "0105", "5d8a7e2c", "00000000"); r.Error == nil || r.Error[0] != float64(24) {
+		t.Error("share of a worker of another connection accepted", r)
+	}
+
+	//only the pool share is forwarded, with the prefix of the miner in front of its extranonce2,
+	// the headers of the host keep prefix 0
+	pool.mutex.Lock()
+	if pool.reserved != ExtraNonceSize {
+		t.Error("reserved", pool.reserved, "extranonce2 bytes of the host")
+	}
+	if len(pool.submitted) != 1 {
+		t.Fatal("forwarded", len(pool.submitted), "shares")
+	}
+	forwarded := pool.submitted[0]
+	if forwarded.ExtraNonce2.Value != 0x00020102 || forwarded.ExtraNonce2.Size != 4 || hex.EncodeToString(forwarded.NTime) != "5d8a7e2d" || pool.nonces[0] != "0000000000000000" {
+		t.Error("forwarded", forwarded.ExtraNonce2, forwarded.NTime, pool.nonces)
+	}
+	pool.job = fakeJob("2")
+	pool.mutex.Unlock()
+
+	//a clean job makes the shares of the previous one stale
+	s.JobChanged(pool, true)
+	if n := m.notification("mining.notify"); n.Params[0] != "2" || n.Params[8] != true {
+		t.Error("job", n.Params)
+	}
+	if r := m.call("mining.submit", "rigb", "1", "0106", "5d8a7e2c", "000000ff"); r.Error == nil || r.Error[0] != float64(21) {
+		t.Error("stale share accepted", r)
+	}
+	//jobs of other clients are ignored
+	s.JobChanged(newFakePool(), true)
+
+	stats := s.Stats()
+	if stats.Miners != 2 || stats.Pool != "fake" || stats.PoolDifficulty != 1 || len(stats.Workers) != 2 {
+		t.Fatal("stats", stats)
+	}
+	rigb := stats.Workers[1]
+	if rigb.Name != "rigb" || rigb.Connections != 1 || rigb.Accepted != 2 || rigb.Forwarded != 1 || rigb.Rejected != 2 ||
+		rigb.Duplicates != 1 || rigb.Stale != 1 || rigb.ShareDifficulty != 2.0/256 {
+		t.Error("rigb", rigb)
+	}
+
+	//the prefix of a miner that left is handed out again
+	miners[0].conn.Close()
+	for s.Stats().Miners != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	r := dialMiner(t, addr).call("mining.subscribe")
+	var result []interface{}
+	json.Unmarshal(r.Result, &result)
+	if len(result) != 3 || result[1] != "080000020001" {
+		t.Error("subscribed with", string(r.Result))
+	}
+	if s.Stats().Workers[0].Connections != 0 {
+		t.Error("riga still connected")
+	}
+}
+
+func TestProxyNoHostHash(t *testing.T) {
+	pool := newFakePool()
+	s := New(Config{})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	defer s.Close()
+	if err := s.SetUpstream(pool, nonceFuncs{}); err != nil {
+		t.Fatal(err)
+	}
+	m := dialMiner(t, ln.Addr().String())
+	m.call("mining.subscribe")
+
+	//shares the host cannot check are never forwarded, the miners are dropped instead
+	unhashed := newFakePool()
+	if err := s.SetUpstream(unhashed, unhashedFuncs{}); err != ErrNoHostHash {
+		t.Error(err)
+	}
+	//the pool that is not shared anymore gets its whole extranonce2 back
+	if pool.reserved != 0 || unhashed.reserved != 0 {
+		t.Error("extranonce2 bytes still reserved", pool.reserved, unhashed.reserved)
+	}
+	for m.scanner.Scan() {
+	}
+	if m.scanner.Err() != nil {
+		t.Error("miner not dropped", m.scanner.Err())
+	}
+	if s.Stats().Pool != "" {
+		t.Error("pool without a host hash shared")
+	}
+}
+
+func TestProxyNotShareable(t *testing.T) {
+	s := New(Config{})
+	client := &struct{ fakePoolClient }{}
+	if err := s.SetUpstream(client, nonceFuncs{}); err != ErrNotShareable {
+		t.Error(err)
+	}
+	if s.Stats().Pool != "" {
+		t.Error("pool without jobs to share")
+	}
+}
+
+//fakePoolClient is a pool client without SharedJob
+type fakePoolClient struct{ clients.Client }
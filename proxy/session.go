@@ -0,0 +1,408 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AGPFMiner/gominer/clients/stratum/jobs"
+	"github.com/AGPFMiner/gominer/driver"
+)
+
+//writeTimeout drops a miner that does not read its messages
+const writeTimeout = 10 * time.Second
+
+//sendBuffer is the number of messages queued for a miner before it is dropped
+const sendBuffer = 32
+
+type request struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type response struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+type notification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+//stratumError is an error reply with the codes of the stratum mining protocol
+type stratumError struct {
+	code    int
+	message string
+}
+
+func (e *stratumError) reply() []interface{} {
+	return []interface{}{e.code, e.message, nil}
+}
+
+var (
+	errUnknownMethod = &stratumError{20, "Unknown method"}
+	errMalformed     = &stratumError{20, "Malformed share"}
+	errNoWork        = &stratumError{20, "No pool job to share yet"}
+	errStale         = &stratumError{21, "Job not found"}
+	errDuplicate     = &stratumError{22, "Duplicate share"}
+	errLowDifficulty = &stratumError{23, "Low difficulty share"}
+	errUnauthorized  = &stratumError{24, "Unauthorized worker"}
+	errNotSubscribed = &stratumError{25, "Not subscribed"}
+)
+
+//sessionJob is a job as a miner got it
+type sessionJob struct {
+	difficulty float64
+	submitted  map[string]bool
+}
+
+//session is the connection of a downstream miner, its fields are protected by the mutex of the server
+type session struct {
+	server *Server
+	conn   net.Conn
+	out    chan interface{}
+	done   chan bool
+	once   sync.Once
+
+	subscribed bool
+	prefix     uint64
+	workers    map[string]bool
+	difficulty float64
+	jobs       map[string]*sessionJob
+	vardiff    vardiff
+}
+
+func newSession(s *Server, conn net.Conn) *session {
+	return &session{
+		server:  s,
+		conn:    conn,
+		out:     make(chan interface{}, sendBuffer),
+		done:    make(chan bool),
+		workers: make(map[string]bool),
+		jobs:    make(map[string]*sessionJob),
+	}
+}
+
+func (sess *session) close() {
+	sess.once.Do(func() {
+		close(sess.done)
+		sess.conn.Close()
+	})
+}
+
+//send queues a message, a miner too slow to take it is dropped
+func (sess *session) send(msg interface{}) {
+	select {
+	case sess.out <- msg:
+	case <-sess.done:
+	default:
+		log.Print("Stratum proxy: dropping ", sess.conn.RemoteAddr(), ", it does not read its messages")
+		sess.close()
+	}
+}
+
+func (sess *session) writeLoop() {
+	encoder := json.NewEncoder(sess.conn)
+	for {
+		select {
+		case msg := <-sess.out:
+			sess.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := encoder.Encode(msg); err != nil {
+				sess.close()
+				return
+			}
+		case <-sess.done:
+			return
+		}
+	}
+}
+
+func (sess *session) serve() {
+	defer sess.cleanup()
+	go sess.writeLoop()
+	scanner := bufio.NewScanner(sess.conn)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Print("Stratum proxy: invalid request from ", sess.conn.RemoteAddr(), ": ", err)
+			return
+		}
+		var result interface{}
+		var serr *stratumError
+		switch req.Method {
+		case "mining.subscribe":
+			serr = sess.subscribe(req.ID)
+			if serr == nil {
+				//the reply went out ahead of the difficulty and the job
+				continue
+			}
+		case "mining.authorize":
+			result, serr = sess.authorize(req.Params)
+		case "mining.submit":
+			result, serr = sess.submit(req.Params)
+		case "mining.extranonce.subscribe":
+			//the extranonce1 never changes, the miners are dropped if the pool changes it
+			result = true
+		default:
+			serr = errUnknownMethod
+		}
+		reply := response{ID: req.ID, Result: result}
+		if serr != nil {
+			reply.Result, reply.Error = nil, serr.reply()
+		}
+		sess.send(reply)
+	}
+}
+
+//cleanup frees the extranonce2 prefix and the worker connections of a miner that left
+func (sess *session) cleanup() {
+	sess.close()
+	s := sess.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, sess)
+	if sess.prefix != 0 {
+		delete(s.prefixes, sess.prefix)
+	}
+	for name := range sess.workers {
+		s.worker(name).Connections--
+	}
+}
+
+//subscribe gives the miner its extranonce2 prefix, the difficulty and the current job
+func (sess *session) subscribe(id interface{}) *stratumError {
+	s := sess.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sess.subscribed {
+		return &stratumError{20, "Already subscribed"}
+	}
+	if s.source == nil || s.job.JobID == "" {
+		return errNoWork
+	}
+	size := s.job.ExtraNonce2.Size
+	if size <= ExtraNonceSize || size > 8 {
+		return &stratumError{20, fmt.Sprintf("The extranonce2 of the pool is %d bytes, sharing it takes 3 to 8", size)}
+	}
+	prefix := s.allocatePrefix()
+	if prefix == 0 {
+		return &stratumError{20, "Every extranonce2 range is taken"}
+	}
+	sess.subscribed = true
+	sess.prefix = prefix
+	sess.difficulty = s.clampDifficulty(s.Config.Difficulty)
+	sess.vardiff = newVardiff(time.Now())
+
+	extranonce1 := append(append([]byte{}, s.extranonce1...), prefixBytes(prefix)...)
+	subscription := strconv.FormatUint(prefix, 16)
+	sess.send(response{ID: id, Result: []interface{}{
+		[]interface{}{
+			[]interface{}{"mining.set_difficulty", subscription},
+			[]interface{}{"mining.notify", subscription},
+		},
+		hex.EncodeToString(extranonce1),
+		size - ExtraNonceSize,
+	}})
+	sess.sendDifficulty()
+	sess.sendJob(s.job, true)
+	return nil
+}
+
+func (sess *session) authorize(params []interface{}) (interface{}, *stratumError) {
+	name, ok := stringParam(params, 0)
+	if !ok {
+		return nil, &stratumError{20, "Missing worker name"}
+	}
+	s := sess.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !sess.workers[name] {
+		sess.workers[name] = true
+		w := s.worker(name)
+		w.Connections++
+		w.Difficulty = sess.difficulty
+	}
+	return true, nil
+}
+
+//submit checks a share against the difficulty of the miner and forwards it if it meets the pool target
+func (sess *session) submit(params []interface{}) (interface{}, *stratumError) {
+	var fields [5]string
+	for i := range fields {
+		var ok bool
+		if fields[i], ok = stringParam(params, i); !ok {
+			return nil, errMalformed
+		}
+	}
+	name, jobID := fields[0], fields[1]
+	extranonce2, err1 := hex.DecodeString(fields[2])
+	ntime, err2 := hex.DecodeString(fields[3])
+	nonce, err3 := hex.DecodeString(fields[4])
+
+	s := sess.server
+	s.mutex.Lock()
+	if !sess.subscribed {
+		s.mutex.Unlock()
+		return nil, errNotSubscribed
+	}
+	if !sess.workers[name] {
+		s.mutex.Unlock()
+		return nil, errUnauthorized
+	}
+	w := s.worker(name)
+	job, ok := s.recentJob(jobID)
+	sj := sess.jobs[jobID]
+	if !ok || sj == nil {
+		w.Stale++
+		s.mutex.Unlock()
+		return nil, errStale
+	}
+	if err1 != nil || err2 != nil || err3 != nil || len(extranonce2) != int(job.ExtraNonce2.Size)-ExtraNonceSize || len(ntime) != 4 || len(nonce) != 4 {
+		w.Rejected++
+		s.mutex.Unlock()
+		return nil, errMalformed
+	}
+	key := fields[2] + fields[3] + fields[4]
+	if sj.submitted[key] {
+		w.Duplicates++
+		s.mutex.Unlock()
+		return nil, errDuplicate
+	}
+	upstream, funcs := s.upstream, s.funcs
+	extranonce1, poolTarget, minerDifficulty := s.extranonce1, target(s.poolDifficulty), sj.difficulty
+	s.mutex.Unlock()
+
+	job.ExtraNonce2 = jobs.ExtraNonce2{Value: sess.prefix<<(8*uint(len(extranonce2))) | beUint(extranonce2), Size: job.ExtraNonce2.Size}
+	job.NTime = ntime
+	nonce = append([]byte{0, 0, 0, 0}, nonce...)
+
+	header := job.Header(extranonce1)
+	hash := funcs.RegenHash(append(append(header, poolTarget...), nonce...))
+	if !funcs.DiffChecker(hash, driver.MiningWork{Target: target(minerDifficulty)}) {
+		s.mutex.Lock()
+		w.Rejected++
+		s.mutex.Unlock()
+		return nil, errLowDifficulty
+	}
+	//only valid shares count for duplicates, a resent low difficulty share is rejected as such again
+	s.mutex.Lock()
+	sj.submitted[key] = true
+	s.mutex.Unlock()
+	forward := funcs.DiffChecker(hash, driver.MiningWork{Target: poolTarget})
+	var err error
+	if forward {
+		err = upstream.SubmitHeader(nonce, job)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err != nil {
+		w.PoolRejected++
+		return nil, &stratumError{20, "Rejected by the pool: " + err.Error()}
+	}
+	if forward {
+		w.Forwarded++
+	}
+	w.Accepted++
+	w.ShareDifficulty += minerDifficulty
+	w.LastShare = time.Now().Unix()
+	sess.retarget(true, time.Now())
+	return true, nil
+}
+
+//sendJob sends a job to a subscribed miner, at the difficulty it has now.
+// The difficulty is lowered first if the pool difficulty dropped below it.
+func (sess *session) sendJob(job jobs.BitcoinJob, clean bool) {
+	if !sess.subscribed {
+		return
+	}
+	if d := sess.server.clampDifficulty(sess.difficulty); d != sess.difficulty {
+		sess.setDifficulty(d)
+	}
+	if clean {
+		sess.jobs = make(map[string]*sessionJob)
+	}
+	for id := range sess.jobs {
+		if _, ok := sess.server.recentJob(id); !ok {
+			delete(sess.jobs, id)
+		}
+	}
+	sess.jobs[job.JobID] = &sessionJob{difficulty: sess.difficulty, submitted: make(map[string]bool)}
+
+	branch := make([]interface{}, len(job.MerkleBranch))
+	for i, h := range job.MerkleBranch {
+		branch[i] = hex.EncodeToString(h)
+	}
+	sess.send(notification{Method: "mining.notify", Params: []interface{}{
+		job.JobID,
+		hex.EncodeToString(job.PrevHash),
+		hex.EncodeToString(job.Coinbase1),
+		hex.EncodeToString(job.Coinbase2),
+		branch,
+		hex.EncodeToString(job.Version),
+		hex.EncodeToString(job.NBits),
+		hex.EncodeToString(job.NTime),
+		clean,
+	}})
+}
+
+//retarget adjusts the difficulty of the miner to its share rate, on a share or a tick
+func (sess *session) retarget(share bool, now time.Time) {
+	if !sess.subscribed || sess.server.funcs == nil {
+		return
+	}
+	d := sess.vardiff.update(sess.difficulty, share, now, sess.server.shareTime())
+	if d == 0 {
+		return
+	}
+	if d = sess.server.clampDifficulty(d); d != sess.difficulty {
+		sess.setDifficulty(d)
+	}
+}
+
+//setDifficulty changes the difficulty of the miner, it applies from the next job on
+func (sess *session) setDifficulty(d float64) {
+	sess.difficulty = d
+	for name := range sess.workers {
+		sess.server.worker(name).Difficulty = d
+	}
+	sess.sendDifficulty()
+}
+
+func (sess *session) sendDifficulty() {
+	sess.send(notification{Method: "mining.set_difficulty", Params: []interface{}{sess.difficulty}})
+}
+
+func stringParam(params []interface{}, i int) (string, bool) {
+	if i >= len(params) {
+		return "", false
+	}
+	s, ok := params[i].(string)
+	return s, ok
+}
+
+//prefixBytes is the big endian extranonce2 prefix of a miner
+func prefixBytes(prefix uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], prefix)
+	return b[8-ExtraNonceSize:]
+}
+
+//beUint reads up to 8 bytes as a big endian number
+func beUint(b []byte) (n uint64) {
+	for _, v := range b {
+		n = n<<8 | uint64(v)
+	}
+	return
+}
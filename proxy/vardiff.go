@@ -0,0 +1,48 @@
+package proxy
+
+import "time"
+
+const (
+	//retargetShares is the number of shares after which a miner is retargeted early, it mines too fast
+	retargetShares = 12
+	//retargetPeriods is the number of share times after which a miner is retargeted at the latest
+	retargetPeriods = 6
+	//maxRetarget bounds the change of a single retarget
+	maxRetarget = 4
+	//retargetTolerance is the change below which the difficulty is kept
+	retargetTolerance = 0.25
+)
+
+//vardiff measures the share rate of a miner since its last retarget
+type vardiff struct {
+	start  time.Time
+	shares int
+}
+
+func newVardiff(now time.Time) vardiff {
+	return vardiff{start: now}
+}
+
+//update counts a share, or only the time for a tick, and returns the difficulty giving a share every shareTime
+// at the rate measured since the last retarget. It returns 0 while it is too early to tell or the change is small.
+func (v *vardiff) update(d float64, share bool, now time.Time, shareTime time.Duration) float64 {
+	if share {
+		v.shares++
+	}
+	elapsed := now.Sub(v.start)
+	if v.shares < retargetShares && elapsed < retargetPeriods*shareTime {
+		return 0
+	}
+	factor := float64(v.shares) * float64(shareTime) / float64(elapsed)
+	if factor > maxRetarget {
+		factor = maxRetarget
+	}
+	if factor < 1.0/maxRetarget {
+		factor = 1.0 / maxRetarget
+	}
+	v.start, v.shares = now, 0
+	if factor > 1-retargetTolerance && factor < 1+retargetTolerance {
+		return 0
+	}
+	return d * factor
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVardiff(t *testing.T) {
+	start := time.Unix(1000, 0)
+	shareTime := 10 * time.Second
+
+	//12 shares in 30s are twice the rate of one share every 10s
+	v := newVardiff(start)
+	var d float64
+	for i := 1; i <= retargetShares; i++ {
+		d = v.update(8, true, start.Add(time.Duration(i)*2500*time.Millisecond), shareTime)
+		if i < retargetShares && d != 0 {
+			t.Fatal("retargeted after", i, "shares")
+		}
+	}
+	if d != 32 {
+		t.Error("fast miner retargeted to", d)
+	}
+
+	//no share for 6 share times quarters the difficulty, the window starts again
+	v = newVardiff(start)
+	if d = v.update(8, false, start.Add(59*time.Second), shareTime); d != 0 {
+		t.Error("retargeted early to", d)
+	}
+	if d = v.update(8, false, start.Add(60*time.Second), shareTime); d != 2 {
+		t.Error("idle miner retargeted to", d)
+	}
+	if v.shares != 0 || !v.start.Equal(start.Add(60*time.Second)) {
+		t.Error("window not restarted", v)
+	}
+
+	//5 shares in 60s are close enough to 6
+	v = newVardiff(start)
+	for i := 0; i < 5; i++ {
+		v.update(8, true, start.Add(time.Duration(i)*time.Second), shareTime)
+	}
+	if d = v.update(8, false, start.Add(60*time.Second), shareTime); d != 0 {
+		t.Error("retargeted to", d)
+	}
+
+	//3 shares in 60s halve it
+	v = newVardiff(start)
+	for i := 0; i < 3; i++ {
+		v.update(8, true, start.Add(time.Duration(i)*time.Second), shareTime)
+	}
+	if d = v.update(8, false, start.Add(60*time.Second), shareTime); d != 4 {
+		t.Error("slow miner retargeted to", d)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/AGPFMiner/gominer/miner"
+
+	"github.com/spf13/cobra"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay FILE",
+	Short: "Run a capture of the board traffic through the nonce parser and share checks offline.",
+	Long: "Run a capture written with the capture setting or mine --capture through the nonce parser\n" +
+		"and the share checks, in the order it was taken and without boards or a pool. Reports the\n" +
+		"nonces of every board and lists those that gave a wrong hash with their header.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logLevel, _ := cmd.Flags().GetString("log")
+		r, err := miner.Replay(args[0], logLevel)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("%s: %d records of %s over %v\n", args[0], r.Records, r.Algo, r.End.Sub(r.Start))
+		if r.Truncated {
+			fmt.Println("warning: the last record is cut short")
+		}
+		for _, b := range r.Boards {
+			fmt.Printf("board %2d  nonces %d  wrong hash %d  below target %d  duplicate %d  stale %d  unknown job %d  shares %d\n",
+				b.Board, b.Nonces, b.WrongHash, b.BelowTarget, b.Duplicates, b.Stale, b.Unknown, b.Shares)
+		}
+		fmt.Printf("parser    resyncs %d  skipped bytes %d  malformed %d\n", r.Resyncs, r.Skipped, r.Malformed)
+		if len(r.WrongHash) > 0 {
+			fmt.Println()
+			for _, n := range r.WrongHash {
+				fmt.Printf("%s  board %2d  job %3d  nonce %02X  header %02X\n",
+					n.Time.Format("15:04:05.000000"), n.Board, n.JobID, n.Nonce, n.Header)
+			}
+		}
+	},
+}
+
+func init() {
+	replayCmd.Flags().String("log", "error", "log level of the driver while replaying")
+}
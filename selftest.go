@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AGPFMiner/gominer/config"
+	"github.com/AGPFMiner/gominer/driver"
+	"github.com/AGPFMiner/gominer/driver/transport"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The selftest command checks the installation before mining.
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Check the configuration, device, openocd and bitstreams.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, problems := config.Load(viper.GetViper())
+		failed := false
+		for _, check := range selfChecks(cfg, problems) {
+			err := check.run()
+			status := "ok"
+			if err != nil {
+				status = "FAIL " + err.Error()
+				failed = true
+			}
+			fmt.Printf("%-24s %s\n", check.name, status)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+type selfCheck struct {
+	name string
+	run  func() error
+}
+
+func selfChecks(cfg *config.Config, problems config.Problems) []selfCheck {
+	checks := []selfCheck{
+		{"configuration", func() error {
+			if problems.Fatal() {
+				return errors.New("run `gominer config check` for details")
+			}
+			return nil
+		}},
+	}
+	devices, muxNum := []string{cfg.Device}, cfg.MuxNum
+	if len(cfg.Devices) > 0 {
+		devices, muxNum = nil, 1
+		for _, d := range cfg.Devices {
+			devices = append(devices, d.Device)
+			if d.MuxNum > muxNum {
+				muxNum = d.MuxNum
+			}
+		}
+	}
+	for _, device := range devices {
+		device := device
+		checks = append(checks, selfCheck{"device " + device, func() error { return checkDevice(device) }})
+	}
+	return append(checks,
+		selfCheck{"gpio", func() error { return driver.OpenBoards(muxNum) }},
+		selfCheck{"openocd", func() error {
+			_, err := exec.LookPath("openocd")
+			return err
+		}},
+		selfCheck{"bitstreams", func() error { return checkBitstreams(cfg) }},
+	)
+}
+
+func checkDevice(device string) error {
+	if strings.HasPrefix(device, "@") {
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(device, "@"), 5*time.Second)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	if pattern, ok := transport.USBPattern(device); ok {
+		if len(transport.USBDevices(pattern)) == 0 {
+			return transport.ErrNoUSBDevice
+		}
+		return nil
+	}
+	_, err := os.Stat(device)
+	return err
+}
+
+// checkBitstreams looks for the bitstream of every configured algorithm.
+// The odocrypt bitstream is named after its epoch, any of them will do.
+func checkBitstreams(cfg *config.Config) error {
+	var missing []string
+	seen := make(map[string]bool)
+	for _, pool := range cfg.Pools {
+		if seen[pool.Algo] {
+			continue
+		}
+		seen[pool.Algo] = true
+		pattern := driver.BitstreamPath(pool.Algo + ".bit")
+		if pool.Algo == "odocrypt" {
+			pattern = driver.BitstreamPath("odocrypt-*.bit")
+		}
+		if found, _ := filepath.Glob(pattern); len(found) == 0 {
+			missing = append(missing, pattern)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
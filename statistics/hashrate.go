@@ -1,22 +1,34 @@
 package statistics
 
+const hashRateSlots = 3600
+
+//HashRate keeps one sample per second for the last hour
 type HashRate struct {
-	dataSeries [3600]float64
+	dataSeries [hashRateSlots]float64
 	currentPos int
+	samples    int
 }
 
+//Add stores num in the next slot, overwriting the oldest sample once the ring is full
 func (hr *HashRate) Add(num float64) {
-	hr.currentPos = (hr.currentPos + 1) % 3600
 	hr.dataSeries[hr.currentPos] = num
+	hr.currentPos = (hr.currentPos + 1) % hashRateSlots
+	if hr.samples < hashRateSlots {
+		hr.samples++
+	}
 }
 
+//RecentNSum sums the recentn most recently added samples
 func (hr *HashRate) RecentNSum(recentn int) (sum float64) {
 	sum = 0
+	if recentn > hr.samples {
+		recentn = hr.samples
+	}
 	pos := 0
-	for i := 0; i < recentn; i++ {
-		pos = (hr.currentPos - i)
+	for i := 1; i <= recentn; i++ {
+		pos = hr.currentPos - i
 		if pos < 0 {
-			pos += 3600
+			pos += hashRateSlots
 		}
 		sum += hr.dataSeries[pos]
 	}
@@ -0,0 +1,226 @@
+package statistics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Metric names recorded by the miner
+const (
+	MetricHashrate    = "hashrate"
+	MetricAccepted    = "accepted"
+	MetricRejected    = "rejected"
+	MetricTemperature = "temperature"
+	MetricVoltage     = "voltage"
+)
+
+//SourceTotal is the source of values aggregated over the whole miner
+const SourceTotal = "total"
+
+//BoardSource names the series source of a board
+func BoardSource(board int) string {
+	return fmt.Sprintf("board/%d", board)
+}
+
+//ChainSource names the series source of a chain of boards
+func ChainSource(chain int) string {
+	return fmt.Sprintf("chain/%d", chain)
+}
+
+//PoolSource names the series source of a pool
+func PoolSource(pool int) string {
+	return fmt.Sprintf("pool/%d", pool)
+}
+
+//Resolution is one tier of a Series: samples are folded into buckets of Step
+// width and the newest Slots buckets are kept
+type Resolution struct {
+	Step  time.Duration
+	Slots int
+}
+
+//Retention is how far back a resolution reaches
+func (r Resolution) Retention() time.Duration {
+	return r.Step * time.Duration(r.Slots)
+}
+
+//DefaultResolutions keeps 1s buckets for an hour, 1m buckets for a day and 15m buckets for a month
+var DefaultResolutions = []Resolution{
+	{Step: time.Second, Slots: 3600},
+	{Step: time.Minute, Slots: 24 * 60},
+	{Step: 15 * time.Minute, Slots: 30 * 24 * 4},
+}
+
+//Point is one aggregated bucket returned by a range query
+type Point struct {
+	Time  int64   `json:"time"`
+	Value float64 `json:"value"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+type bucket struct {
+	index         int64
+	sum, min, max float64
+	count         int
+}
+
+type ring struct {
+	res     Resolution
+	buckets []bucket
+}
+
+//add counts v in the bucket of t. A sample older than the bucket in its slot is dropped,
+// the slot already moved on to a newer period.
+func (r *ring) add(t time.Time, v float64) {
+	idx := t.UnixNano() / int64(r.res.Step)
+	b := &r.buckets[idx%int64(r.res.Slots)]
+	if b.count > 0 && idx < b.index {
+		return
+	}
+	if b.index != idx || b.count == 0 {
+		*b = bucket{index: idx, sum: v, min: v, max: v, count: 1}
+		return
+	}
+	b.sum += v
+	b.count++
+	if v < b.min {
+		b.min = v
+	}
+	if v > b.max {
+		b.max = v
+	}
+}
+
+func (r *ring) query(from, to time.Time) (points []Point) {
+	step := int64(r.res.Step)
+	first, last := from.UnixNano()/step, to.UnixNano()/step
+	if n := int64(r.res.Slots); last-first >= n {
+		first = last - n + 1
+	}
+	for idx := first; idx <= last; idx++ {
+		b := r.buckets[idx%int64(r.res.Slots)]
+		if b.index != idx || b.count == 0 {
+			continue
+		}
+		points = append(points, Point{
+			Time:  idx * step / int64(time.Second),
+			Value: b.sum / float64(b.count),
+			Sum:   b.sum,
+			Min:   b.min,
+			Max:   b.max,
+			Count: b.count,
+		})
+	}
+	return
+}
+
+//Series is a multi-resolution time series of a single value
+type Series struct {
+	mutex sync.RWMutex
+	rings []*ring
+}
+
+//NewSeries creates a series with the given resolutions, finest first
+func NewSeries(resolutions []Resolution) *Series {
+	s := &Series{}
+	for _, res := range resolutions {
+		s.rings = append(s.rings, &ring{res: res, buckets: make([]bucket, res.Slots)})
+	}
+	return s
+}
+
+//Add records v at time t in every resolution
+func (s *Series) Add(t time.Time, v float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, r := range s.rings {
+		r.add(t, v)
+	}
+}
+
+//Query returns the buckets between from and to, using the finest resolution
+// that still reaches back to from
+func (s *Series) Query(from, to time.Time) (step time.Duration, points []Point) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(s.rings) == 0 || to.Before(from) {
+		return
+	}
+	r := s.rings[len(s.rings)-1]
+	age := time.Since(from)
+	for _, candidate := range s.rings {
+		if candidate.res.Retention() >= age {
+			r = candidate
+			break
+		}
+	}
+	return r.res.Step, r.query(from, to)
+}
+
+//SeriesKey identifies a series by metric and source, e.g. hashrate of board/3
+type SeriesKey struct {
+	Metric string `json:"metric"`
+	Source string `json:"source"`
+}
+
+//Store holds every series recorded by the miner
+type Store struct {
+	mutex       sync.RWMutex
+	resolutions []Resolution
+	series      map[SeriesKey]*Series
+}
+
+//NewStore creates a store using DefaultResolutions
+func NewStore() *Store {
+	return &Store{resolutions: DefaultResolutions, series: make(map[SeriesKey]*Series)}
+}
+
+//Record adds v to the series of metric for source, creating it on first use
+func (st *Store) Record(metric, source string, t time.Time, v float64) {
+	key := SeriesKey{Metric: metric, Source: source}
+	st.mutex.RLock()
+	s, ok := st.series[key]
+	st.mutex.RUnlock()
+	if !ok {
+		st.mutex.Lock()
+		if s, ok = st.series[key]; !ok {
+			s = NewSeries(st.resolutions)
+			st.series[key] = s
+		}
+		st.mutex.Unlock()
+	}
+	s.Add(t, v)
+}
+
+//Query returns the points of one series, ok is false if nothing was recorded for it yet
+func (st *Store) Query(metric, source string, from, to time.Time) (step time.Duration, points []Point, ok bool) {
+	st.mutex.RLock()
+	s, ok := st.series[SeriesKey{Metric: metric, Source: source}]
+	st.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	step, points = s.Query(from, to)
+	return
+}
+
+//Keys lists the recorded series sorted by metric and source
+func (st *Store) Keys() (keys []SeriesKey) {
+	st.mutex.RLock()
+	for k := range st.series {
+		keys = append(keys, k)
+	}
+	st.mutex.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Metric != keys[j].Metric {
+			return keys[i].Metric < keys[j].Metric
+		}
+		return keys[i].Source < keys[j].Source
+	})
+	return
+}
@@ -0,0 +1,91 @@
+package statistics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHashRateRecentNSum(t *testing.T) {
+	hr := &HashRate{}
+	for i := 1; i <= 5; i++ {
+		hr.Add(float64(i))
+	}
+	if sum := hr.RecentNSum(1); sum != 5 {
+		t.Error(sum, "returned instead of", 5)
+	}
+	if sum := hr.RecentNSum(2); sum != 9 {
+		t.Error(sum, "returned instead of", 9)
+	}
+	if sum := hr.RecentNSum(60); sum != 15 {
+		t.Error(sum, "returned instead of", 15)
+	}
+}
+
+func TestSeriesQuery(t *testing.T) {
+	s := NewSeries(DefaultResolutions)
+	now := time.Now().Truncate(time.Minute)
+	for i := 0; i < 120; i++ {
+		s.Add(now.Add(-time.Duration(i)*time.Second), 2)
+	}
+
+	step, points := s.Query(now.Add(-10*time.Second), now)
+	if step != time.Second {
+		t.Error(step, "returned instead of", time.Second)
+	}
+	if len(points) != 11 {
+		t.Fatal(len(points), "points returned instead of", 11)
+	}
+
+	step, points = s.Query(now.Add(-2*time.Hour), now)
+	if step != time.Minute {
+		t.Error(step, "returned instead of", time.Minute)
+	}
+	var sum float64
+	for _, p := range points {
+		sum += p.Sum
+		if p.Value != 2 {
+			t.Error("mean", p.Value, "returned instead of", 2)
+		}
+	}
+	if sum != 240 {
+		t.Error(sum, "returned instead of", 240)
+	}
+}
+
+func TestSeriesLateSample(t *testing.T) {
+	s := NewSeries([]Resolution{{Step: time.Second, Slots: 60}})
+	now := time.Now().Truncate(time.Minute)
+	s.Add(now, 1)
+	//a minute older sample maps to the same slot and must not replace the newer bucket
+	s.Add(now.Add(-time.Minute), 5)
+
+	_, points := s.Query(now, now)
+	if len(points) != 1 || points[0].Sum != 1 || points[0].Count != 1 {
+		t.Error(points, "returned instead of the newer bucket")
+	}
+	if _, points = s.Query(now.Add(-time.Minute), now.Add(-time.Minute)); len(points) != 0 {
+		t.Error("late sample stored:", points)
+	}
+}
+
+func TestStoreKeys(t *testing.T) {
+	st := NewStore()
+	now := time.Now()
+	st.Record(MetricHashrate, BoardSource(1), now, 1)
+	st.Record(MetricAccepted, PoolSource(0), now, 1)
+	st.Record(MetricHashrate, BoardSource(0), now, 1)
+
+	keys := st.Keys()
+	expected := []SeriesKey{{MetricAccepted, "pool/0"}, {MetricHashrate, "board/0"}, {MetricHashrate, "board/1"}}
+	if len(keys) != len(expected) {
+		t.Fatal(keys, "returned instead of", expected)
+	}
+	for i := range keys {
+		if keys[i] != expected[i] {
+			t.Error(keys, "returned instead of", expected)
+		}
+	}
+	if _, _, ok := st.Query(MetricVoltage, BoardSource(0), now.Add(-time.Minute), now); ok {
+		t.Error("unknown series reported as recorded")
+	}
+}
@@ -0,0 +1,90 @@
+package types
+
+//APIError is the body of every failed REST API call
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+}
+
+type APIErrorBody struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+//Summary is returned by GET /api/v1/summary
+type Summary struct {
+	Version    string        `json:"version"`
+	Uptime     int64         `json:"uptime"`
+	Algo       string        `json:"algo"`
+	ActivePool int           `json:"activepool"`
+	Status     HardwareStats `json:"status"`
+	Devices    int           `json:"devices"`
+	Hashrate   [3]float64    `json:"hashrate"`
+	Accept     int32         `json:"accept"`
+	Reject     int32         `json:"reject"`
+	Discard    int32         `json:"discard"`
+	Time       int64         `json:"time"`
+	Chains     int           `json:"chains"`
+}
+
+//ChainStates is returned by GET /api/v1/chains, one entry per chain of boards
+type ChainStates struct {
+	Name   string        `json:"name"`
+	Driver string        `json:"driver"`
+	Device string        `json:"device"`
+	Algo   string        `json:"algo"`
+	Status HardwareStats `json:"status"`
+	//Pool is the index of the pool the chain mines for, FollowsActive is set if it moves with the active pool
+	Pool          int  `json:"pool"`
+	FollowsActive bool `json:"followsactive"`
+	//FirstBoard is the number of the chain's first board in /api/v1/devices
+	FirstBoard int        `json:"firstboard"`
+	Boards     int        `json:"boards"`
+	Hashrate   [3]float64 `json:"hashrate"`
+	//Stale counts the nonces found for work replaced by a clean job
+	Stale     uint64          `json:"stale"`
+	Nonces    NonceClasses    `json:"nonces"`
+	Transport *TransportStats `json:"transport,omitempty"`
+}
+
+//PoolConfig is a pool entry as exposed by the API, credentials are never returned
+type PoolConfig struct {
+	URL      string `json:"url"`
+	User     string `json:"user"`
+	Algo     string `json:"algo"`
+	Active   bool   `json:"active"`
+	Priority int    `json:"priority"`
+	Payout   string `json:"payout,omitempty"`
+}
+
+//MinerConfig is returned by GET /api/v1/config
+type MinerConfig struct {
+	Driver       string        `json:"driver"`
+	Device       string        `json:"device"`
+	BaudRate     uint          `json:"baudrate"`
+	MuxNum       int           `json:"muxnum"`
+	PollDelay    int64         `json:"polldelay"`
+	NonceTimeout int64         `json:"noncetimeout"`
+	Debug        string        `json:"debug"`
+	WebListen    string        `json:"listen"`
+	Pools        []PoolConfig  `json:"pools"`
+	Devices      []ChainConfig `json:"devices,omitempty"`
+}
+
+//ChainConfig is a devices entry as exposed by the API
+type ChainConfig struct {
+	Name         string `json:"name"`
+	Driver       string `json:"driver"`
+	Device       string `json:"device"`
+	BaudRate     uint   `json:"baudrate"`
+	MuxNum       int    `json:"muxnum"`
+	SkipSlots    []int  `json:"skipslots"`
+	PollDelay    int64  `json:"polldelay"`
+	NonceTimeout int64  `json:"noncetimeout"`
+	Pool         int    `json:"pool"`
+}
+
+//ActionResult is returned by the POST endpoints of the API
+type ActionResult struct {
+	Action string `json:"action"`
+	OK     bool   `json:"ok"`
+}
@@ -6,6 +6,12 @@ type Pool struct {
 	Pass   string `json:"pass"`
 	Algo   string `json:"algo"`
 	Active bool   `json:"active,omitempty"`
+	//Priority orders the pools when none is marked active, lower values come first
+	Priority int `json:"priority,omitempty"`
+	//Record is a file every stratum line exchanged with the pool is appended to, for replaying the session
+	Record string `json:"record,omitempty"`
+	//Payout is the address solo mining clients pay the block reward to
+	Payout string `json:"payout,omitempty"`
 }
 
 type PoolConnectionStates int
@@ -17,6 +23,21 @@ const (
 	Dead
 )
 
+func (s PoolConnectionStates) String() string {
+	switch s {
+	case NotReady:
+		return "notready"
+	case Alive:
+		return "alive"
+	case Sick:
+		return "sick"
+	case Dead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
 type PoolStates struct {
 	Status       PoolConnectionStates `json:"status"`
 	User         string               `json:"user"`
@@ -47,7 +68,48 @@ type DriverStates struct {
 	NonceNum    [3]float64      `json:"noncenum"`
 	Hashrate    [3]float64      `json:"hashrate"`
 	NonceStats  *map[int]uint64 `json:"nonestats"`
+	Stale       uint64          `json:"stale"`
+	Nonces      NonceClasses    `json:"nonces"`
 	Algo        string          `json:"algo"`
+	Transport   *TransportStats `json:"transport,omitempty"`
+	Chain       string          `json:"chain,omitempty"`
+}
+
+//NonceClasses counts the checked nonces of the boards by what their hash turned out to be
+type NonceClasses struct {
+	//HardwareErrors missed the target the board filters on, it computed a wrong hash
+	HardwareErrors uint64 `json:"hardwareerrors"`
+	//BelowTarget met the target of the board but not the one of the pool
+	BelowTarget uint64 `json:"belowtarget"`
+	//Shares met the pool target and were submitted
+	Shares uint64 `json:"shares"`
+	//Duplicates were reported before for the same work and dropped
+	Duplicates uint64 `json:"duplicates"`
+	//Unhashed were dropped, the host cannot hash their algorithm to check them against the pool target
+	Unhashed uint64 `json:"unhashed"`
+	//Golden were submitted as the board reported them, the host cannot hash their algorithm but the bitstream checks it
+	Golden uint64 `json:"golden"`
+}
+
+//Add adds the counts of o
+func (n *NonceClasses) Add(o NonceClasses) {
+	n.HardwareErrors += o.HardwareErrors
+	n.BelowTarget += o.BelowTarget
+	n.Shares += o.Shares
+	n.Duplicates += o.Duplicates
+	n.Unhashed += o.Unhashed
+	n.Golden += o.Golden
+}
+
+//TransportStats counts the traffic on the link to the boards
+type TransportStats struct {
+	Name        string `json:"name"`
+	BytesIn     uint64 `json:"bytesin"`
+	BytesOut    uint64 `json:"bytesout"`
+	FramesIn    uint64 `json:"framesin"`
+	FramesOut   uint64 `json:"framesout"`
+	ParseErrors uint64 `json:"parseerrors"`
+	Reconnects  uint64 `json:"reconnects"`
 }
 
 type ScriptaMinerStatus struct {